@@ -0,0 +1,100 @@
+package analysis
+
+import (
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"megajam/logger"
+)
+
+// Cache stores analysis results in sidecar files below Dir. Entries are keyed by the
+// track's path and are ignored once the file's size or modification time changes.
+type Cache struct {
+	Dir string
+}
+
+// cacheEntry is the gob-encoded content of a waveform sidecar file.
+type cacheEntry struct {
+	Path     string
+	Size     int64
+	ModTime  time.Time
+	Waveform *Waveform
+}
+
+// NewCache creates a cache rooted at dir.
+func NewCache(dir string) *Cache {
+	return &Cache{Dir: dir}
+}
+
+// Waveform returns the waveform of the file at path, analysing it and storing
+// the result when there is no valid cache entry.
+func (c *Cache) Waveform(path string) (*Waveform, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sidecar := c.sidecarPath(path, ".wfm")
+	if entry, err := readEntry(sidecar); err == nil && entry.Path == path &&
+		entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) {
+		return entry.Waveform, nil
+	}
+
+	waveform, err := AnalyzeWaveform(path)
+	if err != nil {
+		return nil, err
+	}
+	entry := cacheEntry{Path: path, Size: info.Size(), ModTime: info.ModTime(), Waveform: waveform}
+	if err := writeEntry(sidecar, &entry); err != nil {
+		// A failed write only costs a re-analysis next time.
+		logger.Logger.Printf("Failed to cache waveform for '%s': %v", path, err)
+	}
+	return waveform, nil
+}
+
+// sidecarPath returns the cache file used for path.
+func (c *Cache) sidecarPath(path, ext string) string {
+	sum := sha1.Sum([]byte(path))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+ext)
+}
+
+func readEntry(sidecar string) (*cacheEntry, error) {
+	f, err := os.Open(sidecar)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entry cacheEntry
+	if err := gob.NewDecoder(f).Decode(&entry); err != nil {
+		return nil, fmt.Errorf("failed to decode cache file '%s': %w", sidecar, err)
+	}
+	return &entry, nil
+}
+
+// writeEntry writes the entry to a temporary file first so readers never see a partial file.
+func writeEntry(sidecar string, entry *cacheEntry) error {
+	if err := os.MkdirAll(filepath.Dir(sidecar), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := sidecar + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(entry); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, sidecar)
+}
@@ -0,0 +1,91 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"megajam/decoder"
+)
+
+// fft computes the discrete Fourier transform of x in place. len(x) must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	// Bit-reversal permutation.
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := x[start+k], w*x[start+k+size/2]
+				x[start+k] = even + odd
+				x[start+k+size/2] = even - odd
+				w *= step
+			}
+		}
+	}
+}
+
+// hannWindow returns a Hann window of length n.
+func hannWindow(n int) []float64 {
+	window := make([]float64, n)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n))
+	}
+	return window
+}
+
+// spectrumFrames decodes the file at path to mono and calls onFrame with the magnitude
+// spectrum of every windowed frame of frameSize samples, advancing by hopSize samples.
+// Bin i of the spectrum is centred on i*sampleRate/frameSize Hz.
+// It returns the sample rate of the file and its length in samples.
+func spectrumFrames(path string, frameSize, hopSize int, onFrame func(magnitudes []float64, sampleRate int)) (int, int, error) {
+	streamer, format, err := decoder.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer streamer.Close()
+
+	window := hannWindow(frameSize)
+	frame := make([]float64, 0, frameSize)
+	spectrum := make([]complex128, frameSize)
+	magnitudes := make([]float64, frameSize/2+1)
+	buf := make([][2]float64, 4096)
+	total := 0
+	for {
+		n, ok := streamer.Stream(buf)
+		total += n
+		for _, sample := range buf[:n] {
+			frame = append(frame, (sample[0]+sample[1])/2)
+			if len(frame) < frameSize {
+				continue
+			}
+			for i, v := range frame {
+				spectrum[i] = complex(v*window[i], 0)
+			}
+			fft(spectrum)
+			for i := range magnitudes {
+				magnitudes[i] = cmplx.Abs(spectrum[i])
+			}
+			onFrame(magnitudes, int(format.SampleRate))
+			frame = append(frame[:0], frame[hopSize:]...)
+		}
+		if !ok {
+			break
+		}
+	}
+	if err := streamer.Err(); err != nil {
+		return 0, 0, fmt.Errorf("failed to decode '%s': %w", path, err)
+	}
+	return int(format.SampleRate), total, nil
+}
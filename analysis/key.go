@@ -0,0 +1,122 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// keyFrameSize and keyHopSize are the FFT size and step of the chromagram; the large frame
+	// resolves semitones down to the bass register.
+	keyFrameSize = 8192
+	keyHopSize   = 4096
+	// keyMinHz and keyMaxHz bound the frequencies folded into the chromagram.
+	keyMinHz = 55.0
+	keyMaxHz = 4000.0
+)
+
+// pitchNames are the names of the twelve pitch classes, starting at C.
+var pitchNames = [12]string{"C", "C#", "D", "Eb", "E", "F", "F#", "G", "Ab", "A", "Bb", "B"}
+
+// Krumhansl-Kessler key profiles for C major and C minor.
+var (
+	majorProfile = [12]float64{6.35, 2.23, 3.48, 2.33, 4.38, 4.09, 2.52, 5.19, 2.39, 3.66, 2.29, 2.88}
+	minorProfile = [12]float64{6.33, 2.68, 3.52, 5.38, 2.60, 3.53, 2.54, 4.75, 3.98, 2.69, 3.34, 3.17}
+)
+
+// Key is a musical key.
+type Key struct {
+	Tonic    int     // Pitch class of the tonic, 0 for C to 11 for B
+	Minor    bool    // Minor mode when set, major otherwise
+	Strength float64 // Correlation of the track with the key's profile, from -1 to 1
+}
+
+// Name returns the key in standard notation, such as "A minor".
+func (k Key) Name() string {
+	if k.Minor {
+		return pitchNames[k.Tonic] + " minor"
+	}
+	return pitchNames[k.Tonic] + " major"
+}
+
+// Camelot returns the key in Camelot wheel notation, such as "8A" for A minor.
+// Adjacent numbers on the wheel are a fifth apart; A is minor and B is major.
+func (k Key) Camelot() string {
+	tonic, mode := k.Tonic, "B"
+	if k.Minor {
+		// A minor key sits at the position of its relative major.
+		tonic, mode = (k.Tonic+3)%12, "A"
+	}
+	return fmt.Sprintf("%d%s", (tonic*7+7)%12+1, mode)
+}
+
+// AnalyzeKey decodes the file at path and detects its key by matching the track's
+// chromagram against the Krumhansl-Kessler major and minor key profiles.
+func AnalyzeKey(path string) (*Key, error) {
+	var chroma [12]float64
+	var pitchClass []int // Pitch class of each FFT bin, -1 outside the analysed range
+	_, _, err := spectrumFrames(path, keyFrameSize, keyHopSize, func(magnitudes []float64, sampleRate int) {
+		if pitchClass == nil {
+			pitchClass = make([]int, len(magnitudes))
+			for i := range magnitudes {
+				hz := float64(i) * float64(sampleRate) / keyFrameSize
+				pitchClass[i] = -1
+				if hz >= keyMinHz && hz <= keyMaxHz {
+					// MIDI note 69 is A440; pitch class 0 is C.
+					note := int(math.Round(69 + 12*math.Log2(hz/440)))
+					pitchClass[i] = note % 12
+				}
+			}
+		}
+		// Each frame is normalised so that loud passages do not dominate the profile.
+		var frame [12]float64
+		total := 0.0
+		for i, magnitude := range magnitudes {
+			if pitchClass[i] >= 0 {
+				frame[pitchClass[i]] += magnitude
+				total += magnitude
+			}
+		}
+		if total > 0 {
+			for i := range chroma {
+				chroma[i] += frame[i] / total
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	best := Key{Strength: math.Inf(-1)}
+	for tonic := 0; tonic < 12; tonic++ {
+		for _, minor := range []bool{false, true} {
+			profile := majorProfile
+			if minor {
+				profile = minorProfile
+			}
+			var rotated [12]float64
+			for i := range rotated {
+				rotated[(i+tonic)%12] = profile[i]
+			}
+			if r := correlation(chroma[:], rotated[:]); r > best.Strength {
+				best = Key{Tonic: tonic, Minor: minor, Strength: r}
+			}
+		}
+	}
+	if math.IsNaN(best.Strength) || math.IsInf(best.Strength, -1) {
+		return nil, fmt.Errorf("no tonal content found")
+	}
+	return &best, nil
+}
+
+// correlation returns the Pearson correlation coefficient of a and b.
+func correlation(a, b []float64) float64 {
+	meanA, meanB := mean(a), mean(b)
+	var covariance, varianceA, varianceB float64
+	for i := range a {
+		covariance += (a[i] - meanA) * (b[i] - meanB)
+		varianceA += (a[i] - meanA) * (a[i] - meanA)
+		varianceB += (b[i] - meanB) * (b[i] - meanB)
+	}
+	return covariance / math.Sqrt(varianceA*varianceB)
+}
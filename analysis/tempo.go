@@ -0,0 +1,220 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// tempoFrameSize and tempoHopSize are the FFT size and step of the onset detector.
+	tempoFrameSize = 1024
+	tempoHopSize   = 512
+	// minTempo and maxTempo bound the tempo search in beats per minute.
+	minTempo = 60.0
+	maxTempo = 200.0
+	// preferredTempo is where the search is centred to choose between tempo octaves.
+	preferredTempo = 120.0
+	// lowBandHz is the upper edge of the band used to find downbeats, where kick drums sit.
+	lowBandHz = 150.0
+	// minTempoSeconds is the shortest audio the tempo can be detected from.
+	minTempoSeconds = 5.0
+	// beatsPerBar is the assumed time signature when locating the first downbeat.
+	beatsPerBar = 4
+)
+
+// Tempo is the result of tempo detection for a track.
+type Tempo struct {
+	BPM        float64 // Beats per minute, rounded to two decimals
+	Offset     float64 // Time of the first downbeat in seconds
+	Confidence float64 // Onset strength on the beats relative to the average; higher is more reliable
+}
+
+// AnalyzeTempo decodes the file at path and detects its tempo and first downbeat.
+// Onsets are found by spectral flux; the tempo is estimated by autocorrelation and then
+// refined to two decimals by fitting a beat comb over the whole track.
+func AnalyzeTempo(path string) (*Tempo, error) {
+	var flux, lowFlux, previous, previousLow []float64
+	sampleRate, _, err := spectrumFrames(path, tempoFrameSize, tempoHopSize, func(magnitudes []float64, sampleRate int) {
+		lowBins := int(lowBandHz*tempoFrameSize/float64(sampleRate)) + 1
+		if previous == nil {
+			previous = make([]float64, len(magnitudes))
+			previousLow = make([]float64, lowBins)
+		}
+		// Broadband flux uses log magnitudes so quiet and loud onsets count alike; the
+		// low band stays linear so that only strong bass hits register in it.
+		all, low := 0.0, 0.0
+		for i, magnitude := range magnitudes {
+			level := math.Log1p(100 * magnitude)
+			all += math.Max(0, level-previous[i])
+			previous[i] = level
+			if i < lowBins {
+				low += math.Max(0, magnitude-previousLow[i])
+				previousLow[i] = magnitude
+			}
+		}
+		flux = append(flux, all)
+		lowFlux = append(lowFlux, low)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	framesPerSecond := float64(sampleRate) / tempoHopSize
+	if len(flux) > 0 {
+		// The first frame is compared with silence.
+		flux[0], lowFlux[0] = 0, 0
+	}
+	if float64(len(flux)) < minTempoSeconds*framesPerSecond {
+		return nil, fmt.Errorf("track is too short for tempo detection")
+	}
+	onsets := onsetEnvelope(flux)
+	average := mean(onsets)
+	if average == 0 {
+		return nil, fmt.Errorf("no beats found")
+	}
+
+	rough := autocorrelationTempo(onsets, framesPerSecond)
+	bpm, _, score := refineTempo(onsets, framesPerSecond, rough)
+	period := 60 * framesPerSecond / bpm
+
+	// Beats are placed on the low-frequency onsets too, so that broadband off-beat
+	// percussion such as hi-hats does not pull the grid half a beat out of phase.
+	lowOnsets := onsetEnvelope(lowFlux)
+	beats := make([]float64, len(onsets))
+	lowAverage := mean(lowOnsets)
+	for i := range beats {
+		beats[i] = onsets[i] / average
+		if lowAverage > 0 {
+			beats[i] += lowOnsets[i] / lowAverage
+		}
+	}
+	phase, best := 0.0, -1.0
+	for start := 0.0; start < period; start += 0.5 {
+		if strength := combScore(beats, start, period); strength > best {
+			phase, best = start, strength
+		}
+	}
+
+	// The downbeat is the beat of the bar with the strongest low-frequency onsets.
+	downbeat := 0
+	best = -1
+	for b := 0; b < beatsPerBar; b++ {
+		if strength := combScore(lowOnsets, phase+float64(b)*period, period*beatsPerBar); strength > best {
+			downbeat, best = b, strength
+		}
+	}
+	firstDownbeat := phase + float64(downbeat)*period
+
+	// A spectral flux frame is centred half a frame after its first sample.
+	offset := (firstDownbeat*tempoHopSize + tempoFrameSize/2) / float64(sampleRate)
+	return &Tempo{
+		BPM:        math.Round(bpm*100) / 100,
+		Offset:     offset,
+		Confidence: score / average,
+	}, nil
+}
+
+// onsetEnvelope removes the local average from a flux curve, keeps the peaks above it and
+// smooths the result slightly so beat positions tolerate small timing deviations.
+func onsetEnvelope(flux []float64) []float64 {
+	const radius = 8
+	peaks := make([]float64, len(flux))
+	sum := 0.0
+	for i := 0; i < len(flux) && i < radius; i++ {
+		sum += flux[i]
+	}
+	for i := range flux {
+		if i+radius < len(flux) {
+			sum += flux[i+radius]
+		}
+		if i-radius-1 >= 0 {
+			sum -= flux[i-radius-1]
+		}
+		lo, hi := max(0, i-radius), min(len(flux)-1, i+radius)
+		peaks[i] = math.Max(0, flux[i]-sum/float64(hi-lo+1))
+	}
+
+	smoothed := make([]float64, len(peaks))
+	kernel := []float64{0.25, 0.5, 1, 0.5, 0.25}
+	for i := range peaks {
+		for k, weight := range kernel {
+			if j := i + k - len(kernel)/2; j >= 0 && j < len(peaks) {
+				smoothed[i] += weight * peaks[j]
+			}
+		}
+	}
+	return smoothed
+}
+
+// autocorrelationTempo estimates the tempo from the periodicity of the onset envelope,
+// weighting lags towards preferredTempo to pick the most plausible tempo octave.
+func autocorrelationTempo(onsets []float64, framesPerSecond float64) float64 {
+	minLag := int(60 * framesPerSecond / maxTempo)
+	maxLag := int(math.Ceil(60 * framesPerSecond / minTempo))
+	correlation := make([]float64, maxLag+2)
+	for lag := minLag - 1; lag <= maxLag+1; lag++ {
+		sum := 0.0
+		for i := 0; i+lag < len(onsets); i++ {
+			sum += onsets[i] * onsets[i+lag]
+		}
+		correlation[lag] = sum / float64(len(onsets)-lag)
+	}
+
+	bestLag, best := minLag, -1.0
+	for lag := minLag; lag <= maxLag; lag++ {
+		octaves := math.Log2(60 * framesPerSecond / float64(lag) / preferredTempo)
+		weighted := correlation[lag] * math.Exp(-0.5*octaves*octaves)
+		if weighted > best {
+			bestLag, best = lag, weighted
+		}
+	}
+
+	// Parabolic interpolation gives a fractional lag.
+	lag := float64(bestLag)
+	a, b, c := correlation[bestLag-1], correlation[bestLag], correlation[bestLag+1]
+	if denominator := a - 2*b + c; denominator < 0 {
+		lag += 0.5 * (a - c) / denominator
+	}
+	return 60 * framesPerSecond / lag
+}
+
+// refineTempo searches around rough for the tempo whose beat comb collects the most onset
+// strength over the whole track, and returns it with the comb's best phase and score.
+func refineTempo(onsets []float64, framesPerSecond, rough float64) (bpm, phase, score float64) {
+	score = -1
+	for candidate := math.Round(rough*0.98*100) / 100; candidate <= rough*1.02; candidate += 0.01 {
+		period := 60 * framesPerSecond / candidate
+		for start := 0.0; start < period; start += 0.25 {
+			if s := combScore(onsets, start, period); s > score {
+				bpm, phase, score = candidate, start, s
+			}
+		}
+	}
+	return bpm, phase, score
+}
+
+// combScore returns the average onset strength at start, start+period, start+2*period, ...
+func combScore(onsets []float64, start, period float64) float64 {
+	sum, count := 0.0, 0
+	for t := start; t < float64(len(onsets)-1); t += period {
+		i := int(t)
+		frac := t - float64(i)
+		sum += onsets[i]*(1-frac) + onsets[i+1]*frac
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
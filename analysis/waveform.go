@@ -0,0 +1,130 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+
+	"megajam/decoder"
+)
+
+const (
+	// baseBucketSize is the number of samples summarised by one bucket of the finest level.
+	baseBucketSize = 256
+	// levelFactor is how many buckets of one level are merged into a bucket of the next.
+	levelFactor = 4
+	// numLevels is the number of zoom levels computed for each track.
+	numLevels = 5
+)
+
+// PeakLevel summarises a track at one zoom level. Bucket i covers the samples
+// [i*SamplesPerBucket, (i+1)*SamplesPerBucket) of the mono-mixed track.
+type PeakLevel struct {
+	SamplesPerBucket int
+	Min              []float32
+	Max              []float32
+	RMS              []float32
+}
+
+// Waveform holds the peak data of a track at several zoom levels, finest first.
+type Waveform struct {
+	SampleRate int
+	Samples    int // Length of the track in samples
+	Levels     []PeakLevel
+}
+
+// AnalyzeWaveform decodes the file at path and computes its peak levels.
+func AnalyzeWaveform(path string) (*Waveform, error) {
+	streamer, format, err := decoder.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer streamer.Close()
+
+	finest := PeakLevel{SamplesPerBucket: baseBucketSize}
+	buf := make([][2]float64, 4096)
+	total, count := 0, 0
+	lo, hi, sumSquares := math.Inf(1), math.Inf(-1), 0.0
+	flush := func() {
+		finest.Min = append(finest.Min, float32(lo))
+		finest.Max = append(finest.Max, float32(hi))
+		finest.RMS = append(finest.RMS, float32(math.Sqrt(sumSquares/float64(count))))
+		lo, hi, sumSquares, count = math.Inf(1), math.Inf(-1), 0, 0
+	}
+	for {
+		n, ok := streamer.Stream(buf)
+		for _, frame := range buf[:n] {
+			mono := (frame[0] + frame[1]) / 2
+			lo = math.Min(lo, mono)
+			hi = math.Max(hi, mono)
+			sumSquares += mono * mono
+			count++
+			if count == baseBucketSize {
+				flush()
+			}
+		}
+		total += n
+		if !ok {
+			break
+		}
+	}
+	if count > 0 {
+		flush()
+	}
+	if err := streamer.Err(); err != nil {
+		return nil, fmt.Errorf("failed to decode '%s': %w", path, err)
+	}
+
+	w := &Waveform{SampleRate: int(format.SampleRate), Samples: total, Levels: []PeakLevel{finest}}
+	for len(w.Levels) < numLevels {
+		w.Levels = append(w.Levels, mergeLevel(w.Levels[len(w.Levels)-1]))
+	}
+	return w, nil
+}
+
+// mergeLevel builds the next coarser level by combining levelFactor buckets at a time.
+func mergeLevel(fine PeakLevel) PeakLevel {
+	coarse := PeakLevel{SamplesPerBucket: fine.SamplesPerBucket * levelFactor}
+	for start := 0; start < len(fine.Max); start += levelFactor {
+		end := start + levelFactor
+		if end > len(fine.Max) {
+			end = len(fine.Max)
+		}
+		lo, hi, sumSquares := fine.Min[start], fine.Max[start], 0.0
+		for i := start; i < end; i++ {
+			if fine.Min[i] < lo {
+				lo = fine.Min[i]
+			}
+			if fine.Max[i] > hi {
+				hi = fine.Max[i]
+			}
+			sumSquares += float64(fine.RMS[i]) * float64(fine.RMS[i])
+		}
+		coarse.Min = append(coarse.Min, lo)
+		coarse.Max = append(coarse.Max, hi)
+		coarse.RMS = append(coarse.RMS, float32(math.Sqrt(sumSquares/float64(end-start))))
+	}
+	return coarse
+}
+
+// Level returns the coarsest level that still has at least minBuckets buckets,
+// or the finest level when none does.
+func (w *Waveform) Level(minBuckets int) PeakLevel {
+	for i := len(w.Levels) - 1; i >= 0; i-- {
+		if len(w.Levels[i].Max) >= minBuckets {
+			return w.Levels[i]
+		}
+	}
+	return w.Levels[0]
+}
+
+// Envelope returns the peak amplitude of the track in about n buckets, scaled to the int32
+// range used by waveform.Waveform.
+func (w *Waveform) Envelope(n int) []int32 {
+	level := w.Level(n)
+	envelope := make([]int32, len(level.Max))
+	for i := range level.Max {
+		peak := math.Max(math.Abs(float64(level.Min[i])), math.Abs(float64(level.Max[i])))
+		envelope[i] = int32(math.Min(peak, 1) * math.MaxInt32)
+	}
+	return envelope
+}
@@ -0,0 +1,398 @@
+package broadcast
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"megajam/logger"
+)
+
+const (
+	// queueLength is the number of audio buffers held for the encoder before new ones are dropped.
+	queueLength = 64
+	// stableSession is how long a connection must last for the next failure to reconnect quickly.
+	stableSession = 30 * time.Second
+	// writeTimeout is how long the server may stall before the connection is treated as lost.
+	writeTimeout = 10 * time.Second
+)
+
+// minBackoff and maxBackoff bound the wait before reconnecting; it doubles after each failure.
+// They are variables so that tests can reconnect quickly.
+var (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Protocol selects the source protocol of the streaming server.
+type Protocol string
+
+const (
+	// Icecast streams to an Icecast2 mount with an HTTP PUT request.
+	Icecast Protocol = "icecast"
+	// Shoutcast streams with the SHOUTcast v1 source protocol, on the port after the listener port.
+	Shoutcast Protocol = "shoutcast"
+)
+
+// Codec selects the encoding of the stream.
+type Codec string
+
+const (
+	// MP3 encodes with LAME and works with both protocols.
+	MP3 Codec = "mp3"
+	// Opus encodes Opus in an Ogg container and needs Icecast. Each title starts a new
+	// chained stream, as Ogg streams carry their titles in their tags.
+	Opus Codec = "opus"
+)
+
+// Config describes the server a Broadcaster streams to and how the stream is encoded.
+type Config struct {
+	Protocol Protocol
+	Host     string
+	Port     int // Listener port; SHOUTcast sources connect to the next port
+	Mount    string
+	User     string // Source user of Icecast; SHOUTcast v1 uses only the password
+	Password string
+	Codec    Codec
+	Bitrate  int    // Kilobits per second
+	Name     string // Station name shown by the server
+	Encoder  string // Path of the ffmpeg or lame executable
+}
+
+// Validate checks that the configuration describes a stream the server can take.
+func (c Config) Validate() error {
+	if c.Host == "" {
+		return fmt.Errorf("no broadcast host set")
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid broadcast port %d", c.Port)
+	}
+	if c.Bitrate <= 0 {
+		return fmt.Errorf("invalid broadcast bitrate %d", c.Bitrate)
+	}
+	switch c.Protocol {
+	case Icecast:
+		if !strings.HasPrefix(c.Mount, "/") {
+			return fmt.Errorf("icecast mount '%s' must start with '/'", c.Mount)
+		}
+	case Shoutcast:
+		if c.Codec != MP3 {
+			return fmt.Errorf("shoutcast streams must be mp3")
+		}
+	default:
+		return fmt.Errorf("unknown broadcast protocol '%s'", c.Protocol)
+	}
+	switch c.Codec {
+	case MP3:
+	case Opus:
+		if isLame(c.Encoder) {
+			return fmt.Errorf("lame cannot encode opus, use ffmpeg")
+		}
+	default:
+		return fmt.Errorf("unknown broadcast codec '%s'", c.Codec)
+	}
+	// A missing encoder would otherwise only show as a connection failing over and over.
+	if _, err := exec.LookPath(c.Encoder); err != nil {
+		return fmt.Errorf("broadcast encoder '%s' not found: %w", c.Encoder, err)
+	}
+	return nil
+}
+
+// State is the connection state of a Broadcaster.
+type State int
+
+const (
+	Stopped State = iota
+	Connecting
+	Live
+	Waiting // Waiting to reconnect after the connection failed
+)
+
+func (s State) String() string {
+	switch s {
+	case Connecting:
+		return "Connecting"
+	case Live:
+		return "Live"
+	case Waiting:
+		return "Reconnecting"
+	}
+	return "Stopped"
+}
+
+// Status describes a Broadcaster at one moment.
+type Status struct {
+	State   State
+	Err     error         // Why the last connection failed, nil if none has
+	Sent    int64         // Encoded bytes sent over the current connection
+	Uptime  time.Duration // Time connected
+	Retries int           // Failed connections since the last stable one
+}
+
+// Broadcaster encodes the audio written to it and streams it to a server, reconnecting with
+// backoff when the connection fails. Audio written while disconnected is dropped, like a
+// radio that is off air.
+type Broadcaster struct {
+	cfg        Config
+	sampleRate int
+	command    func(song string) *exec.Cmd // Builds the encoder process, replaced by tests
+
+	audio    chan []byte // Interleaved 16-bit little-endian PCM
+	free     chan []byte
+	metadata chan string
+	stop     chan struct{}
+	done     chan struct{}
+
+	mu         sync.Mutex // Guards the fields below and the audio channel against Stop
+	stopped    bool
+	status     Status
+	connected  time.Time
+	nowPlaying string
+}
+
+// New starts a broadcaster streaming sampleRate audio as described by cfg.
+func New(cfg Config, sampleRate int) (*Broadcaster, error) {
+	if cfg.Encoder == "" {
+		cfg.Encoder = "ffmpeg"
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	b := newBroadcaster(cfg, sampleRate)
+	go b.run()
+	return b, nil
+}
+
+// newBroadcaster creates a broadcaster that encodes with cfg.Encoder, without connecting yet.
+func newBroadcaster(cfg Config, sampleRate int) *Broadcaster {
+	b := &Broadcaster{
+		cfg:        cfg,
+		sampleRate: sampleRate,
+		audio:      make(chan []byte, queueLength),
+		free:       make(chan []byte, queueLength),
+		metadata:   make(chan string, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	b.command = func(song string) *exec.Cmd {
+		return exec.Command(b.cfg.Encoder, b.encoderArgs(song)...)
+	}
+	return b
+}
+
+// Write queues samples for the stream. It is called on the audio thread and never blocks;
+// while the broadcaster is offline or behind, the buffer is dropped.
+func (b *Broadcaster) Write(samples [][2]float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped || b.status.State != Live {
+		return
+	}
+
+	var buf []byte
+	select {
+	case buf = <-b.free:
+	default:
+	}
+	if cap(buf) < len(samples)*4 {
+		buf = make([]byte, len(samples)*4)
+	}
+	buf = buf[:len(samples)*4]
+	for i, frame := range samples {
+		binary.LittleEndian.PutUint16(buf[i*4:], uint16(toInt16(frame[0])))
+		binary.LittleEndian.PutUint16(buf[i*4+2:], uint16(toInt16(frame[1])))
+	}
+	select {
+	case b.audio <- buf:
+	default:
+	}
+}
+
+// toInt16 converts a sample from -1..1 to 16-bit, clipping anything beyond full scale.
+func toInt16(v float64) int16 {
+	return int16(math.Round(math.Max(-1, math.Min(1, v)) * math.MaxInt16))
+}
+
+// SetNowPlaying announces the track on air to the server's listeners.
+func (b *Broadcaster) SetNowPlaying(artist, title string) {
+	song := title
+	if artist != "" {
+		song = artist + " - " + title
+	}
+	b.mu.Lock()
+	b.nowPlaying = song
+	b.mu.Unlock()
+
+	// Only the newest title matters, so replace one that has not been sent yet.
+	select {
+	case <-b.metadata:
+	default:
+	}
+	select {
+	case b.metadata <- song:
+	default:
+	}
+}
+
+// Status returns the connection state of the broadcaster.
+func (b *Broadcaster) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := b.status
+	if status.State == Live {
+		status.Uptime = time.Since(b.connected)
+	}
+	return status
+}
+
+// Stop ends the stream and disconnects from the server.
+func (b *Broadcaster) Stop() {
+	b.mu.Lock()
+	if b.stopped {
+		b.mu.Unlock()
+		return
+	}
+	b.stopped = true
+	b.mu.Unlock()
+
+	close(b.stop)
+	<-b.done
+	b.setState(Stopped, nil)
+	logger.Logger.Println("Broadcast stopped.")
+}
+
+// errStopped ends a session when the broadcaster is stopped.
+var errStopped = errors.New("broadcast stopped")
+
+// run streams sessions until the broadcaster is stopped, waiting longer after each failure.
+func (b *Broadcaster) run() {
+	defer close(b.done)
+	backoff := minBackoff
+	for {
+		b.setState(Connecting, b.Status().Err)
+		started := time.Now()
+		err := b.session()
+		if errors.Is(err, errStopped) {
+			return
+		}
+		if time.Since(started) > stableSession {
+			backoff = minBackoff
+			b.mu.Lock()
+			b.status.Retries = 0
+			b.mu.Unlock()
+		}
+		b.mu.Lock()
+		b.status.Retries++
+		b.mu.Unlock()
+		b.setState(Waiting, err)
+		logger.Logger.Printf("Broadcast: %v; reconnecting in %s", err, backoff)
+
+		select {
+		case <-b.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// setState records the connection state and the error that caused it.
+func (b *Broadcaster) setState(state State, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if state == Live {
+		b.connected = time.Now()
+		b.status.Sent = 0
+	}
+	b.status.State = state
+	b.status.Err = err
+}
+
+// session connects to the server and streams until the connection fails or the broadcaster
+// is stopped.
+func (b *Broadcaster) session() error {
+	conn, err := b.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	logger.Logger.Printf("Broadcast: live on %s:%d%s", b.cfg.Host, b.cfg.Port, b.cfg.Mount)
+
+	// The title current now is sent with the new stream, not again from the queue.
+	select {
+	case <-b.metadata:
+	default:
+	}
+	b.mu.Lock()
+	song := b.nowPlaying
+	b.mu.Unlock()
+	enc, err := b.startEncoder(conn, song)
+	if err != nil {
+		return err
+	}
+	b.drainAudio()
+	b.setState(Live, nil)
+	if song != "" && b.cfg.Codec == MP3 {
+		// Ogg streams carry the title they started with in their tags.
+		go b.updateMetadata(song)
+	}
+
+	for {
+		select {
+		case <-b.stop:
+			enc.close()
+			return errStopped
+		case err := <-enc.failed:
+			enc.close()
+			return err
+		case buf := <-b.audio:
+			_, err := enc.stdin.Write(buf)
+			select {
+			case b.free <- buf:
+			default:
+			}
+			if err != nil {
+				enc.close()
+				return fmt.Errorf("encoder stopped: %w", err)
+			}
+		case song := <-b.metadata:
+			if b.cfg.Codec == MP3 {
+				go b.updateMetadata(song)
+				continue
+			}
+			// Icecast refuses admin metadata updates for Ogg streams, which carry their title
+			// in their own tags. The encoder is restarted to chain a new stream tagged with
+			// the title onto the connection; audio queues meanwhile, so none is dropped.
+			if err := enc.close(); err != nil {
+				return err
+			}
+			if enc, err = b.startEncoder(conn, song); err != nil {
+				return err
+			}
+			logger.Logger.Printf("Broadcast: now playing '%s'", song)
+		}
+	}
+}
+
+// drainAudio discards audio queued before the connection was made.
+func (b *Broadcaster) drainAudio() {
+	for {
+		select {
+		case <-b.audio:
+		default:
+			return
+		}
+	}
+}
+
+// countSent adds n encoded bytes to the status.
+func (b *Broadcaster) countSent(n int) {
+	b.mu.Lock()
+	b.status.Sent += int64(n)
+	b.mu.Unlock()
+}
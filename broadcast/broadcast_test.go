@@ -0,0 +1,549 @@
+package broadcast
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"megajam/logger"
+)
+
+// fakeEncoderEnv makes the test binary copy its input to its output in place of an encoder,
+// after a line with the title it was given to tag an Ogg stream with.
+const fakeEncoderEnv = "MEGAJAM_FAKE_ENCODER"
+
+// waitTimeout bounds every wait for the broadcaster or the fake servers.
+const waitTimeout = 5 * time.Second
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeEncoderEnv) == "1" {
+		// The tags come first, as in the header pages of an Ogg stream.
+		for i := 2; i < len(os.Args); i++ {
+			if os.Args[i-1] == "-metadata" && strings.HasPrefix(os.Args[i], "title=") {
+				io.WriteString(os.Stdout, os.Args[i]+"\n")
+			}
+		}
+		io.Copy(os.Stdout, os.Stdin)
+		os.Exit(0)
+	}
+	logger.Logger = log.New(io.Discard, "", 0)
+	os.Exit(m.Run())
+}
+
+// startBroadcaster starts a broadcaster for cfg whose encoder passes the PCM through unchanged,
+// given the encoder arguments of cfg, and counts how often an encoder is started.
+func startBroadcaster(t *testing.T, cfg Config) (*Broadcaster, *atomic.Int32) {
+	t.Helper()
+	if cfg.Bitrate == 0 {
+		cfg.Bitrate = 128
+	}
+	if cfg.Codec == "" {
+		cfg.Codec = MP3
+	}
+	cfg.Encoder = os.Args[0]
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	starts := new(atomic.Int32)
+	b := newBroadcaster(cfg, 44100)
+	b.command = func(song string) *exec.Cmd {
+		starts.Add(1)
+		cmd := exec.Command(os.Args[0], b.encoderArgs(song)...)
+		cmd.Env = append(os.Environ(), fakeEncoderEnv+"=1")
+		return cmd
+	}
+	go b.run()
+	t.Cleanup(b.Stop)
+	return b, starts
+}
+
+// waitForState waits until the broadcaster reaches state and returns its status.
+func waitForState(t *testing.T, b *Broadcaster, state State) Status {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		status := b.Status()
+		if status.State == state {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("state %s, want %s (last error: %v)", status.State, state, status.Err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// receive waits for a value from ch.
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(waitTimeout):
+		t.Fatalf("no %s received", what)
+		panic("unreachable")
+	}
+}
+
+// testSamples returns audio whose 16-bit encoding is easy to recognise in the stream.
+func testSamples() ([][2]float64, []byte) {
+	samples := make([][2]float64, 256)
+	pcm := make([]byte, 4*len(samples))
+	for i := range samples {
+		l, r := int16(i*100), int16(-i*100)
+		samples[i] = [2]float64{float64(l) / 32767, float64(r) / 32767}
+		binary.LittleEndian.PutUint16(pcm[4*i:], uint16(l))
+		binary.LittleEndian.PutUint16(pcm[4*i+2:], uint16(r))
+	}
+	return samples, pcm
+}
+
+// expectStream writes audio to the live broadcaster and checks that it reaches the server.
+func expectStream(t *testing.T, b *Broadcaster, received *bytes.Buffer, mu *sync.Mutex) {
+	t.Helper()
+	samples, pcm := testSamples()
+	b.Write(samples)
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		mu.Lock()
+		got := append([]byte(nil), received.Bytes()...)
+		mu.Unlock()
+		if len(got) >= len(pcm) {
+			if !bytes.Equal(got[:len(pcm)], pcm) {
+				t.Fatalf("server received %x..., want %x...", got[:16], pcm[:16])
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server received %d bytes of %d", len(got), len(pcm))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// fakeIcecast is an Icecast server taking one source at a time on its mount.
+type fakeIcecast struct {
+	*httptest.Server
+	t        *testing.T
+	mount    string
+	user     string
+	password string
+
+	mu       sync.Mutex
+	refuse   int         // Source connections still to refuse
+	connects []time.Time // When each source connection was made
+	source   net.Conn    // The connected source
+	ogg      bool        // Whether the source streams Ogg, whose metadata cannot be updated
+	received bytes.Buffer
+
+	sources  chan *http.Request // Each accepted source request
+	metadata chan url.Values    // Each metadata update request
+}
+
+func newFakeIcecast(t *testing.T) *fakeIcecast {
+	s := &fakeIcecast{
+		t:        t,
+		mount:    "/live",
+		user:     "source",
+		password: "hackme",
+		sources:  make(chan *http.Request, 10),
+		metadata: make(chan url.Values, 10),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// config returns the broadcast configuration for the server's mount.
+func (s *fakeIcecast) config() Config {
+	addr := s.Listener.Addr().(*net.TCPAddr)
+	return Config{Protocol: Icecast, Host: "127.0.0.1", Port: addr.Port, Mount: s.mount,
+		User: s.user, Password: s.password, Name: "Megajam Radio"}
+}
+
+func (s *fakeIcecast) serve(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+	if !ok || user != s.user || password != s.password {
+		http.Error(w, "Authentication Required", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/admin/metadata":
+		s.metadata <- r.URL.Query()
+		s.mu.Lock()
+		ogg := s.ogg
+		s.mu.Unlock()
+		if ogg {
+			// Like Icecast, which takes titles only from the tags of Ogg streams.
+			http.Error(w, "Mountpoint will not accept URL updates", http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, "<iceresponse><message>Metadata update successful</message><return>1</return></iceresponse>")
+	case r.Method == http.MethodPut && r.URL.Path == s.mount:
+		s.mu.Lock()
+		s.connects = append(s.connects, time.Now())
+		refuse := s.refuse > 0
+		if refuse {
+			s.refuse--
+		}
+		s.mu.Unlock()
+		if refuse {
+			http.Error(w, "Mountpoint in use", http.StatusForbidden)
+			return
+		}
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			s.t.Error(err)
+			return
+		}
+		rw.WriteString("HTTP/1.1 200 OK\r\n\r\n")
+		rw.Flush()
+		s.mu.Lock()
+		s.source = conn
+		s.ogg = r.Header.Get("Content-Type") == "audio/ogg"
+		s.received.Reset()
+		s.mu.Unlock()
+		s.sources <- r
+		go s.read(rw.Reader, conn)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// read collects the stream sent by a source until it disconnects.
+func (s *fakeIcecast) read(r io.Reader, conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		s.mu.Lock()
+		if s.source == conn {
+			s.received.Write(buf[:n])
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// dropSource disconnects the connected source.
+func (s *fakeIcecast) dropSource() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source.Close()
+	s.source = nil
+}
+
+func TestIcecastSource(t *testing.T) {
+	server := newFakeIcecast(t)
+	b, _ := startBroadcaster(t, server.config())
+
+	r := receive(t, server.sources, "source connection")
+	if user, password, _ := r.BasicAuth(); user != "source" || password != "hackme" {
+		t.Errorf("credentials = %q, %q", user, password)
+	}
+	if got := r.Header.Get("Content-Type"); got != "audio/mpeg" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := r.Header.Get("Ice-Name"); got != "Megajam Radio" {
+		t.Errorf("Ice-Name = %q", got)
+	}
+	if got := r.Header.Get("Ice-Audio-Info"); !strings.Contains(got, "ice-samplerate=44100") {
+		t.Errorf("Ice-Audio-Info = %q", got)
+	}
+
+	waitForState(t, b, Live)
+	expectStream(t, b, &server.received, &server.mu)
+
+	b.SetNowPlaying("Artist", "Title")
+	query := receive(t, server.metadata, "metadata update")
+	if query.Get("mode") != "updinfo" || query.Get("mount") != "/live" || query.Get("song") != "Artist - Title" {
+		t.Errorf("metadata query = %v", query)
+	}
+}
+
+func TestIcecastRefusal(t *testing.T) {
+	server := newFakeIcecast(t)
+	cfg := server.config()
+	cfg.Password = "wrong"
+	b, starts := startBroadcaster(t, cfg)
+
+	status := waitForState(t, b, Waiting)
+	if status.Err == nil || !strings.Contains(status.Err.Error(), "refused the stream: 401") {
+		t.Errorf("error = %v, want the refusal", status.Err)
+	}
+	if status.Retries != 1 {
+		t.Errorf("retries = %d, want 1", status.Retries)
+	}
+	if starts.Load() != 0 {
+		t.Error("encoder started for a refused stream")
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	defer func(min, max time.Duration) { minBackoff, maxBackoff = min, max }(minBackoff, maxBackoff)
+	minBackoff, maxBackoff = 50*time.Millisecond, 200*time.Millisecond
+
+	server := newFakeIcecast(t)
+	server.refuse = 2
+	b, _ := startBroadcaster(t, server.config())
+
+	receive(t, server.sources, "source connection")
+	if status := waitForState(t, b, Live); status.Retries != 2 {
+		t.Errorf("retries = %d, want 2", status.Retries)
+	}
+	server.mu.Lock()
+	connects := append([]time.Time(nil), server.connects...)
+	server.mu.Unlock()
+	if len(connects) != 3 {
+		t.Fatalf("%d connections, want 3", len(connects))
+	}
+	for i, want := range []time.Duration{minBackoff, 2 * minBackoff} {
+		if gap := connects[i+1].Sub(connects[i]); gap < want {
+			t.Errorf("reconnected after %v, want at least %v", gap, want)
+		}
+	}
+
+	// A dropped connection is noticed when the stream is next sent, and made again.
+	server.dropSource()
+	samples, _ := testSamples()
+	deadline := time.After(waitTimeout)
+	for reconnected := false; !reconnected; {
+		b.Write(samples)
+		select {
+		case <-server.sources:
+			reconnected = true
+		case <-deadline:
+			t.Fatal("no source reconnection received")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	waitForState(t, b, Live)
+	expectStream(t, b, &server.received, &server.mu)
+}
+
+func TestOpusTitlesChainStreams(t *testing.T) {
+	server := newFakeIcecast(t)
+	cfg := server.config()
+	cfg.Codec = Opus
+	b, starts := startBroadcaster(t, cfg)
+
+	r := receive(t, server.sources, "source connection")
+	if got := r.Header.Get("Content-Type"); got != "audio/ogg" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	waitForState(t, b, Live)
+
+	// Each title restarts the encoder on the same connection, so that a new stream carrying
+	// the title in its tags follows the audio of the last.
+	samples, pcm := testSamples()
+	var want []byte
+	for _, title := range []string{"One", "Two"} {
+		b.SetNowPlaying("", title)
+		want = append(want, "title="+title+"\n"...)
+		waitForReceived(t, server, want)
+		b.Write(samples)
+		want = append(want, pcm...)
+		waitForReceived(t, server, want)
+	}
+	if n := starts.Load(); n != 3 {
+		t.Errorf("encoder started %d times, want 3", n)
+	}
+	if n := len(server.sources); n != 0 {
+		t.Errorf("%d more source connections, want the stream kept", n)
+	}
+	if n := len(server.metadata); n != 0 {
+		t.Errorf("%d metadata updates sent for an Ogg stream", n)
+	}
+}
+
+// waitForReceived waits until the server has received want over the current source connection.
+func waitForReceived(t *testing.T, s *fakeIcecast, want []byte) {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		s.mu.Lock()
+		got := append([]byte(nil), s.received.Bytes()...)
+		s.mu.Unlock()
+		if len(got) >= len(want) {
+			if !bytes.Equal(got, want) {
+				t.Fatalf("server received %q, want %q", got, want)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server received %q, want %q", got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// fakeShoutcast is a SHOUTcast v1 server: sources connect to the port after the listener
+// port, where the admin interface runs.
+type fakeShoutcast struct {
+	admin    *httptest.Server
+	source   net.Listener
+	password string
+
+	mu       sync.Mutex
+	received bytes.Buffer
+
+	headers  chan map[string]string // Stream headers of each accepted source
+	refusals chan string            // Each wrong password
+	metadata chan url.Values
+}
+
+func newFakeShoutcast(t *testing.T) *fakeShoutcast {
+	s := &fakeShoutcast{
+		password: "changeme",
+		headers:  make(chan map[string]string, 10),
+		refusals: make(chan string, 10),
+		metadata: make(chan url.Values, 10),
+	}
+	// The source port must follow the admin port.
+	for attempt := 0; s.source == nil; attempt++ {
+		admin, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := admin.Addr().(*net.TCPAddr).Port
+		source, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port+1)))
+		if err != nil {
+			admin.Close()
+			if attempt == 20 {
+				t.Fatal(err)
+			}
+			continue
+		}
+		s.admin = httptest.NewUnstartedServer(http.HandlerFunc(s.serveAdmin))
+		s.admin.Listener.Close()
+		s.admin.Listener = admin
+		s.admin.Start()
+		s.source = source
+	}
+	t.Cleanup(func() {
+		s.source.Close()
+		s.admin.Close()
+	})
+	go s.accept()
+	return s
+}
+
+func (s *fakeShoutcast) config() Config {
+	return Config{Protocol: Shoutcast, Host: "127.0.0.1", Port: s.admin.Listener.Addr().(*net.TCPAddr).Port,
+		Password: s.password, Name: "Megajam Radio"}
+}
+
+func (s *fakeShoutcast) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/admin.cgi" || r.URL.Query().Get("pass") != s.password {
+		http.NotFound(w, r)
+		return
+	}
+	s.metadata <- r.URL.Query()
+	io.WriteString(w, "<html><body>Updated</body></html>")
+}
+
+func (s *fakeShoutcast) accept() {
+	for {
+		conn, err := s.source.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveSource(conn)
+	}
+}
+
+func (s *fakeShoutcast) serveSource(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	password, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	if password = strings.TrimSpace(password); password != s.password {
+		io.WriteString(conn, "invalid password\r\n")
+		s.refusals <- password
+		return
+	}
+	io.WriteString(conn, "OK2\r\nicy-caps:11\r\n\r\n")
+
+	headers := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			break
+		}
+		name, value, _ := strings.Cut(line, ":")
+		headers[name] = value
+	}
+	s.headers <- headers
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		s.mu.Lock()
+		s.received.Write(buf[:n])
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestShoutcastSource(t *testing.T) {
+	server := newFakeShoutcast(t)
+	b, _ := startBroadcaster(t, server.config())
+	b.SetNowPlaying("Artist", "Opening")
+
+	headers := receive(t, server.headers, "stream headers")
+	if headers["icy-name"] != "Megajam Radio" || headers["content-type"] != "audio/mpeg" || headers["icy-br"] != "128" {
+		t.Errorf("headers = %v", headers)
+	}
+	waitForState(t, b, Live)
+	if query := receive(t, server.metadata, "metadata update"); query.Get("mode") != "updinfo" || query.Get("song") != "Artist - Opening" {
+		t.Errorf("metadata query = %v", query)
+	}
+	expectStream(t, b, &server.received, &server.mu)
+}
+
+func TestShoutcastRefusal(t *testing.T) {
+	server := newFakeShoutcast(t)
+	cfg := server.config()
+	cfg.Password = "wrong"
+	b, _ := startBroadcaster(t, cfg)
+
+	if got := receive(t, server.refusals, "refused password"); got != "wrong" {
+		t.Errorf("password = %q", got)
+	}
+	status := waitForState(t, b, Waiting)
+	if status.Err == nil || !strings.Contains(status.Err.Error(), "invalid password") {
+		t.Errorf("error = %v, want the refusal", status.Err)
+	}
+}
+
+func TestNewRequiresEncoder(t *testing.T) {
+	cfg := Config{Protocol: Icecast, Host: "localhost", Port: 8000, Mount: "/live", Codec: MP3,
+		Bitrate: 128, Encoder: "megajam-missing-encoder"}
+	if _, err := New(cfg, 44100); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("New() error = %v, want the missing encoder", err)
+	}
+}
@@ -0,0 +1,134 @@
+package broadcast
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"megajam/logger"
+)
+
+// encoderProcess is a running ffmpeg or lame process whose output is copied to the server.
+type encoderProcess struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	failed  chan error    // Receives why the output could not be sent
+	copied  chan struct{} // Closed when all output has been copied
+	closing atomic.Bool
+	err     error // Copy error, read after copied is closed
+}
+
+// isLame reports whether the encoder executable is lame rather than ffmpeg.
+func isLame(encoder string) bool {
+	name := strings.TrimSuffix(strings.ToLower(filepath.Base(encoder)), ".exe")
+	return name == "lame"
+}
+
+// encoderArgs returns the command line arguments that encode 16-bit stereo PCM from standard
+// input to the stream format on standard output. Ogg streams are tagged with song.
+func (b *Broadcaster) encoderArgs(song string) []string {
+	bitrate := strconv.Itoa(b.cfg.Bitrate)
+	if isLame(b.cfg.Encoder) {
+		kHz := strconv.FormatFloat(float64(b.sampleRate)/1000, 'f', -1, 64)
+		return []string{"-r", "-s", kHz, "--bitwidth", "16", "--signed", "--little-endian",
+			"-b", bitrate, "--cbr", "--quiet", "-", "-"}
+	}
+
+	args := []string{"-hide_banner", "-loglevel", "error",
+		"-f", "s16le", "-ar", strconv.Itoa(b.sampleRate), "-ac", "2", "-i", "pipe:0"}
+	switch b.cfg.Codec {
+	case Opus:
+		// Opus runs at 48 kHz whatever the mixer rate.
+		args = append(args, "-c:a", "libopus", "-b:a", bitrate+"k", "-ar", "48000")
+		if song != "" {
+			args = append(args, "-metadata", "title="+song)
+		}
+		if b.cfg.Name != "" {
+			args = append(args, "-metadata", "album="+b.cfg.Name)
+		}
+		args = append(args, "-f", "ogg")
+	default:
+		args = append(args, "-c:a", "libmp3lame", "-b:a", bitrate+"k", "-f", "mp3")
+	}
+	return append(args, "pipe:1")
+}
+
+// startEncoder starts an encoder whose output is sent over conn.
+func (b *Broadcaster) startEncoder(conn net.Conn, song string) (*encoderProcess, error) {
+	cmd := b.command(song)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start encoder: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start encoder: %w", err)
+	}
+	cmd.Stderr = encoderLog{}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start encoder '%s': %w", b.cfg.Encoder, err)
+	}
+
+	e := &encoderProcess{cmd: cmd, stdin: stdin, failed: make(chan error, 1), copied: make(chan struct{})}
+	go e.copy(b, stdout, conn)
+	return e, nil
+}
+
+// copy sends the encoder's output over conn until the encoder exits or the connection fails.
+func (e *encoderProcess) copy(b *Broadcaster, stdout io.Reader, conn net.Conn) {
+	defer close(e.copied)
+	buf := make([]byte, 16*1024)
+	for {
+		n, err := stdout.Read(buf)
+		if n > 0 {
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if _, err := conn.Write(buf[:n]); err != nil {
+				e.fail(fmt.Errorf("connection lost: %w", err))
+				return
+			}
+			b.countSent(n)
+		}
+		if errors.Is(err, io.EOF) {
+			if !e.closing.Load() {
+				e.fail(errors.New("encoder exited unexpectedly"))
+			}
+			return
+		}
+		if err != nil {
+			e.fail(fmt.Errorf("failed to read encoder output: %w", err))
+			return
+		}
+	}
+}
+
+// fail reports err and kills the encoder, so that writes to it no longer block.
+func (e *encoderProcess) fail(err error) {
+	e.err = err
+	e.failed <- err
+	e.cmd.Process.Kill()
+}
+
+// close ends the encoder's input, waits for its remaining output to be sent and returns any
+// error sending it.
+func (e *encoderProcess) close() error {
+	e.closing.Store(true)
+	e.stdin.Close()
+	<-e.copied
+	e.cmd.Wait()
+	return e.err
+}
+
+// encoderLog writes the encoder's error output to the log.
+type encoderLog struct{}
+
+func (encoderLog) Write(p []byte) (int, error) {
+	logger.Logger.Printf("Broadcast encoder: %s", strings.TrimSpace(string(p)))
+	return len(p), nil
+}
@@ -0,0 +1,146 @@
+package broadcast
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"megajam/logger"
+)
+
+// dialTimeout is how long connecting to the server may take.
+const dialTimeout = 10 * time.Second
+
+// userAgent identifies the source client; SHOUTcast only answers admin requests from browsers.
+const userAgent = "Mozilla/5.0 (compatible; Megajam)"
+
+// contentType returns the MIME type of the stream.
+func (c Config) contentType() string {
+	if c.Codec == Opus {
+		return "audio/ogg"
+	}
+	return "audio/mpeg"
+}
+
+// connect opens a source connection to the server and completes its handshake, leaving the
+// connection ready for the encoded stream.
+func (b *Broadcaster) connect() (net.Conn, error) {
+	port := b.cfg.Port
+	if b.cfg.Protocol == Shoutcast {
+		port++
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(b.cfg.Host, strconv.Itoa(port)), dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if b.cfg.Protocol == Shoutcast {
+		err = b.shoutcastHandshake(conn)
+	} else {
+		err = b.icecastHandshake(conn)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// icecastHandshake starts a PUT request to the mount, whose body is the stream.
+func (b *Broadcaster) icecastHandshake(conn net.Conn) error {
+	credentials := base64.StdEncoding.EncodeToString([]byte(b.cfg.User + ":" + b.cfg.Password))
+	request := fmt.Sprintf("PUT %s HTTP/1.1\r\n", b.cfg.Mount) +
+		fmt.Sprintf("Host: %s\r\n", net.JoinHostPort(b.cfg.Host, strconv.Itoa(b.cfg.Port))) +
+		fmt.Sprintf("Authorization: Basic %s\r\n", credentials) +
+		fmt.Sprintf("User-Agent: %s\r\n", userAgent) +
+		fmt.Sprintf("Content-Type: %s\r\n", b.cfg.contentType()) +
+		fmt.Sprintf("Ice-Name: %s\r\n", b.cfg.Name) +
+		"Ice-Public: 0\r\n" +
+		fmt.Sprintf("Ice-Audio-Info: ice-bitrate=%d;ice-channels=2;ice-samplerate=%d\r\n", b.cfg.Bitrate, b.sampleRate) +
+		"Expect: 100-continue\r\n\r\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return fmt.Errorf("failed to send stream request: %w", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return fmt.Errorf("no response from server: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusContinue && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server refused the stream: %s", resp.Status)
+	}
+	return nil
+}
+
+// shoutcastHandshake logs in with the password and sends the stream headers.
+func (b *Broadcaster) shoutcastHandshake(conn net.Conn) error {
+	if _, err := conn.Write([]byte(b.cfg.Password + "\r\n")); err != nil {
+		return fmt.Errorf("failed to send password: %w", err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("no response from server: %w", err)
+	}
+	if reply = strings.TrimSpace(reply); !strings.HasPrefix(reply, "OK") {
+		return fmt.Errorf("server refused the stream: %s", reply)
+	}
+
+	headers := fmt.Sprintf("icy-name:%s\r\n", b.cfg.Name) +
+		"icy-pub:0\r\n" +
+		fmt.Sprintf("icy-br:%d\r\n", b.cfg.Bitrate) +
+		fmt.Sprintf("content-type:%s\r\n\r\n", b.cfg.contentType())
+	if _, err := conn.Write([]byte(headers)); err != nil {
+		return fmt.Errorf("failed to send stream headers: %w", err)
+	}
+	return nil
+}
+
+// updateMetadata sets the title the server shows for the stream, logging any failure.
+func (b *Broadcaster) updateMetadata(song string) {
+	if err := b.sendMetadata(song); err != nil {
+		logger.Logger.Printf("Broadcast: failed to update now playing: %v", err)
+		return
+	}
+	logger.Logger.Printf("Broadcast: now playing '%s'", song)
+}
+
+// sendMetadata sets the title of an MP3 stream through the server's admin interface.
+func (b *Broadcaster) sendMetadata(song string) error {
+	u := url.URL{Scheme: "http", Host: net.JoinHostPort(b.cfg.Host, strconv.Itoa(b.cfg.Port))}
+	query := url.Values{"mode": {"updinfo"}, "song": {song}}
+	if b.cfg.Protocol == Shoutcast {
+		u.Path = "/admin.cgi"
+		query.Set("pass", b.cfg.Password)
+	} else {
+		u.Path = "/admin/metadata"
+		query.Set("mount", b.cfg.Mount)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if b.cfg.Protocol == Icecast {
+		req.SetBasicAuth(b.cfg.User, b.cfg.Password)
+	}
+	client := http.Client{Timeout: writeTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server answered %s", resp.Status)
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"image/color"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
 )
@@ -25,12 +26,47 @@ type LayoutConfig struct {
 	WindowHeight int `json:"window_height"`
 }
 
+type LibraryConfig struct {
+	Paths       []string `json:"paths"`        // Root folders scanned for music
+	ScanWorkers int      `json:"scan_workers"` // Number of files read concurrently
+}
+
+type AudioConfig struct {
+	SampleRate   int    `json:"sample_rate"`   // Output sample rate in Hz
+	BufferMillis int    `json:"buffer_millis"` // Output buffer length; lower is more responsive
+	Decks        int    `json:"decks"`         // Number of independent decks (2-4)
+	CueOutput    string `json:"cue_output"`    // "off", or "split" for master left and headphone cue right on the one output device
+}
+
+type RecordingConfig struct {
+	Dir    string `json:"dir"`    // Folder the master mix is recorded to
+	Format string `json:"format"` // "wav" or "flac"
+}
+
+type BroadcastConfig struct {
+	Protocol string `json:"protocol"` // "icecast" or "shoutcast"
+	Host     string `json:"host"`
+	Port     int    `json:"port"`  // Listener port of the server
+	Mount    string `json:"mount"` // Icecast mount point, such as "/live"
+	User     string `json:"user"`  // Icecast source user
+	Password string `json:"password"`
+	Codec    string `json:"codec"`   // "mp3" or "opus"
+	Bitrate  int    `json:"bitrate"` // Kilobits per second
+	Name     string `json:"name"`    // Station name shown by the server
+	Encoder  string `json:"encoder"` // Path of the ffmpeg or lame executable
+}
+
 type AppConfig struct {
-	DatabasePath string       `json:"database_path"`
-	ThemeName    string       `json:"theme_name"`
-	Mode         string       `json:"mode"` // "party" or "hardcore"
-	Theme        ThemeConfig  `json:"theme"`
-	Layout       LayoutConfig `json:"layout"`
+	DatabasePath string          `json:"database_path"`
+	CacheDir     string          `json:"cache_dir"` // Sidecar files with analysis results
+	ThemeName    string          `json:"theme_name"`
+	Mode         string          `json:"mode"` // "party" or "hardcore"
+	Theme        ThemeConfig     `json:"theme"`
+	Layout       LayoutConfig    `json:"layout"`
+	Library      LibraryConfig   `json:"library"`
+	Audio        AudioConfig     `json:"audio"`
+	Recording    RecordingConfig `json:"recording"`
+	Broadcast    BroadcastConfig `json:"broadcast"`
 }
 
 var configMutex sync.Mutex // Mutex for thread-safe operations
@@ -121,9 +157,57 @@ func applyDefaults(config *AppConfig) {
 	if config.DatabasePath == "" {
 		config.DatabasePath = "music_library.db" // Default database path
 	}
+	if config.CacheDir == "" {
+		config.CacheDir = "cache" // Default analysis cache folder
+	}
 	if config.Mode == "" {
 		config.Mode = "party" // Default mode
 	}
+	if config.Audio.SampleRate <= 0 {
+		config.Audio.SampleRate = 44100
+	}
+	if config.Audio.BufferMillis <= 0 {
+		config.Audio.BufferMillis = 100
+	}
+	if config.Audio.Decks <= 0 {
+		config.Audio.Decks = 2
+	}
+	if config.Audio.CueOutput == "" {
+		config.Audio.CueOutput = "off"
+	}
+	if config.Recording.Dir == "" {
+		config.Recording.Dir = "recordings"
+	}
+	if config.Recording.Format == "" {
+		config.Recording.Format = "wav"
+	}
+	if config.Broadcast.Protocol == "" {
+		config.Broadcast.Protocol = "icecast"
+	}
+	if config.Broadcast.Port <= 0 {
+		config.Broadcast.Port = 8000
+	}
+	if config.Broadcast.Mount == "" {
+		config.Broadcast.Mount = "/live"
+	}
+	if config.Broadcast.User == "" {
+		config.Broadcast.User = "source"
+	}
+	if config.Broadcast.Codec == "" {
+		config.Broadcast.Codec = "mp3"
+	}
+	if config.Broadcast.Bitrate <= 0 {
+		config.Broadcast.Bitrate = 128
+	}
+	if config.Broadcast.Name == "" {
+		config.Broadcast.Name = "Megajam"
+	}
+	if config.Broadcast.Encoder == "" {
+		config.Broadcast.Encoder = "ffmpeg"
+	}
+	if config.Library.ScanWorkers <= 0 {
+		config.Library.ScanWorkers = runtime.NumCPU()
+	}
 }
 
 // ValidateConfig checks if the configuration is valid.
@@ -135,6 +219,22 @@ func ValidateConfig(config *AppConfig) error {
 		return fmt.Errorf("invalid mode '%s' in config: must be 'party' or 'hardcore'", config.Mode)
 	}
 
+	if config.Audio.Decks < 2 || config.Audio.Decks > 4 {
+		return fmt.Errorf("invalid deck count %d in config: must be between 2 and 4", config.Audio.Decks)
+	}
+	if config.Audio.CueOutput != "off" && config.Audio.CueOutput != "split" {
+		return fmt.Errorf("invalid cue output '%s' in config: must be 'off' or 'split'", config.Audio.CueOutput)
+	}
+	if !strings.EqualFold(config.Recording.Format, "wav") && !strings.EqualFold(config.Recording.Format, "flac") {
+		return fmt.Errorf("invalid recording format '%s' in config: must be 'wav' or 'flac'", config.Recording.Format)
+	}
+	if config.Broadcast.Protocol != "icecast" && config.Broadcast.Protocol != "shoutcast" {
+		return fmt.Errorf("invalid broadcast protocol '%s' in config: must be 'icecast' or 'shoutcast'", config.Broadcast.Protocol)
+	}
+	if config.Broadcast.Codec != "mp3" && config.Broadcast.Codec != "opus" {
+		return fmt.Errorf("invalid broadcast codec '%s' in config: must be 'mp3' or 'opus'", config.Broadcast.Codec)
+	}
+
 	// Check if the selected mode is allowed by the theme
 	modeAllowed := false
 	for _, allowedMode := range config.Theme.AllowedModes {
@@ -3,6 +3,9 @@ package db
 import (
 	"fmt"
 	"megajam/logger"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -29,30 +32,390 @@ func InitDatabase(databasePath string) {
 
 func migrateDB() error {
 	logger.Logger.Println("DB Migration starting..")
-	return DB.AutoMigrate(&Track{}, &Playlist{}, &Crate{}, &CuePoint{}, &Loop{})
+	return DB.AutoMigrate(&Track{}, &Playlist{}, &Crate{}, &CuePoint{}, &Loop{}, &Beatgrid{})
 }
 
 func AddCuePoint(trackID uint, name string, time float64) error {
-	cuePoint := CuePoint{TrackID: trackID, Name: name, Time: time}
+	cuePoint := CuePoint{TrackID: trackID, Name: name, Time: time, SortIndex: nextSortIndex(&CuePoint{}, trackID)}
 	return DB.Create(&cuePoint).Error
 }
 
 func GetCuePoints(trackID uint) ([]CuePoint, error) {
 	var cuePoints []CuePoint
-	err := DB.Where("track_id = ?", trackID).Find(&cuePoints).Error
+	err := DB.Where("track_id = ?", trackID).Order("sort_index, time").Find(&cuePoints).Error
 	return cuePoints, err
 }
 
+// UpdateCuePoint saves changes to the name, time, pad and colour of a cue point.
+func UpdateCuePoint(cuePoint *CuePoint) error {
+	if cuePoint.Time < 0 {
+		return fmt.Errorf("cue time must not be negative")
+	}
+	if cuePoint.Pad < 0 || cuePoint.Pad > HotCuePads {
+		return fmt.Errorf("hot cue pad must be between 1 and %d, or 0 for none", HotCuePads)
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if cuePoint.Pad > 0 {
+			// A pad holds one cue, so the cue moved onto it replaces the one there.
+			err := tx.Where("track_id = ? AND pad = ? AND id <> ?", cuePoint.TrackID, cuePoint.Pad, cuePoint.ID).Delete(&CuePoint{}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(cuePoint).Select("name", "time", "pad", "color").Updates(cuePoint).Error
+	})
+}
+
+// DeleteCuePoint removes a cue point.
+func DeleteCuePoint(id uint) error {
+	return DB.Delete(&CuePoint{}, id).Error
+}
+
+// ClearCuePoints removes every cue point of a track, including its hot cues, and returns how many were removed.
+func ClearCuePoints(trackID uint) (int64, error) {
+	result := DB.Where("track_id = ?", trackID).Delete(&CuePoint{})
+	return result.RowsAffected, result.Error
+}
+
+// ReorderCuePoints sets the list order of a track's cue points to the order of ids.
+func ReorderCuePoints(trackID uint, ids []uint) error {
+	return reorder(&CuePoint{}, trackID, ids)
+}
+
+// GetHotCues returns the cue points of a track assigned to hot cue pads.
+func GetHotCues(trackID uint) ([]CuePoint, error) {
+	var cuePoints []CuePoint
+	err := DB.Where("track_id = ? AND pad > 0", trackID).Order("pad").Find(&cuePoints).Error
+	return cuePoints, err
+}
+
+// SetHotCue stores a cue point on a hot cue pad of a track, replacing the cue previously on that pad.
+func SetHotCue(trackID uint, pad int, name string, time float64, color string) (*CuePoint, error) {
+	if pad < 1 || pad > HotCuePads {
+		return nil, fmt.Errorf("hot cue pad must be between 1 and %d, got %d", HotCuePads, pad)
+	}
+	cuePoint := CuePoint{TrackID: trackID, Name: name, Time: time, Pad: pad, Color: color, SortIndex: nextSortIndex(&CuePoint{}, trackID)}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("track_id = ? AND pad = ?", trackID, pad).Delete(&CuePoint{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&cuePoint).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cuePoint, nil
+}
+
+// DeleteHotCue removes the cue point on a hot cue pad of a track.
+func DeleteHotCue(trackID uint, pad int) error {
+	return DB.Where("track_id = ? AND pad = ?", trackID, pad).Delete(&CuePoint{}).Error
+}
+
+// CreateCuePoint stores a new cue point at the end of the track's cue list. A hot cue replaces
+// the cue previously on its pad.
+func CreateCuePoint(cuePoint *CuePoint) error {
+	if cuePoint.Time < 0 {
+		return fmt.Errorf("cue time must not be negative")
+	}
+	if cuePoint.Pad < 0 || cuePoint.Pad > HotCuePads {
+		return fmt.Errorf("hot cue pad must be between 1 and %d, or 0 for none", HotCuePads)
+	}
+	cuePoint.SortIndex = nextSortIndex(&CuePoint{}, cuePoint.TrackID)
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if cuePoint.Pad > 0 {
+			if err := tx.Where("track_id = ? AND pad = ?", cuePoint.TrackID, cuePoint.Pad).Delete(&CuePoint{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(cuePoint).Error
+	})
+}
+
+// CreateLoop stores a new loop at the end of the track's loop list.
+func CreateLoop(loop *Loop) error {
+	if loop.Start < 0 || loop.Start >= loop.End {
+		return fmt.Errorf("start time must be less than end time")
+	}
+	loop.SortIndex = nextSortIndex(&Loop{}, loop.TrackID)
+	return DB.Create(loop).Error
+}
+
 func AddLoop(trackID uint, name string, start, end float64) error {
 	if start >= end {
 		return fmt.Errorf("start time must be less than end time")
 	}
-	loop := Loop{TrackID: trackID, Name: name, Start: start, End: end}
+	loop := Loop{TrackID: trackID, Name: name, Start: start, End: end, SortIndex: nextSortIndex(&Loop{}, trackID)}
 	return DB.Create(&loop).Error
 }
 
 func GetLoops(trackID uint) ([]Loop, error) {
 	var loops []Loop
-	err := DB.Where("track_id = ?", trackID).Find(&loops).Error
+	err := DB.Where("track_id = ?", trackID).Order("sort_index, start").Find(&loops).Error
 	return loops, err
 }
+
+// UpdateLoop saves changes to the name, times and colour of a loop.
+func UpdateLoop(loop *Loop) error {
+	if loop.Start < 0 || loop.Start >= loop.End {
+		return fmt.Errorf("start time must be less than end time")
+	}
+	return DB.Model(loop).Select("name", "start", "end", "color").Updates(loop).Error
+}
+
+// DeleteLoop removes a loop.
+func DeleteLoop(id uint) error {
+	return DB.Delete(&Loop{}, id).Error
+}
+
+// ClearLoops removes every loop of a track and returns how many were removed.
+func ClearLoops(trackID uint) (int64, error) {
+	result := DB.Where("track_id = ?", trackID).Delete(&Loop{})
+	return result.RowsAffected, result.Error
+}
+
+// ReorderLoops sets the list order of a track's loops to the order of ids.
+func ReorderLoops(trackID uint, ids []uint) error {
+	return reorder(&Loop{}, trackID, ids)
+}
+
+// nextSortIndex returns the list position after the last cue point or loop of a track,
+// depending on model.
+func nextSortIndex(model interface{}, trackID uint) int {
+	var last int
+	DB.Model(model).Where("track_id = ?", trackID).Select("COALESCE(MAX(sort_index), -1)").Scan(&last)
+	return last + 1
+}
+
+// reorder numbers the cue points or loops of a track, depending on model, in the order of ids.
+func reorder(model interface{}, trackID uint, ids []uint) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for i, id := range ids {
+			if err := tx.Model(model).Where("id = ? AND track_id = ?", id, trackID).Update("sort_index", i).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// TrackFileState is the scan bookkeeping stored for a track's file on disk.
+type TrackFileState struct {
+	ID          uint
+	Path        string
+	FileSize    int64
+	FileModTime time.Time
+}
+
+// GetTrackFileStates returns the file state of every track keyed by path.
+func GetTrackFileStates() (map[string]TrackFileState, error) {
+	var states []TrackFileState
+	if err := DB.Model(&Track{}).Select("id", "path", "file_size", "file_mod_time").Find(&states).Error; err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]TrackFileState, len(states))
+	for _, state := range states {
+		byPath[state.Path] = state
+	}
+	return byPath, nil
+}
+
+// UpsertTrack inserts the track, or updates the existing row with the same path.
+// It reports whether a new row was created.
+func UpsertTrack(track *Track) (bool, error) {
+	var existing Track
+	// Soft-deleted rows are included so a file that reappears keeps its cue points and loops.
+	result := DB.Unscoped().Where("path = ?", track.Path).Limit(1).Find(&existing)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return true, DB.Create(track).Error
+	}
+	track.ID = existing.ID
+	track.CreatedAt = existing.CreatedAt
+	track.DeletedAt = gorm.DeletedAt{}
+	// Preserve analysis results that are not derived from the file's tags.
+	if track.BPM == 0 {
+		track.BPM = existing.BPM
+	}
+	if track.Key == "" {
+		track.Key = existing.Key
+		track.Camelot = existing.Camelot
+	}
+	return false, DB.Unscoped().Save(track).Error
+}
+
+// MoveTrack changes the path of a track, keeping its cue points, loops and beatgrid. A track
+// soft-deleted at the new path is dropped so that UpsertTrack finds only the moved one.
+func MoveTrack(trackID uint, path string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("path = ? AND deleted_at IS NOT NULL", path).Delete(&Track{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Track{}).Where("id = ?", trackID).Update("path", path).Error
+	})
+}
+
+// DeleteTracksByPath soft-deletes the track stored at path, or every track below it when path is a folder.
+// It returns the number of tracks removed.
+func DeleteTracksByPath(path string) (int64, error) {
+	folder := strings.TrimSuffix(path, string(filepath.Separator)) + string(filepath.Separator)
+	result := DB.Where("path = ? OR path LIKE ? ESCAPE '\\'", path, escapeLike(folder)+"%").Delete(&Track{})
+	return result.RowsAffected, result.Error
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// SaveTrackKey stores the detected musical key of a track.
+func SaveTrackKey(trackID uint, key, camelot string) error {
+	return DB.Model(&Track{}).Where("id = ?", trackID).Updates(map[string]interface{}{"key": key, "camelot": camelot}).Error
+}
+
+// GetBeatgrid returns the beatgrid of a track, or nil when its tempo has not been analysed.
+func GetBeatgrid(trackID uint) (*Beatgrid, error) {
+	var grid Beatgrid
+	result := DB.Where("track_id = ?", trackID).Limit(1).Find(&grid)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return &grid, nil
+}
+
+// SaveBeatgrid stores the beatgrid of a track, replacing any previous one, and copies its
+// tempo to the track's BPM column.
+func SaveBeatgrid(grid *Beatgrid) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var existing Beatgrid
+		result := tx.Where("track_id = ?", grid.TrackID).Limit(1).Find(&existing)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			grid.ID = existing.ID
+			grid.CreatedAt = existing.CreatedAt
+		}
+		if err := tx.Save(grid).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Track{}).Where("id = ?", grid.TrackID).Update("bpm", grid.BPM).Error
+	})
+}
+
+// GetTracksWithoutBeatgrid returns the tracks whose tempo has not been analysed yet.
+func GetTracksWithoutBeatgrid() ([]Track, error) {
+	var tracks []Track
+	err := DB.Where("id NOT IN (?)", DB.Model(&Beatgrid{}).Select("track_id")).Find(&tracks).Error
+	return tracks, err
+}
+
+// GetPlaylists returns every playlist with its tracks in playlist order.
+func GetPlaylists() ([]Playlist, error) {
+	var playlists []Playlist
+	if err := DB.Order("folder, name").Find(&playlists).Error; err != nil {
+		return nil, err
+	}
+	for i := range playlists {
+		tracks, err := memberTracks("playlist_tracks", "playlist_id", playlists[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		playlists[i].Tracks = tracks
+	}
+	return playlists, nil
+}
+
+// SavePlaylist creates the playlist called name in folder, or replaces the tracks of the
+// existing one. The tracks keep the order of trackIDs; repeated tracks are listed once.
+func SavePlaylist(folder, name string, trackIDs []uint) (*Playlist, error) {
+	var playlist Playlist
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("folder = ? AND name = ?", folder, name).Limit(1).Find(&playlist)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			playlist = Playlist{Folder: folder, Name: name}
+			if err := tx.Create(&playlist).Error; err != nil {
+				return err
+			}
+		}
+		return replaceMembers(tx, "playlist_tracks", "playlist_id", playlist.ID, trackIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
+
+// GetCrates returns every crate with its tracks in crate order.
+func GetCrates() ([]Crate, error) {
+	var crates []Crate
+	if err := DB.Order("name").Find(&crates).Error; err != nil {
+		return nil, err
+	}
+	for i := range crates {
+		tracks, err := memberTracks("crate_tracks", "crate_id", crates[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		crates[i].Tracks = tracks
+	}
+	return crates, nil
+}
+
+// SaveCrate creates the crate called name, or replaces the tracks of the existing one. The
+// tracks keep the order of trackIDs; repeated tracks are listed once.
+func SaveCrate(name string, trackIDs []uint) (*Crate, error) {
+	var crate Crate
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("name = ?", name).Limit(1).Find(&crate)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			crate = Crate{Name: name}
+			if err := tx.Create(&crate).Error; err != nil {
+				return err
+			}
+		}
+		return replaceMembers(tx, "crate_tracks", "crate_id", crate.ID, trackIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &crate, nil
+}
+
+// memberTracks returns the tracks of a playlist or crate in the order they were added to the
+// join table. Preloading the association would list them by track ID instead.
+func memberTracks(table, column string, id uint) ([]Track, error) {
+	var tracks []Track
+	err := DB.Joins("JOIN "+table+" ON "+table+".track_id = tracks.id").
+		Where(table+"."+column+" = ?", id).
+		Order(table + ".rowid").
+		Find(&tracks).Error
+	return tracks, err
+}
+
+// replaceMembers sets the tracks of a playlist or crate in the join table, inserting them in
+// order so that they are read back in the same order.
+func replaceMembers(tx *gorm.DB, table, column string, id uint, trackIDs []uint) error {
+	if err := tx.Table(table).Where(column+" = ?", id).Delete(nil).Error; err != nil {
+		return err
+	}
+	seen := make(map[uint]bool, len(trackIDs))
+	for _, trackID := range trackIDs {
+		if seen[trackID] {
+			continue
+		}
+		seen[trackID] = true
+		if err := tx.Table(table).Create(map[string]interface{}{column: id, "track_id": trackID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
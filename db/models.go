@@ -1,43 +1,66 @@
 package db
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type Track struct {
 	gorm.Model
-	Title    string
-	Artist   string
-	Album    string
-	Path     string
-	Duration string
-	BPM      int
-	Key      string
+	Title       string
+	Artist      string
+	Album       string
+	Path        string `gorm:"index"`
+	Duration    string
+	BPM         float64
+	Key         string    // Musical key in standard notation, such as "A minor"
+	Camelot     string    // Musical key in Camelot notation, such as "8A"
+	FileSize    int64     // Size in bytes when the file was last scanned
+	FileModTime time.Time // Modification time when the file was last scanned
 }
 
 type Playlist struct {
 	gorm.Model
 	Name   string
+	Folder string  // Folders holding the playlist, separated by "/"; empty at the top level
 	Tracks []Track `gorm:"many2many:playlist_tracks;"`
 }
 
 type Crate struct {
 	gorm.Model
-	Name   string
+	Name   string  // Subcrates are named after their parents, as in "Gigs/Friday"
 	Tracks []Track `gorm:"many2many:crate_tracks;"`
 }
 
+// HotCuePads is the number of hot cue pads on a deck.
+const HotCuePads = 8
+
 type CuePoint struct {
-    gorm.Model
-    TrackID uint    `gorm:"index"` // Foreign key to Track
-    Name    string  // Optional name for the cue point
-    Time    float64 // Time in seconds
+	gorm.Model
+	TrackID   uint    `gorm:"index"` // Foreign key to Track
+	Name      string  // Optional name for the cue point
+	Time      float64 // Time in seconds
+	Pad       int     // Hot cue pad from 1 to HotCuePads, or 0 for a memory cue
+	Color     string  // Display colour as "#RRGGBB", empty for the default
+	SortIndex int     // Position in the track's cue list
 }
 
 type Loop struct {
-    gorm.Model
-    TrackID uint    `gorm:"index"` // Foreign key to Track
-    Name    string  // Optional name for the loop
-    Start   float64 // Start time in seconds
-    End     float64 // End time in seconds
+	gorm.Model
+	TrackID   uint    `gorm:"index"` // Foreign key to Track
+	Name      string  // Optional name for the loop
+	Start     float64 // Start time in seconds
+	End       float64 // End time in seconds
+	Color     string  // Display colour as "#RRGGBB", empty for the default
+	SortIndex int     // Position in the track's loop list
+}
+
+// Beatgrid places the beats of a track: for any integer n, a beat falls at Offset + n*60/BPM seconds.
+type Beatgrid struct {
+	gorm.Model
+	TrackID uint    `gorm:"uniqueIndex"` // Foreign key to Track
+	BPM     float64 // Beats per minute
+	Offset  float64 // Time of the first downbeat in seconds
+	Manual  bool    // Set when the grid was corrected by hand, so analysis leaves it alone
 }
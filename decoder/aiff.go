@@ -0,0 +1,216 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/rickcollette/megasound"
+)
+
+// aiffMaxCommSize bounds the COMM chunk: 18 bytes of format, then for AIFF-C a compression
+// type and its name as a Pascal string of up to 255 characters.
+const aiffMaxCommSize = 18 + 4 + 256
+
+// aiffDecoder streams uncompressed PCM from AIFF and AIFF-C files.
+type aiffDecoder struct {
+	f            *os.File
+	dataOffset   int64 // Offset of the first sample frame in the file
+	frames       int
+	channels     int
+	sampleBytes  int
+	littleEndian bool // AIFF-C "sowt" stores samples little-endian
+	float        bool // AIFF-C "fl32"/"fl64" stores IEEE floats
+	pos          int
+	buf          []byte
+	err          error
+}
+
+// decodeAIFF reads the COMM and SSND chunks of an AIFF or AIFF-C file.
+func decodeAIFF(f *os.File) (megasound.StreamSeekCloser, megasound.Format, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		return nil, megasound.Format{}, fmt.Errorf("aiff: failed to read header: %w", err)
+	}
+	isAIFC := string(header[8:12]) == "AIFC"
+
+	d := &aiffDecoder{f: f}
+	var sampleRate float64
+	haveComm, haveData := false, false
+	for !haveComm || !haveData {
+		var chunk [8]byte
+		if _, err := io.ReadFull(f, chunk[:]); err != nil {
+			return nil, megasound.Format{}, fmt.Errorf("aiff: missing COMM or SSND chunk: %w", err)
+		}
+		id := string(chunk[:4])
+		size := int64(binary.BigEndian.Uint32(chunk[4:]))
+		start, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, megasound.Format{}, fmt.Errorf("aiff: %w", err)
+		}
+
+		switch id {
+		case "COMM":
+			if size < 18 || size > aiffMaxCommSize {
+				return nil, megasound.Format{}, fmt.Errorf("aiff: invalid COMM chunk size %d", size)
+			}
+			comm := make([]byte, size)
+			if _, err := io.ReadFull(f, comm); err != nil {
+				return nil, megasound.Format{}, fmt.Errorf("aiff: invalid COMM chunk")
+			}
+			d.channels = int(binary.BigEndian.Uint16(comm[0:2]))
+			d.frames = int(binary.BigEndian.Uint32(comm[2:6]))
+			d.sampleBytes = (int(binary.BigEndian.Uint16(comm[6:8])) + 7) / 8
+			sampleRate = extendedToFloat(comm[8:18])
+			if isAIFC && size >= 22 {
+				switch string(comm[18:22]) {
+				case "NONE", "twos":
+				case "sowt":
+					d.littleEndian = true
+				case "fl32", "FL32":
+					d.float, d.sampleBytes = true, 4
+				case "fl64", "FL64":
+					d.float, d.sampleBytes = true, 8
+				default:
+					return nil, megasound.Format{}, fmt.Errorf("aiff: unsupported compression %q", comm[18:22])
+				}
+			}
+			haveComm = true
+		case "SSND":
+			var ssnd [8]byte
+			if _, err := io.ReadFull(f, ssnd[:]); err != nil {
+				return nil, megasound.Format{}, fmt.Errorf("aiff: invalid SSND chunk")
+			}
+			d.dataOffset = start + 8 + int64(binary.BigEndian.Uint32(ssnd[0:4]))
+			haveData = true
+		}
+
+		// Chunks are padded to an even length.
+		if _, err := f.Seek(start+size+size%2, io.SeekStart); err != nil {
+			return nil, megasound.Format{}, fmt.Errorf("aiff: %w", err)
+		}
+	}
+
+	if d.channels < 1 || d.channels > 2 {
+		return nil, megasound.Format{}, fmt.Errorf("aiff: unsupported channel count %d", d.channels)
+	}
+	if !(sampleRate >= 1) || math.IsInf(sampleRate, 0) {
+		return nil, megasound.Format{}, fmt.Errorf("aiff: invalid sample rate %g", sampleRate)
+	}
+	if d.sampleBytes < 1 || d.sampleBytes > 4 && !d.float {
+		return nil, megasound.Format{}, fmt.Errorf("aiff: unsupported sample size %d bytes", d.sampleBytes)
+	}
+	if _, err := f.Seek(d.dataOffset, io.SeekStart); err != nil {
+		return nil, megasound.Format{}, fmt.Errorf("aiff: %w", err)
+	}
+
+	precision := d.sampleBytes
+	if precision > 3 {
+		precision = 3
+	}
+	format := megasound.Format{
+		SampleRate:  megasound.SampleRate(math.Round(sampleRate)),
+		NumChannels: d.channels,
+		Precision:   precision,
+	}
+	return d, format, nil
+}
+
+// Stream decodes up to len(samples) frames.
+func (d *aiffDecoder) Stream(samples [][2]float64) (n int, ok bool) {
+	if d.err != nil || d.pos >= d.frames {
+		return 0, false
+	}
+	frameBytes := d.channels * d.sampleBytes
+	want := len(samples)
+	if remaining := d.frames - d.pos; want > remaining {
+		want = remaining
+	}
+	if cap(d.buf) < want*frameBytes {
+		d.buf = make([]byte, want*frameBytes)
+	}
+	buf := d.buf[:want*frameBytes]
+	read, err := io.ReadFull(d.f, buf)
+	frames := read / frameBytes
+	if err != nil && err != io.ErrUnexpectedEOF {
+		d.err = err
+	}
+
+	for i := 0; i < frames; i++ {
+		frame := buf[i*frameBytes:]
+		left := d.sample(frame)
+		right := left
+		if d.channels == 2 {
+			right = d.sample(frame[d.sampleBytes:])
+		}
+		samples[i] = [2]float64{left, right}
+	}
+	d.pos += frames
+	return frames, frames > 0
+}
+
+// sample converts one encoded sample at the start of b to the range [-1, 1].
+func (d *aiffDecoder) sample(b []byte) float64 {
+	if d.float {
+		if d.sampleBytes == 8 {
+			return math.Float64frombits(binary.BigEndian.Uint64(b))
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	}
+	var v int32
+	for i := 0; i < d.sampleBytes; i++ {
+		idx := i
+		if d.littleEndian {
+			idx = d.sampleBytes - 1 - i
+		}
+		v = v<<8 | int32(b[idx])
+	}
+	// Sign-extend from the sample width.
+	shift := uint(32 - 8*d.sampleBytes)
+	v = v << shift >> shift
+	return float64(v) / float64(int64(1)<<(8*d.sampleBytes-1))
+}
+
+func (d *aiffDecoder) Err() error {
+	return d.err
+}
+
+func (d *aiffDecoder) Len() int {
+	return d.frames
+}
+
+func (d *aiffDecoder) Position() int {
+	return d.pos
+}
+
+func (d *aiffDecoder) Seek(p int) error {
+	if p < 0 || p > d.frames {
+		return fmt.Errorf("aiff: seek position %d out of range [0, %d]", p, d.frames)
+	}
+	offset := d.dataOffset + int64(p*d.channels*d.sampleBytes)
+	if _, err := d.f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("aiff: seek error: %w", err)
+	}
+	d.pos = p
+	return nil
+}
+
+func (d *aiffDecoder) Close() error {
+	return d.f.Close()
+}
+
+// extendedToFloat converts an 80-bit IEEE 754 extended precision number, used for the AIFF sample rate.
+func extendedToFloat(b []byte) float64 {
+	exponent := int(binary.BigEndian.Uint16(b[0:2]) & 0x7FFF)
+	mantissa := binary.BigEndian.Uint64(b[2:10])
+	if exponent == 0 && mantissa == 0 {
+		return 0
+	}
+	value := float64(mantissa) * math.Pow(2, float64(exponent-16383-63))
+	if b[0]&0x80 != 0 {
+		value = -value
+	}
+	return value
+}
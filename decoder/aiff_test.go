@@ -0,0 +1,172 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// aiffFile builds an AIFF file, or an AIFF-C file when compression is set, holding 16-bit samples.
+func aiffFile(channels int, rate float64, compression string, samples []int16) []byte {
+	comm := new(bytes.Buffer)
+	binary.Write(comm, binary.BigEndian, uint16(channels))
+	frames := 0
+	if channels > 0 {
+		frames = len(samples) / channels
+	}
+	binary.Write(comm, binary.BigEndian, uint32(frames))
+	binary.Write(comm, binary.BigEndian, uint16(16))
+	comm.Write(floatToExtended(rate))
+	if compression != "" {
+		comm.WriteString(compression)
+		comm.Write([]byte{0, 0}) // Empty name, padded to an even length
+	}
+
+	ssnd := new(bytes.Buffer)
+	ssnd.Write(make([]byte, 8)) // Offset and block size
+	for _, s := range samples {
+		if compression == "sowt" {
+			binary.Write(ssnd, binary.LittleEndian, s)
+		} else {
+			binary.Write(ssnd, binary.BigEndian, s)
+		}
+	}
+
+	form := "AIFF"
+	if compression != "" {
+		form = "AIFC"
+	}
+	body := new(bytes.Buffer)
+	body.WriteString(form)
+	writeChunk(body, "COMM", comm.Bytes())
+	writeChunk(body, "SSND", ssnd.Bytes())
+
+	file := new(bytes.Buffer)
+	writeChunk(file, "FORM", body.Bytes())
+	return file.Bytes()
+}
+
+func writeChunk(b *bytes.Buffer, id string, data []byte) {
+	b.WriteString(id)
+	binary.Write(b, binary.BigEndian, uint32(len(data)))
+	b.Write(data)
+	if len(data)%2 == 1 {
+		b.WriteByte(0)
+	}
+}
+
+// floatToExtended encodes a whole, non-negative number as an 80-bit IEEE 754 extended float.
+func floatToExtended(v float64) []byte {
+	b := make([]byte, 10)
+	n := uint64(v)
+	if n == 0 {
+		return b
+	}
+	shift := bits.LeadingZeros64(n)
+	binary.BigEndian.PutUint16(b, uint16(16383+63-shift))
+	binary.BigEndian.PutUint64(b[2:], n<<shift)
+	return b
+}
+
+func writeTemp(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDecodeAIFF(t *testing.T) {
+	samples := []int16{0, 0, 16384, -16384, 32767, -32768, -1, 1}
+	for _, compression := range []string{"", "NONE", "sowt"} {
+		t.Run("compression="+compression, func(t *testing.T) {
+			path := writeTemp(t, "track.aiff", aiffFile(2, 44100, compression, samples))
+			streamer, format, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer streamer.Close()
+
+			if format.SampleRate != 44100 || format.NumChannels != 2 || format.Precision != 2 {
+				t.Fatalf("format = %+v", format)
+			}
+			if streamer.Len() != 4 {
+				t.Fatalf("Len() = %d, want 4", streamer.Len())
+			}
+			got := make([][2]float64, 8)
+			n, ok := streamer.Stream(got)
+			if n != 4 || !ok {
+				t.Fatalf("Stream() = %d, %v", n, ok)
+			}
+			for i := 0; i < n; i++ {
+				for c := 0; c < 2; c++ {
+					want := float64(samples[2*i+c]) / 32768
+					if math.Abs(got[i][c]-want) > 1e-9 {
+						t.Errorf("frame %d channel %d = %v, want %v", i, c, got[i][c], want)
+					}
+				}
+			}
+			if n, ok := streamer.Stream(got); n != 0 || ok {
+				t.Errorf("Stream() after the end = %d, %v", n, ok)
+			}
+
+			if err := streamer.Seek(2); err != nil {
+				t.Fatal(err)
+			}
+			if n, _ := streamer.Stream(got[:1]); n != 1 || got[0][0] != 32767.0/32768 {
+				t.Errorf("frame after seek = %v", got[0])
+			}
+		})
+	}
+}
+
+func TestDecodeAIFFMono(t *testing.T) {
+	path := writeTemp(t, "mono.aif", aiffFile(1, 48000, "", []int16{8192, -8192, 0}))
+	streamer, format, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer streamer.Close()
+	if format.SampleRate != 48000 || format.NumChannels != 1 {
+		t.Fatalf("format = %+v", format)
+	}
+	got := make([][2]float64, 3)
+	if n, _ := streamer.Stream(got); n != 3 || got[0] != [2]float64{0.25, 0.25} || got[1] != [2]float64{-0.25, -0.25} {
+		t.Errorf("Stream() = %d, %v", n, got)
+	}
+}
+
+func TestDecodeAIFFRejectsInvalidFiles(t *testing.T) {
+	hugeComm := aiffFile(2, 44100, "", []int16{0, 0})
+	binary.BigEndian.PutUint32(hugeComm[16:], 0xFFFFFFF0)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"zero sample rate", aiffFile(2, 0, "", []int16{0, 0}), "sample rate"},
+		{"zero channels", aiffFile(0, 44100, "", nil), "channel count"},
+		{"oversized COMM", hugeComm, "COMM"},
+		{"short COMM", append(aiffFile(2, 44100, "", nil)[:12], 'C', 'O', 'M', 'M', 0, 0, 0, 4, 0, 0, 0, 0), "COMM"},
+		{"unsupported compression", aiffFile(2, 44100, "ima4", []int16{0, 0}), "compression"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			streamer, _, err := Open(writeTemp(t, "bad.aiff", tt.data))
+			if err == nil {
+				streamer.Close()
+				t.Fatal("Open() succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Open() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
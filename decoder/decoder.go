@@ -0,0 +1,146 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rickcollette/megasound"
+	"github.com/rickcollette/megasound/flac"
+	"github.com/rickcollette/megasound/mp3"
+	"github.com/rickcollette/megasound/vorbis"
+	"github.com/rickcollette/megasound/wav"
+)
+
+// signatureSize is the number of leading bytes read to identify a file's format.
+const signatureSize = 12
+
+// DecodeFunc decodes an opened audio file. The returned streamer owns f and closes it.
+type DecodeFunc func(f *os.File) (megasound.StreamSeekCloser, megasound.Format, error)
+
+// audioFormat describes a container format the decoder can read.
+type audioFormat struct {
+	name       string
+	extensions []string
+	match      func(signature []byte) bool
+	decode     DecodeFunc
+}
+
+// formats lists the supported formats in detection order.
+var formats = []audioFormat{
+	{
+		name:       "WAV",
+		extensions: []string{".wav", ".wave"},
+		match: func(sig []byte) bool {
+			return bytes.HasPrefix(sig, []byte("RIFF")) && bytes.Equal(sig[8:12], []byte("WAVE"))
+		},
+		decode: func(f *os.File) (megasound.StreamSeekCloser, megasound.Format, error) { return wav.Decode(f) },
+	},
+	{
+		name:       "FLAC",
+		extensions: []string{".flac"},
+		match:      func(sig []byte) bool { return bytes.HasPrefix(sig, []byte("fLaC")) },
+		decode:     func(f *os.File) (megasound.StreamSeekCloser, megasound.Format, error) { return flac.Decode(f) },
+	},
+	{
+		name:       "AIFF",
+		extensions: []string{".aif", ".aiff", ".aifc"},
+		match: func(sig []byte) bool {
+			return bytes.HasPrefix(sig, []byte("FORM")) &&
+				(bytes.Equal(sig[8:12], []byte("AIFF")) || bytes.Equal(sig[8:12], []byte("AIFC")))
+		},
+		decode: func(f *os.File) (megasound.StreamSeekCloser, megasound.Format, error) { return decodeAIFF(f) },
+	},
+	{
+		name:       "Ogg Vorbis",
+		extensions: []string{".ogg", ".oga"},
+		match:      func(sig []byte) bool { return bytes.HasPrefix(sig, []byte("OggS")) },
+		decode:     func(f *os.File) (megasound.StreamSeekCloser, megasound.Format, error) { return vorbis.Decode(f) },
+	},
+	{
+		// MP3 is last: files without an ID3 tag are only recognised by their frame sync.
+		name:       "MP3",
+		extensions: []string{".mp3"},
+		match: func(sig []byte) bool {
+			return bytes.HasPrefix(sig, []byte("ID3")) || (sig[0] == 0xFF && sig[1]&0xE0 == 0xE0)
+		},
+		decode: func(f *os.File) (megasound.StreamSeekCloser, megasound.Format, error) { return mp3.Decode(f) },
+	},
+}
+
+// Open opens and decodes the audio file at path. The format is identified by the file's
+// signature, falling back to its extension. Close the returned streamer to release the file.
+func Open(path string) (megasound.StreamSeekCloser, megasound.Format, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, megasound.Format{}, fmt.Errorf("failed to open audio file: %w", err)
+	}
+
+	format, err := identify(f, path)
+	if err != nil {
+		f.Close()
+		return nil, megasound.Format{}, err
+	}
+	streamer, streamFormat, err := format.decode(f)
+	if err != nil {
+		f.Close()
+		return nil, megasound.Format{}, fmt.Errorf("failed to decode %s: %w", format.name, err)
+	}
+	return streamer, streamFormat, nil
+}
+
+// identify picks the format of f and rewinds it.
+func identify(f *os.File, path string) (audioFormat, error) {
+	signature := make([]byte, signatureSize)
+	n, err := io.ReadFull(f, signature)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return audioFormat{}, fmt.Errorf("failed to read audio file: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return audioFormat{}, fmt.Errorf("failed to rewind audio file: %w", err)
+	}
+
+	if n == signatureSize {
+		for _, format := range formats {
+			if format.match(signature) {
+				return format, nil
+			}
+		}
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, format := range formats {
+		for _, formatExt := range format.extensions {
+			if ext == formatExt {
+				return format, nil
+			}
+		}
+	}
+	return audioFormat{}, fmt.Errorf("unsupported audio format: %s", filepath.Base(path))
+}
+
+// Extensions returns the lower-case file extensions of all supported formats.
+func Extensions() []string {
+	var extensions []string
+	for _, format := range formats {
+		extensions = append(extensions, format.extensions...)
+	}
+	sort.Strings(extensions)
+	return extensions
+}
+
+// IsSupported reports whether path has the extension of a supported format.
+func IsSupported(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, format := range formats {
+		for _, formatExt := range format.extensions {
+			if ext == formatExt {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package dsp
+
+import "math"
+
+// biquad is a second-order IIR filter for two channels, in transposed direct form II.
+// Coefficients follow the RBJ audio EQ cookbook.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             [2]float64
+}
+
+// setLowPass configures the filter as a low-pass at cutoff Hz with resonance q.
+func (f *biquad) setLowPass(sampleRate, cutoff, q float64) {
+	w := 2 * math.Pi * cutoff / sampleRate
+	alpha := math.Sin(w) / (2 * q)
+	cos := math.Cos(w)
+	f.setCoefficients((1-cos)/2, 1-cos, (1-cos)/2, 1+alpha, -2*cos, 1-alpha)
+}
+
+// setHighPass configures the filter as a high-pass at cutoff Hz with resonance q.
+func (f *biquad) setHighPass(sampleRate, cutoff, q float64) {
+	w := 2 * math.Pi * cutoff / sampleRate
+	alpha := math.Sin(w) / (2 * q)
+	cos := math.Cos(w)
+	f.setCoefficients((1+cos)/2, -(1 + cos), (1+cos)/2, 1+alpha, -2*cos, 1-alpha)
+}
+
+// setCoefficients normalises the coefficients by a0. The filter state is kept so that
+// coefficients can change while audio runs through the filter.
+func (f *biquad) setCoefficients(b0, b1, b2, a0, a1, a2 float64) {
+	f.b0, f.b1, f.b2 = b0/a0, b1/a0, b2/a0
+	f.a1, f.a2 = a1/a0, a2/a0
+}
+
+// process filters one sample of channel c.
+func (f *biquad) process(x float64, c int) float64 {
+	y := f.b0*x + f.z1[c]
+	f.z1[c] = f.b1*x - f.a1*y + f.z2[c]
+	f.z2[c] = f.b2*x - f.a2*y
+	return y
+}
+
+// butterworthQ is the resonance of a Butterworth filter section. Two cascaded sections
+// form a fourth-order Linkwitz-Riley filter.
+const butterworthQ = math.Sqrt2 / 2
+
+// smoothed is a parameter that glides towards its target instead of jumping, which would
+// cause zipper noise.
+type smoothed struct {
+	value, target float64
+}
+
+// next moves the value one sample towards the target and returns it.
+func (s *smoothed) next(coefficient float64) float64 {
+	s.value += (s.target - s.value) * coefficient
+	return s.value
+}
+
+// smoothingTime is how long smoothed parameters take to reach about two thirds of a change.
+const smoothingTime = 0.01
+
+// smoothingCoefficient returns the per-sample coefficient of smoothed.next at sampleRate.
+func smoothingCoefficient(sampleRate float64) float64 {
+	return 1 - math.Exp(-1/(smoothingTime*sampleRate))
+}
@@ -0,0 +1,75 @@
+package dsp
+
+import "github.com/rickcollette/megasound"
+
+const (
+	// LowCrossover and HighCrossover are the band edges of EQ in Hz.
+	LowCrossover  = 250.0
+	HighCrossover = 3000.0
+)
+
+// EQ is a three-band isolator EQ. The bands are split by a tree of fourth-order Linkwitz-Riley
+// crossovers, so at unity gain they add back up to the input with a flat response, and each
+// band can be cut completely.
+type EQ struct {
+	Streamer megasound.Streamer
+
+	// Low/rest split at LowCrossover, then mid/high split of the rest at HighCrossover. The low
+	// band also goes through the high crossover, summed again, to match the phase of the others.
+	lowPass, restPass   [2]biquad
+	midPass, highPass   [2]biquad
+	lowPhase, lowPhase2 [2]biquad
+	gains               [3]smoothed // Low, mid and high band gains
+	coefficient         float64
+}
+
+// NewEQ creates a flat EQ for a stream at sampleRate.
+func NewEQ(s megasound.Streamer, sampleRate megasound.SampleRate) *EQ {
+	e := &EQ{Streamer: s, coefficient: smoothingCoefficient(float64(sampleRate))}
+	for i := 0; i < 2; i++ {
+		e.lowPass[i].setLowPass(float64(sampleRate), LowCrossover, butterworthQ)
+		e.restPass[i].setHighPass(float64(sampleRate), LowCrossover, butterworthQ)
+		e.midPass[i].setLowPass(float64(sampleRate), HighCrossover, butterworthQ)
+		e.highPass[i].setHighPass(float64(sampleRate), HighCrossover, butterworthQ)
+		e.lowPhase[i].setLowPass(float64(sampleRate), HighCrossover, butterworthQ)
+		e.lowPhase2[i].setHighPass(float64(sampleRate), HighCrossover, butterworthQ)
+	}
+	for i := range e.gains {
+		e.gains[i] = smoothed{value: 1, target: 1}
+	}
+	return e
+}
+
+// SetGains sets the linear gain of each band; 1 leaves the band unchanged and 0 removes it.
+func (e *EQ) SetGains(low, mid, high float64) {
+	e.gains[0].target, e.gains[1].target, e.gains[2].target = low, mid, high
+}
+
+// Stream streams the equalised audio.
+func (e *EQ) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = e.Streamer.Stream(samples)
+	for i := range samples[:n] {
+		low, mid, high := e.gains[0].next(e.coefficient), e.gains[1].next(e.coefficient), e.gains[2].next(e.coefficient)
+		for c := range samples[i] {
+			x := samples[i][c]
+			lowBand := linkwitzRiley(&e.lowPass, x, c)
+			lowBand = linkwitzRiley(&e.lowPhase, lowBand, c) + linkwitzRiley(&e.lowPhase2, lowBand, c)
+			rest := linkwitzRiley(&e.restPass, x, c)
+			midBand := linkwitzRiley(&e.midPass, rest, c)
+			highBand := linkwitzRiley(&e.highPass, rest, c)
+			samples[i][c] = low*lowBand + mid*midBand + high*highBand
+		}
+	}
+	return n, ok
+}
+
+// Err propagates the wrapped streamer's errors.
+func (e *EQ) Err() error {
+	return e.Streamer.Err()
+}
+
+// linkwitzRiley runs sample x of channel c through two identical Butterworth sections,
+// forming a fourth-order Linkwitz-Riley filter.
+func linkwitzRiley(sections *[2]biquad, x float64, c int) float64 {
+	return sections[1].process(sections[0].process(x, c), c)
+}
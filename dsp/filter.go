@@ -0,0 +1,96 @@
+package dsp
+
+import (
+	"math"
+
+	"github.com/rickcollette/megasound"
+)
+
+// Cutoff ranges of Filter's sweeps, in Hz.
+const (
+	filterMinLowPass   = 40.0
+	filterMaxLowPass   = 20000.0
+	filterMinHighPass  = 20.0
+	filterMaxHighPass  = 16000.0
+	filterResonance    = 1.5  // Q of both sweeps, for a slight peak at the cutoff
+	filterFadeWidth    = 0.05 // Part of the knob travel either side of the centre that fades the filter in
+	filterUpdatePeriod = 16   // Samples between coefficient updates while the cutoff moves
+)
+
+// Filter is a one-knob DJ filter. At the centre position it passes the stream unchanged; turning
+// towards -1 sweeps a resonant low-pass down and towards 1 sweeps a high-pass up. The position
+// glides to new values, so the cutoff moves without zipper noise.
+type Filter struct {
+	Streamer megasound.Streamer
+
+	lowPass, highPass biquad
+	position          smoothed
+	sampleRate        float64
+	coefficient       float64
+	countdown         int
+}
+
+// NewFilter creates a filter at the centre position for a stream at sampleRate.
+func NewFilter(s megasound.Streamer, sampleRate megasound.SampleRate) *Filter {
+	f := &Filter{
+		Streamer:    s,
+		sampleRate:  float64(sampleRate),
+		coefficient: smoothingCoefficient(float64(sampleRate)),
+	}
+	f.updateCoefficients()
+	return f
+}
+
+// SetPosition sets the knob position, from -1 (low-pass fully closed) through 0 (bypass)
+// to 1 (high-pass fully closed).
+func (f *Filter) SetPosition(position float64) {
+	f.position.target = math.Max(-1, math.Min(1, position))
+}
+
+// Position returns the knob position set last.
+func (f *Filter) Position() float64 {
+	return f.position.target
+}
+
+// Stream streams the filtered audio.
+func (f *Filter) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = f.Streamer.Stream(samples)
+	if f.position.value == 0 && f.position.target == 0 {
+		return n, ok // Bypassed
+	}
+	for i := range samples[:n] {
+		position := f.position.next(f.coefficient)
+		if math.Abs(position-f.position.target) < 1e-6 {
+			position = f.position.target
+			f.position.value = position
+		}
+		f.countdown--
+		if f.countdown <= 0 {
+			f.updateCoefficients()
+		}
+		lowMix := math.Min(1, math.Max(0, -position/filterFadeWidth))
+		highMix := math.Min(1, math.Max(0, position/filterFadeWidth))
+		for c := range samples[i] {
+			x := samples[i][c]
+			y := x + lowMix*(f.lowPass.process(x, c)-x)
+			samples[i][c] = y + highMix*(f.highPass.process(y, c)-y)
+		}
+	}
+	return n, ok
+}
+
+// Err propagates the wrapped streamer's errors.
+func (f *Filter) Err() error {
+	return f.Streamer.Err()
+}
+
+// updateCoefficients sets both cutoffs from the current position. Cutoffs sweep exponentially,
+// so equal knob movements sound like equal steps.
+func (f *Filter) updateCoefficients() {
+	f.countdown = filterUpdatePeriod
+	nyquistLimit := 0.45 * f.sampleRate
+	lowCutoff := filterMaxLowPass * math.Pow(filterMinLowPass/filterMaxLowPass, math.Max(0, -f.position.value))
+	highCutoff := filterMinHighPass * math.Pow(filterMaxHighPass/filterMinHighPass, math.Max(0, f.position.value))
+	f.lowPass.setLowPass(f.sampleRate, math.Min(lowCutoff, nyquistLimit), filterResonance)
+	f.highPass.setHighPass(f.sampleRate, math.Min(highCutoff, nyquistLimit), filterResonance)
+}
@@ -0,0 +1,103 @@
+package dsp
+
+import (
+	"math"
+
+	"github.com/rickcollette/megasound"
+)
+
+// Gain scales a stream by a linear gain that glides to new values instead of jumping.
+type Gain struct {
+	Streamer megasound.Streamer
+
+	gain        smoothed
+	coefficient float64
+}
+
+// NewGain creates a gain stage for a stream at sampleRate.
+func NewGain(s megasound.Streamer, sampleRate megasound.SampleRate, gain float64) *Gain {
+	return &Gain{
+		Streamer:    s,
+		gain:        smoothed{value: gain, target: gain},
+		coefficient: smoothingCoefficient(float64(sampleRate)),
+	}
+}
+
+// SetGain sets the linear gain.
+func (g *Gain) SetGain(gain float64) {
+	g.gain.target = gain
+}
+
+// Stream streams the scaled audio.
+func (g *Gain) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = g.Streamer.Stream(samples)
+	for i := range samples[:n] {
+		gain := g.gain.next(g.coefficient)
+		samples[i][0] *= gain
+		samples[i][1] *= gain
+	}
+	return n, ok
+}
+
+// Err propagates the wrapped streamer's errors.
+func (g *Gain) Err() error {
+	return g.Streamer.Err()
+}
+
+// DecibelsToGain converts a level in decibels to a linear gain.
+func DecibelsToGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// limiterRelease is how long the limiter takes to recover about two thirds of its gain reduction.
+const limiterRelease = 0.1
+
+// Limiter keeps a stream's peaks at or below a ceiling. Gain drops at once when a peak would
+// exceed the ceiling and recovers smoothly afterwards; anything left above the ceiling is clipped.
+type Limiter struct {
+	Streamer megasound.Streamer
+	Ceiling  float64 // Highest absolute sample value let through
+
+	gain    float64
+	release float64
+	active  bool
+}
+
+// NewLimiter creates a limiter for a stream at sampleRate.
+func NewLimiter(s megasound.Streamer, sampleRate megasound.SampleRate, ceiling float64) *Limiter {
+	return &Limiter{
+		Streamer: s,
+		Ceiling:  ceiling,
+		gain:     1,
+		release:  1 - math.Exp(-1/(limiterRelease*float64(sampleRate))),
+	}
+}
+
+// Stream streams the limited audio.
+func (l *Limiter) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = l.Streamer.Stream(samples)
+	l.active = false
+	for i := range samples[:n] {
+		peak := math.Max(math.Abs(samples[i][0]), math.Abs(samples[i][1]))
+		if peak*l.gain > l.Ceiling {
+			l.gain = l.Ceiling / peak
+			l.active = true
+		} else {
+			l.gain += (1 - l.gain) * l.release
+		}
+		for c := range samples[i] {
+			samples[i][c] = math.Max(-l.Ceiling, math.Min(l.Ceiling, samples[i][c]*l.gain))
+		}
+	}
+	return n, ok
+}
+
+// Limiting reports whether the limiter reduced any peak in the most recent buffer.
+func (l *Limiter) Limiting() bool {
+	return l.active
+}
+
+// Err propagates the wrapped streamer's errors.
+func (l *Limiter) Err() error {
+	return l.Streamer.Err()
+}
@@ -0,0 +1,177 @@
+package dsp
+
+import (
+	"math"
+
+	"github.com/rickcollette/megasound"
+)
+
+const (
+	// stretchFrame is the length of the frames overlap-added by TimeStretch, about 46ms at 44.1kHz.
+	stretchFrame = 2048
+	// stretchHop is the output distance between frames; frames overlap by half.
+	stretchHop = stretchFrame / 2
+	// stretchTolerance is how far a frame may move from its nominal input position to
+	// continue the previous frame's waveform.
+	stretchTolerance = 512
+	// stretchCompare is the number of samples compared when choosing a frame's position.
+	stretchCompare = 512
+)
+
+// TimeStretch changes the tempo of a stream without changing its pitch. It implements WSOLA:
+// windowed frames of the input are overlap-added at a fixed output hop while the input advances
+// by the hop times the ratio, and each frame is shifted to where its waveform best continues the
+// previous frame.
+type TimeStretch struct {
+	source     megasound.Streamer
+	ratio      float64
+	window     []float64
+	input      [][2]float64 // Buffered input; input[0] is at position inputStart of the source
+	inputStart int
+	drained    bool
+	nominal    float64      // Input position where the next frame would start without adjustment
+	previous   int          // Input position of the previous frame, -1 before the first frame
+	output     [][2]float64 // Overlap-add accumulator
+	pending    [][2]float64 // Finished output not streamed yet
+	done       bool
+
+	// Scratch buffers reused between frames to keep the audio thread free of allocations.
+	hop       [][2]float64
+	reference []float64
+	chunk     [][2]float64
+}
+
+// NewTimeStretch plays source at ratio times its original tempo: 2 plays twice as fast.
+func NewTimeStretch(source megasound.Streamer, ratio float64) *TimeStretch {
+	window := make([]float64, stretchFrame)
+	for i := range window {
+		// A periodic Hann window; two of them overlapping by half sum to one.
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/stretchFrame)
+	}
+	return &TimeStretch{
+		source:    source,
+		ratio:     ratio,
+		window:    window,
+		previous:  -1,
+		output:    make([][2]float64, stretchFrame),
+		hop:       make([][2]float64, stretchHop),
+		reference: make([]float64, stretchCompare/2),
+		chunk:     make([][2]float64, 1024),
+	}
+}
+
+// SetRatio changes the tempo without glitches.
+func (t *TimeStretch) SetRatio(ratio float64) {
+	t.ratio = ratio
+}
+
+// Ratio returns the tempo relative to the source.
+func (t *TimeStretch) Ratio() float64 {
+	return t.ratio
+}
+
+// Stream fills samples with the stretched audio.
+func (t *TimeStretch) Stream(samples [][2]float64) (n int, ok bool) {
+	for n < len(samples) {
+		if len(t.pending) == 0 {
+			if t.done || !t.nextFrame() {
+				t.done = true
+				return n, n > 0
+			}
+		}
+		copied := copy(samples[n:], t.pending)
+		t.pending = t.pending[copied:]
+		n += copied
+	}
+	return n, true
+}
+
+// Err propagates the source's errors.
+func (t *TimeStretch) Err() error {
+	return t.source.Err()
+}
+
+// nextFrame overlap-adds the next frame and moves a hop of finished output to pending.
+// It returns false once the input is exhausted.
+func (t *TimeStretch) nextFrame() bool {
+	start := int(t.nominal)
+	if t.previous >= 0 {
+		start = t.bestStart(start, t.previous+stretchHop)
+	}
+	t.fill(start + stretchFrame)
+	if t.drained && start >= t.inputStart+len(t.input) {
+		return false
+	}
+
+	for i := 0; i < stretchFrame; i++ {
+		sample := t.at(start + i)
+		t.output[i][0] += sample[0] * t.window[i]
+		t.output[i][1] += sample[1] * t.window[i]
+	}
+	copy(t.hop, t.output[:stretchHop])
+	t.pending = t.hop
+	copy(t.output, t.output[stretchHop:])
+	for i := stretchFrame - stretchHop; i < stretchFrame; i++ {
+		t.output[i] = [2]float64{}
+	}
+
+	t.previous = start
+	t.nominal += stretchHop * t.ratio
+	t.discard(min(int(t.nominal)-stretchTolerance, t.previous+stretchHop))
+	return true
+}
+
+// bestStart returns the position within stretchTolerance of nominal whose waveform is most
+// similar to the input at target, where the previous frame would naturally continue.
+func (t *TimeStretch) bestStart(nominal, target int) int {
+	lo := max(nominal-stretchTolerance, t.inputStart)
+	hi := nominal + stretchTolerance
+	t.fill(max(hi, target) + stretchCompare)
+
+	reference := t.reference
+	for i := range reference {
+		sample := t.at(target + 2*i)
+		reference[i] = sample[0] + sample[1]
+	}
+	best, bestScore := nominal, math.Inf(-1)
+	for candidate := lo; candidate <= hi; candidate++ {
+		score := 0.0
+		for i, r := range reference {
+			sample := t.at(candidate + 2*i)
+			score += r * (sample[0] + sample[1])
+		}
+		if score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best
+}
+
+// fill reads the source until the input buffer reaches position end or the source drains.
+func (t *TimeStretch) fill(end int) {
+	for !t.drained && t.inputStart+len(t.input) < end {
+		n, ok := t.source.Stream(t.chunk)
+		t.input = append(t.input, t.chunk[:n]...)
+		if !ok {
+			t.drained = true
+		}
+	}
+}
+
+// discard drops buffered input before position.
+func (t *TimeStretch) discard(position int) {
+	if drop := position - t.inputStart; drop > 0 {
+		drop = min(drop, len(t.input))
+		t.input = append(t.input[:0], t.input[drop:]...)
+		t.inputStart += drop
+	}
+}
+
+// at returns the input sample at position, or silence outside the buffered input.
+func (t *TimeStretch) at(position int) [2]float64 {
+	i := position - t.inputStart
+	if i < 0 || i >= len(t.input) {
+		return [2]float64{}
+	}
+	return t.input[i]
+}
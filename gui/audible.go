@@ -0,0 +1,34 @@
+package gui
+
+import "megajam/db"
+
+// audibleTracker notices when a deck's track becomes audible in the master mix, for
+// tracklists and now-playing titles.
+type audibleTracker struct {
+	decks []*deckController
+	heard []string // Path of each deck's audible track, empty while the deck is silent
+	last  string   // Path of the track reported last
+}
+
+func newAudibleTracker(decks []*deckController) *audibleTracker {
+	return &audibleTracker{decks: decks, heard: make([]string, len(decks))}
+}
+
+// update returns the track that became audible since the last call, or nil if none did. A
+// track coming back while it is still the last one reported is not reported again.
+func (t *audibleTracker) update() *db.Track {
+	var found *db.Track
+	for i, deck := range t.decks {
+		track := deck.Track()
+		if track == nil || !deck.deck.Audible() {
+			t.heard[i] = ""
+			continue
+		}
+		if t.heard[i] != track.Path && track.Path != t.last {
+			found = track
+			t.last = track.Path
+		}
+		t.heard[i] = track.Path
+	}
+	return found
+}
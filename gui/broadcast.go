@@ -0,0 +1,179 @@
+package gui
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"megajam/broadcast"
+	"megajam/config"
+	"megajam/player"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// broadcastSink names the broadcaster's tap on the master mix.
+const broadcastSink = "broadcast"
+
+// broadcastController streams the master mix to a server and announces each track that
+// becomes audible as now playing.
+type broadcastController struct {
+	engine *player.Engine
+	decks  []*deckController
+	cfg    config.BroadcastConfig // Server details, prefilled in the Go Live form
+	window fyne.Window
+
+	button *widget.Button
+	status *widget.Label
+
+	caster   *broadcast.Broadcaster // nil while off air
+	stop     chan struct{}
+	followed chan struct{} // Closed when follow has returned
+}
+
+// createBroadcastSection creates the Go Live button and stream status display. The returned
+// function ends any stream in progress, for when the app closes.
+func createBroadcastSection(engine *player.Engine, decks []*deckController, cfg config.BroadcastConfig, window fyne.Window) (*fyne.Container, func()) {
+	c := &broadcastController{engine: engine, decks: decks, cfg: cfg, window: window}
+	c.button = widget.NewButton("Go Live", c.Toggle)
+	c.status = widget.NewLabel("Off air")
+	return container.NewHBox(c.button, c.status), c.Close
+}
+
+// Toggle asks for the server details and starts streaming, or ends the stream in progress.
+func (c *broadcastController) Toggle() {
+	if c.caster != nil {
+		c.Close()
+		c.button.SetText("Go Live")
+		c.button.Importance = widget.MediumImportance
+		c.button.Refresh()
+		c.status.SetText("Off air")
+		return
+	}
+
+	protocol := widget.NewSelect([]string{string(broadcast.Icecast), string(broadcast.Shoutcast)}, nil)
+	protocol.SetSelected(c.cfg.Protocol)
+	host := widget.NewEntry()
+	host.SetText(c.cfg.Host)
+	port := widget.NewEntry()
+	port.SetText(strconv.Itoa(c.cfg.Port))
+	mount := widget.NewEntry()
+	mount.SetText(c.cfg.Mount)
+	user := widget.NewEntry()
+	user.SetText(c.cfg.User)
+	password := widget.NewPasswordEntry()
+	password.SetText(c.cfg.Password)
+	codec := widget.NewSelect([]string{string(broadcast.MP3), string(broadcast.Opus)}, nil)
+	codec.SetSelected(c.cfg.Codec)
+	bitrate := widget.NewEntry()
+	bitrate.SetText(strconv.Itoa(c.cfg.Bitrate))
+	name := widget.NewEntry()
+	name.SetText(c.cfg.Name)
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Server", protocol),
+		widget.NewFormItem("Host", host),
+		widget.NewFormItem("Port", port),
+		widget.NewFormItem("Mount", mount),
+		widget.NewFormItem("User", user),
+		widget.NewFormItem("Password", password),
+		widget.NewFormItem("Codec", codec),
+		widget.NewFormItem("Bitrate (kbps)", bitrate),
+		widget.NewFormItem("Station", name),
+	}
+	dialog.ShowForm("Go Live", "Start", "Cancel", items, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		portNumber, err := strconv.Atoi(port.Text)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("invalid port '%s'", port.Text), c.window)
+			return
+		}
+		kbps, err := strconv.Atoi(bitrate.Text)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("invalid bitrate '%s'", bitrate.Text), c.window)
+			return
+		}
+		c.cfg.Protocol, c.cfg.Host, c.cfg.Port, c.cfg.Mount = protocol.Selected, host.Text, portNumber, mount.Text
+		c.cfg.User, c.cfg.Password, c.cfg.Codec, c.cfg.Bitrate, c.cfg.Name = user.Text, password.Text, codec.Selected, kbps, name.Text
+		c.start()
+	}, c.window)
+}
+
+// start connects to the configured server and starts streaming the master mix.
+func (c *broadcastController) start() {
+	caster, err := broadcast.New(broadcast.Config{
+		Protocol: broadcast.Protocol(c.cfg.Protocol),
+		Host:     c.cfg.Host,
+		Port:     c.cfg.Port,
+		Mount:    c.cfg.Mount,
+		User:     c.cfg.User,
+		Password: c.cfg.Password,
+		Codec:    broadcast.Codec(c.cfg.Codec),
+		Bitrate:  c.cfg.Bitrate,
+		Name:     c.cfg.Name,
+		Encoder:  c.cfg.Encoder,
+	}, int(c.engine.Format().SampleRate))
+	if err != nil {
+		dialog.ShowError(err, c.window)
+		return
+	}
+	c.caster = caster
+	c.stop, c.followed = make(chan struct{}), make(chan struct{})
+	c.engine.SetMasterSink(broadcastSink, caster.Write)
+	go c.follow(caster, c.stop, c.followed)
+
+	c.button.SetText("End Stream")
+	c.button.Importance = widget.DangerImportance
+	c.button.Refresh()
+}
+
+// Close ends any stream in progress. It waits for follow to return, so that the status is not
+// updated after Toggle shows the stream is off air.
+func (c *broadcastController) Close() {
+	if c.caster == nil {
+		return
+	}
+	c.engine.SetMasterSink(broadcastSink, nil)
+	close(c.stop)
+	<-c.followed
+	c.caster.Stop()
+	c.caster = nil
+}
+
+// follow updates the stream status and now-playing title until stop is closed, then closes
+// done.
+func (c *broadcastController) follow(caster *broadcast.Broadcaster, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(recordingPoll)
+	defer ticker.Stop()
+	tracker := newAudibleTracker(c.decks)
+	for {
+		if track := tracker.update(); track != nil {
+			caster.SetNowPlaying(track.Artist, track.Title)
+		}
+		c.status.SetText(broadcastStatus(caster.Status()))
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// broadcastStatus describes the state of a stream.
+func broadcastStatus(status broadcast.Status) string {
+	switch status.State {
+	case broadcast.Live:
+		seconds := int(status.Uptime / time.Second)
+		return fmt.Sprintf("ON AIR %02d:%02d:%02d  %.1f MB", seconds/3600, seconds/60%60, seconds%60, float64(status.Sent)/(1<<20))
+	case broadcast.Waiting:
+		return fmt.Sprintf("Reconnecting (attempt %d): %v", status.Retries, status.Err)
+	}
+	return status.State.String() + "..."
+}
@@ -2,15 +2,19 @@ package gui
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"log"
+	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
 	"megajam/db"
+	"megajam/library"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
@@ -52,11 +56,89 @@ func ExtractAlbumArt(filePath string) *canvas.Image {
 	return canvasImage
 }
 
+// trackRow is a row of the track browser. It offers a load menu on right-click
+// and can be dragged onto a deck to load the track there.
+type trackRow struct {
+	widget.BaseWidget
+	titleLabel  *widget.Label
+	artistLabel *widget.Label
+	bpmLabel    *widget.Label
+	keyLabel    *widget.Label
+	track       db.Track
+	onMenu      func(track db.Track, pos fyne.Position)
+	onDrop      func(track db.Track, pos fyne.Position)
+	dragPos     fyne.Position
+}
+
+func newTrackRow(onMenu, onDrop func(track db.Track, pos fyne.Position)) *trackRow {
+	row := &trackRow{
+		titleLabel:  widget.NewLabel("Title"),
+		artistLabel: widget.NewLabel("Artist"),
+		bpmLabel:    widget.NewLabel("BPM"),
+		keyLabel:    widget.NewLabel("Key"),
+		onMenu:      onMenu,
+		onDrop:      onDrop,
+	}
+	row.ExtendBaseWidget(row)
+	return row
+}
+
+// SetTrack shows track in the row.
+func (r *trackRow) SetTrack(track db.Track) {
+	r.track = track
+	r.titleLabel.SetText(track.Title)
+	r.artistLabel.SetText(track.Artist)
+	r.bpmLabel.SetText(formatBPM(track.BPM))
+	r.keyLabel.SetText(formatKey(track))
+}
+
+func (r *trackRow) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(container.NewGridWithColumns(len(browserColumns), r.titleLabel, r.artistLabel, r.bpmLabel, r.keyLabel))
+}
+
+// TappedSecondary opens the load menu for the row's track.
+func (r *trackRow) TappedSecondary(event *fyne.PointEvent) {
+	if r.onMenu != nil && r.track.ID != 0 {
+		r.onMenu(r.track, event.AbsolutePosition)
+	}
+}
+
+// Dragged tracks where the row is being dragged to.
+func (r *trackRow) Dragged(event *fyne.DragEvent) {
+	r.dragPos = event.AbsolutePosition
+}
+
+// DragEnd drops the row's track at the last drag position.
+func (r *trackRow) DragEnd() {
+	if r.onDrop != nil && r.track.ID != 0 {
+		r.onDrop(r.track, r.dragPos)
+	}
+}
+
+// browserColumns are the columns of the track browser; clicking a header sorts by it.
+var browserColumns = []string{"Title", "Artist", "BPM", "Key"}
+
+// allKeys is the key filter option that shows tracks in every key.
+const allKeys = "All Keys"
+
 // createEnhancedBrowserSection creates the browser interface with search and track options.
-func createEnhancedBrowserSection(myWindow fyne.Window) *fyne.Container {
+// Tracks can be loaded into decks with buttons, the row context menu or drag and drop, sorted
+// by any column and filtered by key. Key detection runs on the shown tracks in the background
+// with up to analysisWorkers at once. The returned function reloads the track list from the database.
+func createEnhancedBrowserSection(myWindow fyne.Window, decks []*deckController, analysisWorkers int) (*fyne.Container, func()) {
 	searchEntry := widget.NewEntry()
 	searchEntry.SetPlaceHolder("Search...")
 
+	keyOptions := []string{allKeys}
+	for _, mode := range []string{"A", "B"} {
+		for n := 1; n <= 12; n++ {
+			keyOptions = append(keyOptions, strconv.Itoa(n)+mode)
+		}
+	}
+	keyFilter := widget.NewSelect(keyOptions, nil)
+	keyFilter.Selected = allKeys
+	sortColumn, sortDescending := "Title", false
+
 	var tracks []db.Track
 	if err := db.DB.Find(&tracks).Error; err != nil {
 		log.Printf("Error loading tracks: %v", err)
@@ -66,13 +148,71 @@ func createEnhancedBrowserSection(myWindow fyne.Window) *fyne.Container {
 	var mutex sync.Mutex
 	selectedTrackID := uint(0)
 
+	// filterTracks rebuilds filteredTracks from tracks in the current sort order; callers must hold mutex.
+	filterTracks := func(query string) {
+		query = strings.ToLower(query)
+		filteredTracks = []db.Track{}
+		for _, track := range tracks {
+			if keyFilter.Selected != allKeys && track.Camelot != keyFilter.Selected {
+				continue
+			}
+			if strings.Contains(strings.ToLower(track.Title), query) || strings.Contains(strings.ToLower(track.Artist), query) {
+				filteredTracks = append(filteredTracks, track)
+			}
+		}
+		sort.SliceStable(filteredTracks, func(i, j int) bool {
+			if sortDescending {
+				return trackLess(filteredTracks[j], filteredTracks[i], sortColumn)
+			}
+			return trackLess(filteredTracks[i], filteredTracks[j], sortColumn)
+		})
+	}
+	filterTracks("")
+
+	// Key detection for a single track from the context menu or for every shown track.
+	statusLabel := widget.NewLabel("")
+	analyzer := library.NewAnalyzer(analysisWorkers)
+	analyzer.OnProgress = func(progress library.AnalysisProgress) {
+		if progress.Finished {
+			statusLabel.SetText(fmt.Sprintf("Key detection: %d analysed, %d failed", progress.Done-progress.Failed, progress.Failed))
+			return
+		}
+		statusLabel.SetText(fmt.Sprintf("Detecting keys %d/%d...", progress.Done, progress.Total))
+	}
+	var reload func()
+	detectKeys := func(selection []db.Track) {
+		go func() {
+			if _, err := analyzer.AnalyzeKey(context.Background(), selection); err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			reload()
+		}()
+	}
+
+	showLoadMenu := func(track db.Track, pos fyne.Position) {
+		items := make([]*fyne.MenuItem, 0, len(decks))
+		for _, deck := range decks {
+			deck := deck
+			items = append(items, fyne.NewMenuItem("Load to "+deck.name, func() { deck.Load(track) }))
+		}
+		items = append(items, fyne.NewMenuItemSeparator(), fyne.NewMenuItem("Detect Key", func() {
+			detectKeys([]db.Track{track})
+		}), fyne.NewMenuItem("Cues & Loops...", func() {
+			showCueManager(track, func() { refreshDeckOverlays(decks, track.ID) })
+		}))
+		widget.ShowPopUpMenuAtPosition(fyne.NewMenu("", items...), myWindow.Canvas(), pos)
+	}
+	dropOnDeck := func(track db.Track, pos fyne.Position) {
+		if deck := deckAt(decks, pos); deck != nil {
+			deck.Load(track)
+		}
+	}
+
 	trackList := widget.NewList(
 		func() int { return len(filteredTracks) },
 		func() fyne.CanvasObject {
-			return container.NewHBox(
-				widget.NewLabel("Title"),
-				widget.NewLabel("Artist"),
-			)
+			return newTrackRow(showLoadMenu, dropOnDeck)
 		},
 		func(id widget.ListItemID, item fyne.CanvasObject) {
 			mutex.Lock()
@@ -80,10 +220,7 @@ func createEnhancedBrowserSection(myWindow fyne.Window) *fyne.Container {
 			if id < 0 || id >= len(filteredTracks) {
 				return
 			}
-			track := filteredTracks[id]
-			labels := item.(*fyne.Container).Objects
-			labels[0].(*widget.Label).SetText(track.Title)
-			labels[1].(*widget.Label).SetText(track.Artist)
+			item.(*trackRow).SetTrack(filteredTracks[id])
 		},
 	)
 
@@ -93,19 +230,80 @@ func createEnhancedBrowserSection(myWindow fyne.Window) *fyne.Container {
 		}
 	}
 
-	searchEntry.OnChanged = func(query string) {
+	refilter := func() {
 		mutex.Lock()
-		defer mutex.Unlock()
-		query = strings.ToLower(query)
-		filteredTracks = []db.Track{}
-		for _, track := range tracks {
-			if strings.Contains(strings.ToLower(track.Title), query) || strings.Contains(strings.ToLower(track.Artist), query) {
-				filteredTracks = append(filteredTracks, track)
+		filterTracks(searchEntry.Text)
+		mutex.Unlock()
+		trackList.Refresh()
+	}
+	searchEntry.OnChanged = func(string) { refilter() }
+	keyFilter.OnChanged = func(string) { refilter() }
+
+	header := container.NewGridWithColumns(len(browserColumns))
+	for _, column := range browserColumns {
+		column := column
+		header.Add(widget.NewButton(column, func() {
+			mutex.Lock()
+			if sortColumn == column {
+				sortDescending = !sortDescending
+			} else {
+				sortColumn, sortDescending = column, false
+			}
+			mutex.Unlock()
+			refilter()
+		}))
+	}
+
+	detectKeysButton := widget.NewButton("Detect Keys", func() {
+		mutex.Lock()
+		shown := append([]db.Track(nil), filteredTracks...)
+		mutex.Unlock()
+		if len(shown) == 0 {
+			dialog.ShowInformation("No Tracks", "There are no tracks shown to analyse.", myWindow)
+			return
+		}
+		dialog.ShowConfirm("Detect Keys", fmt.Sprintf("Detect the key of the %d shown track(s)?", len(shown)), func(confirmed bool) {
+			if confirmed {
+				detectKeys(shown)
 			}
+		}, myWindow)
+	})
+
+	reload = func() {
+		var loaded []db.Track
+		if err := db.DB.Find(&loaded).Error; err != nil {
+			log.Printf("Error reloading tracks: %v", err)
+			return
 		}
+		mutex.Lock()
+		tracks = loaded
+		filterTracks(searchEntry.Text)
+		mutex.Unlock()
 		trackList.Refresh()
 	}
 
+	// Load buttons, one per deck.
+	loadButtons := container.NewHBox()
+	for _, deck := range decks {
+		deck := deck
+		loadButtons.Add(widget.NewButton("Load to "+deck.name, func() {
+			mutex.Lock()
+			var selected *db.Track
+			for i := range tracks {
+				if tracks[i].ID == selectedTrackID {
+					selected = &tracks[i]
+					break
+				}
+			}
+			mutex.Unlock()
+			if selected == nil {
+				dialog.ShowInformation("No Track Selected", "Please select a track to load.", myWindow)
+				return
+			}
+			deck.Load(*selected)
+		}))
+	}
+
 	addCueButton := widget.NewButton("Add Cue", func() {
 		if selectedTrackID == 0 {
 			dialog.ShowInformation("No Track Selected", "Please select a track to add a cue point.", myWindow)
@@ -136,6 +334,7 @@ func createEnhancedBrowserSection(myWindow fyne.Window) *fyne.Container {
 			if err := db.AddCuePoint(selectedTrackID, nameEntry.Text, time); err != nil {
 				dialog.ShowError(err, myWindow)
 			} else {
+				refreshDeckOverlays(decks, selectedTrackID)
 				dialog.ShowInformation("Success", "Cue point added successfully.", myWindow)
 			}
 		}, myWindow)
@@ -174,14 +373,84 @@ func createEnhancedBrowserSection(myWindow fyne.Window) *fyne.Container {
 			if err := db.AddLoop(selectedTrackID, nameEntry.Text, start, end); err != nil {
 				dialog.ShowError(err, myWindow)
 			} else {
+				refreshDeckOverlays(decks, selectedTrackID)
 				dialog.ShowInformation("Success", "Loop added successfully.", myWindow)
 			}
 		}, myWindow)
 	})
 
+	manageCuesButton := widget.NewButton("Cues & Loops", func() {
+		mutex.Lock()
+		var selected *db.Track
+		for i := range tracks {
+			if tracks[i].ID == selectedTrackID {
+				selected = &tracks[i]
+				break
+			}
+		}
+		mutex.Unlock()
+		if selected == nil {
+			dialog.ShowInformation("No Track Selected", "Please select a track to manage its cue points and loops.", myWindow)
+			return
+		}
+		trackID := selected.ID
+		showCueManager(*selected, func() { refreshDeckOverlays(decks, trackID) })
+	})
+
 	return container.NewVBox(
-		searchEntry,
+		container.NewBorder(nil, nil, nil, keyFilter, searchEntry),
+		header,
 		trackList,
-		container.NewHBox(addCueButton, addLoopButton),
-	)
+		loadButtons,
+		container.NewHBox(addCueButton, addLoopButton, manageCuesButton, detectKeysButton),
+		statusLabel,
+	), reload
+}
+
+// formatKey formats the key of a track for the browser, using "--" when it is unknown.
+func formatKey(track db.Track) string {
+	if track.Camelot == "" {
+		return "--"
+	}
+	return track.Camelot + " (" + track.Key + ")"
+}
+
+// trackLess reports whether track a sorts before track b in the given browser column.
+func trackLess(a, b db.Track, column string) bool {
+	switch column {
+	case "Artist":
+		return strings.ToLower(a.Artist) < strings.ToLower(b.Artist)
+	case "BPM":
+		return a.BPM < b.BPM
+	case "Key":
+		return camelotOrder(a.Camelot) < camelotOrder(b.Camelot)
+	default:
+		return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+	}
+}
+
+// camelotOrder returns the position of a Camelot key around the wheel, 1A, 1B, 2A, ...,
+// placing tracks with an unknown key last.
+func camelotOrder(camelot string) int {
+	if len(camelot) < 2 {
+		return math.MaxInt
+	}
+	number, err := strconv.Atoi(camelot[:len(camelot)-1])
+	if err != nil {
+		return math.MaxInt
+	}
+	order := number * 2
+	if camelot[len(camelot)-1] == 'B' {
+		order++
+	}
+	return order
+}
+
+// refreshDeckOverlays redraws the cue and loop overlays of every deck holding the track.
+func refreshDeckOverlays(decks []*deckController, trackID uint) {
+	for _, deck := range decks {
+		if track := deck.Track(); track != nil && track.ID == trackID {
+			deck.RefreshOverlays()
+		}
+	}
 }
@@ -0,0 +1,271 @@
+package gui
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+
+	"megajam/config"
+	"megajam/db"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// cueColorChoices are the colours offered for cue points and loops; the default colour is stored empty.
+var cueColorChoices = []struct {
+	name string
+	hex  string
+}{
+	{"Default", ""},
+	{"Red", hotCueColors[0]}, {"Orange", hotCueColors[1]}, {"Yellow", hotCueColors[2]}, {"Green", hotCueColors[3]},
+	{"Cyan", hotCueColors[4]}, {"Blue", hotCueColors[5]}, {"Purple", hotCueColors[6]}, {"Pink", hotCueColors[7]},
+}
+
+// colorSelect creates a colour chooser showing hex, or a custom entry for a colour not in cueColorChoices.
+func colorSelect(hex string) *widget.Select {
+	options := make([]string, len(cueColorChoices))
+	for i, choice := range cueColorChoices {
+		options[i] = choice.name
+	}
+	selected := widget.NewSelect(options, nil)
+	selected.Selected = options[0]
+	for _, choice := range cueColorChoices {
+		if choice.hex == hex {
+			selected.Selected = choice.name
+			return selected
+		}
+	}
+	selected.Options = append(selected.Options, hex)
+	selected.Selected = hex
+	return selected
+}
+
+// selectedColor returns the hex colour chosen in a colorSelect.
+func selectedColor(selected *widget.Select) string {
+	for _, choice := range cueColorChoices {
+		if choice.name == selected.Selected {
+			return choice.hex
+		}
+	}
+	return selected.Selected
+}
+
+// loopColor returns the translucent display colour of a loop region.
+func loopColor(loop db.Loop) color.Color {
+	c, err := config.ParseHexColor(loop.Color)
+	if err != nil {
+		return loopRegionColor
+	}
+	r, g, b, _ := c.RGBA()
+	return color.NRGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: loopRegionColor.A}
+}
+
+// showCueManager opens a window listing the cue points and loops of track, where they can be
+// edited, reordered and deleted. onChange is called after every change.
+func showCueManager(track db.Track, onChange func()) {
+	managerWindow := fyne.CurrentApp().NewWindow("Cues & Loops: " + track.Title)
+	var cues []db.CuePoint
+	var loops []db.Loop
+	selectedCue, selectedLoop := -1, -1
+
+	cueList := widget.NewList(
+		func() int { return len(cues) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			item.(*widget.Label).SetText(cueDescription(cues[id]))
+		},
+	)
+	cueList.OnSelected = func(id widget.ListItemID) { selectedCue = id }
+	loopList := widget.NewList(
+		func() int { return len(loops) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			item.(*widget.Label).SetText(loopDescription(loops[id]))
+		},
+	)
+	loopList.OnSelected = func(id widget.ListItemID) { selectedLoop = id }
+
+	reload := func() {
+		var err error
+		if cues, err = db.GetCuePoints(track.ID); err != nil {
+			dialog.ShowError(err, managerWindow)
+		}
+		if loops, err = db.GetLoops(track.ID); err != nil {
+			dialog.ShowError(err, managerWindow)
+		}
+		selectedCue, selectedLoop = -1, -1
+		cueList.UnselectAll()
+		loopList.UnselectAll()
+		cueList.Refresh()
+		loopList.Refresh()
+	}
+	// changed reports err, or reloads the lists and notifies onChange after a successful change.
+	changed := func(err error) {
+		if err != nil {
+			dialog.ShowError(err, managerWindow)
+		}
+		reload()
+		if onChange != nil {
+			onChange()
+		}
+	}
+	reload()
+
+	// Cue point actions
+	editCue := widget.NewButton("Edit", func() {
+		if selectedCue < 0 || selectedCue >= len(cues) {
+			return
+		}
+		cue := cues[selectedCue]
+		nameEntry := widget.NewEntry()
+		nameEntry.SetText(cue.Name)
+		timeEntry := widget.NewEntry()
+		timeEntry.SetText(strconv.FormatFloat(cue.Time, 'f', 3, 64))
+		padOptions := []string{"None"}
+		for pad := 1; pad <= db.HotCuePads; pad++ {
+			padOptions = append(padOptions, strconv.Itoa(pad))
+		}
+		padSelect := widget.NewSelect(padOptions, nil)
+		padSelect.Selected = padOptions[cue.Pad]
+		cueColors := colorSelect(cue.Color)
+		dialog.ShowForm("Edit Cue Point", "Save", "Cancel", []*widget.FormItem{
+			widget.NewFormItem("Name", nameEntry),
+			widget.NewFormItem("Time (seconds)", timeEntry),
+			widget.NewFormItem("Hot cue pad", padSelect),
+			widget.NewFormItem("Colour", cueColors),
+		}, func(confirmed bool) {
+			if !confirmed {
+				return
+			}
+			time, err := strconv.ParseFloat(timeEntry.Text, 64)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("invalid time format"), managerWindow)
+				return
+			}
+			cue.Name, cue.Time, cue.Color = nameEntry.Text, time, selectedColor(cueColors)
+			cue.Pad, _ = strconv.Atoi(padSelect.Selected) // "None" leaves the cue off the pads
+			changed(db.UpdateCuePoint(&cue))
+		}, managerWindow)
+	})
+	deleteCue := widget.NewButton("Delete", func() {
+		if selectedCue >= 0 && selectedCue < len(cues) {
+			changed(db.DeleteCuePoint(cues[selectedCue].ID))
+		}
+	})
+	moveCue := func(delta int) func() {
+		return func() {
+			if to := selectedCue + delta; selectedCue >= 0 && to >= 0 && to < len(cues) {
+				ids := make([]uint, len(cues))
+				for i, cue := range cues {
+					ids[i] = cue.ID
+				}
+				ids[selectedCue], ids[to] = ids[to], ids[selectedCue]
+				changed(db.ReorderCuePoints(track.ID, ids))
+				cueList.Select(to)
+			}
+		}
+	}
+	clearCues := widget.NewButton("Clear All", func() {
+		dialog.ShowConfirm("Clear Cue Points", "Delete every cue point and hot cue of this track?", func(confirmed bool) {
+			if confirmed {
+				_, err := db.ClearCuePoints(track.ID)
+				changed(err)
+			}
+		}, managerWindow)
+	})
+
+	// Loop actions
+	editLoop := widget.NewButton("Edit", func() {
+		if selectedLoop < 0 || selectedLoop >= len(loops) {
+			return
+		}
+		loop := loops[selectedLoop]
+		nameEntry := widget.NewEntry()
+		nameEntry.SetText(loop.Name)
+		startEntry := widget.NewEntry()
+		startEntry.SetText(strconv.FormatFloat(loop.Start, 'f', 3, 64))
+		endEntry := widget.NewEntry()
+		endEntry.SetText(strconv.FormatFloat(loop.End, 'f', 3, 64))
+		loopColors := colorSelect(loop.Color)
+		dialog.ShowForm("Edit Loop", "Save", "Cancel", []*widget.FormItem{
+			widget.NewFormItem("Name", nameEntry),
+			widget.NewFormItem("Start Time (seconds)", startEntry),
+			widget.NewFormItem("End Time (seconds)", endEntry),
+			widget.NewFormItem("Colour", loopColors),
+		}, func(confirmed bool) {
+			if !confirmed {
+				return
+			}
+			start, err1 := strconv.ParseFloat(startEntry.Text, 64)
+			end, err2 := strconv.ParseFloat(endEntry.Text, 64)
+			if err1 != nil || err2 != nil {
+				dialog.ShowError(fmt.Errorf("invalid start or end time"), managerWindow)
+				return
+			}
+			loop.Name, loop.Start, loop.End, loop.Color = nameEntry.Text, start, end, selectedColor(loopColors)
+			changed(db.UpdateLoop(&loop))
+		}, managerWindow)
+	})
+	deleteLoop := widget.NewButton("Delete", func() {
+		if selectedLoop >= 0 && selectedLoop < len(loops) {
+			changed(db.DeleteLoop(loops[selectedLoop].ID))
+		}
+	})
+	moveLoop := func(delta int) func() {
+		return func() {
+			if to := selectedLoop + delta; selectedLoop >= 0 && to >= 0 && to < len(loops) {
+				ids := make([]uint, len(loops))
+				for i, loop := range loops {
+					ids[i] = loop.ID
+				}
+				ids[selectedLoop], ids[to] = ids[to], ids[selectedLoop]
+				changed(db.ReorderLoops(track.ID, ids))
+				loopList.Select(to)
+			}
+		}
+	}
+	clearLoops := widget.NewButton("Clear All", func() {
+		dialog.ShowConfirm("Clear Loops", "Delete every loop of this track?", func(confirmed bool) {
+			if confirmed {
+				_, err := db.ClearLoops(track.ID)
+				changed(err)
+			}
+		}, managerWindow)
+	})
+
+	tabs := container.NewAppTabs(
+		container.NewTabItem("Cue Points", container.NewBorder(nil,
+			container.NewHBox(editCue, deleteCue, widget.NewButton("Up", moveCue(-1)), widget.NewButton("Down", moveCue(1)), clearCues),
+			nil, nil, cueList)),
+		container.NewTabItem("Loops", container.NewBorder(nil,
+			container.NewHBox(editLoop, deleteLoop, widget.NewButton("Up", moveLoop(-1)), widget.NewButton("Down", moveLoop(1)), clearLoops),
+			nil, nil, loopList)),
+	)
+	managerWindow.SetContent(tabs)
+	managerWindow.Resize(fyne.NewSize(480, 400))
+	managerWindow.Show()
+}
+
+// cueDescription describes a cue point in the manager list.
+func cueDescription(cue db.CuePoint) string {
+	text := formatCueTime(cue.Time)
+	if cue.Pad > 0 {
+		text = fmt.Sprintf("[Pad %d] %s", cue.Pad, text)
+	}
+	if cue.Name != "" {
+		text += "  " + cue.Name
+	}
+	return text
+}
+
+// loopDescription describes a loop in the manager list.
+func loopDescription(loop db.Loop) string {
+	text := formatCueTime(loop.Start) + " - " + formatCueTime(loop.End)
+	if loop.Name != "" {
+		text += "  " + loop.Name
+	}
+	return text
+}
@@ -1,86 +1,465 @@
 package gui
 
 import (
-    "image/color"
-    "log"
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
 
-    "fyne.io/fyne/v2"
-    "fyne.io/fyne/v2/canvas"
-    "fyne.io/fyne/v2/container"
-    "fyne.io/fyne/v2/widget"
+	"megajam/db"
+	"megajam/waveform"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
 )
 
+// defaultArtwork is shown when a deck is empty or the loaded track has no album art.
+var defaultArtwork = fyne.NewStaticResource("default.png", nil)
+
+// DeckSection is the on-screen part of a deck. Its display changes when a track is loaded.
+type DeckSection struct {
+	Container  *fyne.Container
+	titleLabel *widget.Label
+	timeLabel  *widget.Label
+	bpmLabel   *widget.Label
+	artwork    *canvas.Image
+	playButton *widget.Button
+	overview   *waveform.Overview
+	waveform   *waveform.ScrollingWaveform
+
+	onTapTempo  func()
+	onEditTempo func()
+	onSyncLock  func(bool)
+	onKeyLock   func(bool)
+	onPad       func(pad int)
+	onCue       func()
+	onQuantize  func(bool)
+	onClearPad  func(pad int)
+
+	pads       [db.HotCuePads]*hotCuePad
+	shiftCheck *widget.Check // While checked, pressing a pad clears it
+
+	loopHandlers LoopHandlers
+	reloopButton *widget.Button
+	savedLoops   *widget.Select
+
+	pitchSlider   *widget.Slider
+	rangeSelect   *widget.Select
+	pitchMu       sync.Mutex  // Serialises SetPitch, which is called from the UI and playback goroutines
+	updatingPitch atomic.Bool // Set while the slider follows the deck, so the change is not sent back
+}
+
+// LoopHandlers are the functions called by the loop controls of a deck.
+type LoopHandlers struct {
+	In, Out, Reloop, Halve, Double func()
+	Auto                           func(beats float64) // Loops the selected number of beats
+	Move                           func(beats float64) // Moves the loop, negative for backwards
+	Recall                         func(index int)     // Plays a saved loop, by its index in SetSavedLoops
+}
+
+// loopLengths are the auto-loop lengths in beats, with their labels.
+var loopLengths = []struct {
+	label string
+	beats float64
+}{
+	{"1/32", 1.0 / 32}, {"1/16", 1.0 / 16}, {"1/8", 1.0 / 8}, {"1/4", 0.25}, {"1/2", 0.5},
+	{"1", 1}, {"2", 2}, {"4", 4}, {"8", 8}, {"16", 16}, {"32", 32},
+}
+
+// pitchRanges are the selectable ranges of the pitch fader, in percent.
+var pitchRanges = []float64{8, 16, 50}
+
 // CreateDeckSection creates the deck interface with play/pause, sync, pitch control, and pads.
-func CreateDeckSection(deckName, songTitle, timeLeft, bpm string, mp3Image *canvas.Image, playPauseHandler func(), syncHandler func(), pitchHandler func(float64)) *fyne.Container {
-    // Sync Button
-    syncButton := widget.NewButton("Sync", func() {
-        if syncHandler != nil {
-            syncHandler()
-        } else {
-            log.Println("Sync handler not implemented")
-        }
-    })
-
-    // Circular Display
-    titleLabel := widget.NewLabelWithStyle(songTitle, fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
-    timeLabel := widget.NewLabel(timeLeft)
-    bpmLabel := widget.NewLabel("BPM: " + bpm)
-
-    if mp3Image == nil {
-        // Provide a default image if none is supplied
-        // You need to provide a default.png resource or handle it accordingly
-        mp3Image = canvas.NewImageFromResource(fyne.NewStaticResource("default.png", nil))
-        mp3Image.FillMode = canvas.ImageFillContain
-        mp3Image.SetMinSize(fyne.NewSize(100, 100))
-    }
-
-    mainDisplay := container.NewVBox(
-        mp3Image,
-        titleLabel,
-        timeLabel,
-        bpmLabel,
-    )
-    mainDisplayContainer := container.NewStack(
-        canvas.NewCircle(color.Black), // Circular background
-        mainDisplay,
-    )
-
-    // Pitch Control Slider
-    pitchSlider := widget.NewSlider(-10, 10) // Adjust range as needed
-    pitchSlider.Orientation = widget.Vertical
-    pitchSlider.OnChanged = pitchHandler
-    pitchControl := container.NewVBox(
-        widget.NewLabelWithStyle("Pitch Control", fyne.TextAlignCenter, fyne.TextStyle{}),
-        pitchSlider,
-    )
-
-    // Play/Pause Button
-    playPauseButton := widget.NewButton("Play", func() {
-        if playPauseHandler != nil {
-            playPauseHandler()
-        } else {
-            log.Println("Play/Pause handler not implemented")
-        }
-    })
-
-    // Pads
-    pads := container.NewGridWithColumns(4,
-        widget.NewButton("Pad 1", func() { log.Println("Pad 1 pressed") }),
-        widget.NewButton("Pad 2", func() { log.Println("Pad 2 pressed") }),
-        widget.NewButton("Pad 3", func() { log.Println("Pad 3 pressed") }),
-        widget.NewButton("Pad 4", func() { log.Println("Pad 4 pressed") }),
-        widget.NewButton("Pad 5", func() { log.Println("Pad 5 pressed") }),
-        widget.NewButton("Pad 6", func() { log.Println("Pad 6 pressed") }),
-        widget.NewButton("Pad 7", func() { log.Println("Pad 7 pressed") }),
-        widget.NewButton("Pad 8", func() { log.Println("Pad 8 pressed") }),
-    )
-
-    // Assemble Deck Layout
-    return container.NewVBox(
-        widget.NewLabelWithStyle(deckName, fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
-        container.NewHBox(syncButton, widget.NewLabel("")),    // Sync button
-        container.NewHBox(mainDisplayContainer, pitchControl), // Main Display and Pitch Control
-        playPauseButton,                                       // Play/Pause button
-        container.NewVBox(widget.NewLabel("PADS"), pads),      // Pads section
-    )
+func CreateDeckSection(deckName, songTitle, timeLeft, bpm string, mp3Image *canvas.Image, playPauseHandler func(), syncHandler func(), pitchHandler func(float64)) *DeckSection {
+	// Sync Button
+	syncButton := widget.NewButton("Sync", func() {
+		if syncHandler != nil {
+			syncHandler()
+		} else {
+			log.Println("Sync handler not implemented")
+		}
+	})
+
+	// Tempo correction buttons; their handlers are set with SetTempoHandlers.
+	section := &DeckSection{}
+	tapButton := widget.NewButton("Tap", func() {
+		if section.onTapTempo != nil {
+			section.onTapTempo()
+		}
+	})
+	editTempoButton := widget.NewButton("Edit BPM", func() {
+		if section.onEditTempo != nil {
+			section.onEditTempo()
+		}
+	})
+	syncLockCheck := widget.NewCheck("Lock", func(locked bool) {
+		if section.onSyncLock != nil {
+			section.onSyncLock(locked)
+		}
+	})
+
+	// Circular Display
+	titleLabel := widget.NewLabelWithStyle(songTitle, fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
+	timeLabel := widget.NewLabel(timeLeft)
+	bpmLabel := widget.NewLabel("BPM: " + bpm)
+
+	if mp3Image == nil {
+		// Provide a default image if none is supplied
+		mp3Image = canvas.NewImageFromResource(defaultArtwork)
+	}
+	mp3Image.FillMode = canvas.ImageFillContain
+	mp3Image.SetMinSize(fyne.NewSize(100, 100))
+
+	mainDisplay := container.NewVBox(
+		mp3Image,
+		titleLabel,
+		timeLabel,
+		bpmLabel,
+	)
+	mainDisplayContainer := container.NewStack(
+		canvas.NewCircle(color.Black), // Circular background
+		mainDisplay,
+	)
+
+	// Pitch Control Slider, in percent of the original tempo
+	pitchSlider := widget.NewSlider(-pitchRanges[0], pitchRanges[0])
+	pitchSlider.Orientation = widget.Vertical
+	pitchSlider.Step = 0.05
+	pitchLabel := widget.NewLabel(formatPitch(0))
+	pitchSlider.OnChanged = func(value float64) {
+		pitchLabel.SetText(formatPitch(value))
+		if pitchHandler != nil && !section.updatingPitch.Load() {
+			pitchHandler(value)
+		}
+	}
+	rangeOptions := make([]string, len(pitchRanges))
+	for i, pitchRange := range pitchRanges {
+		rangeOptions[i] = fmt.Sprintf("±%g%%", pitchRange)
+	}
+	rangeSelect := widget.NewSelect(rangeOptions, func(selected string) {
+		for i, option := range rangeOptions {
+			if option == selected {
+				pitchSlider.Min, pitchSlider.Max = -pitchRanges[i], pitchRanges[i]
+				// SetValue clamps the current pitch into the new range.
+				pitchSlider.SetValue(pitchSlider.Value)
+				pitchSlider.Refresh()
+			}
+		}
+	})
+	rangeSelect.Selected = rangeOptions[0] // Matches the slider's initial range without calling back
+	resetPitchButton := widget.NewButton("0%", func() { pitchSlider.SetValue(0) })
+	keyLockCheck := widget.NewCheck("Key Lock", func(keyLock bool) {
+		if section.onKeyLock != nil {
+			section.onKeyLock(keyLock)
+		}
+	})
+	pitchControl := container.NewBorder(
+		container.NewVBox(
+			widget.NewLabelWithStyle("Pitch Control", fyne.TextAlignCenter, fyne.TextStyle{}),
+			rangeSelect,
+		),
+		container.NewVBox(pitchLabel, resetPitchButton, keyLockCheck),
+		nil, nil,
+		pitchSlider,
+	)
+
+	// Play/Pause Button
+	playPauseButton := widget.NewButton("Play", func() {
+		if playPauseHandler != nil {
+			playPauseHandler()
+		} else {
+			log.Println("Play/Pause handler not implemented")
+		}
+	})
+	cueButton := widget.NewButton("Cue", func() {
+		if section.onCue != nil {
+			section.onCue()
+		}
+	})
+	quantizeCheck := widget.NewCheck("Quantize", func(quantize bool) {
+		if section.onQuantize != nil {
+			section.onQuantize(quantize)
+		}
+	})
+
+	// Waveforms: an overview of the whole track above a zoomable view around the playhead
+	overview := waveform.NewOverview()
+	scrolling := waveform.NewScrollingWaveform()
+	zoomButtons := container.NewVBox(
+		widget.NewButtonWithIcon("", theme.ZoomInIcon(), scrolling.ZoomIn),
+		widget.NewButtonWithIcon("", theme.ZoomOutIcon(), scrolling.ZoomOut),
+	)
+	waveforms := container.NewVBox(overview, container.NewBorder(nil, nil, nil, zoomButtons, scrolling))
+
+	// Hot cue pads: a tap sets or jumps to the pad's cue; right-click or Shift and tap clears it.
+	shiftCheck := widget.NewCheck("Shift", nil)
+	pads := container.NewGridWithColumns(4)
+	for i := range section.pads {
+		pad := i + 1
+		clear := func() {
+			if section.onClearPad != nil {
+				section.onClearPad(pad)
+			}
+		}
+		section.pads[i] = newHotCuePad(pad, func() {
+			if shiftCheck.Checked {
+				clear()
+			} else if section.onPad != nil {
+				section.onPad(pad)
+			}
+		}, clear)
+		pads.Add(section.pads[i].container)
+	}
+
+	// Loop controls
+	call := func(handler *func()) func() {
+		return func() {
+			if *handler != nil {
+				(*handler)()
+			}
+		}
+	}
+	lengthOptions := make([]string, len(loopLengths))
+	for i, length := range loopLengths {
+		lengthOptions[i] = length.label
+	}
+	lengthSelect := widget.NewSelect(lengthOptions, nil)
+	lengthSelect.Selected = "4"
+	autoLoopButton := widget.NewButton("Loop", func() {
+		for _, length := range loopLengths {
+			if length.label == lengthSelect.Selected && section.loopHandlers.Auto != nil {
+				section.loopHandlers.Auto(length.beats)
+			}
+		}
+	})
+	move := func(beats float64) func() {
+		return func() {
+			if section.loopHandlers.Move != nil {
+				section.loopHandlers.Move(beats)
+			}
+		}
+	}
+	reloopButton := widget.NewButton("Reloop", call(&section.loopHandlers.Reloop))
+	savedLoops := widget.NewSelect(nil, nil)
+	savedLoops.PlaceHolder = "Saved loops"
+	savedLoops.OnChanged = func(selected string) {
+		if selected == "" {
+			return // Cleared after the previous recall
+		}
+		if index := savedLoops.SelectedIndex(); index >= 0 && section.loopHandlers.Recall != nil {
+			section.loopHandlers.Recall(index)
+		}
+		savedLoops.ClearSelected()
+	}
+	loopControls := container.NewVBox(
+		container.NewHBox(
+			widget.NewButton("In", call(&section.loopHandlers.In)),
+			widget.NewButton("Out", call(&section.loopHandlers.Out)),
+			reloopButton,
+			lengthSelect,
+			autoLoopButton,
+		),
+		container.NewHBox(
+			widget.NewButton("½", call(&section.loopHandlers.Halve)),
+			widget.NewButton("×2", call(&section.loopHandlers.Double)),
+			widget.NewButtonWithIcon("", theme.NavigateBackIcon(), move(-1)),
+			widget.NewButtonWithIcon("", theme.NavigateNextIcon(), move(1)),
+			savedLoops,
+		),
+	)
+
+	// Assemble Deck Layout
+	sectionPads := section.pads
+	*section = DeckSection{
+		Container: container.NewVBox(
+			widget.NewLabelWithStyle(deckName, fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
+			waveforms, // Overview and scrolling waveform
+			container.NewHBox(syncButton, syncLockCheck, tapButton, editTempoButton, quantizeCheck), // Sync and tempo buttons
+			container.NewHBox(mainDisplayContainer, pitchControl),                                   // Main Display and Pitch Control
+			container.NewGridWithColumns(2, cueButton, playPauseButton),                             // Cue and Play/Pause buttons
+			container.NewVBox(widget.NewLabel("LOOP"), loopControls),                                // Loop section
+			container.NewVBox(container.NewHBox(widget.NewLabel("PADS"), shiftCheck), pads),         // Pads section
+		),
+		titleLabel: titleLabel,
+		timeLabel:  timeLabel,
+		bpmLabel:   bpmLabel,
+		artwork:    mp3Image,
+		playButton: playPauseButton,
+		overview:   overview,
+		waveform:   scrolling,
+
+		pitchSlider: pitchSlider,
+		rangeSelect: rangeSelect,
+		pads:        sectionPads,
+		shiftCheck:  shiftCheck,
+
+		reloopButton: reloopButton,
+		savedLoops:   savedLoops,
+	}
+	return section
+}
+
+// SetTrack updates the deck display for a newly loaded track. A nil image restores the default artwork.
+func (d *DeckSection) SetTrack(songTitle, timeLeft, bpm string, mp3Image *canvas.Image) {
+	d.titleLabel.SetText(songTitle)
+	d.timeLabel.SetText(timeLeft)
+	d.bpmLabel.SetText("BPM: " + bpm)
+
+	if mp3Image != nil {
+		d.artwork.Resource = nil
+		d.artwork.Image = mp3Image.Image
+	} else {
+		d.artwork.Image = nil
+		d.artwork.Resource = defaultArtwork
+	}
+	d.artwork.Refresh()
+}
+
+// SetBPM updates the tempo shown on the deck.
+func (d *DeckSection) SetBPM(bpm string) {
+	d.bpmLabel.SetText("BPM: " + bpm)
+}
+
+// SetTempoHandlers sets the functions called by the Tap and Edit BPM buttons.
+func (d *DeckSection) SetTempoHandlers(onTap, onEdit func()) {
+	d.onTapTempo = onTap
+	d.onEditTempo = onEdit
+}
+
+// SetSyncLockHandler sets the function called when the sync Lock option is toggled.
+func (d *DeckSection) SetSyncLockHandler(onSyncLock func(locked bool)) {
+	d.onSyncLock = onSyncLock
+}
+
+// SetKeyLockHandler sets the function called when the Key Lock option is toggled.
+func (d *DeckSection) SetKeyLockHandler(onKeyLock func(keyLock bool)) {
+	d.onKeyLock = onKeyLock
+}
+
+// SetPadHandlers sets the functions called when a hot cue pad is pressed or cleared, with
+// the pad number from 1.
+func (d *DeckSection) SetPadHandlers(onPad, onClear func(pad int)) {
+	d.onPad = onPad
+	d.onClearPad = onClear
+}
+
+// SetHotCues shows the hot cues of the loaded track on the pads; pads without a cue are shown empty.
+func (d *DeckSection) SetHotCues(cues []db.CuePoint) {
+	var byPad [db.HotCuePads]*db.CuePoint
+	for i := range cues {
+		if cues[i].Pad >= 1 && cues[i].Pad <= db.HotCuePads {
+			byPad[cues[i].Pad-1] = &cues[i]
+		}
+	}
+	for i, pad := range d.pads {
+		pad.set(i+1, byPad[i])
+	}
+}
+
+// SetTransportHandlers sets the functions called by the Cue button and the Quantize option.
+func (d *DeckSection) SetTransportHandlers(onCue func(), onQuantize func(quantize bool)) {
+	d.onCue = onCue
+	d.onQuantize = onQuantize
+}
+
+// SetLoopHandlers sets the functions called by the loop controls.
+func (d *DeckSection) SetLoopHandlers(handlers LoopHandlers) {
+	d.loopHandlers = handlers
+}
+
+// SetLoopActive highlights the Reloop button, which exits the loop, while a loop plays.
+func (d *DeckSection) SetLoopActive(active bool) {
+	if active {
+		d.reloopButton.SetText("Exit")
+		d.reloopButton.Importance = widget.HighImportance
+	} else {
+		d.reloopButton.SetText("Reloop")
+		d.reloopButton.Importance = widget.MediumImportance
+	}
+	d.reloopButton.Refresh()
+}
+
+// SetSavedLoops lists the saved loops of the loaded track by name.
+func (d *DeckSection) SetSavedLoops(names []string) {
+	d.savedLoops.Options = names
+	d.savedLoops.Refresh()
+}
+
+// SetPitch moves the pitch fader to percent without calling the pitch handler, for when the
+// deck's rate was changed elsewhere, such as by sync. When percent is outside the fader's
+// range, the range is widened so that the fader shows the real rate and does not jump the
+// tempo when it is next moved.
+func (d *DeckSection) SetPitch(percent float64) {
+	d.pitchMu.Lock()
+	defer d.pitchMu.Unlock()
+	if math.Abs(percent) > d.pitchSlider.Max {
+		for i, pitchRange := range pitchRanges {
+			if math.Abs(percent) <= pitchRange || i == len(pitchRanges)-1 {
+				d.pitchSlider.Min, d.pitchSlider.Max = -pitchRange, pitchRange
+				d.rangeSelect.Selected = d.rangeSelect.Options[i] // Without calling back, which would clamp the pitch
+				d.rangeSelect.Refresh()
+				break
+			}
+		}
+	}
+	d.updatingPitch.Store(true)
+	d.pitchSlider.SetValue(percent)
+	d.updatingPitch.Store(false)
+}
+
+// formatPitch formats a pitch fader position for display.
+func formatPitch(percent float64) string {
+	return fmt.Sprintf("%+.2f%%", percent)
+}
+
+// SetTime updates the running clock of the deck.
+func (d *DeckSection) SetTime(timeLeft string) {
+	d.timeLabel.SetText(timeLeft)
+}
+
+// SetPlaying switches the play/pause button label to match the transport state.
+func (d *DeckSection) SetPlaying(playing bool) {
+	if playing {
+		d.playButton.SetText("Pause")
+	} else {
+		d.playButton.SetText("Play")
+	}
+}
+
+// SetPeaks shows the waveform of the loaded track; nil levels clear it.
+func (d *DeckSection) SetPeaks(levels []waveform.Peaks, duration float64) {
+	d.overview.SetPeaks(levels, duration)
+	d.waveform.SetPeaks(levels, duration)
+}
+
+// SetPosition moves the playhead of both waveforms to seconds.
+func (d *DeckSection) SetPosition(seconds float64) {
+	d.overview.SetPosition(seconds)
+	d.waveform.SetPosition(seconds)
+}
+
+// SetOverlays replaces the cue markers and loop regions drawn over both waveforms.
+func (d *DeckSection) SetOverlays(markers []waveform.Marker, regions []waveform.Region) {
+	d.overview.SetOverlays(markers, regions)
+	d.waveform.SetOverlays(markers, regions)
+}
+
+// SetSeekHandler sets the function called with the time tapped on either waveform.
+func (d *DeckSection) SetSeekHandler(onSeek func(seconds float64)) {
+	d.overview.OnSeek = onSeek
+	d.waveform.OnSeek = onSeek
+}
+
+// Contains reports whether the absolute canvas position pos lies inside the deck.
+func (d *DeckSection) Contains(pos fyne.Position) bool {
+	driver := fyne.CurrentApp().Driver()
+	topLeft := driver.AbsolutePositionForObject(d.Container)
+	size := d.Container.Size()
+	return pos.X >= topLeft.X && pos.X < topLeft.X+size.Width &&
+		pos.Y >= topLeft.Y && pos.Y < topLeft.Y+size.Height
 }
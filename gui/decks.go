@@ -0,0 +1,617 @@
+package gui
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"megajam/analysis"
+	"megajam/db"
+	"megajam/library"
+	"megajam/logger"
+	"megajam/player"
+	"megajam/waveform"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Colours of the cue markers and loop regions drawn over the deck waveforms.
+var (
+	cueMarkerColor  = color.NRGBA{R: 255, G: 140, B: 0, A: 255}
+	loopRegionColor = color.NRGBA{R: 0, G: 200, B: 80, A: 90}
+	activeLoopColor = color.NRGBA{R: 255, G: 220, B: 0, A: 110} // The loop playing on the deck
+)
+
+// beatsPerBar is the number of beats in a bar of the beatgrid.
+const beatsPerBar = 4
+
+// deckController connects a DeckSection to its deck in the audio engine. Its methods are
+// called from the UI, from the goroutine following the deck's playback events and from the
+// goroutines analysing a loaded track.
+type deckController struct {
+	name    string
+	deck    *player.Deck
+	section *DeckSection
+	window  fyne.Window
+	cache   *analysis.Cache
+
+	mu    sync.Mutex // Guards the fields below
+	track *db.Track  // Track loaded into the deck, nil when empty
+
+	beatgrid *db.Beatgrid  // Beatgrid of the loaded track, nil until known
+	hotCues  []db.CuePoint // Hot cues of the loaded track
+	loops    []db.Loop     // Saved loops of the loaded track
+
+	markers    []waveform.Marker // Overlays of the saved cues and loops
+	regions    []waveform.Region
+	activeLoop waveform.Region // Loop playing on the deck, zero when none
+	tap        tapTempo
+	bpmText    string  // Tempo shown on the deck, to avoid redundant label updates
+	rate       float64 // Rate shown on the pitch fader
+
+	// OnWaveform is called with the waveform of each loaded track once it is available.
+	OnWaveform func(*analysis.Waveform)
+}
+
+// newDeckController creates a controller for an empty deck and starts following its playback events.
+func newDeckController(name string, deck *player.Deck, section *DeckSection, window fyne.Window, cache *analysis.Cache) *deckController {
+	c := &deckController{name: name, deck: deck, section: section, window: window, cache: cache, rate: 1}
+	section.SetSeekHandler(c.Seek)
+	section.SetTempoHandlers(c.TapTempo, c.EditTempo)
+	section.SetSyncLockHandler(deck.SetSyncLock)
+	section.SetKeyLockHandler(c.SetKeyLock)
+	section.SetPadHandlers(c.PressPad, c.ClearPad)
+	section.SetTransportHandlers(c.Cue, deck.SetQuantize)
+	section.SetLoopHandlers(LoopHandlers{
+		In:     c.loopAction("loop in", deck.LoopIn),
+		Out:    c.loopAction("loop out", deck.LoopOut),
+		Reloop: c.loopAction("reloop", deck.Reloop),
+		Halve:  c.loopAction("halve loop", deck.HalveLoop),
+		Double: c.loopAction("double loop", deck.DoubleLoop),
+		Auto: func(beats float64) {
+			c.loopAction("auto loop", func() error { return deck.AutoLoop(beats) })()
+		},
+		Move: func(beats float64) {
+			c.loopAction("move loop", func() error { return deck.MoveLoop(beats) })()
+		},
+		Recall: c.RecallLoop,
+	})
+	go c.followEvents(deck.Subscribe())
+	return c
+}
+
+// followEvents keeps the deck display in step with playback.
+func (c *deckController) followEvents(events <-chan player.Event) {
+	for event := range events {
+		switch event.Type {
+		case player.EventPosition:
+			c.section.SetTime(formatClock(event.Position, c.deck.Duration()))
+			c.section.SetPosition(event.Position.Seconds())
+			c.refreshBPM()
+			c.refreshLoop()
+		case player.EventEnded:
+			c.section.SetTime(formatClock(event.Position, c.deck.Duration()))
+			c.section.SetPosition(event.Position.Seconds())
+			c.section.SetPlaying(false)
+			logger.Logger.Printf("%s Deck: track ended", c.name)
+		}
+	}
+}
+
+// Load opens the track in the deck and shows its details on the deck display.
+func (c *deckController) Load(track db.Track) {
+	// The deck and the controller switch tracks together, so a beatgrid analysed for the
+	// previous track cannot reach the deck in between.
+	c.mu.Lock()
+	err := c.deck.Load(track.Path)
+	if err == nil {
+		c.track = &track
+		c.beatgrid = nil
+		c.bpmText = formatBPM(track.BPM)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		logger.Logger.Printf("%s Deck: failed to load '%s': %v", c.name, track.Path, err)
+		dialog.ShowError(err, c.window)
+		return
+	}
+	c.section.SetTrack(track.Title, formatClock(0, c.deck.Duration()), formatBPM(track.BPM), ExtractAlbumArt(track.Path))
+	c.section.SetPlaying(false)
+	c.section.SetPeaks(nil, c.deck.Duration().Seconds())
+	c.refreshLoop()
+	c.RefreshOverlays()
+	logger.Logger.Printf("%s Deck: loaded '%s'", c.name, track.Title)
+	go c.loadWaveform(track.Path)
+	go c.loadBeatgrid(track)
+}
+
+// Track returns a copy of the track loaded into the deck, or nil when the deck is empty.
+func (c *deckController) Track() *db.Track {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.track == nil {
+		return nil
+	}
+	track := *c.track
+	return &track
+}
+
+// Beatgrid returns the beatgrid of the loaded track, or nil until it is known.
+func (c *deckController) Beatgrid() *db.Beatgrid {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.beatgrid
+}
+
+// loadBeatgrid fetches the beatgrid of the track, detecting its tempo first if needed.
+func (c *deckController) loadBeatgrid(track db.Track) {
+	grid, err := db.GetBeatgrid(track.ID)
+	if err == nil && grid == nil {
+		grid, err = library.AnalyzeTrackTempo(track)
+	}
+	if err != nil {
+		logger.Logger.Printf("%s Deck: tempo analysis failed for '%s': %v", c.name, track.Path, err)
+		return
+	}
+	c.setBeatgrid(track.ID, grid)
+}
+
+// setBeatgrid makes grid the beatgrid of the loaded track and shows its tempo. The grid is
+// dropped when another track has been loaded since it was requested.
+func (c *deckController) setBeatgrid(trackID uint, grid *db.Beatgrid) {
+	c.mu.Lock()
+	if c.track == nil || c.track.ID != trackID {
+		c.mu.Unlock()
+		return
+	}
+	c.beatgrid = grid
+	c.track.BPM = grid.BPM
+	c.deck.SetBeatgrid(&player.Beatgrid{
+		BPM:    grid.BPM,
+		Offset: time.Duration(grid.Offset * float64(time.Second)),
+	})
+	c.mu.Unlock()
+	c.refreshBPM()
+}
+
+// SetPitch changes the deck's playback rate by percent of the original tempo.
+func (c *deckController) SetPitch(percent float64) {
+	c.mu.Lock()
+	c.rate = 1 + percent/100
+	c.deck.SetRate(c.rate)
+	c.mu.Unlock()
+	c.refreshBPM()
+}
+
+// SetKeyLock sets whether the deck keeps the pitch of its track when the tempo changes.
+func (c *deckController) SetKeyLock(keyLock bool) {
+	if err := c.deck.SetKeyLock(keyLock); err != nil {
+		logger.Logger.Printf("%s Deck: %v", c.name, err)
+		dialog.ShowError(err, c.window)
+		return
+	}
+	logger.Logger.Printf("%s Deck: key lock %t", c.name, keyLock)
+}
+
+// refreshBPM shows the tempo the deck is playing at, which differs from the track's tempo
+// when the deck is pitched or synced, and moves the pitch fader when sync changed the rate.
+func (c *deckController) refreshBPM() {
+	c.mu.Lock()
+	rate := c.deck.Rate()
+	rateChanged := rate != c.rate
+	c.rate = rate
+	bpm := c.deck.BPM()
+	if bpm == 0 && c.track != nil {
+		bpm = c.track.BPM
+	}
+	text := formatBPM(bpm)
+	bpmChanged := text != c.bpmText
+	c.bpmText = text
+	c.mu.Unlock()
+
+	// The pitch fader is moved outside the lock, as moving it calls back into the controller.
+	if rateChanged {
+		c.section.SetPitch((rate - 1) * 100)
+	}
+	if bpmChanged {
+		c.section.SetBPM(text)
+	}
+}
+
+// Sync matches the deck's tempo and beat phase to the master deck.
+func (c *deckController) Sync() {
+	if err := c.deck.Sync(); err != nil {
+		logger.Logger.Printf("%s Deck: sync failed: %v", c.name, err)
+		dialog.ShowInformation("Cannot Sync", err.Error(), c.window)
+		return
+	}
+	c.refreshBPM()
+}
+
+// TapTempo measures the tempo from repeated taps and saves it as a manual beatgrid. While the
+// track plays, the grid is aligned so that a downbeat falls on the latest tap.
+func (c *deckController) TapTempo() {
+	bpm, ok := c.tap.Tap(time.Now())
+	track := c.Track()
+	if !ok || track == nil {
+		return
+	}
+	offset := 0.0
+	if grid := c.Beatgrid(); grid != nil {
+		offset = grid.Offset
+	}
+	if !c.deck.Paused() {
+		bar := beatsPerBar * 60 / bpm
+		offset = math.Mod(c.deck.Position().Seconds(), bar)
+	}
+	c.saveManualBeatgrid(track.ID, math.Round(bpm*100)/100, offset)
+}
+
+// EditTempo shows a dialog for correcting the tempo and first downbeat of the loaded track.
+func (c *deckController) EditTempo() {
+	track := c.Track()
+	if track == nil {
+		dialog.ShowInformation("No Track Loaded", "Load a track to edit its tempo.", c.window)
+		return
+	}
+	bpmEntry := widget.NewEntry()
+	offsetEntry := widget.NewEntry()
+	if grid := c.Beatgrid(); grid != nil {
+		bpmEntry.SetText(strconv.FormatFloat(grid.BPM, 'f', 2, 64))
+		offsetEntry.SetText(strconv.FormatFloat(grid.Offset, 'f', 3, 64))
+	}
+	scale := func(factor float64) func() {
+		return func() {
+			if bpm, err := strconv.ParseFloat(bpmEntry.Text, 64); err == nil {
+				bpmEntry.SetText(strconv.FormatFloat(bpm*factor, 'f', 2, 64))
+			}
+		}
+	}
+	items := []*widget.FormItem{
+		widget.NewFormItem("BPM", bpmEntry),
+		widget.NewFormItem("", container.NewHBox(widget.NewButton("x2", scale(2)), widget.NewButton("/2", scale(0.5)))),
+		widget.NewFormItem("First downbeat (s)", offsetEntry),
+	}
+	dialog.ShowForm("Edit Tempo", "Save", "Cancel", items, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		bpm, err := strconv.ParseFloat(bpmEntry.Text, 64)
+		if err != nil || bpm <= 0 {
+			dialog.ShowError(fmt.Errorf("invalid BPM: %s", bpmEntry.Text), c.window)
+			return
+		}
+		offset, err := strconv.ParseFloat(offsetEntry.Text, 64)
+		if err != nil {
+			offset = 0
+		}
+		c.saveManualBeatgrid(track.ID, bpm, offset)
+	}, c.window)
+}
+
+// saveManualBeatgrid stores a hand-corrected beatgrid for the track and applies it if the
+// track is still loaded.
+func (c *deckController) saveManualBeatgrid(trackID uint, bpm, offset float64) {
+	grid := &db.Beatgrid{TrackID: trackID, BPM: bpm, Offset: offset, Manual: true}
+	if err := db.SaveBeatgrid(grid); err != nil {
+		logger.Logger.Printf("%s Deck: failed to save beatgrid: %v", c.name, err)
+		dialog.ShowError(err, c.window)
+		return
+	}
+	c.setBeatgrid(trackID, grid)
+	logger.Logger.Printf("%s Deck: tempo set to %.2f BPM", c.name, bpm)
+}
+
+// Seek moves the playhead of the loaded track to seconds and updates the display at once,
+// so the waveform follows even while the deck is paused.
+func (c *deckController) Seek(seconds float64) {
+	if !c.deck.Loaded() {
+		return
+	}
+	position := time.Duration(seconds * float64(time.Second))
+	if err := c.deck.Seek(position); err != nil {
+		logger.Logger.Printf("%s Deck: seek failed: %v", c.name, err)
+		return
+	}
+	c.showPosition()
+}
+
+// jumpTo moves the playhead to a cue at seconds, on the beat and in phase with quantize on.
+func (c *deckController) jumpTo(seconds float64) {
+	if err := c.deck.JumpTo(time.Duration(seconds * float64(time.Second))); err != nil {
+		logger.Logger.Printf("%s Deck: jump failed: %v", c.name, err)
+		return
+	}
+	c.showPosition()
+}
+
+// Cue returns to the cue point and pauses while playing, or sets the cue point while paused.
+func (c *deckController) Cue() {
+	if !c.deck.Loaded() {
+		return
+	}
+	if err := c.deck.Cue(); err != nil {
+		logger.Logger.Printf("%s Deck: cue failed: %v", c.name, err)
+		return
+	}
+	c.section.SetPlaying(false)
+	c.showPosition()
+}
+
+// showPosition updates the clock and waveforms to the deck's position.
+func (c *deckController) showPosition() {
+	c.section.SetTime(formatClock(c.deck.Position(), c.deck.Duration()))
+	c.section.SetPosition(c.deck.Position().Seconds())
+}
+
+// PressPad jumps to the hot cue on pad, or sets one there at the current position when the
+// pad is empty.
+func (c *deckController) PressPad(pad int) {
+	c.mu.Lock()
+	track, hotCues := c.track, c.hotCues
+	c.mu.Unlock()
+	if track == nil {
+		return
+	}
+	for _, cue := range hotCues {
+		if cue.Pad == pad {
+			c.jumpTo(cue.Time)
+			return
+		}
+	}
+	position := c.deck.Snap(c.deck.Position()).Seconds()
+	if _, err := db.SetHotCue(track.ID, pad, "", position, hotCueColors[pad-1]); err != nil {
+		logger.Logger.Printf("%s Deck: failed to set hot cue %d: %v", c.name, pad, err)
+		dialog.ShowError(err, c.window)
+		return
+	}
+	logger.Logger.Printf("%s Deck: hot cue %d set at %.3fs", c.name, pad, position)
+	c.RefreshOverlays()
+}
+
+// ClearPad removes the hot cue on pad.
+func (c *deckController) ClearPad(pad int) {
+	track := c.Track()
+	if track == nil {
+		return
+	}
+	if err := db.DeleteHotCue(track.ID, pad); err != nil {
+		logger.Logger.Printf("%s Deck: failed to clear hot cue %d: %v", c.name, pad, err)
+		dialog.ShowError(err, c.window)
+		return
+	}
+	logger.Logger.Printf("%s Deck: hot cue %d cleared", c.name, pad)
+	c.RefreshOverlays()
+}
+
+// RefreshOverlays reloads the cue points and loops of the loaded track onto the waveforms
+// and the hot cue pads.
+func (c *deckController) RefreshOverlays() {
+	track := c.Track()
+	var cues []db.CuePoint
+	var loops []db.Loop
+	if track != nil {
+		var err error
+		if cues, err = db.GetCuePoints(track.ID); err != nil {
+			logger.Logger.Printf("%s Deck: failed to load cue points: %v", c.name, err)
+		}
+		if loops, err = db.GetLoops(track.ID); err != nil {
+			logger.Logger.Printf("%s Deck: failed to load loops: %v", c.name, err)
+		}
+	}
+
+	var hotCues []db.CuePoint
+	markers := make([]waveform.Marker, 0, len(cues))
+	for i, cue := range cues {
+		if cue.Pad > 0 {
+			hotCues = append(hotCues, cue)
+		}
+		markers = append(markers, waveform.Marker{Time: cue.Time, Color: cueColor(&cues[i])})
+	}
+	regions := make([]waveform.Region, 0, len(loops))
+	names := make([]string, 0, len(loops))
+	for _, loop := range loops {
+		regions = append(regions, waveform.Region{Start: loop.Start, End: loop.End, Color: loopColor(loop)})
+		names = append(names, loopName(loop))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if (c.track == nil) != (track == nil) || c.track != nil && c.track.ID != track.ID {
+		return // Another track was loaded meanwhile, and refreshes the overlays itself
+	}
+	c.hotCues, c.loops, c.markers, c.regions = hotCues, loops, markers, regions
+	c.section.SetHotCues(hotCues)
+	c.section.SetSavedLoops(names)
+	c.showOverlays()
+}
+
+// showOverlays draws the saved cues and loops and the playing loop over the waveforms. The
+// caller holds c.mu, so that overlays drawn from different goroutines land in order.
+func (c *deckController) showOverlays() {
+	regions := c.regions
+	if c.activeLoop.End > c.activeLoop.Start {
+		regions = append(regions[:len(regions):len(regions)], c.activeLoop)
+	}
+	c.section.SetOverlays(c.markers, regions)
+}
+
+// loopAction returns a function that runs a loop operation of the deck and shows the result.
+func (c *deckController) loopAction(name string, action func() error) func() {
+	return func() {
+		if !c.deck.Loaded() {
+			return
+		}
+		if err := action(); err != nil {
+			logger.Logger.Printf("%s Deck: %s failed: %v", c.name, name, err)
+			dialog.ShowInformation("Cannot Loop", err.Error(), c.window)
+		}
+		c.refreshLoop()
+	}
+}
+
+// RecallLoop plays the saved loop with the given index in the loaded track's loop list.
+func (c *deckController) RecallLoop(index int) {
+	c.mu.Lock()
+	if index < 0 || index >= len(c.loops) {
+		c.mu.Unlock()
+		return
+	}
+	loop := c.loops[index]
+	c.mu.Unlock()
+	c.loopAction("recall loop", func() error {
+		return c.deck.SetLoop(time.Duration(loop.Start*float64(time.Second)), time.Duration(loop.End*float64(time.Second)))
+	})()
+}
+
+// refreshLoop shows whether a loop is playing and where, when that has changed.
+func (c *deckController) refreshLoop() {
+	start, end, active := c.deck.Loop()
+	loop := waveform.Region{}
+	if active {
+		loop = waveform.Region{Start: start.Seconds(), End: end.Seconds(), Color: activeLoopColor}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if loop == c.activeLoop {
+		return
+	}
+	c.activeLoop = loop
+	c.section.SetLoopActive(active)
+	c.showOverlays()
+}
+
+// loopName labels a saved loop in the deck's loop list.
+func loopName(loop db.Loop) string {
+	span := formatCueTime(loop.Start) + "-" + formatCueTime(loop.End)
+	if loop.Name == "" {
+		return span
+	}
+	return loop.Name + " (" + span + ")"
+}
+
+// loadWaveform fetches the waveform of the track at path from the cache, analysing it if needed.
+func (c *deckController) loadWaveform(path string) {
+	peaks, err := c.cache.Waveform(path)
+	if err != nil {
+		logger.Logger.Printf("%s Deck: waveform analysis failed for '%s': %v", c.name, path, err)
+		return
+	}
+	// Drop the result if another track was loaded while analysing.
+	if track := c.Track(); track == nil || track.Path != path {
+		return
+	}
+	c.section.SetPeaks(deckPeaks(peaks), c.deck.Duration().Seconds())
+	c.section.SetPosition(c.deck.Position().Seconds())
+	if c.OnWaveform != nil {
+		c.OnWaveform(peaks)
+	}
+}
+
+// deckPeaks converts analysed peak levels to the time-based levels drawn by the deck waveforms.
+func deckPeaks(w *analysis.Waveform) []waveform.Peaks {
+	levels := make([]waveform.Peaks, 0, len(w.Levels))
+	for _, level := range w.Levels {
+		levels = append(levels, waveform.Peaks{
+			SecondsPerBucket: float64(level.SamplesPerBucket) / float64(w.SampleRate),
+			Min:              level.Min,
+			Max:              level.Max,
+		})
+	}
+	return levels
+}
+
+// TogglePlay plays or pauses the loaded track.
+func (c *deckController) TogglePlay() {
+	if !c.deck.Loaded() {
+		return
+	}
+	if c.deck.Paused() {
+		if c.deck.Position() >= c.deck.Duration() {
+			// Restart a track that has played to its end.
+			c.deck.Seek(0)
+		}
+		c.deck.Play()
+		c.section.SetPlaying(true)
+		logger.Logger.Printf("%s Deck: Play", c.name)
+	} else {
+		c.deck.Pause()
+		c.section.SetPlaying(false)
+		logger.Logger.Printf("%s Deck: Pause", c.name)
+	}
+}
+
+// formatClock formats the elapsed and remaining time of a track for the deck display.
+func formatClock(position, duration time.Duration) string {
+	remaining := duration - position
+	if remaining < 0 {
+		remaining = 0
+	}
+	return library.FormatDuration(position) + " / -" + library.FormatDuration(remaining)
+}
+
+// formatBPM formats a tempo for the deck display, using "--" when it is unknown.
+func formatBPM(bpm float64) string {
+	if bpm <= 0 {
+		return "--"
+	}
+	return strconv.FormatFloat(bpm, 'f', 2, 64)
+}
+
+// deckName returns the name of the deck with the given zero-based index. The first two decks
+// are named after their side of the mixer and any further ones by number.
+func deckName(index int) string {
+	switch index {
+	case 0:
+		return "Left"
+	case 1:
+		return "Right"
+	}
+	return fmt.Sprintf("Deck %d", index+1)
+}
+
+// deckAt returns the controller whose deck lies under the absolute canvas position pos.
+func deckAt(decks []*deckController, pos fyne.Position) *deckController {
+	for _, c := range decks {
+		if c.section.Contains(pos) {
+			return c
+		}
+	}
+	return nil
+}
+
+// trackForFile returns the library track for a file dropped from outside the application,
+// importing the file into the library first if needed.
+func trackForFile(path string) (db.Track, error) {
+	var track db.Track
+	result := db.DB.Where("path = ?", path).Limit(1).Find(&track)
+	if result.Error != nil {
+		return track, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return track, nil
+	}
+
+	if !library.IsSupported(path) {
+		return track, fmt.Errorf("unsupported file type: %s", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return track, err
+	}
+	imported, err := library.ReadTrack(path, info)
+	if err != nil {
+		return track, err
+	}
+	if _, err := db.UpsertTrack(imported); err != nil {
+		return track, err
+	}
+	return *imported, nil
+}
@@ -1,13 +1,16 @@
 package gui
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"image/color"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"megajam/analysis"
 	"megajam/config"
 	"megajam/db"
 	"megajam/logger"
@@ -20,20 +23,24 @@ import (
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/widget"
+	"github.com/rickcollette/megasound"
 )
 
+// waveformBuckets is the number of peaks drawn by the waveform visualizer.
+const waveformBuckets = 2000
+
 // CreateWaveformVisualizer creates the waveform visualizer using the waveform package.
-func CreateWaveformVisualizer(audioData []int32) *fyne.Container {
+func CreateWaveformVisualizer(audioData []int32) *waveform.Waveform {
 	logger.Logger.Println("Starting waveform visualizer")
 	wave := waveform.NewWaveform(audioData)
 	wave.SetMinSize(fyne.NewSize(400, 100))
+	wave.StretchSamples = true
 	wave.OverrideForeground = true
 	wave.OverrideForegroundColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255} // Red waveform
 
-	return container.NewVBox(
-		wave,
-	)
+	return wave
 }
 
 // CreateGUI initializes and runs the GUI application with the given AppConfig.
@@ -41,6 +48,10 @@ func CreateGUI(appConfig *config.AppConfig) {
 	logger.Logger.Println("Starting UI")
 	var err error
 
+	// Background library work stops when the window closes.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	// Initialize Fyne app.
 	myApp := app.NewWithID("com.megalithiCode.megajam")
 	myWindow := myApp.NewWindow("Megajam DJ Controller")
@@ -68,40 +79,20 @@ func CreateGUI(appConfig *config.AppConfig) {
 		logger.Logger.Printf("Unknown mode '%s', defaulting to Party mode.", appConfig.Mode)
 	}
 
-	// Check for tracks in the database.
-	logger.Logger.Println("Checking for tracks in the database...")
-	var trackCount int64
-	if err := db.DB.Model(&db.Track{}).Count(&trackCount).Error; err != nil {
-		dialog.ShowError(err, myWindow)
-		logger.Logger.Fatalf("Failed to query database: %v", err)
+	// Start the audio engine; the output device is opened once and shared by all decks.
+	logger.Logger.Println("Starting audio engine...")
+	engine, err := player.NewEngine(
+		megasound.SampleRate(appConfig.Audio.SampleRate),
+		time.Duration(appConfig.Audio.BufferMillis)*time.Millisecond,
+		appConfig.Audio.Decks,
+	)
+	if err != nil {
+		logger.Logger.Fatalf("Failed to start audio engine: %v", err)
 		return
 	}
-
-	// Only initialize the MP3 player if there are tracks in the database.
-	var mp3Player *player.MP3Player
-	if trackCount > 0 {
-		logger.Logger.Println("Tracks found in the database. Initializing player...")
-		firstTrack := db.Track{}
-		if err := db.DB.First(&firstTrack).Error; err != nil {
-			dialog.ShowError(err, myWindow)
-			logger.Logger.Fatalf("Failed to fetch first track: %v", err)
-			return
-		}
-
-		mp3Player, err = player.NewMP3Player(firstTrack.Path)
-		if err != nil {
-			dialog.ShowError(err, myWindow)
-			logger.Logger.Printf("Failed to load track '%s': %v", firstTrack.Path, err)
-		} else {
-			logger.Logger.Printf("Player initialized with track: %s", firstTrack.Title)
-		}
-	} else {
-		logger.Logger.Println("No tracks found in the database. Skipping player initialization.")
-	}
-
-	// Ensure player is closed on exit.
-	if mp3Player != nil {
-		defer mp3Player.Close()
+	defer engine.Close()
+	if appConfig.Audio.CueOutput == "split" {
+		engine.SetCueRouting(player.CueSplit)
 	}
 
 	// Initialize playlist.
@@ -133,60 +124,84 @@ func CreateGUI(appConfig *config.AppConfig) {
 		logger.Logger.Println("Track removed from playlist.")
 	})
 
+	// Create decks with waveform visualization.
+	analysisCache := analysis.NewCache(appConfig.CacheDir)
+	// Every deck of the engine gets a section; the first sits left of the mixer, the second right,
+	// and any further ones below them in turn.
+	logger.Logger.Printf("Creating %d decks...", engine.NumDecks())
+	decks := make([]*deckController, engine.NumDecks())
+	var leftColumn, rightColumn []fyne.CanvasObject
+	for i := range decks {
+		i := i
+		name := deckName(i)
+		section := CreateDeckSection(
+			name+" Deck", "No Track", "--:--", "--",
+			nil,
+			func() { decks[i].TogglePlay() },
+			func() { decks[i].Sync() },
+			func(value float64) { decks[i].SetPitch(value) },
+		)
+		decks[i] = newDeckController(name, engine.Deck(i), section, myWindow, analysisCache)
+		if i%2 == 0 {
+			leftColumn = append(leftColumn, section.Container)
+		} else {
+			rightColumn = append(rightColumn, section.Container)
+		}
+	}
+
+	// The master deck sets the tempo that synced decks follow.
+	deckNames := make([]string, len(decks))
+	for i, deck := range decks {
+		deckNames[i] = deck.name
+	}
+	masterSelect := widget.NewRadioGroup(deckNames, func(selected string) {
+		for i, deck := range decks {
+			if deck.name == selected {
+				engine.SetMaster(i)
+			}
+		}
+	})
+	masterSelect.Horizontal = true
+	masterSelect.Required = true
+	masterSelect.SetSelected(decks[0].name)
+
+	// The master mix can be recorded with a tracklist of the decks heard, and streamed live.
+	recorderSection, closeRecorder := createRecorderSection(engine, decks, appConfig.Recording, myWindow)
+	defer closeRecorder()
+	broadcastSection, closeBroadcast := createBroadcastSection(engine, decks, appConfig.Broadcast, myWindow)
+	defer closeBroadcast()
+
 	// Create browser section.
 	logger.Logger.Println("Initializing track browser...")
+	trackBrowser, reloadTracks := createEnhancedBrowserSection(myWindow, decks, appConfig.Library.ScanWorkers)
 	browserSection := container.NewVBox(
-		createEnhancedBrowserSection(myWindow),
+		createLibraryScanSection(ctx, appConfig, myWindow, reloadTracks),
+		trackBrowser,
 		container.NewHBox(addTrackButton, removeTrackButton),
 	)
 
-	// Create decks with waveform visualization.
-	logger.Logger.Println("Creating left Deck...")
-	leftDeck := CreateDeckSection(
-		"Left Deck", "Track A", "3:45", "120",
-		nil,
-		func() {
-			if mp3Player != nil && mp3Player.Paused() {
-				mp3Player.Play()
-				logger.Logger.Println("Left Deck: Play")
-			} else if mp3Player != nil {
-				mp3Player.Pause()
-				logger.Logger.Println("Left Deck: Pause")
-			}
-		},
-		func() { logger.Logger.Println("Left Deck: Sync button clicked") },
-		func(value float64) {
-			if mp3Player != nil {
-				mp3Player.SetVolume(value / 100)
-				logger.Logger.Printf("Left Deck: Volume set to %.1f%%", value)
-			}
-		},
-	)
-
-	rightDeck := CreateDeckSection(
-		"Right Deck", "Track B", "4:10", "126",
-		nil,
-		func() {
-			if mp3Player != nil && mp3Player.Paused() {
-				mp3Player.Play()
-				logger.Logger.Println("Right Deck: Play")
-			} else if mp3Player != nil {
-				mp3Player.Pause()
-				logger.Logger.Println("Right Deck: Pause")
-			}
-		},
-		func() { logger.Logger.Println("Right Deck: Sync button clicked") },
-		func(value float64) {
-			if mp3Player != nil {
-				mp3Player.SetVolume(value / 100)
-				logger.Logger.Printf("Right Deck: Volume set to %.1f%%", value)
-			}
-		},
-	)
+	// Files dropped from the file manager load into the deck they are dropped on.
+	myWindow.SetOnDropped(func(pos fyne.Position, uris []fyne.URI) {
+		deck := deckAt(decks, pos)
+		if deck == nil || len(uris) == 0 {
+			return
+		}
+		track, err := trackForFile(uris[0].Path())
+		if err != nil {
+			dialog.ShowError(err, myWindow)
+			return
+		}
+		deck.Load(track)
+		reloadTracks()
+	})
 
-	// Placeholder waveform data. Replace with real audio data from selected tracks.
-	audioData := []int32{-500, 1000, -2000, 3000, -4000, 5000}
-	waveformVisualizer := CreateWaveformVisualizer(audioData)
+	// The visualizer shows the most recently loaded track.
+	waveformVisualizer := CreateWaveformVisualizer(nil)
+	for _, deck := range decks {
+		deck.OnWaveform = func(w *analysis.Waveform) {
+			waveformVisualizer.SetAudioData(w.Envelope(waveformBuckets))
+		}
+	}
 
 	// Determine initial background color based on mode.
 	var backgroundColor color.Color
@@ -202,9 +217,18 @@ func CreateGUI(appConfig *config.AppConfig) {
 
 	// Combine all sections into the main layout.
 	mainLayout := container.NewVBox(
-		CreateToolbar(myWindow, background),
+		CreateToolbar(myWindow, background,
+			func() {
+				showOpenPlaylist(myWindow, func(p *playlist.Playlist) {
+					currentPlaylist = p
+					reloadTracks()
+				})
+			},
+			func() { showSavePlaylist(myWindow, currentPlaylist) },
+		),
 		waveformVisualizer,
-		container.NewGridWithColumns(2, leftDeck, rightDeck),
+		container.NewHBox(widget.NewLabel("Master Deck:"), masterSelect, layout.NewSpacer(), recorderSection, broadcastSection),
+		container.NewGridWithColumns(3, container.NewVBox(leftColumn...), CreateMixerSection(engine), container.NewVBox(rightColumn...)),
 		browserSection,
 	)
 	content := container.NewMax(background, mainLayout)
@@ -214,21 +238,23 @@ func CreateGUI(appConfig *config.AppConfig) {
 	myWindow.ShowAndRun()
 }
 
-// CreateToolbar creates the top toolbar with the Settings button.
-func CreateToolbar(parent fyne.Window, background *canvas.Rectangle) *fyne.Container {
+// CreateToolbar creates the top toolbar with the playlist Open and Save buttons and the
+// Settings button.
+func CreateToolbar(parent fyne.Window, background *canvas.Rectangle, onOpen, onSave func()) *fyne.Container {
 	openButton := widget.NewButton("Open", func() {
 		logger.Logger.Println("Open clicked")
-		// Add functionality to load saved playlists or configurations
+		onOpen()
 	})
 	saveButton := widget.NewButton("Save", func() {
 		logger.Logger.Println("Save clicked")
-		// Add functionality to save playlists or configurations
+		onSave()
 	})
 	settingsButton := widget.NewButton("Settings", nil) // Handler will be set later
 	exitButton := widget.NewButton("Exit", func() {
 		logger.Logger.Println("Exit clicked")
-		// Exit the application
-		os.Exit(0)
+		// Quit rather than exit the process, so that the cleanup deferred in CreateGUI finishes any
+		// recording and stream in progress.
+		fyne.CurrentApp().Quit()
 	})
 
 	toolbar := container.NewHBox(
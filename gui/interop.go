@@ -0,0 +1,209 @@
+package gui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"megajam/interop"
+	"megajam/logger"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/widget"
+)
+
+// libraryFormat is the library of another DJ application that can be imported and exported,
+// either as a single file or, when dirImporter and dirExporter are set, as a folder.
+type libraryFormat struct {
+	name        string
+	extension   string // Of the exported file, with the dot
+	importer    func(io.Reader, interop.Options) (*interop.Report, error)
+	exporter    func(io.Writer, interop.Options) (*interop.Report, error)
+	dirImporter func(string, interop.Options) (*interop.Report, error)
+	dirExporter func(string, interop.Options) (*interop.Report, error)
+}
+
+// libraryFormats lists the formats offered by the import and export dialog.
+var libraryFormats = []libraryFormat{
+	{name: "rekordbox XML", extension: ".xml", importer: interop.ImportRekordbox, exporter: interop.ExportRekordbox},
+	{name: "Traktor NML", extension: ".nml", importer: interop.ImportTraktor, exporter: interop.ExportTraktor},
+	{name: "Serato crates and cues", dirImporter: interop.ImportSerato, dirExporter: interop.ExportSerato},
+}
+
+// createInteropButton creates the button that imports the library of another DJ application
+// or exports this one for it. onChanged runs after an import.
+func createInteropButton(myWindow fyne.Window, onChanged func()) *widget.Button {
+	return widget.NewButton("Import/Export", func() {
+		names := make([]string, len(libraryFormats))
+		for i, format := range libraryFormats {
+			names[i] = format.name
+		}
+		formatSelect := widget.NewSelect(names, nil)
+		formatSelect.SetSelectedIndex(0)
+		mappingsEntry := widget.NewMultiLineEntry()
+		mappingsEntry.SetPlaceHolder("/Users/dj/Music => /home/dj/Music")
+
+		var popup dialog.Dialog
+		run := func(export bool) {
+			format := libraryFormats[formatSelect.SelectedIndex()]
+			opts := interop.Options{Mappings: parsePathMappings(mappingsEntry.Text)}
+			popup.Hide()
+			if export {
+				showLibraryExport(myWindow, format, opts)
+			} else {
+				showLibraryImport(myWindow, format, opts, onChanged)
+			}
+		}
+		content := container.NewVBox(
+			widget.NewForm(
+				widget.NewFormItem("Format", formatSelect),
+				widget.NewFormItem("Path mappings", mappingsEntry),
+			),
+			container.NewHBox(
+				widget.NewButton("Import...", func() { run(false) }),
+				widget.NewButton("Export...", func() { run(true) }),
+			),
+		)
+		popup = dialog.NewCustom("Import/Export Library", "Close", content, myWindow)
+		popup.Resize(fyne.NewSize(480, 280))
+		popup.Show()
+	})
+}
+
+// showLibraryImport asks for a library file or folder of the given format and imports it.
+func showLibraryImport(myWindow fyne.Window, format libraryFormat, opts interop.Options, onChanged func()) {
+	if format.dirImporter != nil {
+		dialog.ShowFolderOpen(func(dir fyne.ListableURI, err error) {
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			if dir == nil {
+				return
+			}
+			go func() {
+				report, err := format.dirImporter(dir.Path(), opts)
+				finishLibraryImport(myWindow, format, report, err, onChanged)
+			}()
+		}, myWindow)
+		return
+	}
+
+	open := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, myWindow)
+			return
+		}
+		if reader == nil {
+			return
+		}
+		go func() {
+			defer reader.Close()
+			report, err := format.importer(reader, opts)
+			finishLibraryImport(myWindow, format, report, err, onChanged)
+		}()
+	}, myWindow)
+	open.SetFilter(storage.NewExtensionFileFilter([]string{format.extension}))
+	open.Show()
+}
+
+// finishLibraryImport refreshes the library after an import and shows its report or error.
+func finishLibraryImport(myWindow fyne.Window, format libraryFormat, report *interop.Report, err error, onChanged func()) {
+	if onChanged != nil {
+		onChanged()
+	}
+	if err != nil {
+		logger.Logger.Printf("%s import failed: %v", format.name, err)
+		dialog.ShowError(err, myWindow)
+		return
+	}
+	showInteropReport(myWindow, "Imported", report)
+}
+
+// showLibraryExport asks where to save the library in the given format and writes it.
+func showLibraryExport(myWindow fyne.Window, format libraryFormat, opts interop.Options) {
+	if format.dirExporter != nil {
+		dialog.ShowFolderOpen(func(dir fyne.ListableURI, err error) {
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			if dir == nil {
+				return
+			}
+			go func() {
+				report, err := format.dirExporter(dir.Path(), opts)
+				if err != nil {
+					logger.Logger.Printf("%s export failed: %v", format.name, err)
+					dialog.ShowError(err, myWindow)
+					return
+				}
+				showInteropReport(myWindow, "Exported", report)
+			}()
+		}, myWindow)
+		return
+	}
+
+	save := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, myWindow)
+			return
+		}
+		if writer == nil {
+			return
+		}
+		go func() {
+			report, err := format.exporter(writer, opts)
+			if closeErr := writer.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				logger.Logger.Printf("%s export failed: %v", format.name, err)
+				os.Remove(writer.URI().Path())
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			showInteropReport(myWindow, "Exported", report)
+		}()
+	}, myWindow)
+	save.SetFilter(storage.NewExtensionFileFilter([]string{format.extension}))
+	save.SetFileName("megajam" + format.extension)
+	save.Show()
+}
+
+// showInteropReport shows what an import or export did, listing the entries without a file.
+func showInteropReport(myWindow fyne.Window, action string, report *interop.Report) {
+	message := fmt.Sprintf("%s %s.", action, report)
+	if len(report.Unmatched) > 0 {
+		shown := report.Unmatched
+		if len(shown) > 10 {
+			shown = shown[:10]
+		}
+		message += "\n\nNot found:\n" + strings.Join(shown, "\n")
+		if len(report.Unmatched) > len(shown) {
+			message += fmt.Sprintf("\n...and %d more (see the log)", len(report.Unmatched)-len(shown))
+		}
+		for _, location := range report.Unmatched {
+			logger.Logger.Printf("Unmatched library entry: %s", location)
+		}
+	}
+	dialog.ShowInformation("Library "+action, message, myWindow)
+}
+
+// parsePathMappings reads one "from => to" mapping per line, skipping blank and malformed lines.
+func parsePathMappings(text string) []interop.PathMapping {
+	var mappings []interop.PathMapping
+	for _, line := range strings.Split(text, "\n") {
+		from, to, found := strings.Cut(line, "=>")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !found || from == "" {
+			continue
+		}
+		mappings = append(mappings, interop.PathMapping{From: from, To: to})
+	}
+	return mappings
+}
@@ -0,0 +1,133 @@
+package gui
+
+import (
+	"context"
+	"fmt"
+
+	"megajam/config"
+	"megajam/db"
+	"megajam/library"
+	"megajam/logger"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// createLibraryScanSection creates the scan, tempo analysis and import/export buttons and their
+// progress display for the music library. When library folders are configured a scan starts
+// immediately and the folders are watched for changes until ctx is cancelled. onChanged runs after
+// each scan, analysis job or import and whenever the watcher updates tracks.
+func createLibraryScanSection(ctx context.Context, appConfig *config.AppConfig, myWindow fyne.Window, onChanged func()) *fyne.Container {
+	scanner := library.NewScanner(appConfig.Library.Paths, appConfig.Library.ScanWorkers)
+
+	progressBar := widget.NewProgressBar()
+	progressBar.Hide()
+	statusLabel := widget.NewLabel("")
+
+	scanner.OnProgress = func(progress library.Progress) {
+		if progress.Found > 0 {
+			progressBar.SetValue(float64(progress.Scanned) / float64(progress.Found))
+		}
+		if progress.Finished {
+			progressBar.Hide()
+			statusLabel.SetText(fmt.Sprintf("Library: %d added, %d updated, %d unchanged, %d failed",
+				progress.Added, progress.Updated, progress.Skipped, progress.Failed))
+			return
+		}
+		statusLabel.SetText(fmt.Sprintf("Scanning %d/%d...", progress.Scanned, progress.Found))
+	}
+
+	var scanButton *widget.Button
+	startScan := func() {
+		if len(scanner.Roots) == 0 {
+			dialog.ShowInformation("No Library Folders", "Add folders to \"library.paths\" in the configuration to scan them.", myWindow)
+			return
+		}
+		scanButton.Disable()
+		progressBar.SetValue(0)
+		progressBar.Show()
+		go func() {
+			defer scanButton.Enable()
+			if _, err := scanner.Scan(ctx); err != nil && ctx.Err() == nil {
+				logger.Logger.Printf("Library scan failed: %v", err)
+				dialog.ShowError(err, myWindow)
+			}
+			if onChanged != nil {
+				onChanged()
+			}
+		}()
+	}
+	scanButton = widget.NewButton("Scan Library", startScan)
+
+	analyzeButton := createTempoAnalysisButton(ctx, appConfig, myWindow, statusLabel, progressBar, onChanged)
+
+	if len(scanner.Roots) > 0 {
+		startScan()
+		watchLibrary(ctx, scanner.Roots, onChanged)
+	}
+
+	return container.NewBorder(nil, nil, container.NewHBox(scanButton, analyzeButton, createInteropButton(myWindow, onChanged)), nil, container.NewVBox(statusLabel, progressBar))
+}
+
+// createTempoAnalysisButton creates the button that detects the tempo of every library track
+// without a beatgrid, reporting progress on the shared status label and progress bar.
+func createTempoAnalysisButton(ctx context.Context, appConfig *config.AppConfig, myWindow fyne.Window, statusLabel *widget.Label, progressBar *widget.ProgressBar, onChanged func()) *widget.Button {
+	analyzer := library.NewAnalyzer(appConfig.Library.ScanWorkers)
+	analyzer.OnProgress = func(progress library.AnalysisProgress) {
+		if progress.Total > 0 {
+			progressBar.SetValue(float64(progress.Done) / float64(progress.Total))
+		}
+		if progress.Finished {
+			progressBar.Hide()
+			statusLabel.SetText(fmt.Sprintf("Tempo analysis: %d analysed, %d failed",
+				progress.Done-progress.Failed, progress.Failed))
+			return
+		}
+		statusLabel.SetText(fmt.Sprintf("Analysing tempo %d/%d...", progress.Done, progress.Total))
+	}
+
+	var analyzeButton *widget.Button
+	analyzeButton = widget.NewButton("Analyze BPM", func() {
+		tracks, err := db.GetTracksWithoutBeatgrid()
+		if err != nil {
+			dialog.ShowError(err, myWindow)
+			return
+		}
+		if len(tracks) == 0 {
+			dialog.ShowInformation("Tempo Analysis", "Every track in the library already has a beatgrid.", myWindow)
+			return
+		}
+		analyzeButton.Disable()
+		progressBar.SetValue(0)
+		progressBar.Show()
+		go func() {
+			defer analyzeButton.Enable()
+			if _, err := analyzer.AnalyzeTempo(ctx, tracks); err != nil && ctx.Err() == nil {
+				logger.Logger.Printf("Tempo analysis failed: %v", err)
+				dialog.ShowError(err, myWindow)
+			}
+			if onChanged != nil {
+				onChanged()
+			}
+		}()
+	})
+	return analyzeButton
+}
+
+// watchLibrary watches the library folders in the background until ctx is cancelled.
+func watchLibrary(ctx context.Context, roots []string, onChanged func()) {
+	watcher, err := library.NewWatcher(roots)
+	if err != nil {
+		logger.Logger.Printf("Library watching disabled: %v", err)
+		return
+	}
+	watcher.OnChange = onChanged
+	go func() {
+		defer watcher.Close()
+		if err := watcher.Run(ctx); err != nil && ctx.Err() == nil {
+			logger.Logger.Printf("Library watcher stopped: %v", err)
+		}
+	}()
+}
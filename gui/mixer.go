@@ -1,90 +1,164 @@
 package gui
 
 import (
+	"math"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/widget"
 	"megajam/knobs"
+	"megajam/player"
 )
 
-// CreateMixerSection creates the mixer interface with sliders, knobs, and crossfader.
-func CreateMixerSection() *fyne.Container {
-	// Volume sliders
-	leftVolume := widget.NewSlider(0, 100)
-	leftVolume.SetValue(50)
-	leftVolume.OnChanged = func(value float64) {
-		// Handle left volume change
-	}
+// eqBoost is the gain in decibels of an EQ knob turned fully up.
+const eqBoost = 6.0
 
-	rightVolume := widget.NewSlider(0, 100)
-	rightVolume.SetValue(50)
-	rightVolume.OnChanged = func(value float64) {
-		// Handle right volume change
-	}
+// trimRange is the range in decibels of the channel gain knobs either side of unity.
+const trimRange = 12.0
 
-	// EQ knobs
-	leftEQ := container.NewVBox(
-		widget.NewLabel("Hi"),
-		knobs.CreateKnobWithLabel("", -10, 10, func(value float64) {
-			// Handle Left Hi EQ
-		}),
-		widget.NewLabel("Mid"),
-		knobs.CreateKnobWithLabel("", -10, 10, func(value float64) {
-			// Handle Left Mid EQ
-		}),
-		widget.NewLabel("Low"),
-		knobs.CreateKnobWithLabel("", -10, 10, func(value float64) {
-			// Handle Left Low EQ
-		}),
-	)
+// crossfaderCurves names the selectable crossfader curves, in the order of player.CrossfaderCurve.
+var crossfaderCurves = []string{"Linear", "Constant Power", "Cut"}
 
-	rightEQ := container.NewVBox(
-		widget.NewLabel("Hi"),
-		knobs.CreateKnobWithLabel("", -10, 10, func(value float64) {
-			// Handle Right Hi EQ
-		}),
-		widget.NewLabel("Mid"),
-		knobs.CreateKnobWithLabel("", -10, 10, func(value float64) {
-			// Handle Right Mid EQ
-		}),
-		widget.NewLabel("Low"),
-		knobs.CreateKnobWithLabel("", -10, 10, func(value float64) {
-			// Handle Right Low EQ
+// cueLabels label the cue buttons of the decks, up to player.MaxDecks.
+var cueLabels = []string{"Cue L", "Cue R", "Cue 3", "Cue 4"}
+
+// CreateMixerSection creates the mixer interface with a channel strip and cue button for every
+// deck of engine, and the headphone, crossfader and master controls. The strips of the decks in
+// the left column sit left of the headphone knobs and those in the right column to their right,
+// with the first two decks nearest the centre.
+func CreateMixerSection(engine *player.Engine) *fyne.Container {
+	var leftStrips, rightStrips, cueButtons []fyne.CanvasObject
+	for i := 0; i < engine.NumDecks(); i++ {
+		deck := engine.Deck(i)
+		if i%2 == 0 {
+			leftStrips = append([]fyne.CanvasObject{channelFader(deck), channelEQ(deck)}, leftStrips...)
+		} else {
+			rightStrips = append(rightStrips, channelEQ(deck), channelFader(deck))
+		}
+		// Cue buttons toggle each deck on the headphone bus; the knobs set its mix and level.
+		cueButtons = append(cueButtons, cueButton(cueLabels[i], deck))
+	}
+
+	cueMix := container.NewVBox(
+		widget.NewLabel("CUE/MST"),
+		knobs.CreateKnobWithLabel("", 0, 100, func(value float64) {
+			engine.SetCueMix(value / 100)
 		}),
 	)
-
-	// Color knob
-	colorKnob := container.NewVBox(
-		widget.NewLabel("COLOR"),
+	cueVolume := container.NewVBox(
+		widget.NewLabel("PHONES"),
 		knobs.CreateKnobWithLabel("", 0, 100, func(value float64) {
-			// Handle color adjustment
+			engine.SetCueVolume(value / 100)
 		}),
 	)
-
-	// Cue buttons
-	cueL := widget.NewButton("Cue L", func() {
-		// Handle Cue L button
-	})
-	cueR := widget.NewButton("Cue R", func() {
-		// Handle Cue R button
+	engine.SetCueMix(0.5) // Matches the knobs' centred start
+	engine.SetCueVolume(0.5)
+	splitCheck := widget.NewCheck("Split Output", func(split bool) {
+		if split {
+			engine.SetCueRouting(player.CueSplit)
+		} else {
+			engine.SetCueRouting(player.CueOff)
+		}
 	})
-	cueButtons := container.NewHBox(cueL, cueR)
+	splitCheck.Checked = engine.CueRouting() == player.CueSplit
+	cueRow := container.NewHBox(append(cueButtons, splitCheck)...)
 
-	// Crossfader slider
+	// Crossfader slider and curve
 	crossfader := widget.NewSlider(0, 100)
 	crossfader.SetValue(50)
 	crossfader.OnChanged = func(value float64) {
-		// Handle crossfader movement
+		engine.SetCrossfader(value / 100)
 	}
+	curveSelect := widget.NewSelect(crossfaderCurves, func(selected string) {
+		for i, curve := range crossfaderCurves {
+			if curve == selected {
+				engine.SetCrossfaderCurve(player.CrossfaderCurve(i))
+			}
+		}
+	})
+	curveSelect.Selected = crossfaderCurves[player.CurveConstantPower] // The engine's default
 	crossfaderSection := container.NewVBox(
-		widget.NewLabel("Crossfader"),
+		container.NewBorder(nil, nil, widget.NewLabel("Crossfader"), curveSelect),
 		crossfader,
 	)
 
+	// Master level; the engine limits peaks so the output never clips.
+	masterLevel := widget.NewSlider(0, 100)
+	masterLevel.SetValue(100)
+	masterLevel.OnChanged = func(value float64) {
+		engine.SetMasterLevel(value / 100)
+	}
+	masterSection := container.NewBorder(nil, nil, widget.NewLabel("Master"), nil, masterLevel)
+
 	// Combine everything
 	mixerLayout := container.NewVBox(
-		container.NewHBox(leftVolume, leftEQ, colorKnob, rightEQ, rightVolume),
-		container.NewVBox(cueButtons, crossfaderSection),
+		container.NewHBox(append(append(leftStrips, container.NewVBox(cueMix, cueVolume)), rightStrips...)...),
+		container.NewVBox(cueRow, crossfaderSection, masterSection),
 	)
 	return mixerLayout
 }
+
+// cueButton creates a button that toggles deck on the headphone cue bus and is highlighted
+// while the deck is cued.
+func cueButton(label string, deck *player.Deck) *widget.Button {
+	button := widget.NewButton(label, nil)
+	button.OnTapped = func() {
+		cued := !deck.Cued()
+		deck.SetCue(cued)
+		if cued {
+			button.Importance = widget.HighImportance
+		} else {
+			button.Importance = widget.MediumImportance
+		}
+		button.Refresh()
+	}
+	return button
+}
+
+// channelFader creates the volume fader of deck, starting at full level.
+func channelFader(deck *player.Deck) *widget.Slider {
+	fader := widget.NewSlider(0, 100)
+	fader.Orientation = widget.Vertical
+	fader.SetValue(100)
+	fader.OnChanged = func(value float64) {
+		deck.SetVolume(value / 100)
+	}
+	return fader
+}
+
+// channelEQ creates the gain knob, Hi/Mid/Low EQ knobs and COLOR filter knob of deck. Every knob
+// starts centred, leaving the sound unchanged.
+func channelEQ(deck *player.Deck) *fyne.Container {
+	gains := [3]float64{1, 1, 1} // Low, mid and high
+	band := func(i int) func(float64) {
+		return func(value float64) {
+			gains[i] = eqGain(value)
+			deck.SetEQ(gains[0], gains[1], gains[2])
+		}
+	}
+	return container.NewVBox(
+		widget.NewLabel("Gain"),
+		knobs.CreateKnobWithLabel("", -trimRange, trimRange, deck.SetTrim),
+		widget.NewLabel("Hi"),
+		knobs.CreateKnobWithLabel("", -10, 10, band(2)),
+		widget.NewLabel("Mid"),
+		knobs.CreateKnobWithLabel("", -10, 10, band(1)),
+		widget.NewLabel("Low"),
+		knobs.CreateKnobWithLabel("", -10, 10, band(0)),
+		widget.NewLabel("COLOR"),
+		knobs.CreateKnobWithLabel("", 0, 100, func(value float64) {
+			// Left of centre sweeps the low-pass filter, right of centre the high-pass.
+			deck.SetFilter((value - 50) / 50)
+		}),
+	)
+}
+
+// eqGain converts an EQ knob position from -10 to 10 into a band gain. The upper half boosts
+// by up to eqBoost decibels and the lower half fades the band out, killing it at -10.
+func eqGain(value float64) float64 {
+	if value >= 0 {
+		return math.Pow(10, value/10*eqBoost/20)
+	}
+	cut := 1 + value/10
+	return cut * cut
+}
@@ -0,0 +1,93 @@
+package gui
+
+import (
+	"fmt"
+	"image/color"
+
+	"megajam/config"
+	"megajam/db"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// hotCueColors are the colours given to new hot cues, one per pad.
+var hotCueColors = [db.HotCuePads]string{
+	"#E03C3C", "#E8A33C", "#E8E03C", "#3CE05A", "#3CC8E0", "#3C5AE0", "#A03CE0", "#E03CA8",
+}
+
+// emptyPadColor is the background of a pad without a hot cue.
+var emptyPadColor = color.NRGBA{R: 60, G: 60, B: 60, A: 255}
+
+// padButton is a hot cue pad. A tap triggers the pad and a right-click clears it.
+type padButton struct {
+	widget.Button
+	onClear func()
+}
+
+func newPadButton(label string, onTap, onClear func()) *padButton {
+	pad := &padButton{onClear: onClear}
+	pad.Text = label
+	pad.OnTapped = onTap
+	pad.Importance = widget.LowImportance // Lets the pad colour show through
+	pad.ExtendBaseWidget(pad)
+	return pad
+}
+
+// TappedSecondary clears the pad.
+func (p *padButton) TappedSecondary(*fyne.PointEvent) {
+	if p.onClear != nil {
+		p.onClear()
+	}
+}
+
+// hotCuePad is a pad button drawn over its cue colour.
+type hotCuePad struct {
+	button     *padButton
+	background *canvas.Rectangle
+	container  *fyne.Container
+}
+
+func newHotCuePad(pad int, onTap, onClear func()) *hotCuePad {
+	background := canvas.NewRectangle(emptyPadColor)
+	button := newPadButton(padLabel(pad, nil), onTap, onClear)
+	return &hotCuePad{button: button, background: background, container: container.NewStack(background, button)}
+}
+
+// set shows the hot cue on the pad, or an empty pad when cue is nil.
+func (p *hotCuePad) set(pad int, cue *db.CuePoint) {
+	p.button.SetText(padLabel(pad, cue))
+	p.background.FillColor = emptyPadColor
+	if cue != nil {
+		p.background.FillColor = cueColor(cue)
+	}
+	p.background.Refresh()
+}
+
+// padLabel returns the text of a pad: its number, followed by the cue's name when it has one.
+func padLabel(pad int, cue *db.CuePoint) string {
+	switch {
+	case cue == nil:
+		return fmt.Sprintf("Pad %d", pad)
+	case cue.Name != "":
+		return fmt.Sprintf("%d: %s", pad, cue.Name)
+	default:
+		return fmt.Sprintf("%d: %s", pad, formatCueTime(cue.Time))
+	}
+}
+
+// formatCueTime formats a cue time in seconds as minutes, seconds and tenths.
+func formatCueTime(seconds float64) string {
+	tenths := int(seconds * 10)
+	return fmt.Sprintf("%d:%02d.%d", tenths/600, tenths/10%60, tenths%10)
+}
+
+// cueColor returns the display colour of a cue point, falling back to the default marker colour.
+func cueColor(cue *db.CuePoint) color.Color {
+	if c, err := config.ParseHexColor(cue.Color); err == nil {
+		return c
+	}
+	return cueMarkerColor
+}
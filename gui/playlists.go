@@ -0,0 +1,91 @@
+package gui
+
+import (
+	"fmt"
+
+	"megajam/library"
+	"megajam/logger"
+	"megajam/playlist"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+)
+
+// playlistFilter lists the playlist formats that can be opened and saved.
+var playlistFilter = storage.NewExtensionFileFilter([]string{".m3u", ".m3u8", ".pls", ".json"})
+
+// showOpenPlaylist asks for a playlist file, adds its tracks to the library and passes the
+// playlist to onOpen.
+func showOpenPlaylist(window fyne.Window, onOpen func(*playlist.Playlist)) {
+	open := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		if reader == nil {
+			return
+		}
+		reader.Close()
+
+		p, err := playlist.Import(reader.URI().Path())
+		if err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		missing := 0
+		for _, path := range p.Tracks {
+			if _, err := trackForFile(path); err != nil {
+				logger.Logger.Printf("Playlist '%s': %v", p.Name, err)
+				missing++
+			}
+		}
+		onOpen(p)
+		message := fmt.Sprintf("Opened '%s' with %d tracks.", p.Name, len(p.Tracks))
+		if missing > 0 {
+			message += fmt.Sprintf(" %d could not be added to the library.", missing)
+		}
+		dialog.ShowInformation("Playlist Opened", message, window)
+	}, window)
+	open.SetFilter(playlistFilter)
+	open.Show()
+}
+
+// showSavePlaylist asks where to save p and writes it in the format of the chosen extension:
+// .m3u, .m3u8, .pls or .json.
+func showSavePlaylist(window fyne.Window, p *playlist.Playlist) {
+	save := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		if writer == nil {
+			return
+		}
+		writer.Close()
+
+		if err := p.Export(writer.URI().Path(), describeTrack); err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		logger.Logger.Printf("Playlist '%s' saved to %s", p.Name, writer.URI().Path())
+	}, window)
+	save.SetFilter(playlistFilter)
+	save.SetFileName(p.Name + ".m3u8")
+	save.Show()
+}
+
+// describeTrack returns the title and duration of the library track at path for a playlist file.
+func describeTrack(path string) playlist.Entry {
+	entry := playlist.Entry{Path: path}
+	track, err := trackForFile(path)
+	if err != nil {
+		return entry
+	}
+	entry.Title = track.Title
+	if track.Artist != "" {
+		entry.Title = track.Artist + " - " + track.Title
+	}
+	entry.Duration, _ = library.ParseDuration(track.Duration)
+	return entry
+}
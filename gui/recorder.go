@@ -0,0 +1,152 @@
+package gui
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"megajam/config"
+	"megajam/logger"
+	"megajam/player"
+	"megajam/recorder"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// recorderSink names the recorder's tap on the master mix.
+const recorderSink = "recorder"
+
+// recordingPoll is how often the recording display and tracklist are updated.
+const recordingPoll = 250 * time.Millisecond
+
+// recordingFormats names the selectable recording formats, in the order of recorder.Format.
+var recordingFormats = []string{recorder.WAV.String(), recorder.FLAC.String()}
+
+// recordingController records the master mix and marks a track in its tracklist whenever a
+// deck's track becomes audible.
+type recordingController struct {
+	engine *player.Engine
+	decks  []*deckController
+	dir    string
+	window fyne.Window
+
+	button       *widget.Button
+	formatSelect *widget.Select
+	status       *widget.Label
+
+	rec      *recorder.Recorder // nil while not recording
+	stop     chan struct{}
+	followed chan struct{} // Closed when follow has returned
+}
+
+// createRecorderSection creates the record button, format choice and elapsed time and size
+// display. The returned function finishes any recording in progress, for when the app closes.
+func createRecorderSection(engine *player.Engine, decks []*deckController, recording config.RecordingConfig, window fyne.Window) (*fyne.Container, func()) {
+	c := &recordingController{engine: engine, decks: decks, dir: recording.Dir, window: window}
+	c.button = widget.NewButton("Record", c.Toggle)
+	c.formatSelect = widget.NewSelect(recordingFormats, nil)
+	format, err := recorder.ParseFormat(recording.Format)
+	if err != nil {
+		logger.Logger.Printf("%v, recording WAV", err)
+	}
+	c.formatSelect.SetSelected(format.String())
+	c.status = widget.NewLabel("Not recording")
+	return container.NewHBox(c.button, c.formatSelect, c.status), c.Close
+}
+
+// Toggle starts recording, or stops and saves the recording in progress.
+func (c *recordingController) Toggle() {
+	if c.rec != nil {
+		c.Stop()
+		return
+	}
+
+	format := recorder.WAV
+	if c.formatSelect.Selected == recorder.FLAC.String() {
+		format = recorder.FLAC
+	}
+	name := "megajam-" + time.Now().Format("2006-01-02-150405") + format.Extension()
+	rec, err := recorder.New(filepath.Join(c.dir, name), format, int(c.engine.Format().SampleRate))
+	if err != nil {
+		dialog.ShowError(err, c.window)
+		return
+	}
+	c.rec = rec
+	c.stop, c.followed = make(chan struct{}), make(chan struct{})
+	c.engine.SetMasterSink(recorderSink, rec.Write)
+	go c.follow(rec, c.stop, c.followed)
+
+	c.button.SetText("Stop")
+	c.button.Importance = widget.DangerImportance
+	c.button.Refresh()
+	c.formatSelect.Disable()
+}
+
+// Stop finishes the recording and its tracklist.
+func (c *recordingController) Stop() {
+	rec := c.rec
+	err := c.finish()
+
+	c.button.SetText("Record")
+	c.button.Importance = widget.MediumImportance
+	c.button.Refresh()
+	c.formatSelect.Enable()
+	c.status.SetText(recordingStatus("Saved", rec))
+	if err != nil {
+		dialog.ShowError(err, c.window)
+		return
+	}
+	dialog.ShowInformation("Recording Saved", fmt.Sprintf("The mix was saved to %s with %d tracks listed.", rec.Path(), len(rec.Tracks())), c.window)
+}
+
+// Close finishes any recording in progress without updating the display.
+func (c *recordingController) Close() {
+	if c.rec != nil {
+		c.finish()
+	}
+}
+
+// finish detaches the recorder from the master mix and stops it. It waits for follow to
+// return, so that the display is not updated after it shows the saved recording.
+func (c *recordingController) finish() error {
+	rec := c.rec
+	c.engine.SetMasterSink(recorderSink, nil)
+	close(c.stop)
+	<-c.followed
+	c.rec = nil
+	return rec.Stop()
+}
+
+// follow updates the display and tracklist of rec until stop is closed, then closes done.
+func (c *recordingController) follow(rec *recorder.Recorder, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(recordingPoll)
+	defer ticker.Stop()
+	tracker := newAudibleTracker(c.decks)
+	for {
+		if track := tracker.update(); track != nil {
+			rec.MarkTrack(track.Artist, track.Title)
+		}
+		c.status.SetText(recordingStatus("REC", rec))
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordingStatus describes the length and size of rec after prefix, and how much audio was
+// dropped when the disk fell behind.
+func recordingStatus(prefix string, rec *recorder.Recorder) string {
+	seconds := int(rec.Elapsed() / time.Second)
+	status := fmt.Sprintf("%s %02d:%02d:%02d  %.1f MB", prefix, seconds/3600, seconds/60%60, seconds%60, float64(rec.Size())/(1<<20))
+	if dropped := rec.Dropped(); dropped > 0 {
+		status += fmt.Sprintf("  %.1fs dropped", dropped.Seconds())
+	}
+	return status
+}
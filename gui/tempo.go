@@ -0,0 +1,34 @@
+package gui
+
+import "time"
+
+const (
+	// tapTempoReset is the pause after which a tap starts a new tempo measurement.
+	tapTempoReset = 2 * time.Second
+	// tapTempoMinTaps is the number of taps needed before a tempo is reported.
+	tapTempoMinTaps = 4
+	// tapTempoMaxTaps is the number of most recent taps the tempo is averaged over.
+	tapTempoMaxTaps = 16
+)
+
+// tapTempo measures a tempo from taps on a button.
+type tapTempo struct {
+	taps []time.Time
+}
+
+// Tap records a tap at now and returns the tempo of the recent taps in beats per minute.
+// ok is false until enough taps have been made.
+func (t *tapTempo) Tap(now time.Time) (bpm float64, ok bool) {
+	if len(t.taps) > 0 && now.Sub(t.taps[len(t.taps)-1]) > tapTempoReset {
+		t.taps = t.taps[:0]
+	}
+	t.taps = append(t.taps, now)
+	if len(t.taps) > tapTempoMaxTaps {
+		t.taps = t.taps[len(t.taps)-tapTempoMaxTaps:]
+	}
+	if len(t.taps) < tapTempoMinTaps {
+		return 0, false
+	}
+	span := t.taps[len(t.taps)-1].Sub(t.taps[0])
+	return float64(len(t.taps)-1) * 60 / span.Seconds(), true
+}
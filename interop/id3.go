@@ -0,0 +1,280 @@
+package interop
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"unicode/utf16"
+)
+
+// id3HeaderSize is the size of the ID3v2 tag header and of each ID3v2.3 and ID3v2.4 frame header.
+const id3HeaderSize = 10
+
+// id3Padding is the space left after the frames when a file is rewritten, so that later edits
+// fit in place.
+const id3Padding = 2048
+
+// errUnsupportedTag is returned for ID3v2.2 tags and for tags unsynchronised as a whole, which
+// are not rewritten.
+var errUnsupportedTag = errors.New("unsupported ID3 tag")
+
+// id3Tag is the ID3v2 tag at the start of an MP3 file. Frames are kept as they were read, so
+// the ones this package does not edit are written back unchanged.
+type id3Tag struct {
+	version    byte // 3 or 4
+	frames     []id3Frame
+	audioStart int64 // Offset of the first byte after the tag
+}
+
+type id3Frame struct {
+	id    string
+	flags [2]byte
+	data  []byte
+}
+
+// readID3 reads the ID3v2 tag of the file at path. A file without one gets an empty ID3v2.3 tag.
+func readID3(path string) (*id3Tag, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, id3HeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[:3]) != "ID3" {
+		return &id3Tag{version: 3}, nil
+	}
+	version, flags := header[3], header[5]
+	if version != 3 && version != 4 || flags&0x80 != 0 {
+		return nil, errUnsupportedTag
+	}
+	body := make([]byte, synchsafe(header[6:10]))
+	if _, err := io.ReadFull(f, body); err != nil {
+		return nil, fmt.Errorf("failed to read ID3 tag: %w", err)
+	}
+	tag := &id3Tag{version: version, audioStart: int64(id3HeaderSize + len(body))}
+	if flags&0x10 != 0 {
+		tag.audioStart += id3HeaderSize // ID3v2.4 footer
+	}
+
+	pos := 0
+	if flags&0x40 != 0 {
+		// The extended header is dropped when the tag is written back.
+		if len(body) < 4 {
+			return nil, fmt.Errorf("corrupt ID3 extended header")
+		}
+		size := int(binary.BigEndian.Uint32(body))
+		if version == 3 {
+			size += 4 // The ID3v2.3 size excludes itself
+		} else {
+			size = synchsafe(body[:4])
+		}
+		pos = size
+	}
+	for pos+id3HeaderSize <= len(body) && body[pos] != 0 {
+		frameHeader := body[pos : pos+id3HeaderSize]
+		size := int(binary.BigEndian.Uint32(frameHeader[4:8]))
+		if version == 4 {
+			size = synchsafe(frameHeader[4:8])
+		}
+		pos += id3HeaderSize
+		if size < 0 || pos+size > len(body) {
+			return nil, fmt.Errorf("corrupt ID3 frame %q", frameHeader[:4])
+		}
+		tag.frames = append(tag.frames, id3Frame{
+			id:    string(frameHeader[:4]),
+			flags: [2]byte{frameHeader[8], frameHeader[9]},
+			data:  body[pos : pos+size],
+		})
+		pos += size
+	}
+	return tag, nil
+}
+
+// geob returns the data of the general encapsulated object frame with the given description.
+func (t *id3Tag) geob(description string) ([]byte, bool) {
+	for _, frame := range t.frames {
+		if frame.id != "GEOB" || !frame.plain(t.version) {
+			continue
+		}
+		if got, data, ok := parseGEOB(frame.data); ok && got == description {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// setGEOB replaces the general encapsulated object frame with the given description.
+func (t *id3Tag) setGEOB(description string, data []byte) {
+	frames := t.frames[:0]
+	for _, frame := range t.frames {
+		if frame.id == "GEOB" && frame.plain(t.version) {
+			if got, _, ok := parseGEOB(frame.data); ok && got == description {
+				continue
+			}
+		}
+		frames = append(frames, frame)
+	}
+
+	var b bytes.Buffer
+	b.WriteByte(0) // ISO-8859-1 text
+	b.WriteString("application/octet-stream\x00")
+	b.WriteByte(0) // No file name
+	b.WriteString(description + "\x00")
+	b.Write(data)
+	t.frames = append(frames, id3Frame{id: "GEOB", data: b.Bytes()})
+}
+
+// write stores the tag in the file at path. The file is updated in place when the tag fits in
+// the space of the old one, and rewritten through a temporary file otherwise.
+func (t *id3Tag) write(path string) error {
+	var frames bytes.Buffer
+	for _, frame := range t.frames {
+		frames.WriteString(frame.id)
+		size := make([]byte, 4)
+		if t.version == 4 {
+			putSynchsafe(size, len(frame.data))
+		} else {
+			binary.BigEndian.PutUint32(size, uint32(len(frame.data)))
+		}
+		frames.Write(size)
+		frames.Write(frame.flags[:])
+		frames.Write(frame.data)
+	}
+
+	if space := int(t.audioStart) - id3HeaderSize; t.audioStart > 0 && frames.Len() <= space {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		frames.Write(make([]byte, space-frames.Len()))
+		if _, err := f.WriteAt(append(t.header(space), frames.Bytes()...), 0); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write ID3 tag: %w", err)
+		}
+		return f.Close()
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := src.Seek(t.audioStart, io.SeekStart); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	frames.Write(make([]byte, id3Padding))
+	_, err = tmp.Write(append(t.header(frames.Len()), frames.Bytes()...))
+	if err == nil {
+		_, err = io.Copy(tmp, src)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write ID3 tag: %w", err)
+	}
+	src.Close()
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// header returns the tag header for a tag body of size bytes.
+func (t *id3Tag) header(size int) []byte {
+	header := []byte{'I', 'D', '3', t.version, 0, 0, 0, 0, 0, 0}
+	putSynchsafe(header[6:], size)
+	return header
+}
+
+// plain reports whether the frame data is stored as is, without compression, encryption or
+// unsynchronisation.
+func (f id3Frame) plain(version byte) bool {
+	if version == 3 {
+		return f.flags[1]&0xC0 == 0
+	}
+	return f.flags[1]&0x0F == 0
+}
+
+// parseGEOB splits the data of a GEOB frame into its description and its object.
+func parseGEOB(data []byte) (description string, object []byte, ok bool) {
+	if len(data) < 1 {
+		return "", nil, false
+	}
+	encoding, rest := data[0], data[1:]
+	_, rest, ok = cutText(0, rest) // MIME type
+	if !ok {
+		return "", nil, false
+	}
+	if _, rest, ok = cutText(encoding, rest); !ok { // File name
+		return "", nil, false
+	}
+	text, rest, ok := cutText(encoding, rest)
+	if !ok {
+		return "", nil, false
+	}
+	if encoding == 1 || encoding == 2 {
+		return decodeUTF16(text, encoding == 2), rest, true
+	}
+	return string(text), rest, true
+}
+
+// cutText splits a string terminated by NUL in the given ID3 text encoding from the data after it.
+func cutText(encoding byte, data []byte) (text, rest []byte, ok bool) {
+	if encoding != 1 && encoding != 2 {
+		i := bytes.IndexByte(data, 0)
+		if i < 0 {
+			return nil, nil, false
+		}
+		return data[:i], data[i+1:], true
+	}
+	for i := 0; i+1 < len(data); i += 2 {
+		if data[i] == 0 && data[i+1] == 0 {
+			return data[:i], data[i+2:], true
+		}
+	}
+	return nil, nil, false
+}
+
+// decodeUTF16 decodes UTF-16 text, with a byte order mark unless bigEndian is set.
+func decodeUTF16(b []byte, bigEndian bool) string {
+	order := binary.ByteOrder(binary.BigEndian)
+	if !bigEndian && len(b) >= 2 {
+		switch {
+		case b[0] == 0xFF && b[1] == 0xFE:
+			order = binary.LittleEndian
+			b = b[2:]
+		case b[0] == 0xFE && b[1] == 0xFF:
+			b = b[2:]
+		}
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = order.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// synchsafe reads a 28-bit integer stored in the low 7 bits of four bytes.
+func synchsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+func putSynchsafe(b []byte, n int) {
+	b[0], b[1], b[2], b[3] = byte(n>>21)&0x7F, byte(n>>14)&0x7F, byte(n>>7)&0x7F, byte(n)&0x7F
+}
@@ -0,0 +1,322 @@
+package interop
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// id3Options describes the parts of a test tag around its frames.
+type id3Options struct {
+	version  byte
+	extended bool // Add an extended header
+	footer   bool // Add an ID3v2.4 footer
+	padding  int
+}
+
+// buildID3 encodes an ID3v2.3 or ID3v2.4 tag holding frames.
+func buildID3(opts id3Options, frames []id3Frame) []byte {
+	var body bytes.Buffer
+	var flags byte
+	if opts.extended {
+		flags |= 0x40
+		if opts.version == 3 {
+			// Size excluding itself, flags and the size of the padding.
+			body.Write([]byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0})
+		} else {
+			// Synchsafe size including itself, one flag byte and the flags.
+			body.Write([]byte{0, 0, 0, 6, 1, 0})
+		}
+	}
+	for _, frame := range frames {
+		body.WriteString(frame.id)
+		size := make([]byte, 4)
+		if opts.version == 4 {
+			size = []byte{byte(len(frame.data) >> 21 & 0x7F), byte(len(frame.data) >> 14 & 0x7F), byte(len(frame.data) >> 7 & 0x7F), byte(len(frame.data) & 0x7F)}
+		} else {
+			binary.BigEndian.PutUint32(size, uint32(len(frame.data)))
+		}
+		body.Write(size)
+		body.Write(frame.flags[:])
+		body.Write(frame.data)
+	}
+	body.Write(make([]byte, opts.padding))
+	if opts.footer {
+		flags |= 0x10
+	}
+
+	n := body.Len()
+	header := []byte{'I', 'D', '3', opts.version, 0, flags, byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+	tag := append(header, body.Bytes()...)
+	if opts.footer {
+		footer := append([]byte{}, header...)
+		copy(footer, "3DI")
+		tag = append(tag, footer...)
+	}
+	return tag
+}
+
+func textFrame(id, text string) id3Frame {
+	return id3Frame{id: id, data: append([]byte{0}, text...)}
+}
+
+// geobFrame builds a GEOB frame with an ISO-8859-1 description.
+func geobFrame(description string, object []byte) id3Frame {
+	data := []byte("\x00application/octet-stream\x00\x00" + description + "\x00")
+	return id3Frame{id: "GEOB", data: append(data, object...)}
+}
+
+// testAudio stands in for the MPEG frames after a tag.
+func testAudio() []byte {
+	audio := make([]byte, 5000)
+	for i := range audio {
+		audio[i] = byte(i * 7)
+	}
+	copy(audio, []byte{0xFF, 0xFB, 0x90, 0x64})
+	return audio
+}
+
+// writeMP3 writes a file holding tag and audio and returns its path.
+func writeMP3(t *testing.T, dir, name string, tag, audio []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(append([]byte{}, tag...), audio...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkAudio checks that the file at path still holds audio after its tag.
+func checkAudio(t *testing.T, path string, audio []byte) *id3Tag {
+	t.Helper()
+	tag, err := readID3(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[tag.audioStart:], audio) {
+		t.Errorf("%s: audio after the tag changed", filepath.Base(path))
+	}
+	return tag
+}
+
+func TestSynchsafe(t *testing.T) {
+	b := make([]byte, 4)
+	putSynchsafe(b, 0x0FFFFFFF)
+	if !bytes.Equal(b, []byte{0x7F, 0x7F, 0x7F, 0x7F}) {
+		t.Errorf("putSynchsafe(0x0FFFFFFF) = % X", b)
+	}
+	putSynchsafe(b, 300)
+	if !bytes.Equal(b, []byte{0, 0, 2, 0x2C}) || synchsafe(b) != 300 {
+		t.Errorf("putSynchsafe(300) = % X, read back as %d", b, synchsafe(b))
+	}
+}
+
+func TestReadID3(t *testing.T) {
+	frames := []id3Frame{
+		textFrame("TIT2", "Title"),
+		geobFrame("Serato Analysis", []byte{2, 1}),
+		geobFrame("Serato Overview", bytes.Repeat([]byte{0x55}, 300)), // Sizes differ when synchsafe
+		{id: "PRIV", flags: [2]byte{0x40, 0}, data: []byte("owner\x00data")},
+	}
+	tests := []struct {
+		name string
+		opts id3Options
+	}{
+		{"ID3v2.3", id3Options{version: 3, padding: 100}},
+		{"ID3v2.4", id3Options{version: 4, padding: 100}},
+		{"ID3v2.3 extended header", id3Options{version: 3, extended: true, padding: 20}},
+		{"ID3v2.4 extended header", id3Options{version: 4, extended: true}},
+		{"ID3v2.4 footer", id3Options{version: 4, footer: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tagData := buildID3(tt.opts, frames)
+			path := writeMP3(t, t.TempDir(), "track.mp3", tagData, testAudio())
+			tag, err := readID3(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tag.version != tt.opts.version || tag.audioStart != int64(len(tagData)) {
+				t.Errorf("version %d, audio at %d, want version %d, audio at %d", tag.version, tag.audioStart, tt.opts.version, len(tagData))
+			}
+			if !reflect.DeepEqual(tag.frames, frames) {
+				t.Errorf("frames = %+v, want %+v", tag.frames, frames)
+			}
+			if data, ok := tag.geob("Serato Overview"); !ok || !bytes.Equal(data, frames[2].data[len(frames[2].data)-300:]) {
+				t.Errorf("geob() = %d bytes, %t", len(data), ok)
+			}
+			if _, ok := tag.geob(seratoMarkersDescription); ok {
+				t.Error("geob() found a frame the tag does not hold")
+			}
+		})
+	}
+}
+
+func TestReadID3WithoutTag(t *testing.T) {
+	tag, err := readID3(writeMP3(t, t.TempDir(), "bare.mp3", nil, testAudio()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.version != 3 || len(tag.frames) != 0 || tag.audioStart != 0 {
+		t.Errorf("tag = %+v, want an empty ID3v2.3 tag", tag)
+	}
+}
+
+func TestReadID3RejectsInvalidTags(t *testing.T) {
+	v22 := buildID3(id3Options{version: 3}, nil)
+	v22[3] = 2
+	unsynchronised := buildID3(id3Options{version: 4}, []id3Frame{textFrame("TIT2", "Title")})
+	unsynchronised[5] |= 0x80
+	oversized := buildID3(id3Options{version: 3}, []id3Frame{textFrame("TIT2", "Title")})
+	binary.BigEndian.PutUint32(oversized[14:], 1000)
+	truncated := buildID3(id3Options{version: 3, padding: 10}, nil)
+
+	tests := []struct {
+		name        string
+		data        []byte
+		unsupported bool
+	}{
+		{"ID3v2.2", v22, true},
+		{"unsynchronised", unsynchronised, true},
+		{"oversized frame", oversized, false},
+		{"truncated tag", truncated[:15], false},
+	}
+	for _, tt := range tests {
+		path := writeMP3(t, t.TempDir(), "bad.mp3", tt.data, nil)
+		_, err := readID3(path)
+		if err == nil {
+			t.Errorf("%s: readID3() succeeded", tt.name)
+			continue
+		}
+		if errors.Is(err, errUnsupportedTag) != tt.unsupported {
+			t.Errorf("%s: readID3() error = %v", tt.name, err)
+		}
+	}
+}
+
+func TestWriteID3(t *testing.T) {
+	object := bytes.Repeat([]byte("markers "), 80)
+	frames := []id3Frame{
+		textFrame("TIT2", "Title"),
+		geobFrame(seratoMarkersDescription, []byte("old markers")),
+		geobFrame("Serato Analysis", []byte{2, 1}),
+	}
+	tests := []struct {
+		name    string
+		opts    id3Options
+		inPlace bool
+	}{
+		{"ID3v2.3 with room", id3Options{version: 3, padding: 2000}, true},
+		{"ID3v2.4 with room", id3Options{version: 4, padding: 2000}, true},
+		{"ID3v2.3 extended header with room", id3Options{version: 3, extended: true, padding: 1000}, true},
+		{"ID3v2.4 extended header with room", id3Options{version: 4, extended: true, padding: 1000}, true},
+		{"ID3v2.3 too small", id3Options{version: 3}, false},
+		{"ID3v2.4 too small", id3Options{version: 4, padding: 100}, false},
+		{"ID3v2.4 extended header too small", id3Options{version: 4, extended: true, padding: 10}, false},
+		{"ID3v2.4 footer too small", id3Options{version: 4, footer: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			audio := testAudio()
+			path := writeMP3(t, dir, "track.mp3", buildID3(tt.opts, frames), audio)
+			if err := os.Chmod(path, 0600); err != nil {
+				t.Fatal(err)
+			}
+			before, _ := os.Stat(path)
+
+			tag, err := readID3(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tag.setGEOB(seratoMarkersDescription, object)
+			if err := tag.write(path); err != nil {
+				t.Fatal(err)
+			}
+
+			after, _ := os.Stat(path)
+			if inPlace := after.Size() == before.Size(); inPlace != tt.inPlace {
+				t.Errorf("file went from %d to %d bytes, want in place: %t", before.Size(), after.Size(), tt.inPlace)
+			}
+			if after.Mode().Perm() != 0600 {
+				t.Errorf("file mode = %v, want 0600", after.Mode().Perm())
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("folder holds %d files after the write", len(entries))
+			}
+
+			tag = checkAudio(t, path, audio)
+			if tag.version != tt.opts.version {
+				t.Errorf("version = %d, want %d", tag.version, tt.opts.version)
+			}
+			want := []id3Frame{frames[0], frames[2], geobFrame(seratoMarkersDescription, object)}
+			if !reflect.DeepEqual(tag.frames, want) {
+				t.Errorf("frames = %+v, want %+v", tag.frames, want)
+			}
+		})
+	}
+}
+
+func TestWriteID3WithoutTag(t *testing.T) {
+	audio := testAudio()
+	path := writeMP3(t, t.TempDir(), "bare.mp3", nil, audio)
+	tag, err := readID3(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag.setGEOB(seratoMarkersDescription, []byte("markers"))
+	if err := tag.write(path); err != nil {
+		t.Fatal(err)
+	}
+	tag = checkAudio(t, path, audio)
+	if data, ok := tag.geob(seratoMarkersDescription); tag.version != 3 || !ok || string(data) != "markers" {
+		t.Errorf("tag = %+v", tag)
+	}
+	if tag.audioStart-id3HeaderSize < id3Padding {
+		t.Errorf("rewritten tag has %d bytes, want room for later edits", tag.audioStart)
+	}
+}
+
+func TestGEOBEncodings(t *testing.T) {
+	// UTF-16 with a byte order mark, as some taggers write it, and a compressed frame this package
+	// cannot read.
+	utf16Description := []byte{1}
+	utf16Description = append(utf16Description, "application/octet-stream\x00"...)
+	utf16Description = append(utf16Description, 0xFF, 0xFE, 0, 0) // Empty file name
+	utf16Description = append(utf16Description, 0xFF, 0xFE)
+	for _, c := range seratoMarkersDescription {
+		utf16Description = append(utf16Description, byte(c), 0)
+	}
+	utf16Description = append(utf16Description, 0, 0)
+	compressed := geobFrame("Serato Overview", []byte("zlib data"))
+	compressed.flags[1] = 0x80
+	tag := &id3Tag{version: 3, frames: []id3Frame{
+		{id: "GEOB", data: append(utf16Description, "object"...)},
+		compressed,
+	}}
+
+	if data, ok := tag.geob(seratoMarkersDescription); !ok || string(data) != "object" {
+		t.Errorf("geob() of a UTF-16 description = %q, %t", data, ok)
+	}
+	if _, ok := tag.geob("Serato Overview"); ok {
+		t.Error("geob() read a compressed frame")
+	}
+	tag.setGEOB(seratoMarkersDescription, []byte("replaced"))
+	if len(tag.frames) != 2 || !reflect.DeepEqual(tag.frames[0], compressed) {
+		t.Errorf("frames after setGEOB() = %+v", tag.frames)
+	}
+	if data, ok := tag.geob(seratoMarkersDescription); !ok || string(data) != "replaced" {
+		t.Errorf("geob() after setGEOB() = %q, %t", data, ok)
+	}
+}
@@ -0,0 +1,211 @@
+package interop
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"megajam/db"
+	"megajam/library"
+)
+
+// markerTolerance is how close in seconds two cue or loop times must be to count as the same.
+const markerTolerance = 0.001
+
+// PathMapping replaces the start of paths, for libraries made on another computer or drive.
+type PathMapping struct {
+	From string // Start of the paths in the other application's library, with "/" separators
+	To   string // Start of the same paths on this computer
+}
+
+// Options controls an import or export.
+type Options struct {
+	// Mappings are tried in order; the first whose From matches a path is applied on import,
+	// and the first whose To matches is reversed on export.
+	Mappings []PathMapping
+}
+
+// Report counts what an import or export did.
+type Report struct {
+	Tracks    int      // Entries matched to library tracks
+	Added     int      // Of those, files that were not in the library yet
+	Cues      int      // Cue points stored
+	Loops     int      // Loops stored
+	Beatgrids int      // Beatgrids stored
+	Playlists int      // Playlists or crates stored
+	Unmatched []string // Locations of entries whose file was not found
+}
+
+// String summarises the report in a sentence.
+func (r *Report) String() string {
+	summary := fmt.Sprintf("%d tracks (%d new), %d cue points, %d loops, %d beatgrids and %d playlists",
+		r.Tracks, r.Added, r.Cues, r.Loops, r.Beatgrids, r.Playlists)
+	if len(r.Unmatched) > 0 {
+		summary += fmt.Sprintf("; %d entries did not match a file", len(r.Unmatched))
+	}
+	return summary
+}
+
+// localPath turns a path from another application's library, with "/" separators, into the
+// path of the file on this computer.
+func (o Options) localPath(path string) string {
+	for _, mapping := range o.Mappings {
+		if mapping.From != "" && strings.HasPrefix(path, mapping.From) {
+			path = mapping.To + strings.TrimPrefix(path, mapping.From)
+			break
+		}
+	}
+	return filepath.FromSlash(path)
+}
+
+// foreignPath turns the path of a file on this computer into the path written for another
+// application, with "/" separators.
+func (o Options) foreignPath(path string) string {
+	for _, mapping := range o.Mappings {
+		if mapping.To != "" && strings.HasPrefix(path, mapping.To) {
+			path = mapping.From + strings.TrimPrefix(path, mapping.To)
+			break
+		}
+	}
+	return filepath.ToSlash(path)
+}
+
+// matchTrack returns the library track stored at path, adding the file to the library when it
+// exists but has not been scanned yet. It returns nil when there is no such file.
+func matchTrack(path string, report *Report) (*db.Track, error) {
+	var track db.Track
+	result := db.DB.Where("path = ?", path).Limit(1).Find(&track)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		report.Tracks++
+		return &track, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() || !library.IsSupported(path) {
+		return nil, nil
+	}
+	imported, err := library.ReadTrack(path, info)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.UpsertTrack(imported); err != nil {
+		return nil, err
+	}
+	report.Tracks++
+	report.Added++
+	return imported, nil
+}
+
+// mergeCuePoints stores the cue points of a track that it does not already have. A hot cue
+// replaces a different cue on its pad; other cues are added unless one is at the same time.
+func mergeCuePoints(trackID uint, cues []db.CuePoint, report *Report) error {
+	existing, err := db.GetCuePoints(trackID)
+	if err != nil {
+		return err
+	}
+	for _, cue := range cues {
+		duplicate := false
+		for _, other := range existing {
+			if other.Pad == cue.Pad && sameTime(other.Time, cue.Time) {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		cue.TrackID = trackID
+		if err := db.CreateCuePoint(&cue); err != nil {
+			return fmt.Errorf("failed to store cue point: %w", err)
+		}
+		existing = append(existing, cue)
+		report.Cues++
+	}
+	return nil
+}
+
+// mergeLoops stores the loops of a track that it does not already have.
+func mergeLoops(trackID uint, loops []db.Loop, report *Report) error {
+	existing, err := db.GetLoops(trackID)
+	if err != nil {
+		return err
+	}
+	for _, loop := range loops {
+		duplicate := false
+		for _, other := range existing {
+			if sameTime(other.Start, loop.Start) && sameTime(other.End, loop.End) {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		loop.TrackID = trackID
+		if err := db.CreateLoop(&loop); err != nil {
+			return fmt.Errorf("failed to store loop: %w", err)
+		}
+		existing = append(existing, loop)
+		report.Loops++
+	}
+	return nil
+}
+
+// saveBeatgrid stores a beatgrid read from another application. Such grids were usually
+// checked by hand, so they are marked manual to keep analysis from replacing them.
+func saveBeatgrid(trackID uint, bpm, firstBeat float64, report *Report) error {
+	if bpm <= 0 {
+		return nil
+	}
+	if err := db.SaveBeatgrid(&db.Beatgrid{TrackID: trackID, BPM: bpm, Offset: downbeat(firstBeat, bpm), Manual: true}); err != nil {
+		return fmt.Errorf("failed to store beatgrid: %w", err)
+	}
+	report.Beatgrids++
+	return nil
+}
+
+// downbeat moves a downbeat at t by whole bars of four beats to the first one in the track.
+func downbeat(t, bpm float64) float64 {
+	bar := 4 * 60 / bpm
+	return t - math.Floor(t/bar)*bar
+}
+
+func sameTime(a, b float64) bool {
+	return math.Abs(a-b) < markerTolerance
+}
+
+// rgbColor formats a colour as "#RRGGBB".
+func rgbColor(r, g, b int) string {
+	return fmt.Sprintf("#%02X%02X%02X", r&0xFF, g&0xFF, b&0xFF)
+}
+
+// parseRGB reads a "#RRGGBB" colour.
+func parseRGB(color string) (r, g, b int, ok bool) {
+	if len(color) != 7 || color[0] != '#' {
+		return 0, 0, 0, false
+	}
+	if _, err := fmt.Sscanf(color[1:], "%02x%02x%02x", &r, &g, &b); err != nil {
+		return 0, 0, 0, false
+	}
+	return r, g, b, true
+}
+
+// allTracks returns every library track in path order.
+func allTracks() ([]db.Track, error) {
+	var tracks []db.Track
+	err := db.DB.Order("path").Find(&tracks).Error
+	return tracks, err
+}
+
+// splitFolder splits a "/"-separated playlist folder into its folder names.
+func splitFolder(folder string) []string {
+	if folder == "" {
+		return nil
+	}
+	return strings.Split(folder, "/")
+}
@@ -0,0 +1,178 @@
+package interop
+
+import (
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"megajam/db"
+	"megajam/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = log.New(io.Discard, "", 0)
+	os.Exit(m.Run())
+}
+
+// openLibrary points db.DB at a new, empty library database for the rest of the test.
+func openLibrary(t *testing.T) {
+	t.Helper()
+	db.DB = nil
+	db.InitDatabase(filepath.Join(t.TempDir(), "library.db"))
+	if db.DB == nil {
+		t.Fatal("failed to open the library database")
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+// addTrack stores a library track for the file at path, which need not exist.
+func addTrack(t *testing.T, track db.Track) uint {
+	t.Helper()
+	if _, err := db.UpsertTrack(&track); err != nil {
+		t.Fatal(err)
+	}
+	return track.ID
+}
+
+// trackID returns the ID of the library track stored at path.
+func trackID(t *testing.T, path string) uint {
+	t.Helper()
+	var track db.Track
+	if err := db.DB.Where("path = ?", path).First(&track).Error; err != nil {
+		t.Fatalf("no track at %s: %v", path, err)
+	}
+	return track.ID
+}
+
+// addMarkers stores cue points and loops for a track.
+func addMarkers(t *testing.T, trackID uint, cues []db.CuePoint, loops []db.Loop) {
+	t.Helper()
+	for _, cue := range cues {
+		cue.TrackID = trackID
+		if err := db.CreateCuePoint(&cue); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, loop := range loops {
+		loop.TrackID = trackID
+		if err := db.CreateLoop(&loop); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// storedCues returns the name, time, pad and colour of a track's cue points, with times
+// rounded to the millisecond the formats store.
+func storedCues(t *testing.T, trackID uint) []db.CuePoint {
+	t.Helper()
+	cues, err := db.GetCuePoints(trackID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []db.CuePoint
+	for _, cue := range cues {
+		got = append(got, db.CuePoint{Name: cue.Name, Time: roundMillis(cue.Time), Pad: cue.Pad, Color: cue.Color})
+	}
+	return got
+}
+
+// storedLoops returns the name, times and colour of a track's loops, with times rounded to the
+// millisecond.
+func storedLoops(t *testing.T, trackID uint) []db.Loop {
+	t.Helper()
+	loops, err := db.GetLoops(trackID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []db.Loop
+	for _, loop := range loops {
+		got = append(got, db.Loop{Name: loop.Name, Start: roundMillis(loop.Start), End: roundMillis(loop.End), Color: loop.Color})
+	}
+	return got
+}
+
+// playlistSummary is a playlist reduced to what the formats carry.
+type playlistSummary struct {
+	Folder, Name string
+	Paths        []string
+}
+
+func storedPlaylists(t *testing.T) []playlistSummary {
+	t.Helper()
+	playlists, err := db.GetPlaylists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []playlistSummary
+	for _, playlist := range playlists {
+		summary := playlistSummary{Folder: playlist.Folder, Name: playlist.Name}
+		for _, track := range playlist.Tracks {
+			summary.Paths = append(summary.Paths, track.Path)
+		}
+		got = append(got, summary)
+	}
+	return got
+}
+
+// checkBeatgrid checks the stored beatgrid of a track.
+func checkBeatgrid(t *testing.T, trackID uint, bpm, offset float64) {
+	t.Helper()
+	grid, err := db.GetBeatgrid(trackID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grid == nil {
+		t.Fatalf("track %d has no beatgrid", trackID)
+	}
+	if math.Abs(grid.BPM-bpm) > 1e-9 || !sameTime(grid.Offset, offset) || !grid.Manual {
+		t.Errorf("beatgrid of track %d = %.3f BPM from %.4fs (manual %t), want %.3f BPM from %.4fs",
+			trackID, grid.BPM, grid.Offset, grid.Manual, bpm, offset)
+	}
+}
+
+func roundMillis(t float64) float64 {
+	return math.Round(t*1000) / 1000
+}
+
+func TestPathMappings(t *testing.T) {
+	opts := Options{Mappings: []PathMapping{
+		{From: "C:/Users/dj/Music", To: "/home/dj/Music"},
+		{From: "/Volumes/USB", To: "/media/usb"},
+	}}
+	tests := []struct {
+		foreign, local string
+	}{
+		{"C:/Users/dj/Music/House/One.mp3", "/home/dj/Music/House/One.mp3"},
+		{"/Volumes/USB/Two.flac", "/media/usb/Two.flac"},
+		{"/srv/elsewhere/Three.wav", "/srv/elsewhere/Three.wav"},
+	}
+	for _, tt := range tests {
+		if got := opts.localPath(tt.foreign); got != filepath.FromSlash(tt.local) {
+			t.Errorf("localPath(%q) = %q, want %q", tt.foreign, got, tt.local)
+		}
+		if got := opts.foreignPath(filepath.FromSlash(tt.local)); got != tt.foreign {
+			t.Errorf("foreignPath(%q) = %q, want %q", tt.local, got, tt.foreign)
+		}
+	}
+}
+
+func TestColors(t *testing.T) {
+	if got := rgbColor(40, 226, 20); got != "#28E214" {
+		t.Errorf("rgbColor() = %q", got)
+	}
+	if r, g, b, ok := parseRGB("#28e214"); !ok || r != 40 || g != 226 || b != 20 {
+		t.Errorf("parseRGB() = %d, %d, %d, %t", r, g, b, ok)
+	}
+	for _, color := range []string{"", "28E214", "#28E21", "#GGGGGG"} {
+		if _, _, _, ok := parseRGB(color); ok {
+			t.Errorf("parseRGB(%q) succeeded", color)
+		}
+	}
+}
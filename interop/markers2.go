@@ -0,0 +1,184 @@
+package interop
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"megajam/db"
+)
+
+// seratoMarkersDescription names the GEOB frame in which Serato stores cues, loops and the
+// track colour.
+const seratoMarkersDescription = "Serato Markers2"
+
+// seratoMarkersMinSize is the size Serato pads the Markers2 frame to.
+const seratoMarkersMinSize = 470
+
+// seratoMarkersLineLength is the length of the lines the base64 payload is split into.
+const seratoMarkersLineLength = 72
+
+// Serato's default colours for hot cues, saved loops and tracks.
+const (
+	seratoCueColor   = "#CC0000"
+	seratoLoopColor  = "#27AAE1"
+	seratoTrackColor = "#FFFFFF"
+)
+
+// seratoEntry is an entry of the Markers2 payload, such as "CUE", "LOOP", "COLOR" or "BPMLOCK".
+type seratoEntry struct {
+	name string
+	data []byte
+}
+
+// decodeSeratoMarkers reads the entries of a Markers2 frame: a version, then base64 text split
+// into lines and padded with NULs, whose payload is a version followed by the entries.
+func decodeSeratoMarkers(frame []byte) ([]seratoEntry, error) {
+	if len(frame) < 2 || frame[0] != 1 || frame[1] != 1 {
+		return nil, fmt.Errorf("unsupported Serato Markers2 version")
+	}
+	text := frame[2:]
+	if i := bytes.IndexByte(text, 0); i >= 0 {
+		text = text[:i]
+	}
+	encoded := strings.NewReplacer("\n", "", "=", "").Replace(string(text))
+	if len(encoded)%4 == 1 {
+		encoded += "A" // Serato drops a trailing zero character
+	}
+	payload, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Serato Markers2: %w", err)
+	}
+	if len(payload) < 2 || payload[0] != 1 || payload[1] != 1 {
+		return nil, fmt.Errorf("unsupported Serato Markers2 payload version")
+	}
+
+	var entries []seratoEntry
+	rest := payload[2:]
+	for len(rest) > 0 && rest[0] != 0 {
+		i := bytes.IndexByte(rest, 0)
+		if i < 0 || len(rest) < i+5 {
+			return nil, fmt.Errorf("corrupt Serato Markers2 entry")
+		}
+		name := string(rest[:i])
+		size := int(binary.BigEndian.Uint32(rest[i+1:]))
+		rest = rest[i+5:]
+		if size > len(rest) {
+			return nil, fmt.Errorf("corrupt Serato Markers2 entry %s", name)
+		}
+		entries = append(entries, seratoEntry{name: name, data: rest[:size]})
+		rest = rest[size:]
+	}
+	return entries, nil
+}
+
+// encodeSeratoMarkers builds a Markers2 frame holding entries.
+func encodeSeratoMarkers(entries []seratoEntry) []byte {
+	payload := []byte{1, 1}
+	for _, entry := range entries {
+		payload = append(payload, entry.name...)
+		payload = append(payload, 0)
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(entry.data)))
+		payload = append(payload, entry.data...)
+	}
+	payload = append(payload, 0)
+
+	encoded := base64.RawStdEncoding.EncodeToString(payload)
+	frame := []byte{1, 1}
+	for len(encoded) > seratoMarkersLineLength {
+		frame = append(frame, encoded[:seratoMarkersLineLength]...)
+		frame = append(frame, '\n')
+		encoded = encoded[seratoMarkersLineLength:]
+	}
+	frame = append(frame, encoded...)
+	frame = append(frame, 0)
+	if len(frame) < seratoMarkersMinSize {
+		frame = append(frame, make([]byte, seratoMarkersMinSize-len(frame))...)
+	}
+	return frame
+}
+
+// seratoMarkers returns the hot cues and saved loops in Markers2 entries. Hot cue indexes from
+// 0 become pads from 1.
+func seratoMarkers(entries []seratoEntry) ([]db.CuePoint, []db.Loop) {
+	var cues []db.CuePoint
+	var loops []db.Loop
+	for _, entry := range entries {
+		data := entry.data
+		switch {
+		case entry.name == "CUE" && len(data) >= 12:
+			index := int(data[1])
+			if index >= db.HotCuePads {
+				continue
+			}
+			name, _, _ := cutText(0, data[12:])
+			cues = append(cues, db.CuePoint{
+				Name:  string(name),
+				Time:  float64(binary.BigEndian.Uint32(data[2:])) / 1000,
+				Pad:   index + 1,
+				Color: rgbColor(int(data[7]), int(data[8]), int(data[9])),
+			})
+		case entry.name == "LOOP" && len(data) >= 20:
+			name, _, _ := cutText(0, data[20:])
+			loops = append(loops, db.Loop{
+				Name:  string(name),
+				Start: float64(binary.BigEndian.Uint32(data[2:])) / 1000,
+				End:   float64(binary.BigEndian.Uint32(data[6:])) / 1000,
+				Color: rgbColor(int(data[15]), int(data[16]), int(data[17])),
+			})
+		}
+	}
+	return cues, loops
+}
+
+// setSeratoMarkers replaces the hot cues and saved loops in Markers2 entries, keeping the other
+// entries. Memory cues have no place in Serato and are left out, as are loops past the eighth.
+func setSeratoMarkers(entries []seratoEntry, cues []db.CuePoint, loops []db.Loop) []seratoEntry {
+	kept := []seratoEntry{}
+	for _, entry := range entries {
+		if entry.name != "CUE" && entry.name != "LOOP" {
+			kept = append(kept, entry)
+		}
+	}
+	if len(entries) == 0 {
+		kept = append(kept, seratoEntry{name: "COLOR", data: seratoColor(seratoTrackColor, seratoTrackColor)})
+	}
+
+	for _, cue := range cues {
+		if cue.Pad < 1 {
+			continue
+		}
+		data := []byte{0, byte(cue.Pad - 1)}
+		data = binary.BigEndian.AppendUint32(data, uint32(cue.Time*1000+0.5))
+		data = append(data, seratoColor(cue.Color, seratoCueColor)...)
+		data = append(data, 0, 0)
+		data = append(data, cue.Name...)
+		kept = append(kept, seratoEntry{name: "CUE", data: append(data, 0)})
+	}
+	for i, loop := range loops {
+		if i >= db.HotCuePads {
+			break
+		}
+		data := []byte{0, byte(i)}
+		data = binary.BigEndian.AppendUint32(data, uint32(loop.Start*1000+0.5))
+		data = binary.BigEndian.AppendUint32(data, uint32(loop.End*1000+0.5))
+		data = append(data, 0xFF, 0xFF, 0xFF, 0xFF)
+		data = append(data, seratoColor(loop.Color, seratoLoopColor)...)
+		data = append(data, 0, 0) // Not locked
+		data = append(data, loop.Name...)
+		kept = append(kept, seratoEntry{name: "LOOP", data: append(data, 0)})
+	}
+	return kept
+}
+
+// seratoColor encodes a "#RRGGBB" colour as Serato's four colour bytes, using fallback when
+// color is empty or malformed.
+func seratoColor(color, fallback string) []byte {
+	r, g, b, ok := parseRGB(color)
+	if !ok {
+		r, g, b, _ = parseRGB(fallback)
+	}
+	return []byte{0, byte(r), byte(g), byte(b)}
+}
@@ -0,0 +1,159 @@
+package interop
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"megajam/db"
+)
+
+// seratoFrame builds a Markers2 frame the way Serato writes it: the base64 text loses its
+// padding and, when it ends in a zero character, that character too.
+func seratoFrame(t *testing.T, entries []seratoEntry) []byte {
+	t.Helper()
+	payload := []byte{1, 1}
+	for _, entry := range entries {
+		payload = append(payload, entry.name...)
+		payload = append(payload, 0)
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(entry.data)))
+		payload = append(payload, entry.data...)
+	}
+	payload = append(payload, 0)
+	encoded := base64.StdEncoding.EncodeToString(payload)
+	encoded = strings.TrimRight(encoded, "=")
+	if len(encoded)%4 != 2 || !strings.HasSuffix(encoded, "A") {
+		t.Fatalf("payload of %d bytes does not end in a character Serato drops", len(payload))
+	}
+	encoded = encoded[:len(encoded)-1]
+
+	frame := []byte{1, 1}
+	for len(encoded) > seratoMarkersLineLength {
+		frame = append(frame, encoded[:seratoMarkersLineLength]+"\n"...)
+		encoded = encoded[seratoMarkersLineLength:]
+	}
+	frame = append(frame, encoded...)
+	return append(frame, make([]byte, seratoMarkersMinSize-len(frame))...)
+}
+
+func TestDecodeSeratoMarkers(t *testing.T) {
+	cue := []byte{0, 2, 0, 0, 0x03, 0xE8, 0, 0xCC, 0x88, 0, 0, 0}
+	cue = append(cue, "AB\x00"...)
+	loop := []byte{0, 0, 0, 0, 0x75, 0x30, 0, 0, 0xB1, 0xC4, 0xFF, 0xFF, 0xFF, 0xFF, 0, 0x27, 0xAA, 0xE1, 0, 0}
+	loop = append(loop, "Build\x00"...)
+	entries := []seratoEntry{
+		{name: "COLOR", data: []byte{0, 0xFF, 0x99, 0xFF}},
+		{name: "CUE", data: cue},
+		{name: "LOOP", data: loop},
+		{name: "BPMLOCK", data: []byte{1}},
+	}
+
+	got, err := decodeSeratoMarkers(seratoFrame(t, entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Fatalf("entries = %+v, want %+v", got, entries)
+	}
+	cues, loops := seratoMarkers(got)
+	wantCues := []db.CuePoint{{Name: "AB", Time: 1, Pad: 3, Color: "#CC8800"}}
+	if !reflect.DeepEqual(cues, wantCues) {
+		t.Errorf("cues = %+v, want %+v", cues, wantCues)
+	}
+	wantLoops := []db.Loop{{Name: "Build", Start: 30, End: 45.508, Color: "#27AAE1"}}
+	if !reflect.DeepEqual(loops, wantLoops) {
+		t.Errorf("loops = %+v, want %+v", loops, wantLoops)
+	}
+}
+
+func TestDecodeSeratoMarkersRejectsInvalidFrames(t *testing.T) {
+	valid := encodeSeratoMarkers([]seratoEntry{{name: "BPMLOCK", data: []byte{0}}})
+	payload := func(b []byte) []byte {
+		return append([]byte{1, 1}, base64.RawStdEncoding.EncodeToString(b)+"\x00"...)
+	}
+	tests := map[string][]byte{
+		"empty":                    nil,
+		"frame version":            append([]byte{2, 1}, valid[2:]...),
+		"payload version":          payload([]byte{2, 1, 0}),
+		"not base64":               []byte("\x01\x01!!!!\x00"),
+		"entry size past the end":  payload([]byte{1, 1, 'C', 'U', 'E', 0, 0, 0, 1, 0, 1, 2, 3}),
+		"entry name without a NUL": payload([]byte{1, 1, 'C', 'U', 'E'}),
+	}
+	for name, frame := range tests {
+		if entries, err := decodeSeratoMarkers(frame); err == nil {
+			t.Errorf("%s: decodeSeratoMarkers() = %+v", name, entries)
+		}
+	}
+}
+
+func TestSeratoMarkersRoundTrip(t *testing.T) {
+	cues := []db.CuePoint{
+		{Name: "Memory", Time: 5},
+		{Name: "Drop", Time: 64.123, Pad: 1, Color: "#28E214"},
+		{Time: 0, Pad: 2},
+		{Name: "Ünïcode", Time: 3599.999, Pad: 8, Color: "#0000ff"},
+	}
+	var loops []db.Loop
+	for i := 0; i < db.HotCuePads+1; i++ {
+		loops = append(loops, db.Loop{Start: float64(10 * i), End: float64(10*i) + 4.25, Color: "#FF8C00"})
+	}
+	loops[0].Name, loops[0].Color = "Intro", ""
+
+	frame := encodeSeratoMarkers(setSeratoMarkers(nil, cues, loops))
+	if len(frame) < seratoMarkersMinSize || frame[0] != 1 || frame[1] != 1 {
+		t.Fatalf("frame of %d bytes starting % X", len(frame), frame[:2])
+	}
+	text := frame[2 : bytes.IndexByte(frame[2:], 0)+2]
+	for _, line := range strings.Split(string(text), "\n") {
+		if len(line) > seratoMarkersLineLength {
+			t.Errorf("line of %d characters", len(line))
+		}
+	}
+
+	entries, err := decodeSeratoMarkers(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].name != "COLOR" {
+		t.Errorf("first entry = %s, want the track colour", entries[0].name)
+	}
+	gotCues, gotLoops := seratoMarkers(entries)
+	// Memory cues and loops past the eighth have no place in Serato, and missing colours are
+	// written as Serato's defaults.
+	wantCues := []db.CuePoint{
+		{Name: "Drop", Time: 64.123, Pad: 1, Color: "#28E214"},
+		{Time: 0, Pad: 2, Color: seratoCueColor},
+		{Name: "Ünïcode", Time: 3599.999, Pad: 8, Color: "#0000FF"},
+	}
+	if !reflect.DeepEqual(gotCues, wantCues) {
+		t.Errorf("cues = %+v, want %+v", gotCues, wantCues)
+	}
+	wantLoops := append([]db.Loop{}, loops[:db.HotCuePads]...)
+	wantLoops[0].Color = seratoLoopColor
+	if !reflect.DeepEqual(gotLoops, wantLoops) {
+		t.Errorf("loops = %+v, want %+v", gotLoops, wantLoops)
+	}
+}
+
+func TestSetSeratoMarkersKeepsOtherEntries(t *testing.T) {
+	entries := []seratoEntry{
+		{name: "COLOR", data: []byte{0, 0xFF, 0x99, 0xFF}},
+		{name: "CUE", data: []byte("old cue")},
+		{name: "BPMLOCK", data: []byte{1}},
+		{name: "LOOP", data: []byte("old loop")},
+	}
+	got := setSeratoMarkers(entries, []db.CuePoint{{Time: 1, Pad: 1}}, nil)
+	var names []string
+	for _, entry := range got {
+		names = append(names, entry.name)
+	}
+	if want := []string{"COLOR", "BPMLOCK", "CUE"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("entries = %q, want %q", names, want)
+	}
+	if !bytes.Equal(got[0].data, entries[0].data) {
+		t.Errorf("track colour = % X, want % X", got[0].data, entries[0].data)
+	}
+}
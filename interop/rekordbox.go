@@ -0,0 +1,350 @@
+package interop
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"megajam/db"
+	"megajam/library"
+	"megajam/logger"
+)
+
+// Rekordbox position mark types.
+const (
+	rekordboxCue  = 0
+	rekordboxLoop = 4
+)
+
+// rekordboxLocationPrefix starts the file URL of every track in a rekordbox collection.
+const rekordboxLocationPrefix = "file://localhost"
+
+// rekordboxLibrary is the DJ_PLAYLISTS document rekordbox imports and exports.
+type rekordboxLibrary struct {
+	XMLName    xml.Name            `xml:"DJ_PLAYLISTS"`
+	Version    string              `xml:"Version,attr"`
+	Product    rekordboxProduct    `xml:"PRODUCT"`
+	Collection rekordboxCollection `xml:"COLLECTION"`
+	Playlists  *rekordboxNode      `xml:"PLAYLISTS>NODE"` // The ROOT folder
+}
+
+type rekordboxProduct struct {
+	Name    string `xml:"Name,attr"`
+	Version string `xml:"Version,attr"`
+	Company string `xml:"Company,attr"`
+}
+
+type rekordboxCollection struct {
+	Entries int              `xml:"Entries,attr"`
+	Tracks  []rekordboxTrack `xml:"TRACK"`
+}
+
+type rekordboxTrack struct {
+	TrackID    string           `xml:"TrackID,attr"`
+	Name       string           `xml:"Name,attr"`
+	Artist     string           `xml:"Artist,attr"`
+	Album      string           `xml:"Album,attr"`
+	Kind       string           `xml:"Kind,attr"`
+	Size       int64            `xml:"Size,attr"`
+	TotalTime  int              `xml:"TotalTime,attr"` // Seconds
+	AverageBpm float64          `xml:"AverageBpm,attr"`
+	Location   string           `xml:"Location,attr"`
+	Tempos     []rekordboxTempo `xml:"TEMPO"`
+	Marks      []rekordboxMark  `xml:"POSITION_MARK"`
+}
+
+// rekordboxTempo starts a section of the beatgrid at a beat.
+type rekordboxTempo struct {
+	Inizio  float64 `xml:"Inizio,attr"` // Time of the beat in seconds
+	Bpm     float64 `xml:"Bpm,attr"`
+	Metro   string  `xml:"Metro,attr"`
+	Battito int     `xml:"Battito,attr"` // Position of the beat in its bar, from 1
+}
+
+// rekordboxMark is a memory cue, hot cue or loop.
+type rekordboxMark struct {
+	Name  string   `xml:"Name,attr"`
+	Type  int      `xml:"Type,attr"`
+	Start float64  `xml:"Start,attr"`
+	End   *float64 `xml:"End,attr,omitempty"`
+	Num   int      `xml:"Num,attr"` // Hot cue from 0, or -1 for a memory cue
+	Red   *int     `xml:"Red,attr,omitempty"`
+	Green *int     `xml:"Green,attr,omitempty"`
+	Blue  *int     `xml:"Blue,attr,omitempty"`
+}
+
+// rekordboxNode is a playlist folder (Type 0) or a playlist (Type 1).
+type rekordboxNode struct {
+	Type    int             `xml:"Type,attr"`
+	Name    string          `xml:"Name,attr"`
+	Count   *int            `xml:"Count,attr,omitempty"`   // Children of a folder
+	KeyType *int            `xml:"KeyType,attr,omitempty"` // 0 when tracks are keyed by TrackID, 1 by Location
+	Entries *int            `xml:"Entries,attr,omitempty"` // Tracks of a playlist
+	Nodes   []rekordboxNode `xml:"NODE"`
+	Tracks  []rekordboxKey  `xml:"TRACK"`
+}
+
+type rekordboxKey struct {
+	Key string `xml:"Key,attr"`
+}
+
+// ImportRekordbox reads a rekordbox collection XML into the library: tracks are matched by
+// file, and their beatgrids, cues, loops and the playlist tree are stored alongside.
+func ImportRekordbox(r io.Reader, opts Options) (*Report, error) {
+	var doc rekordboxLibrary
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse rekordbox XML: %w", err)
+	}
+
+	report := &Report{}
+	byID := make(map[string]uint)       // Library track IDs by rekordbox TrackID
+	byLocation := make(map[string]uint) // and by Location
+	for _, entry := range doc.Collection.Tracks {
+		path, err := rekordboxPath(entry.Location)
+		if err != nil {
+			report.Unmatched = append(report.Unmatched, entry.Location)
+			continue
+		}
+		track, err := matchTrack(opts.localPath(path), report)
+		if err != nil {
+			return report, err
+		}
+		if track == nil {
+			report.Unmatched = append(report.Unmatched, entry.Location)
+			continue
+		}
+		byID[entry.TrackID] = track.ID
+		byLocation[entry.Location] = track.ID
+		if err := importRekordboxTrack(track.ID, entry, report); err != nil {
+			return report, err
+		}
+	}
+
+	if doc.Playlists != nil {
+		for _, node := range doc.Playlists.Nodes {
+			if err := importRekordboxNode(node, "", byID, byLocation, report); err != nil {
+				return report, err
+			}
+		}
+	}
+	logger.Logger.Printf("Imported rekordbox collection: %s", report)
+	return report, nil
+}
+
+// importRekordboxTrack stores the beatgrid, cues and loops of a collection entry.
+func importRekordboxTrack(trackID uint, entry rekordboxTrack, report *Report) error {
+	if len(entry.Tempos) > 0 {
+		// The grid is stored with one tempo; the first section places the downbeats.
+		tempo := entry.Tempos[0]
+		firstBeat := tempo.Inizio
+		if tempo.Bpm > 0 && tempo.Battito > 1 {
+			firstBeat += float64(5-tempo.Battito) * 60 / tempo.Bpm
+		}
+		if err := saveBeatgrid(trackID, tempo.Bpm, firstBeat, report); err != nil {
+			return err
+		}
+	}
+
+	var cues []db.CuePoint
+	var loops []db.Loop
+	for _, mark := range entry.Marks {
+		color := ""
+		if mark.Red != nil && mark.Green != nil && mark.Blue != nil {
+			color = rgbColor(*mark.Red, *mark.Green, *mark.Blue)
+		}
+		if mark.Type == rekordboxLoop && mark.End != nil && *mark.End > mark.Start {
+			loops = append(loops, db.Loop{Name: mark.Name, Start: mark.Start, End: *mark.End, Color: color})
+			continue
+		}
+		cue := db.CuePoint{Name: mark.Name, Time: mark.Start, Color: color}
+		if mark.Num >= 0 && mark.Num < db.HotCuePads {
+			cue.Pad = mark.Num + 1
+		}
+		cues = append(cues, cue)
+	}
+	if err := mergeCuePoints(trackID, cues, report); err != nil {
+		return err
+	}
+	return mergeLoops(trackID, loops, report)
+}
+
+// importRekordboxNode stores the playlists in node, which is held by the given folder.
+func importRekordboxNode(node rekordboxNode, folder string, byID, byLocation map[string]uint, report *Report) error {
+	if node.Type == 0 {
+		if folder != "" {
+			folder += "/"
+		}
+		folder += strings.ReplaceAll(node.Name, "/", "-")
+		for _, child := range node.Nodes {
+			if err := importRekordboxNode(child, folder, byID, byLocation, report); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	keys := byID
+	if node.KeyType != nil && *node.KeyType == 1 {
+		keys = byLocation
+	}
+	var trackIDs []uint
+	for _, track := range node.Tracks {
+		if id, ok := keys[track.Key]; ok {
+			trackIDs = append(trackIDs, id)
+		}
+	}
+	if _, err := db.SavePlaylist(folder, node.Name, trackIDs); err != nil {
+		return fmt.Errorf("failed to store playlist '%s': %w", node.Name, err)
+	}
+	report.Playlists++
+	return nil
+}
+
+// ExportRekordbox writes the whole library, with beatgrids, cues, loops and playlists, as a
+// rekordbox collection XML.
+func ExportRekordbox(w io.Writer, opts Options) (*Report, error) {
+	tracks, err := allTracks()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	doc := rekordboxLibrary{
+		Version: "1.0.0",
+		Product: rekordboxProduct{Name: "megajam", Version: "1.0", Company: "megalithiCode"},
+	}
+	for _, track := range tracks {
+		entry, err := exportRekordboxTrack(track, opts, report)
+		if err != nil {
+			return report, err
+		}
+		doc.Collection.Tracks = append(doc.Collection.Tracks, entry)
+	}
+	doc.Collection.Entries = len(doc.Collection.Tracks)
+
+	playlists, err := db.GetPlaylists()
+	if err != nil {
+		return report, err
+	}
+	root := rekordboxFolder("ROOT")
+	for _, playlist := range playlists {
+		parent := root
+		for _, name := range splitFolder(playlist.Folder) {
+			parent = parent.folder(name)
+		}
+		keyType, entries := 0, len(playlist.Tracks)
+		node := rekordboxNode{Type: 1, Name: playlist.Name, KeyType: &keyType, Entries: &entries}
+		for _, track := range playlist.Tracks {
+			node.Tracks = append(node.Tracks, rekordboxKey{Key: strconv.FormatUint(uint64(track.ID), 10)})
+		}
+		parent.Nodes = append(parent.Nodes, node)
+		*parent.Count = len(parent.Nodes)
+		report.Playlists++
+	}
+	doc.Playlists = root
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return report, err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return report, fmt.Errorf("failed to write rekordbox XML: %w", err)
+	}
+	logger.Logger.Printf("Exported rekordbox collection: %s", report)
+	return report, nil
+}
+
+// exportRekordboxTrack builds the collection entry of a library track.
+func exportRekordboxTrack(track db.Track, opts Options, report *Report) (rekordboxTrack, error) {
+	entry := rekordboxTrack{
+		TrackID:    strconv.FormatUint(uint64(track.ID), 10),
+		Name:       track.Title,
+		Artist:     track.Artist,
+		Album:      track.Album,
+		Kind:       strings.ToUpper(strings.TrimPrefix(filepath.Ext(track.Path), ".")) + " File",
+		Size:       track.FileSize,
+		AverageBpm: track.BPM,
+		Location:   rekordboxLocation(opts.foreignPath(track.Path)),
+	}
+	if duration, err := library.ParseDuration(track.Duration); err == nil {
+		entry.TotalTime = int(duration.Seconds())
+	}
+	report.Tracks++
+
+	grid, err := db.GetBeatgrid(track.ID)
+	if err != nil {
+		return entry, err
+	}
+	if grid != nil {
+		entry.Tempos = []rekordboxTempo{{Inizio: grid.Offset, Bpm: grid.BPM, Metro: "4/4", Battito: 1}}
+		report.Beatgrids++
+	}
+
+	cues, err := db.GetCuePoints(track.ID)
+	if err != nil {
+		return entry, err
+	}
+	for _, cue := range cues {
+		mark := rekordboxMark{Name: cue.Name, Type: rekordboxCue, Start: cue.Time, Num: cue.Pad - 1}
+		if red, green, blue, ok := parseRGB(cue.Color); ok && cue.Pad > 0 {
+			mark.Red, mark.Green, mark.Blue = &red, &green, &blue
+		}
+		entry.Marks = append(entry.Marks, mark)
+		report.Cues++
+	}
+	loops, err := db.GetLoops(track.ID)
+	if err != nil {
+		return entry, err
+	}
+	for _, loop := range loops {
+		end := loop.End
+		entry.Marks = append(entry.Marks, rekordboxMark{Name: loop.Name, Type: rekordboxLoop, Start: loop.Start, End: &end, Num: -1})
+		report.Loops++
+	}
+	return entry, nil
+}
+
+// rekordboxFolder returns an empty playlist folder node.
+func rekordboxFolder(name string) *rekordboxNode {
+	count := 0
+	return &rekordboxNode{Type: 0, Name: name, Count: &count}
+}
+
+// folder returns the subfolder of n called name, adding it when missing.
+func (n *rekordboxNode) folder(name string) *rekordboxNode {
+	for i := range n.Nodes {
+		if n.Nodes[i].Type == 0 && n.Nodes[i].Name == name {
+			return &n.Nodes[i]
+		}
+	}
+	n.Nodes = append(n.Nodes, *rekordboxFolder(name))
+	*n.Count = len(n.Nodes)
+	return &n.Nodes[len(n.Nodes)-1]
+}
+
+// rekordboxPath decodes the file URL of a collection entry into a "/"-separated path.
+// Windows paths keep their drive letter, as in "C:/Music/track.mp3".
+func rekordboxPath(location string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported track location '%s'", location)
+	}
+	path := u.Path
+	if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return path, nil
+}
+
+// rekordboxLocation encodes a "/"-separated path as the file URL of a collection entry.
+func rekordboxLocation(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path // Windows drive letter
+	}
+	return rekordboxLocationPrefix + (&url.URL{Path: path}).EscapedPath()
+}
@@ -0,0 +1,216 @@
+package interop
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"megajam/db"
+)
+
+// rekordboxFixture is a collection as rekordbox exports it, with a beatgrid that does not start
+// on a downbeat, memory and hot cues, loops, a Windows path and entries that match no file.
+const rekordboxFixture = `<?xml version="1.0" encoding="UTF-8"?>
+<DJ_PLAYLISTS Version="1.0.0">
+  <PRODUCT Name="rekordbox" Version="6.8.5" Company="AlphaTheta"/>
+  <COLLECTION Entries="4">
+    <TRACK TrackID="101" Name="First" Artist="Someone" Kind="MP3 File" TotalTime="300" AverageBpm="124.00"
+           Location="file://localhost/Users/dj/Music/First%20Track.mp3">
+      <TEMPO Inizio="0.350" Bpm="124.00" Metro="4/4" Battito="3"/>
+      <TEMPO Inizio="60.000" Bpm="125.00" Metro="4/4" Battito="1"/>
+      <POSITION_MARK Name="" Type="0" Start="0.350" Num="-1"/>
+      <POSITION_MARK Name="Drop" Type="0" Start="61.290" Num="0" Red="40" Green="226" Blue="20"/>
+      <POSITION_MARK Name="Break" Type="0" Start="92.500" Num="7" Red="255" Green="0" Blue="0"/>
+      <POSITION_MARK Name="Intro" Type="4" Start="0.350" End="8.092" Num="-1"/>
+    </TRACK>
+    <TRACK TrackID="102" Name="Second" Kind="MP3 File" Location="file://localhost/C:/Music/Second.mp3">
+      <POSITION_MARK Name="Outro" Type="4" Start="180.000" End="196.000" Num="2" Red="255" Green="140" Blue="0"/>
+    </TRACK>
+    <TRACK TrackID="103" Name="Missing" Location="file://localhost/Users/dj/Music/Missing.mp3"/>
+    <TRACK TrackID="104" Name="Stream" Location="https://example.com/stream.mp3"/>
+  </COLLECTION>
+  <PLAYLISTS>
+    <NODE Type="0" Name="ROOT" Count="2">
+      <NODE Type="0" Name="Gigs" Count="1">
+        <NODE Name="Friday" Type="1" KeyType="0" Entries="3">
+          <TRACK Key="102"/>
+          <TRACK Key="103"/>
+          <TRACK Key="101"/>
+        </NODE>
+      </NODE>
+      <NODE Name="By location" Type="1" KeyType="1" Entries="1">
+        <TRACK Key="file://localhost/C:/Music/Second.mp3"/>
+      </NODE>
+    </NODE>
+  </PLAYLISTS>
+</DJ_PLAYLISTS>
+`
+
+func TestImportRekordbox(t *testing.T) {
+	openLibrary(t)
+	first := filepath.FromSlash("/Users/dj/Music/First Track.mp3")
+	second := filepath.FromSlash("/home/dj/Music/Second.mp3")
+	firstID := addTrack(t, db.Track{Path: first})
+	secondID := addTrack(t, db.Track{Path: second})
+	opts := Options{Mappings: []PathMapping{{From: "C:/Music", To: "/home/dj/Music"}}}
+
+	report, err := ImportRekordbox(strings.NewReader(rekordboxFixture), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Report{Tracks: 2, Cues: 3, Loops: 2, Beatgrids: 1, Playlists: 2,
+		Unmatched: []string{"file://localhost/Users/dj/Music/Missing.mp3", "https://example.com/stream.mp3"}}
+	if !reflect.DeepEqual(*report, want) {
+		t.Errorf("report = %+v, want %+v", *report, want)
+	}
+
+	// Battito 3 puts the first downbeat two beats after Inizio.
+	checkBeatgrid(t, firstID, 124, 0.35+2*60.0/124)
+	wantCues := []db.CuePoint{
+		{Time: 0.35},
+		{Name: "Drop", Time: 61.29, Pad: 1, Color: "#28E214"},
+		{Name: "Break", Time: 92.5, Pad: 8, Color: "#FF0000"},
+	}
+	if got := storedCues(t, firstID); !reflect.DeepEqual(got, wantCues) {
+		t.Errorf("cues = %+v, want %+v", got, wantCues)
+	}
+	wantLoops := []db.Loop{{Name: "Intro", Start: 0.35, End: 8.092}}
+	if got := storedLoops(t, firstID); !reflect.DeepEqual(got, wantLoops) {
+		t.Errorf("loops = %+v, want %+v", got, wantLoops)
+	}
+	wantLoops = []db.Loop{{Name: "Outro", Start: 180, End: 196, Color: "#FF8C00"}}
+	if got := storedLoops(t, secondID); !reflect.DeepEqual(got, wantLoops) {
+		t.Errorf("loops of the second track = %+v, want %+v", got, wantLoops)
+	}
+	wantPlaylists := []playlistSummary{
+		{Name: "By location", Paths: []string{second}},
+		{Folder: "Gigs", Name: "Friday", Paths: []string{second, first}},
+	}
+	if got := storedPlaylists(t); !reflect.DeepEqual(got, wantPlaylists) {
+		t.Errorf("playlists = %+v, want %+v", got, wantPlaylists)
+	}
+
+	// Importing again adds no markers twice.
+	report, err = ImportRekordbox(strings.NewReader(rekordboxFixture), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Cues != 0 || report.Loops != 0 {
+		t.Errorf("second import stored %d cues and %d loops", report.Cues, report.Loops)
+	}
+}
+
+func TestRekordboxRoundTrip(t *testing.T) {
+	openLibrary(t)
+	one := filepath.FromSlash("/music/House/One.mp3")
+	two := filepath.FromSlash("/music/Café #2 & more.flac")
+	other := filepath.FromSlash("/srv/other/Three.wav")
+	tracks := []db.Track{
+		{Path: one, Title: "One", Artist: "Artist", Album: "Album", Duration: "5:04", BPM: 126, FileSize: 12345678},
+		{Path: two, Title: "Two"},
+		{Path: other, Title: "Three"},
+	}
+	for _, track := range tracks {
+		addTrack(t, track)
+	}
+	cues := []db.CuePoint{
+		{Name: "Start", Time: 0.25},
+		{Name: "Drop", Time: 64.123, Pad: 1, Color: "#28E214"},
+		{Time: 120.5, Pad: 8, Color: "#FF0000"},
+	}
+	loops := []db.Loop{
+		{Name: "Intro", Start: 0.25, End: 8.25},
+		{Start: 32, End: 40.5},
+	}
+	addMarkers(t, trackID(t, one), cues, loops)
+	if err := db.SaveBeatgrid(&db.Beatgrid{TrackID: trackID(t, one), BPM: 126, Offset: 0.25}); err != nil {
+		t.Fatal(err)
+	}
+	addMarkers(t, trackID(t, two), []db.CuePoint{{Name: "Vocal", Time: 45, Pad: 3, Color: "#0000FF"}}, nil)
+	playlists := []playlistSummary{
+		{Name: "Warm up", Paths: []string{two, one}},
+		{Folder: "Gigs", Name: "Saturday", Paths: []string{other, two, one}},
+		{Folder: "Gigs/2024", Name: "Friday", Paths: []string{one}},
+	}
+	for _, playlist := range playlists {
+		var ids []uint
+		for _, path := range playlist.Paths {
+			ids = append(ids, trackID(t, path))
+		}
+		if _, err := db.SavePlaylist(playlist.Folder, playlist.Name, ids); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := Options{Mappings: []PathMapping{{From: "D:/Music", To: filepath.FromSlash("/music")}}}
+	var xml bytes.Buffer
+	report, err := ExportRekordbox(&xml, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Report{Tracks: 3, Cues: 4, Loops: 2, Beatgrids: 1, Playlists: 3}); !reflect.DeepEqual(*report, want) {
+		t.Errorf("export report = %+v, want %+v", *report, want)
+	}
+	for _, location := range []string{
+		`Location="file://localhost/D:/Music/House/One.mp3"`,
+		`Location="file://localhost/D:/Music/Caf%C3%A9%20%232%20&amp;%20more.flac"`,
+		`Location="file://localhost/srv/other/Three.wav"`,
+		`TotalTime="304"`,
+	} {
+		if !strings.Contains(xml.String(), location) {
+			t.Errorf("exported XML lacks %s:\n%s", location, xml.String())
+		}
+	}
+
+	// Import into a new library whose tracks were added in another order, so their IDs differ.
+	openLibrary(t)
+	for i := len(tracks) - 1; i >= 0; i-- {
+		addTrack(t, db.Track{Path: tracks[i].Path})
+	}
+	report, err = ImportRekordbox(&xml, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Report{Tracks: 3, Cues: 4, Loops: 2, Beatgrids: 1, Playlists: 3}); !reflect.DeepEqual(*report, want) {
+		t.Errorf("import report = %+v, want %+v", *report, want)
+	}
+	if got := storedCues(t, trackID(t, one)); !reflect.DeepEqual(got, cues) {
+		t.Errorf("cues = %+v, want %+v", got, cues)
+	}
+	if got := storedLoops(t, trackID(t, one)); !reflect.DeepEqual(got, loops) {
+		t.Errorf("loops = %+v, want %+v", got, loops)
+	}
+	checkBeatgrid(t, trackID(t, one), 126, 0.25)
+	wantCues := []db.CuePoint{{Name: "Vocal", Time: 45, Pad: 3, Color: "#0000FF"}}
+	if got := storedCues(t, trackID(t, two)); !reflect.DeepEqual(got, wantCues) {
+		t.Errorf("cues of the second track = %+v, want %+v", got, wantCues)
+	}
+	if got := storedPlaylists(t); !reflect.DeepEqual(got, playlists) {
+		t.Errorf("playlists = %+v, want %+v", got, playlists)
+	}
+}
+
+func TestRekordboxLocations(t *testing.T) {
+	for _, path := range []string{"/Users/dj/Music/A Track.mp3", "C:/Music/B#1.mp3", "/tmp/100%.wav", "/Música/ñ.flac"} {
+		location := rekordboxLocation(path)
+		if !strings.HasPrefix(location, rekordboxLocationPrefix+"/") {
+			t.Errorf("rekordboxLocation(%q) = %q", path, location)
+		}
+		got, err := rekordboxPath(location)
+		if err != nil || got != path {
+			t.Errorf("rekordboxPath(%q) = %q, %v, want %q", location, got, err, path)
+		}
+	}
+	if _, err := rekordboxPath("/Users/dj/Music/no-scheme.mp3"); err == nil {
+		t.Error("rekordboxPath() accepted a location without a file scheme")
+	}
+}
+
+func TestImportRekordboxRejectsInvalidXML(t *testing.T) {
+	openLibrary(t)
+	if _, err := ImportRekordbox(strings.NewReader("<DJ_PLAYLISTS><COLLECTION>"), Options{}); err == nil {
+		t.Error("ImportRekordbox() accepted truncated XML")
+	}
+}
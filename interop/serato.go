@@ -0,0 +1,351 @@
+package interop
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"megajam/db"
+	"megajam/logger"
+)
+
+// seratoFolder is the folder Serato keeps its library in, at the root of each drive and in the
+// user's Music folder.
+const seratoFolder = "_Serato_"
+
+// seratoSubcrates is the folder of crate files inside the Serato folder.
+const seratoSubcrates = "Subcrates"
+
+// seratoSubcrateSeparator joins the names of a subcrate and its parents in a crate file name.
+const seratoSubcrateSeparator = "%%"
+
+// seratoCrateVersion is written at the start of every crate file.
+const seratoCrateVersion = "1.0/Serato ScratchLive Crate"
+
+// seratoLibrary is a Serato folder and the folder its track paths are relative to.
+type seratoLibrary struct {
+	dir    string // The _Serato_ folder
+	root   string
+	system bool // Set for the library in the user's Music folder, whose paths start at the root of the system drive
+}
+
+// openSeratoLibrary locates the Serato folder at dir, which may also be the drive or Music folder
+// holding it.
+func openSeratoLibrary(dir string) seratoLibrary {
+	if filepath.Base(dir) != seratoFolder {
+		if info, err := os.Stat(filepath.Join(dir, seratoFolder)); err == nil && info.IsDir() {
+			dir = filepath.Join(dir, seratoFolder)
+		}
+	}
+	parent := filepath.Dir(dir)
+	if home, err := os.UserHomeDir(); err == nil && parent == filepath.Join(home, "Music") {
+		return seratoLibrary{dir: dir, root: filepath.VolumeName(parent) + string(filepath.Separator), system: true}
+	}
+	return seratoLibrary{dir: dir, root: parent}
+}
+
+// localPath turns a track path from a crate into the path of the file on this computer.
+func (l seratoLibrary) localPath(path string, opts Options) string {
+	if l.system {
+		return filepath.Join(l.root, opts.localPath("/"+path))
+	}
+	return filepath.Join(l.root, filepath.FromSlash(path))
+}
+
+// cratePath turns the path of a file on this computer into a track path for a crate. Tracks
+// outside the drive of a drive library cannot be reached by Serato, so ok is false for them.
+func (l seratoLibrary) cratePath(path string, opts Options) (string, bool) {
+	if l.system {
+		path = opts.foreignPath(path)
+		return strings.TrimPrefix(path[len(filepath.VolumeName(path)):], "/"), true
+	}
+	rel, err := filepath.Rel(l.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// ImportSerato reads the crates of the Serato library at dir into the library, along with the
+// hot cues and saved loops Serato stored in the tags of their tracks. Subcrates are named after
+// their parents, as in "Gigs/Friday".
+func ImportSerato(dir string, opts Options) (*Report, error) {
+	library := openSeratoLibrary(dir)
+	files, err := filepath.Glob(filepath.Join(library.dir, seratoSubcrates, "*.crate"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Serato crates found in %s", dir)
+	}
+	sort.Strings(files)
+
+	report := &Report{}
+	byPath := make(map[string]uint) // Library track IDs by crate path
+	unmatched := make(map[string]bool)
+	for _, file := range files {
+		paths, err := readSeratoCrate(file)
+		if err != nil {
+			return report, err
+		}
+		var trackIDs []uint
+		for _, path := range paths {
+			if id, ok := byPath[path]; ok {
+				trackIDs = append(trackIDs, id)
+				continue
+			}
+			if unmatched[path] {
+				continue
+			}
+			track, err := matchTrack(library.localPath(path, opts), report)
+			if err != nil {
+				return report, err
+			}
+			if track == nil {
+				unmatched[path] = true
+				report.Unmatched = append(report.Unmatched, path)
+				continue
+			}
+			byPath[path] = track.ID
+			trackIDs = append(trackIDs, track.ID)
+			if err := importSeratoMarkers(track, report); err != nil {
+				return report, err
+			}
+		}
+
+		name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		name = strings.ReplaceAll(name, seratoSubcrateSeparator, "/")
+		if _, err := db.SaveCrate(name, trackIDs); err != nil {
+			return report, fmt.Errorf("failed to store crate '%s': %w", name, err)
+		}
+		report.Playlists++
+	}
+	logger.Logger.Printf("Imported Serato library: %s", report)
+	return report, nil
+}
+
+// importSeratoMarkers stores the hot cues and saved loops in the Markers2 tag of a track. Files
+// without an ID3 tag Serato can write to are skipped.
+func importSeratoMarkers(track *db.Track, report *Report) error {
+	if !strings.EqualFold(filepath.Ext(track.Path), ".mp3") {
+		return nil
+	}
+	tag, err := readID3(track.Path)
+	if err != nil {
+		logger.Logger.Printf("Serato markers of %s skipped: %v", track.Path, err)
+		return nil
+	}
+	frame, ok := tag.geob(seratoMarkersDescription)
+	if !ok {
+		return nil
+	}
+	entries, err := decodeSeratoMarkers(frame)
+	if err != nil {
+		logger.Logger.Printf("Serato markers of %s skipped: %v", track.Path, err)
+		return nil
+	}
+	cues, loops := seratoMarkers(entries)
+	if err := mergeCuePoints(track.ID, cues, report); err != nil {
+		return err
+	}
+	return mergeLoops(track.ID, loops, report)
+}
+
+// ExportSerato writes every crate to the Serato library at dir, and the hot cues and loops of
+// each MP3 track Serato can reach from there to its Markers2 tag.
+func ExportSerato(dir string, opts Options) (*Report, error) {
+	library := openSeratoLibrary(dir)
+	if filepath.Base(library.dir) != seratoFolder {
+		library = openSeratoLibrary(filepath.Join(dir, seratoFolder))
+	}
+	if err := os.MkdirAll(filepath.Join(library.dir, seratoSubcrates), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create Serato crates folder: %w", err)
+	}
+
+	report := &Report{}
+	exported := make(map[uint]bool)
+	crates, err := db.GetCrates()
+	if err != nil {
+		return nil, err
+	}
+	for _, crate := range crates {
+		var paths []string
+		for _, track := range crate.Tracks {
+			path, ok := library.cratePath(track.Path, opts)
+			if !ok {
+				report.Unmatched = append(report.Unmatched, track.Path)
+				continue
+			}
+			paths = append(paths, path)
+			if !exported[track.ID] {
+				exported[track.ID] = true
+				report.Tracks++
+			}
+		}
+		name := strings.ReplaceAll(crate.Name, "/", seratoSubcrateSeparator)
+		if err := writeSeratoCrate(filepath.Join(library.dir, seratoSubcrates, name+".crate"), paths); err != nil {
+			return report, err
+		}
+		report.Playlists++
+	}
+
+	tracks, err := allTracks()
+	if err != nil {
+		return report, err
+	}
+	for _, track := range tracks {
+		if _, ok := library.cratePath(track.Path, opts); !ok || !strings.EqualFold(filepath.Ext(track.Path), ".mp3") {
+			continue
+		}
+		written, err := exportSeratoMarkers(track, report)
+		if err != nil {
+			logger.Logger.Printf("Serato markers of %s not written: %v", track.Path, err)
+			continue
+		}
+		if written && !exported[track.ID] {
+			exported[track.ID] = true
+			report.Tracks++
+		}
+	}
+	logger.Logger.Printf("Exported Serato library: %s", report)
+	return report, nil
+}
+
+// exportSeratoMarkers writes the hot cues and loops of a track to its Markers2 tag, keeping the
+// track colour and other entries Serato stored there. It reports whether the track has markers;
+// the file is left alone when the tag would not change.
+func exportSeratoMarkers(track db.Track, report *Report) (bool, error) {
+	cues, err := db.GetCuePoints(track.ID)
+	if err != nil {
+		return false, err
+	}
+	loops, err := db.GetLoops(track.ID)
+	if err != nil {
+		return false, err
+	}
+	if len(cues) == 0 && len(loops) == 0 {
+		return false, nil
+	}
+
+	tag, err := readID3(track.Path)
+	if err != nil {
+		return false, err
+	}
+	var entries []seratoEntry
+	frame, found := tag.geob(seratoMarkersDescription)
+	if found {
+		if entries, err = decodeSeratoMarkers(frame); err != nil {
+			return false, err
+		}
+	}
+	entries = setSeratoMarkers(entries, cues, loops)
+	for _, entry := range entries {
+		switch entry.name {
+		case "CUE":
+			report.Cues++
+		case "LOOP":
+			report.Loops++
+		}
+	}
+
+	updated := encodeSeratoMarkers(entries)
+	if found && bytes.Equal(frame, updated) {
+		return true, nil
+	}
+	tag.setGEOB(seratoMarkersDescription, updated)
+	return true, tag.write(track.Path)
+}
+
+// readSeratoCrate returns the track paths of a crate file. A crate is a list of fields, each a
+// four letter name, a big-endian length and data; every "otrk" field holds the "ptrk" field of
+// a track, whose path is UTF-16 text.
+func readSeratoCrate(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read crate: %w", err)
+	}
+	fields, err := seratoFields(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read crate %s: %w", filepath.Base(path), err)
+	}
+	var paths []string
+	for _, field := range fields {
+		if field.name != "otrk" {
+			continue
+		}
+		track, err := seratoFields(field.data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read crate %s: %w", filepath.Base(path), err)
+		}
+		for _, inner := range track {
+			if inner.name == "ptrk" {
+				paths = append(paths, decodeUTF16(inner.data, true))
+			}
+		}
+	}
+	return paths, nil
+}
+
+// writeSeratoCrate writes a crate file listing paths, showing the song and artist columns.
+func writeSeratoCrate(path string, paths []string) error {
+	var b bytes.Buffer
+	writeSeratoField(&b, "vrsn", seratoText(seratoCrateVersion))
+	var order bytes.Buffer
+	writeSeratoField(&order, "tvcn", seratoText("song"))
+	writeSeratoField(&order, "brev", []byte{0})
+	writeSeratoField(&b, "osrt", order.Bytes())
+	for _, column := range []string{"song", "artist"} {
+		var c bytes.Buffer
+		writeSeratoField(&c, "tvcn", seratoText(column))
+		writeSeratoField(&c, "tvcw", seratoText("0"))
+		writeSeratoField(&b, "ovct", c.Bytes())
+	}
+	for _, track := range paths {
+		var t bytes.Buffer
+		writeSeratoField(&t, "ptrk", seratoText(track))
+		writeSeratoField(&b, "otrk", t.Bytes())
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write crate: %w", err)
+	}
+	return nil
+}
+
+// seratoFields splits crate data into its fields, which have the same name and data as the
+// entries of a Markers2 payload.
+func seratoFields(data []byte) ([]seratoEntry, error) {
+	var fields []seratoEntry
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("truncated field")
+		}
+		size := int(binary.BigEndian.Uint32(data[4:8]))
+		if size > len(data)-8 {
+			return nil, fmt.Errorf("truncated field %q", data[:4])
+		}
+		fields = append(fields, seratoEntry{name: string(data[:4]), data: data[8 : 8+size]})
+		data = data[8+size:]
+	}
+	return fields, nil
+}
+
+func writeSeratoField(b *bytes.Buffer, name string, data []byte) {
+	b.WriteString(name)
+	binary.Write(b, binary.BigEndian, uint32(len(data)))
+	b.Write(data)
+}
+
+// seratoText encodes text as big-endian UTF-16 without a terminator.
+func seratoText(text string) []byte {
+	units := utf16.Encode([]rune(text))
+	b := make([]byte, 2*len(units))
+	for i, unit := range units {
+		binary.BigEndian.PutUint16(b[2*i:], unit)
+	}
+	return b
+}
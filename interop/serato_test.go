@@ -0,0 +1,268 @@
+package interop
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"megajam/db"
+)
+
+func TestSeratoCrateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	tests := [][]string{
+		nil,
+		{"Music/One.mp3"},
+		{"Music/Música/ñandú.flac", "Sets/🎵 Two.mp3", "Music/One.mp3", "Music/One.mp3"},
+	}
+	for _, paths := range tests {
+		path := filepath.Join(dir, "Crate.crate")
+		if err := writeSeratoCrate(path, paths); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, []byte("vrsn")) {
+			t.Errorf("crate starts with %q, want the version field", data[:4])
+		}
+		got, err := readSeratoCrate(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, paths) {
+			t.Errorf("read back %q, want %q", got, paths)
+		}
+	}
+}
+
+func TestReadSeratoCrateRejectsTruncatedFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Crate.crate")
+	if err := writeSeratoCrate(path, []string{"Music/One.mp3"}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	for _, size := range []int{len(data) - 1, len(data) - 20, 6} {
+		if err := os.WriteFile(path, data[:size], 0644); err != nil {
+			t.Fatal(err)
+		}
+		if paths, err := readSeratoCrate(path); err == nil {
+			t.Errorf("crate cut to %d bytes read as %q", size, paths)
+		}
+	}
+}
+
+func TestSeratoLibraryPaths(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	opts := Options{Mappings: []PathMapping{{From: "/Users/dj", To: filepath.FromSlash("/home/dj")}}}
+
+	// The library in the Music folder reaches every file from the root of the system drive.
+	if err := os.MkdirAll(filepath.Join(home, "Music", seratoFolder), 0755); err != nil {
+		t.Fatal(err)
+	}
+	system := openSeratoLibrary(filepath.Join(home, "Music"))
+	if !system.system || system.dir != filepath.Join(home, "Music", seratoFolder) {
+		t.Fatalf("library in the Music folder = %+v", system)
+	}
+	local := filepath.FromSlash("/home/dj/Music/One.mp3")
+	path, ok := system.cratePath(local, opts)
+	if !ok || path != "Users/dj/Music/One.mp3" {
+		t.Errorf("cratePath() = %q, %t", path, ok)
+	}
+	if got := system.localPath(path, opts); got != local {
+		t.Errorf("localPath(%q) = %q, want %q", path, got, local)
+	}
+
+	// A drive library reaches only the files on its drive, relative to the drive.
+	drive := t.TempDir()
+	library := openSeratoLibrary(filepath.Join(drive, seratoFolder))
+	if library.system || library.root != drive {
+		t.Fatalf("library on a drive = %+v", library)
+	}
+	local = filepath.Join(drive, "Sets", "Two.mp3")
+	if path, ok := library.cratePath(local, opts); !ok || path != "Sets/Two.mp3" {
+		t.Errorf("cratePath() = %q, %t", path, ok)
+	} else if got := library.localPath(path, opts); got != local {
+		t.Errorf("localPath(%q) = %q, want %q", path, got, local)
+	}
+	if path, ok := library.cratePath(filepath.Join(home, "Three.mp3"), opts); ok {
+		t.Errorf("cratePath() of a file on another drive = %q", path)
+	}
+}
+
+func TestSeratoRoundTrip(t *testing.T) {
+	openLibrary(t)
+	drive := t.TempDir()
+	audio := testAudio()
+	// Serato's own entries in an existing Markers2 tag are kept when the markers are replaced.
+	trackColor := seratoEntry{name: "COLOR", data: []byte{0, 0xFF, 0x99, 0xFF}}
+	bpmLock := seratoEntry{name: "BPMLOCK", data: []byte{1}}
+	oldMarkers := encodeSeratoMarkers([]seratoEntry{trackColor, {name: "CUE", data: []byte("stale")}, bpmLock})
+
+	roomy := writeMP3(t, drive, "Music/Roomy.mp3", buildID3(id3Options{version: 3, padding: 4000},
+		[]id3Frame{textFrame("TIT2", "Roomy")}), audio)
+	tight := writeMP3(t, drive, "Music/Tight.mp3", buildID3(id3Options{version: 4, extended: true},
+		[]id3Frame{textFrame("TIT2", "Tight"), geobFrame(seratoMarkersDescription, oldMarkers)}), audio)
+	bare := writeMP3(t, drive, "Sets/Bare.mp3", nil, audio)
+	flac := writeMP3(t, drive, "Music/Lossless.flac", []byte("fLaC"), audio)
+	outside := writeMP3(t, t.TempDir(), "Outside.mp3", nil, audio)
+	paths := []string{roomy, tight, bare, flac, outside}
+	for _, path := range paths {
+		addTrack(t, db.Track{Path: path})
+	}
+	roomySize := int64(len(buildID3(id3Options{version: 3, padding: 4000}, []id3Frame{textFrame("TIT2", "Roomy")})) + len(audio))
+
+	addMarkers(t, trackID(t, roomy), []db.CuePoint{
+		{Name: "Memory", Time: 2},
+		{Name: "Drop", Time: 64.123, Pad: 1, Color: "#28E214"},
+		{Time: 120.5, Pad: 8},
+	}, []db.Loop{
+		{Name: "Intro", Start: 0.25, End: 8.25, Color: "#FF8C00"},
+		{Start: 32, End: 40.5},
+	})
+	addMarkers(t, trackID(t, tight), []db.CuePoint{{Name: "Vocal", Time: 45, Pad: 3, Color: "#0000FF"}}, nil)
+	addMarkers(t, trackID(t, bare), nil, []db.Loop{{Name: "Outro", Start: 180, End: 196, Color: "#FF0000"}})
+	addMarkers(t, trackID(t, flac), []db.CuePoint{{Time: 1, Pad: 1}}, nil)
+	crates := []struct {
+		name  string
+		paths []string
+	}{
+		{"Gigs", []string{tight, roomy, outside, flac}},
+		{"Gigs/Friday", []string{bare}},
+		{"Warm up", []string{roomy}},
+	}
+	for _, crate := range crates {
+		var ids []uint
+		for _, path := range crate.paths {
+			ids = append(ids, trackID(t, path))
+		}
+		if _, err := db.SaveCrate(crate.name, ids); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := ExportSerato(drive, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Report{Tracks: 4, Cues: 3, Loops: 3, Playlists: 3, Unmatched: []string{outside}}
+	if !reflect.DeepEqual(*report, want) {
+		t.Errorf("export report = %+v, want %+v", *report, want)
+	}
+	subcrates := filepath.Join(drive, seratoFolder, seratoSubcrates)
+	got, err := readSeratoCrate(filepath.Join(subcrates, "Gigs.crate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Music/Tight.mp3", "Music/Roomy.mp3", "Music/Lossless.flac"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Gigs crate = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(subcrates, "Gigs%%Friday.crate")); err != nil {
+		t.Errorf("subcrate not written: %v", err)
+	}
+
+	// The roomy tag is updated in place and the others rewritten; every file keeps its audio.
+	if info, _ := os.Stat(roomy); info.Size() != roomySize {
+		t.Errorf("roomy file went from %d to %d bytes", roomySize, info.Size())
+	}
+	for _, path := range []string{roomy, tight, bare} {
+		tag := checkAudio(t, path, audio)
+		if _, ok := tag.geob(seratoMarkersDescription); !ok {
+			t.Errorf("%s has no Markers2 tag", filepath.Base(path))
+		}
+	}
+	if data, _ := os.ReadFile(flac); !bytes.Equal(data, append([]byte("fLaC"), audio...)) {
+		t.Error("FLAC file was changed")
+	}
+	tag := checkAudio(t, tight, audio)
+	frame, _ := tag.geob(seratoMarkersDescription)
+	entries, err := decodeSeratoMarkers(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.version != 4 || len(entries) != 3 || !reflect.DeepEqual(entries[:2], []seratoEntry{trackColor, bpmLock}) {
+		t.Errorf("tight tag = version %d with entries %+v", tag.version, entries)
+	}
+	if title := tag.frames[0]; title.id != "TIT2" || string(title.data) != "\x00Tight" {
+		t.Errorf("first frame = %+v, want the title", title)
+	}
+
+	// Exporting again leaves the files alone.
+	before, _ := os.ReadFile(tight)
+	if _, err := ExportSerato(drive, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(tight); !bytes.Equal(before, after) {
+		t.Error("second export rewrote an unchanged tag")
+	}
+
+	openLibrary(t)
+	for i := len(paths) - 1; i >= 0; i-- {
+		addTrack(t, db.Track{Path: paths[i]})
+	}
+	report, err = ImportSerato(filepath.Join(drive, seratoFolder), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Report{Tracks: 4, Cues: 3, Loops: 3, Playlists: 3}); !reflect.DeepEqual(*report, want) {
+		t.Errorf("import report = %+v, want %+v", *report, want)
+	}
+	// Serato has no memory cues, and gives hot cues and loops without a colour its defaults.
+	wantCues := []db.CuePoint{
+		{Name: "Drop", Time: 64.123, Pad: 1, Color: "#28E214"},
+		{Time: 120.5, Pad: 8, Color: seratoCueColor},
+	}
+	if got := storedCues(t, trackID(t, roomy)); !reflect.DeepEqual(got, wantCues) {
+		t.Errorf("cues = %+v, want %+v", got, wantCues)
+	}
+	wantLoops := []db.Loop{
+		{Name: "Intro", Start: 0.25, End: 8.25, Color: "#FF8C00"},
+		{Start: 32, End: 40.5, Color: seratoLoopColor},
+	}
+	if got := storedLoops(t, trackID(t, roomy)); !reflect.DeepEqual(got, wantLoops) {
+		t.Errorf("loops = %+v, want %+v", got, wantLoops)
+	}
+	wantCues = []db.CuePoint{{Name: "Vocal", Time: 45, Pad: 3, Color: "#0000FF"}}
+	if got := storedCues(t, trackID(t, tight)); !reflect.DeepEqual(got, wantCues) {
+		t.Errorf("cues of the tight track = %+v, want %+v", got, wantCues)
+	}
+	wantLoops = []db.Loop{{Name: "Outro", Start: 180, End: 196, Color: "#FF0000"}}
+	if got := storedLoops(t, trackID(t, bare)); !reflect.DeepEqual(got, wantLoops) {
+		t.Errorf("loops of the untagged track = %+v, want %+v", got, wantLoops)
+	}
+	if got := storedCues(t, trackID(t, flac)); got != nil {
+		t.Errorf("cues of the FLAC track = %+v", got)
+	}
+
+	stored, err := db.GetCrates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotCrates []playlistSummary
+	for _, crate := range stored {
+		summary := playlistSummary{Name: crate.Name}
+		for _, track := range crate.Tracks {
+			summary.Paths = append(summary.Paths, track.Path)
+		}
+		gotCrates = append(gotCrates, summary)
+	}
+	wantCrates := []playlistSummary{
+		{Name: "Gigs", Paths: []string{tight, roomy, flac}},
+		{Name: "Gigs/Friday", Paths: []string{bare}},
+		{Name: "Warm up", Paths: []string{roomy}},
+	}
+	if !reflect.DeepEqual(gotCrates, wantCrates) {
+		t.Errorf("crates = %+v, want %+v", gotCrates, wantCrates)
+	}
+}
+
+func TestImportSeratoWithoutCrates(t *testing.T) {
+	openLibrary(t)
+	if _, err := ImportSerato(t.TempDir(), Options{}); err == nil {
+		t.Error("ImportSerato() succeeded without crates")
+	}
+}
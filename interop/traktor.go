@@ -0,0 +1,412 @@
+package interop
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"megajam/db"
+	"megajam/library"
+	"megajam/logger"
+)
+
+// Traktor cue types.
+const (
+	traktorCue     = 0
+	traktorFadeIn  = 1
+	traktorFadeOut = 2
+	traktorLoad    = 3
+	traktorGrid    = 4
+	traktorLoop    = 5
+)
+
+// traktorColors are the colours Traktor shows for each cue type, which it stores instead of a
+// colour per cue.
+var traktorColors = map[int]string{
+	traktorCue:     "#0A7BD1",
+	traktorFadeIn:  "#E0841A",
+	traktorFadeOut: "#E0841A",
+	traktorLoad:    "#E8D11B",
+	traktorLoop:    "#3BB143",
+}
+
+// traktorUnnamed is the name Traktor gives cues the user did not name.
+const traktorUnnamed = "n.n."
+
+// traktorSystemVolume is the volume written for paths outside /Volumes, as Traktor names the
+// start-up disk of a Mac.
+const traktorSystemVolume = "Macintosh HD"
+
+// traktorLibrary is the NML document of a Traktor collection.
+type traktorLibrary struct {
+	XMLName    xml.Name          `xml:"NML"`
+	Version    string            `xml:"VERSION,attr"`
+	Head       traktorHead       `xml:"HEAD"`
+	Collection traktorCollection `xml:"COLLECTION"`
+	Playlists  *traktorNode      `xml:"PLAYLISTS>NODE"` // The $ROOT folder
+}
+
+type traktorHead struct {
+	Company string `xml:"COMPANY,attr"`
+	Program string `xml:"PROGRAM,attr"`
+}
+
+type traktorCollection struct {
+	Entries int            `xml:"ENTRIES,attr"`
+	Tracks  []traktorEntry `xml:"ENTRY"`
+}
+
+type traktorEntry struct {
+	Title    string          `xml:"TITLE,attr,omitempty"`
+	Artist   string          `xml:"ARTIST,attr,omitempty"`
+	Location traktorLocation `xml:"LOCATION"`
+	Album    *traktorAlbum   `xml:"ALBUM"`
+	Info     *traktorInfo    `xml:"INFO"`
+	Tempo    *traktorTempo   `xml:"TEMPO"`
+	Cues     []traktorCueV2  `xml:"CUE_V2"`
+}
+
+// traktorLocation names a file by its volume, its folder with each name preceded by "/:" and
+// its file name, as in DIR="/:Users/:dj/:Music/:" FILE="track.mp3" VOLUME="Macintosh HD".
+type traktorLocation struct {
+	Dir    string `xml:"DIR,attr"`
+	File   string `xml:"FILE,attr"`
+	Volume string `xml:"VOLUME,attr"`
+}
+
+type traktorAlbum struct {
+	Title string `xml:"TITLE,attr"`
+}
+
+type traktorInfo struct {
+	Playtime int   `xml:"PLAYTIME,attr,omitempty"` // Seconds
+	FileSize int64 `xml:"FILESIZE,attr,omitempty"` // Kilobytes
+}
+
+type traktorTempo struct {
+	BPM        float64 `xml:"BPM,attr"`
+	BPMQuality float64 `xml:"BPM_QUALITY,attr"`
+}
+
+// traktorCueV2 is a cue, fade or load marker, grid marker or loop. Times are in milliseconds.
+type traktorCueV2 struct {
+	Name         string  `xml:"NAME,attr"`
+	DisplayOrder int     `xml:"DISPL_ORDER,attr"`
+	Type         int     `xml:"TYPE,attr"`
+	Start        float64 `xml:"START,attr"`
+	Length       float64 `xml:"LEN,attr"`
+	Repeats      int     `xml:"REPEATS,attr"`
+	Hotcue       int     `xml:"HOTCUE,attr"` // Hot cue from 0, or -1 for none
+}
+
+// traktorNode is a playlist folder (TYPE="FOLDER") or a playlist (TYPE="PLAYLIST").
+type traktorNode struct {
+	Type     string           `xml:"TYPE,attr"`
+	Name     string           `xml:"NAME,attr"`
+	Subnodes *traktorSubnodes `xml:"SUBNODES"`
+	Playlist *traktorPlaylist `xml:"PLAYLIST"`
+}
+
+type traktorSubnodes struct {
+	Count int           `xml:"COUNT,attr"`
+	Nodes []traktorNode `xml:"NODE"`
+}
+
+type traktorPlaylist struct {
+	Entries int                    `xml:"ENTRIES,attr"`
+	Type    string                 `xml:"TYPE,attr"`
+	UUID    string                 `xml:"UUID,attr"`
+	Tracks  []traktorPlaylistEntry `xml:"ENTRY"`
+}
+
+type traktorPlaylistEntry struct {
+	Key traktorPrimaryKey `xml:"PRIMARYKEY"`
+}
+
+// traktorPrimaryKey refers to a collection entry by its volume, folder and file name joined.
+type traktorPrimaryKey struct {
+	Type string `xml:"TYPE,attr"`
+	Key  string `xml:"KEY,attr"`
+}
+
+// ImportTraktor reads a Traktor collection NML into the library: tracks are matched by file,
+// and their beatgrids, cues, loops and the playlist tree are stored alongside.
+func ImportTraktor(r io.Reader, opts Options) (*Report, error) {
+	var doc traktorLibrary
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse Traktor NML: %w", err)
+	}
+
+	report := &Report{}
+	byKey := make(map[string]uint) // Library track IDs by primary key
+	for _, entry := range doc.Collection.Tracks {
+		var track *db.Track
+		for _, candidate := range entry.Location.paths() {
+			var err error
+			if track, err = matchTrack(opts.localPath(candidate), report); err != nil {
+				return report, err
+			}
+			if track != nil {
+				break
+			}
+		}
+		if track == nil {
+			report.Unmatched = append(report.Unmatched, entry.Location.paths()[0])
+			continue
+		}
+		byKey[entry.Location.key()] = track.ID
+		if err := importTraktorEntry(track.ID, entry, report); err != nil {
+			return report, err
+		}
+	}
+
+	if doc.Playlists != nil && doc.Playlists.Subnodes != nil {
+		for _, node := range doc.Playlists.Subnodes.Nodes {
+			if err := importTraktorNode(node, "", byKey, report); err != nil {
+				return report, err
+			}
+		}
+	}
+	logger.Logger.Printf("Imported Traktor collection: %s", report)
+	return report, nil
+}
+
+// importTraktorEntry stores the beatgrid, cues and loops of a collection entry.
+func importTraktorEntry(trackID uint, entry traktorEntry, report *Report) error {
+	var cues []db.CuePoint
+	var loops []db.Loop
+	gridStored := false
+	for _, cue := range entry.Cues {
+		name := cue.Name
+		if name == traktorUnnamed {
+			name = ""
+		}
+		start := cue.Start / 1000
+		switch {
+		case cue.Type == traktorGrid:
+			// Traktor stores one tempo per track; the first grid marker places the downbeats.
+			if entry.Tempo != nil && !gridStored {
+				if err := saveBeatgrid(trackID, entry.Tempo.BPM, start, report); err != nil {
+					return err
+				}
+				gridStored = true
+			}
+		case cue.Type == traktorLoop && cue.Length > 0:
+			loops = append(loops, db.Loop{Name: name, Start: start, End: start + cue.Length/1000, Color: traktorColors[traktorLoop]})
+		default:
+			point := db.CuePoint{Name: name, Time: start, Color: traktorColors[cue.Type]}
+			if cue.Hotcue >= 0 && cue.Hotcue < db.HotCuePads {
+				point.Pad = cue.Hotcue + 1
+			}
+			cues = append(cues, point)
+		}
+	}
+	if err := mergeCuePoints(trackID, cues, report); err != nil {
+		return err
+	}
+	return mergeLoops(trackID, loops, report)
+}
+
+// importTraktorNode stores the playlists in node, which is held by the given folder. Smart
+// playlists are skipped as their tracks are not listed.
+func importTraktorNode(node traktorNode, folder string, byKey map[string]uint, report *Report) error {
+	switch {
+	case node.Type == "FOLDER" && node.Subnodes != nil:
+		if folder != "" {
+			folder += "/"
+		}
+		folder += strings.ReplaceAll(node.Name, "/", "-")
+		for _, child := range node.Subnodes.Nodes {
+			if err := importTraktorNode(child, folder, byKey, report); err != nil {
+				return err
+			}
+		}
+	case node.Type == "PLAYLIST" && node.Playlist != nil:
+		var trackIDs []uint
+		for _, entry := range node.Playlist.Tracks {
+			if id, ok := byKey[entry.Key.Key]; ok {
+				trackIDs = append(trackIDs, id)
+			}
+		}
+		if _, err := db.SavePlaylist(folder, node.Name, trackIDs); err != nil {
+			return fmt.Errorf("failed to store playlist '%s': %w", node.Name, err)
+		}
+		report.Playlists++
+	}
+	return nil
+}
+
+// ExportTraktor writes the whole library, with beatgrids, cues, loops and playlists, as a
+// Traktor collection NML.
+func ExportTraktor(w io.Writer, opts Options) (*Report, error) {
+	tracks, err := allTracks()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	doc := traktorLibrary{
+		Version: "19",
+		Head:    traktorHead{Company: "www.native-instruments.com", Program: "Traktor"},
+	}
+	keys := make(map[uint]string, len(tracks)) // Primary keys by library track ID
+	for _, track := range tracks {
+		entry, err := exportTraktorEntry(track, opts, report)
+		if err != nil {
+			return report, err
+		}
+		doc.Collection.Tracks = append(doc.Collection.Tracks, entry)
+		keys[track.ID] = entry.Location.key()
+	}
+	doc.Collection.Entries = len(doc.Collection.Tracks)
+
+	playlists, err := db.GetPlaylists()
+	if err != nil {
+		return report, err
+	}
+	root := traktorFolder("$ROOT")
+	for _, playlist := range playlists {
+		parent := root
+		for _, name := range splitFolder(playlist.Folder) {
+			parent = parent.folder(name)
+		}
+		list := &traktorPlaylist{Entries: len(playlist.Tracks), Type: "LIST", UUID: traktorUUID()}
+		for _, track := range playlist.Tracks {
+			list.Tracks = append(list.Tracks, traktorPlaylistEntry{Key: traktorPrimaryKey{Type: "TRACK", Key: keys[track.ID]}})
+		}
+		parent.Subnodes.Nodes = append(parent.Subnodes.Nodes, traktorNode{Type: "PLAYLIST", Name: playlist.Name, Playlist: list})
+		parent.Subnodes.Count = len(parent.Subnodes.Nodes)
+		report.Playlists++
+	}
+	doc.Playlists = root
+
+	if _, err := io.WriteString(w, `<?xml version="1.0" encoding="UTF-8" standalone="no" ?>`+"\n"); err != nil {
+		return report, err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return report, fmt.Errorf("failed to write Traktor NML: %w", err)
+	}
+	logger.Logger.Printf("Exported Traktor collection: %s", report)
+	return report, nil
+}
+
+// exportTraktorEntry builds the collection entry of a library track. Cues keep their pad;
+// Traktor has no colour per cue, so colours are not written.
+func exportTraktorEntry(track db.Track, opts Options, report *Report) (traktorEntry, error) {
+	entry := traktorEntry{
+		Title:    track.Title,
+		Artist:   track.Artist,
+		Location: newTraktorLocation(opts.foreignPath(track.Path)),
+		Info:     &traktorInfo{FileSize: track.FileSize / 1024},
+	}
+	if track.Album != "" {
+		entry.Album = &traktorAlbum{Title: track.Album}
+	}
+	if duration, err := library.ParseDuration(track.Duration); err == nil {
+		entry.Info.Playtime = int(duration.Seconds())
+	}
+	if track.BPM > 0 {
+		entry.Tempo = &traktorTempo{BPM: track.BPM, BPMQuality: 100}
+	}
+	report.Tracks++
+
+	grid, err := db.GetBeatgrid(track.ID)
+	if err != nil {
+		return entry, err
+	}
+	if grid != nil {
+		entry.Tempo = &traktorTempo{BPM: grid.BPM, BPMQuality: 100}
+		entry.Cues = append(entry.Cues, traktorCueV2{Name: "Beat Marker", Type: traktorGrid, Start: grid.Offset * 1000, Repeats: -1, Hotcue: -1})
+		report.Beatgrids++
+	}
+
+	cues, err := db.GetCuePoints(track.ID)
+	if err != nil {
+		return entry, err
+	}
+	for _, cue := range cues {
+		name := cue.Name
+		if name == "" {
+			name = traktorUnnamed
+		}
+		entry.Cues = append(entry.Cues, traktorCueV2{Name: name, Type: traktorCue, Start: cue.Time * 1000, Repeats: -1, Hotcue: cue.Pad - 1})
+		report.Cues++
+	}
+	loops, err := db.GetLoops(track.ID)
+	if err != nil {
+		return entry, err
+	}
+	for _, loop := range loops {
+		name := loop.Name
+		if name == "" {
+			name = traktorUnnamed
+		}
+		entry.Cues = append(entry.Cues, traktorCueV2{Name: name, Type: traktorLoop, Start: loop.Start * 1000,
+			Length: (loop.End - loop.Start) * 1000, Repeats: -1, Hotcue: -1})
+		report.Loops++
+	}
+	return entry, nil
+}
+
+// newTraktorLocation splits a "/"-separated path into a Traktor location. Windows paths use
+// their drive as the volume, and Mac paths under /Volumes the name of the disk.
+func newTraktorLocation(p string) traktorLocation {
+	volume := traktorSystemVolume
+	switch {
+	case len(p) >= 2 && p[1] == ':':
+		volume, p = p[:2], p[2:]
+	case strings.HasPrefix(p, "/Volumes/"):
+		name, rest, _ := strings.Cut(strings.TrimPrefix(p, "/Volumes/"), "/")
+		volume, p = name, "/"+rest
+	}
+	dir, file := path.Split(p)
+	return traktorLocation{Dir: strings.ReplaceAll(dir, "/", "/:"), File: file, Volume: volume}
+}
+
+// paths returns the "/"-separated paths the location may stand for, most likely first. Traktor
+// does not record whether a Mac volume is the start-up disk, so both forms are tried.
+func (l traktorLocation) paths() []string {
+	p := strings.ReplaceAll(l.Dir, "/:", "/") + l.File
+	if len(l.Volume) == 2 && l.Volume[1] == ':' {
+		return []string{l.Volume + p}
+	}
+	if l.Volume == "" {
+		return []string{p}
+	}
+	return []string{p, "/Volumes/" + l.Volume + p}
+}
+
+// key returns the primary key playlists use to refer to the location's entry.
+func (l traktorLocation) key() string {
+	return l.Volume + l.Dir + l.File
+}
+
+// traktorFolder returns an empty playlist folder node.
+func traktorFolder(name string) *traktorNode {
+	return &traktorNode{Type: "FOLDER", Name: name, Subnodes: &traktorSubnodes{}}
+}
+
+// folder returns the subfolder of n called name, adding it when missing.
+func (n *traktorNode) folder(name string) *traktorNode {
+	for i := range n.Subnodes.Nodes {
+		if n.Subnodes.Nodes[i].Type == "FOLDER" && n.Subnodes.Nodes[i].Name == name {
+			return &n.Subnodes.Nodes[i]
+		}
+	}
+	n.Subnodes.Nodes = append(n.Subnodes.Nodes, *traktorFolder(name))
+	n.Subnodes.Count = len(n.Subnodes.Nodes)
+	return &n.Subnodes.Nodes[len(n.Subnodes.Nodes)-1]
+}
+
+// traktorUUID returns a random playlist identifier in Traktor's 32 hex digit form.
+func traktorUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
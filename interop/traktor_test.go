@@ -0,0 +1,218 @@
+package interop
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"megajam/db"
+)
+
+// traktorFixture is a collection as Traktor writes it, with tracks on the start-up disk, an
+// external drive and a Windows drive, every kind of cue, a smart playlist and an entry that
+// matches no file.
+const traktorFixture = `<?xml version="1.0" encoding="UTF-8" standalone="no" ?>
+<NML VERSION="19"><HEAD COMPANY="www.native-instruments.com" PROGRAM="Traktor"></HEAD>
+<COLLECTION ENTRIES="4">
+<ENTRY TITLE="First" ARTIST="Someone"><LOCATION DIR="/:Users/:dj/:Music/:" FILE="First.mp3" VOLUME="Macintosh HD" VOLUMEID="Macintosh HD"></LOCATION>
+<ALBUM TITLE="Album"></ALBUM><INFO BITRATE="320000" PLAYTIME="300" FILESIZE="11720"></INFO>
+<TEMPO BPM="124.000000" BPM_QUALITY="100.000000"></TEMPO>
+<CUE_V2 NAME="AutoGrid" DISPL_ORDER="0" TYPE="4" START="350.500000" LEN="0.000000" REPEATS="-1" HOTCUE="0"></CUE_V2>
+<CUE_V2 NAME="Beat Marker" DISPL_ORDER="0" TYPE="4" START="60000.000000" LEN="0.000000" REPEATS="-1" HOTCUE="-1"></CUE_V2>
+<CUE_V2 NAME="n.n." DISPL_ORDER="0" TYPE="0" START="412.000000" LEN="0.000000" REPEATS="-1" HOTCUE="1"></CUE_V2>
+<CUE_V2 NAME="Fade in" DISPL_ORDER="0" TYPE="1" START="1000.000000" LEN="0.000000" REPEATS="-1" HOTCUE="-1"></CUE_V2>
+<CUE_V2 NAME="Load" DISPL_ORDER="0" TYPE="3" START="0.000000" LEN="0.000000" REPEATS="-1" HOTCUE="-1"></CUE_V2>
+<CUE_V2 NAME="Loop" DISPL_ORDER="0" TYPE="5" START="30000.000000" LEN="15483.870968" REPEATS="-1" HOTCUE="3"></CUE_V2>
+<CUE_V2 NAME="Far" DISPL_ORDER="0" TYPE="0" START="90000.000000" LEN="0.000000" REPEATS="-1" HOTCUE="9"></CUE_V2>
+</ENTRY>
+<ENTRY TITLE="Second"><LOCATION DIR="/:Sets/:" FILE="Second.mp3" VOLUME="USB Stick" VOLUMEID="1a2b"></LOCATION></ENTRY>
+<ENTRY TITLE="Third"><LOCATION DIR="/:Music/:" FILE="Third.mp3" VOLUME="C:" VOLUMEID="C:"></LOCATION></ENTRY>
+<ENTRY TITLE="Missing"><LOCATION DIR="/:Users/:dj/:Music/:" FILE="Missing.mp3" VOLUME="Macintosh HD" VOLUMEID="Macintosh HD"></LOCATION></ENTRY>
+</COLLECTION>
+<PLAYLISTS><NODE TYPE="FOLDER" NAME="$ROOT"><SUBNODES COUNT="3">
+<NODE TYPE="FOLDER" NAME="Gigs"><SUBNODES COUNT="2">
+<NODE TYPE="PLAYLIST" NAME="Friday"><PLAYLIST ENTRIES="3" TYPE="LIST" UUID="0f6c1f1c3b6e4f4c9a2d7e5b8c1a2b3c">
+<ENTRY><PRIMARYKEY TYPE="TRACK" KEY="USB Stick/:Sets/:Second.mp3"></PRIMARYKEY></ENTRY>
+<ENTRY><PRIMARYKEY TYPE="TRACK" KEY="Macintosh HD/:Users/:dj/:Music/:Missing.mp3"></PRIMARYKEY></ENTRY>
+<ENTRY><PRIMARYKEY TYPE="TRACK" KEY="Macintosh HD/:Users/:dj/:Music/:First.mp3"></PRIMARYKEY></ENTRY>
+</PLAYLIST></NODE>
+<NODE TYPE="SMARTLIST" NAME="Recent"><SMARTLIST UUID="9d8c7b6a5f4e3d2c1b0a998877665544"><SEARCH_EXPRESSION VERSION="1" QUERY="$IMPORTDATE &gt; 7"></SEARCH_EXPRESSION></SMARTLIST></NODE>
+</SUBNODES></NODE>
+<NODE TYPE="FOLDER" NAME="Old/New"><SUBNODES COUNT="1">
+<NODE TYPE="PLAYLIST" NAME="Windows"><PLAYLIST ENTRIES="1" TYPE="LIST" UUID="11112222333344445555666677778888">
+<ENTRY><PRIMARYKEY TYPE="TRACK" KEY="C:/:Music/:Third.mp3"></PRIMARYKEY></ENTRY>
+</PLAYLIST></NODE>
+</SUBNODES></NODE>
+<NODE TYPE="PLAYLIST" NAME="_RECORDINGS"><PLAYLIST ENTRIES="0" TYPE="LIST" UUID="aaaabbbbccccddddeeeeffff00001111"></PLAYLIST></NODE>
+</SUBNODES></NODE></PLAYLISTS>
+</NML>
+`
+
+func TestImportTraktor(t *testing.T) {
+	openLibrary(t)
+	first := filepath.FromSlash("/Users/dj/Music/First.mp3")
+	second := filepath.FromSlash("/Volumes/USB Stick/Sets/Second.mp3")
+	third := filepath.FromSlash("/home/dj/Music/Third.mp3")
+	firstID := addTrack(t, db.Track{Path: first})
+	for _, path := range []string{second, third} {
+		addTrack(t, db.Track{Path: path})
+	}
+	opts := Options{Mappings: []PathMapping{{From: "C:/Music", To: "/home/dj/Music"}}}
+
+	report, err := ImportTraktor(strings.NewReader(traktorFixture), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Report{Tracks: 3, Cues: 4, Loops: 1, Beatgrids: 1, Playlists: 3, Unmatched: []string{"/Users/dj/Music/Missing.mp3"}}
+	if !reflect.DeepEqual(*report, want) {
+		t.Errorf("report = %+v, want %+v", *report, want)
+	}
+
+	// Only the first grid marker places the grid.
+	checkBeatgrid(t, firstID, 124, 0.3505)
+	wantCues := []db.CuePoint{
+		{Time: 0.412, Pad: 2, Color: traktorColors[traktorCue]},
+		{Name: "Fade in", Time: 1, Color: traktorColors[traktorFadeIn]},
+		{Name: "Load", Time: 0, Color: traktorColors[traktorLoad]},
+		{Name: "Far", Time: 90, Color: traktorColors[traktorCue]},
+	}
+	if got := storedCues(t, firstID); !reflect.DeepEqual(got, wantCues) {
+		t.Errorf("cues = %+v, want %+v", got, wantCues)
+	}
+	wantLoops := []db.Loop{{Name: "Loop", Start: 30, End: 45.484, Color: traktorColors[traktorLoop]}}
+	if got := storedLoops(t, firstID); !reflect.DeepEqual(got, wantLoops) {
+		t.Errorf("loops = %+v, want %+v", got, wantLoops)
+	}
+	wantPlaylists := []playlistSummary{
+		{Name: "_RECORDINGS"},
+		{Folder: "Gigs", Name: "Friday", Paths: []string{second, first}},
+		{Folder: "Old-New", Name: "Windows", Paths: []string{third}},
+	}
+	if got := storedPlaylists(t); !reflect.DeepEqual(got, wantPlaylists) {
+		t.Errorf("playlists = %+v, want %+v", got, wantPlaylists)
+	}
+
+	report, err = ImportTraktor(strings.NewReader(traktorFixture), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Cues != 0 || report.Loops != 0 {
+		t.Errorf("second import stored %d cues and %d loops", report.Cues, report.Loops)
+	}
+}
+
+func TestTraktorRoundTrip(t *testing.T) {
+	openLibrary(t)
+	one := filepath.FromSlash("/Volumes/USB Stick/Sets/One.mp3")
+	two := filepath.FromSlash("/Users/dj/Music/Two & Two.mp3")
+	three := filepath.FromSlash("/home/dj/Music/Three.mp3")
+	tracks := []db.Track{
+		{Path: one, Title: "One", Artist: "Artist", Album: "Album", Duration: "5:04", BPM: 126, FileSize: 12345678},
+		{Path: two, Title: "Two", BPM: 98},
+		{Path: three, Title: "Three"},
+	}
+	for _, track := range tracks {
+		addTrack(t, track)
+	}
+	addMarkers(t, trackID(t, one), []db.CuePoint{
+		{Time: 0.25},
+		{Name: "Drop", Time: 64.123, Pad: 1, Color: "#28E214"},
+		{Name: "Break", Time: 120.5, Pad: 8},
+	}, []db.Loop{
+		{Name: "Intro", Start: 0.25, End: 8.25, Color: "#FF0000"},
+		{Start: 32, End: 40.5},
+	})
+	if err := db.SaveBeatgrid(&db.Beatgrid{TrackID: trackID(t, one), BPM: 126, Offset: 0.25}); err != nil {
+		t.Fatal(err)
+	}
+	playlists := []playlistSummary{
+		{Name: "Warm up", Paths: []string{two, one}},
+		{Folder: "Gigs", Name: "Saturday", Paths: []string{three, two, one}},
+		{Folder: "Gigs/2024", Name: "Friday", Paths: []string{one}},
+	}
+	for _, playlist := range playlists {
+		var ids []uint
+		for _, path := range playlist.Paths {
+			ids = append(ids, trackID(t, path))
+		}
+		if _, err := db.SavePlaylist(playlist.Folder, playlist.Name, ids); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := Options{Mappings: []PathMapping{{From: "C:/Music", To: filepath.FromSlash("/home/dj/Music")}}}
+	var nml bytes.Buffer
+	report, err := ExportTraktor(&nml, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Report{Tracks: 3, Cues: 3, Loops: 2, Beatgrids: 1, Playlists: 3}); !reflect.DeepEqual(*report, want) {
+		t.Errorf("export report = %+v, want %+v", *report, want)
+	}
+	for _, text := range []string{
+		`<LOCATION DIR="/:Sets/:" FILE="One.mp3" VOLUME="USB Stick"></LOCATION>`,
+		`<LOCATION DIR="/:Users/:dj/:Music/:" FILE="Two &amp; Two.mp3" VOLUME="Macintosh HD"></LOCATION>`,
+		`<LOCATION DIR="/:Music/:" FILE="Three.mp3" VOLUME="C:"></LOCATION>`,
+		`<PRIMARYKEY TYPE="TRACK" KEY="USB Stick/:Sets/:One.mp3"></PRIMARYKEY>`,
+		`<INFO PLAYTIME="304" FILESIZE="12056"></INFO>`,
+		`<TEMPO BPM="98" BPM_QUALITY="100"></TEMPO>`,
+	} {
+		if !strings.Contains(nml.String(), text) {
+			t.Errorf("exported NML lacks %s:\n%s", text, nml.String())
+		}
+	}
+
+	openLibrary(t)
+	for i := len(tracks) - 1; i >= 0; i-- {
+		addTrack(t, db.Track{Path: tracks[i].Path})
+	}
+	report, err = ImportTraktor(&nml, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Report{Tracks: 3, Cues: 3, Loops: 2, Beatgrids: 1, Playlists: 3}); !reflect.DeepEqual(*report, want) {
+		t.Errorf("import report = %+v, want %+v", *report, want)
+	}
+	// Traktor keeps no colour per cue, so cues and loops come back in the colours of their type.
+	wantCues := []db.CuePoint{
+		{Time: 0.25, Color: traktorColors[traktorCue]},
+		{Name: "Drop", Time: 64.123, Pad: 1, Color: traktorColors[traktorCue]},
+		{Name: "Break", Time: 120.5, Pad: 8, Color: traktorColors[traktorCue]},
+	}
+	if got := storedCues(t, trackID(t, one)); !reflect.DeepEqual(got, wantCues) {
+		t.Errorf("cues = %+v, want %+v", got, wantCues)
+	}
+	wantLoops := []db.Loop{
+		{Name: "Intro", Start: 0.25, End: 8.25, Color: traktorColors[traktorLoop]},
+		{Start: 32, End: 40.5, Color: traktorColors[traktorLoop]},
+	}
+	if got := storedLoops(t, trackID(t, one)); !reflect.DeepEqual(got, wantLoops) {
+		t.Errorf("loops = %+v, want %+v", got, wantLoops)
+	}
+	checkBeatgrid(t, trackID(t, one), 126, 0.25)
+	if got := storedPlaylists(t); !reflect.DeepEqual(got, playlists) {
+		t.Errorf("playlists = %+v, want %+v", got, playlists)
+	}
+}
+
+func TestTraktorLocations(t *testing.T) {
+	tests := []struct {
+		path     string
+		location traktorLocation
+	}{
+		{"/Users/dj/Music/One.mp3", traktorLocation{Dir: "/:Users/:dj/:Music/:", File: "One.mp3", Volume: traktorSystemVolume}},
+		{"/Volumes/USB/Two.mp3", traktorLocation{Dir: "/:", File: "Two.mp3", Volume: "USB"}},
+		{"D:/DJ/Sets/Three.flac", traktorLocation{Dir: "/:DJ/:Sets/:", File: "Three.flac", Volume: "D:"}},
+	}
+	for _, tt := range tests {
+		location := newTraktorLocation(tt.path)
+		if location != tt.location {
+			t.Errorf("newTraktorLocation(%q) = %+v, want %+v", tt.path, location, tt.location)
+		}
+		if paths := location.paths(); paths[len(paths)-1] != tt.path && paths[0] != tt.path {
+			t.Errorf("paths() of %q = %q", tt.path, paths)
+		}
+	}
+}
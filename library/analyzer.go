@@ -0,0 +1,176 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"megajam/analysis"
+	"megajam/db"
+	"megajam/logger"
+)
+
+// AnalysisProgress describes the state of a running analysis job.
+type AnalysisProgress struct {
+	Total    int    // Tracks queued for analysis
+	Done     int    // Tracks processed, including failed ones
+	Failed   int    // Tracks that could not be analysed
+	Current  string // Path of the most recently processed track
+	Finished bool
+}
+
+// Analyzer runs audio analysis over library tracks in the background.
+type Analyzer struct {
+	Workers    int
+	OnProgress func(AnalysisProgress) // Called from the analysing goroutine; may be nil
+
+	mu      sync.Mutex
+	running bool
+}
+
+// analysisTask analyses one track on a worker goroutine. The returned function stores
+// the result and is called on a single goroutine, keeping database writes serial.
+type analysisTask func(track db.Track) (save func() error, err error)
+
+// NewAnalyzer creates an analyzer that processes up to workers tracks at once.
+func NewAnalyzer(workers int) *Analyzer {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &Analyzer{Workers: workers}
+}
+
+// AnalyzeTempo detects the tempo and first downbeat of each track and stores its beatgrid.
+// Tracks whose beatgrid was corrected by hand are left alone.
+func (a *Analyzer) AnalyzeTempo(ctx context.Context, tracks []db.Track) (AnalysisProgress, error) {
+	return a.run(ctx, "Tempo", tracks, func(track db.Track) (func() error, error) {
+		tempo, err := analysis.AnalyzeTempo(track.Path)
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			_, err := saveTempo(track, tempo)
+			return err
+		}, nil
+	})
+}
+
+// AnalyzeKey detects the musical key of each track and stores it in standard and Camelot notation.
+func (a *Analyzer) AnalyzeKey(ctx context.Context, tracks []db.Track) (AnalysisProgress, error) {
+	return a.run(ctx, "Key", tracks, func(track db.Track) (func() error, error) {
+		key, err := analysis.AnalyzeKey(track.Path)
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			logger.Logger.Printf("Key analysis: '%s' is %s (%s)", track.Path, key.Name(), key.Camelot())
+			return db.SaveTrackKey(track.ID, key.Name(), key.Camelot())
+		}, nil
+	})
+}
+
+// AnalyzeTrackTempo detects the tempo of a single track and stores its beatgrid. A beatgrid
+// corrected by hand is returned unchanged.
+func AnalyzeTrackTempo(track db.Track) (*db.Beatgrid, error) {
+	tempo, err := analysis.AnalyzeTempo(track.Path)
+	if err != nil {
+		return nil, err
+	}
+	return saveTempo(track, tempo)
+}
+
+// saveTempo stores a detected tempo as the beatgrid of track unless it has a manual one.
+func saveTempo(track db.Track, tempo *analysis.Tempo) (*db.Beatgrid, error) {
+	existing, err := db.GetBeatgrid(track.ID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.Manual {
+		return existing, nil
+	}
+	grid := &db.Beatgrid{TrackID: track.ID, BPM: tempo.BPM, Offset: tempo.Offset}
+	if err := db.SaveBeatgrid(grid); err != nil {
+		return nil, err
+	}
+	logger.Logger.Printf("Tempo analysis: '%s' is %.2f BPM (confidence %.1f)", track.Path, tempo.BPM, tempo.Confidence)
+	return grid, nil
+}
+
+// run analyses the tracks with a bounded worker pool. Only one job runs at a time.
+func (a *Analyzer) run(ctx context.Context, name string, tracks []db.Track, task analysisTask) (AnalysisProgress, error) {
+	a.mu.Lock()
+	if a.running {
+		a.mu.Unlock()
+		return AnalysisProgress{}, fmt.Errorf("an analysis job is already running")
+	}
+	a.running = true
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.running = false
+		a.mu.Unlock()
+	}()
+
+	logger.Logger.Printf("%s analysis started for %d track(s)", name, len(tracks))
+	type result struct {
+		track db.Track
+		save  func() error
+		err   error
+	}
+	queue := make(chan db.Track)
+	results := make(chan result, a.Workers)
+
+	go func() {
+		defer close(queue)
+		for _, track := range tracks {
+			select {
+			case queue <- track:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for i := 0; i < a.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for track := range queue {
+				save, err := task(track)
+				results <- result{track: track, save: save, err: err}
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	progress := AnalysisProgress{Total: len(tracks)}
+	a.report(progress)
+	for r := range results {
+		progress.Done++
+		progress.Current = r.track.Path
+		err := r.err
+		if err == nil {
+			err = r.save()
+		}
+		if err != nil {
+			progress.Failed++
+			logger.Logger.Printf("%s analysis: skipping '%s': %v", name, r.track.Path, err)
+		}
+		a.report(progress)
+	}
+
+	progress.Finished = true
+	a.report(progress)
+	logger.Logger.Printf("%s analysis finished: %d analysed, %d failed", name, progress.Done-progress.Failed, progress.Failed)
+	return progress, ctx.Err()
+}
+
+func (a *Analyzer) report(progress AnalysisProgress) {
+	if a.OnProgress != nil {
+		a.OnProgress(progress)
+	}
+}
@@ -0,0 +1,271 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"megajam/db"
+	"megajam/decoder"
+	"megajam/logger"
+
+	"github.com/dhowden/tag"
+)
+
+// progressInterval limits how often OnProgress is called while scanning.
+const progressInterval = 100 * time.Millisecond
+
+// Progress describes the state of a running scan.
+type Progress struct {
+	Found    int    // Music files discovered so far
+	Scanned  int    // Files processed, including skipped and failed ones
+	Added    int    // New tracks inserted
+	Updated  int    // Existing tracks whose file changed
+	Skipped  int    // Files unchanged since the last scan
+	Failed   int    // Files that could not be read
+	Current  string // Path of the most recently processed file
+	Finished bool
+}
+
+// Scanner walks the library root folders and keeps db.Track rows in sync with the files on disk.
+type Scanner struct {
+	Roots      []string
+	Workers    int
+	OnProgress func(Progress) // Called from the scanning goroutine; may be nil
+
+	mu      sync.Mutex
+	running bool
+}
+
+// scanResult is produced by a worker for every file it processes.
+type scanResult struct {
+	path    string
+	track   *db.Track // nil when the file was skipped or failed
+	skipped bool
+	err     error
+}
+
+// NewScanner creates a scanner for the given root folders.
+func NewScanner(roots []string, workers int) *Scanner {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &Scanner{Roots: roots, Workers: workers}
+}
+
+// IsSupported reports whether the scanner imports files with the given path's extension.
+// Every format the decoder package can play is imported.
+func IsSupported(path string) bool {
+	return decoder.IsSupported(path)
+}
+
+// Scan walks every root folder and upserts a db.Track for each new or changed music file.
+// Only one scan runs at a time; a second concurrent call returns an error.
+func (s *Scanner) Scan(ctx context.Context) (Progress, error) {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return Progress{}, fmt.Errorf("a library scan is already running")
+	}
+	s.running = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+	}()
+
+	logger.Logger.Printf("Library scan started for %d root(s)", len(s.Roots))
+	known, err := db.GetTrackFileStates()
+	if err != nil {
+		return Progress{}, fmt.Errorf("failed to load library state: %w", err)
+	}
+
+	paths := make(chan string, s.Workers*4)
+	results := make(chan scanResult, s.Workers*4)
+
+	var found int
+	var foundMutex sync.Mutex
+	walkErr := make(chan error, 1)
+	go func() {
+		defer close(paths)
+		walkErr <- s.walk(ctx, paths, func() {
+			foundMutex.Lock()
+			found++
+			foundMutex.Unlock()
+		})
+	}()
+
+	var workers sync.WaitGroup
+	for i := 0; i < s.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for path := range paths {
+				results <- scanFile(path, known)
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	// All database writes happen on this goroutine to avoid SQLite lock contention.
+	var progress Progress
+	lastReport := time.Time{}
+	for result := range results {
+		progress.Scanned++
+		progress.Current = result.path
+		switch {
+		case result.err != nil:
+			progress.Failed++
+			logger.Logger.Printf("Library scan: skipping '%s': %v", result.path, result.err)
+		case result.skipped:
+			progress.Skipped++
+		default:
+			created, err := db.UpsertTrack(result.track)
+			if err != nil {
+				progress.Failed++
+				logger.Logger.Printf("Library scan: failed to save '%s': %v", result.path, err)
+			} else if created {
+				progress.Added++
+			} else {
+				progress.Updated++
+			}
+		}
+
+		if time.Since(lastReport) >= progressInterval {
+			foundMutex.Lock()
+			progress.Found = found
+			foundMutex.Unlock()
+			s.report(progress)
+			lastReport = time.Now()
+		}
+	}
+
+	progress.Found = found
+	progress.Finished = true
+	s.report(progress)
+	logger.Logger.Printf("Library scan finished: %d added, %d updated, %d unchanged, %d failed",
+		progress.Added, progress.Updated, progress.Skipped, progress.Failed)
+
+	if err := <-walkErr; err != nil {
+		return progress, err
+	}
+	return progress, ctx.Err()
+}
+
+// walk sends every supported file below the root folders to paths.
+func (s *Scanner) walk(ctx context.Context, paths chan<- string, onFound func()) error {
+	for _, root := range s.Roots {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				logger.Logger.Printf("Library scan: cannot read '%s': %v", path, err)
+				if entry != nil && entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if entry.IsDir() || !IsSupported(path) {
+				return nil
+			}
+			onFound()
+			select {
+			case paths <- path:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to walk library root '%s': %w", root, err)
+		}
+	}
+	return nil
+}
+
+// scanFile reads a single file, skipping it when its size and modification time are unchanged.
+func scanFile(path string, known map[string]db.TrackFileState) scanResult {
+	info, err := os.Stat(path)
+	if err != nil {
+		return scanResult{path: path, err: err}
+	}
+	if state, ok := known[path]; ok && state.FileSize == info.Size() && state.FileModTime.Equal(info.ModTime()) {
+		return scanResult{path: path, skipped: true}
+	}
+
+	track, err := ReadTrack(path, info)
+	if err != nil {
+		return scanResult{path: path, err: err}
+	}
+	return scanResult{path: path, track: track}
+}
+
+// ReadTrack builds a db.Track from the tags and audio stream of the file at path.
+func ReadTrack(path string, info os.FileInfo) (*db.Track, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	track := &db.Track{
+		Path:        path,
+		FileSize:    info.Size(),
+		FileModTime: info.ModTime(),
+	}
+
+	// Missing or broken tags are not fatal; the file name is used as a fallback title.
+	if meta, err := tag.ReadFrom(f); err == nil {
+		track.Title = strings.TrimSpace(meta.Title())
+		track.Artist = strings.TrimSpace(meta.Artist())
+		track.Album = strings.TrimSpace(meta.Album())
+	}
+	if track.Title == "" {
+		track.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	duration, err := readDuration(path)
+	if err != nil {
+		return nil, err
+	}
+	track.Duration = FormatDuration(duration)
+	return track, nil
+}
+
+// readDuration decodes the stream headers to find the playing time of the file.
+func readDuration(path string) (time.Duration, error) {
+	streamer, format, err := decoder.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer streamer.Close()
+	return format.SampleRate.D(streamer.Len()), nil
+}
+
+// FormatDuration formats a duration as m:ss, the format stored in db.Track.Duration.
+func FormatDuration(d time.Duration) string {
+	seconds := int(d.Round(time.Second) / time.Second)
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// ParseDuration parses a duration in the m:ss format of FormatDuration.
+func ParseDuration(s string) (time.Duration, error) {
+	var minutes, seconds int
+	if _, err := fmt.Sscanf(s, "%d:%d", &minutes, &seconds); err != nil {
+		return 0, fmt.Errorf("invalid duration '%s': %w", s, err)
+	}
+	return time.Duration(minutes*60+seconds) * time.Second, nil
+}
+
+func (s *Scanner) report(progress Progress) {
+	if s.OnProgress != nil {
+		s.OnProgress(progress)
+	}
+}
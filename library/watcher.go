@@ -0,0 +1,245 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"megajam/db"
+	"megajam/logger"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultDebounce is how long the watcher waits for a burst of events to settle.
+const DefaultDebounce = 750 * time.Millisecond
+
+// Watcher keeps db.Track rows in sync with changes below the library root folders.
+type Watcher struct {
+	Roots    []string
+	Debounce time.Duration
+	OnChange func() // Called after a batch of changes has been written; may be nil
+
+	fsWatcher *fsnotify.Watcher
+	pending   map[string]struct{}
+}
+
+// NewWatcher creates a watcher for the given root folders and all of their subfolders.
+func NewWatcher(roots []string) (*Watcher, error) {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	w := &Watcher{
+		Roots:     roots,
+		Debounce:  DefaultDebounce,
+		fsWatcher: fsWatcher,
+		pending:   make(map[string]struct{}),
+	}
+	for _, root := range roots {
+		if err := w.addTree(root); err != nil {
+			fsWatcher.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Run processes file system events until ctx is cancelled or the watcher is closed.
+func (w *Watcher) Run(ctx context.Context) error {
+	logger.Logger.Printf("Watching %d library root(s) for changes", len(w.Roots))
+	timer := time.NewTimer(w.Debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-w.fsWatcher.Events:
+			if !ok {
+				return nil
+			}
+			if w.handleEvent(event) {
+				timer.Reset(w.Debounce)
+			}
+		case err, ok := <-w.fsWatcher.Errors:
+			if !ok {
+				return nil
+			}
+			logger.Logger.Printf("Library watcher error: %v", err)
+		case <-timer.C:
+			w.flush()
+		}
+	}
+}
+
+// Close stops watching the library folders.
+func (w *Watcher) Close() error {
+	return w.fsWatcher.Close()
+}
+
+// addTree watches root and every folder below it.
+func (w *Watcher) addTree(root string) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			logger.Logger.Printf("Library watcher: cannot read '%s': %v", path, err)
+			if entry != nil && entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if err := w.fsWatcher.Add(path); err != nil {
+			return fmt.Errorf("failed to watch '%s': %w", path, err)
+		}
+		return nil
+	})
+}
+
+// handleEvent queues the path affected by event and reports whether anything was queued.
+func (w *Watcher) handleEvent(event fsnotify.Event) bool {
+	if event.Has(fsnotify.Chmod) && !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+		return false
+	}
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			// A new or moved-in folder may already contain files, so its contents are queued as well.
+			if err := w.addTree(event.Name); err != nil {
+				logger.Logger.Printf("Library watcher: %v", err)
+			}
+			w.queueTree(event.Name)
+			return true
+		}
+	}
+	// Removed or renamed paths may be folders, which have no extension to check.
+	if !IsSupported(event.Name) && !event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+		return false
+	}
+	w.pending[event.Name] = struct{}{}
+	return true
+}
+
+// queueTree queues every supported file below root.
+func (w *Watcher) queueTree(root string) {
+	filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() && IsSupported(path) {
+			w.pending[path] = struct{}{}
+		}
+		return nil
+	})
+}
+
+// flush writes all queued changes to the database. A file that appears in the same batch as
+// a removed path, with the size and modification time of a track stored there, is taken to
+// have been moved or renamed: its track keeps its row, and with it its cue points, loops and
+// beatgrid.
+func (w *Watcher) flush() {
+	if len(w.pending) == 0 {
+		return
+	}
+	known, err := db.GetTrackFileStates()
+	if err != nil {
+		logger.Logger.Printf("Library watcher: failed to load library state: %v", err)
+		return
+	}
+
+	var removed, present []string
+	for path := range w.pending {
+		delete(w.pending, path)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			removed = append(removed, path)
+		} else {
+			present = append(present, path)
+		}
+	}
+	vanished := tracksBelow(removed, known)
+
+	changed := 0
+	for _, path := range present {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() || !IsSupported(path) {
+			continue
+		}
+
+		if _, ok := known[path]; !ok {
+			if i := matchMoved(vanished, path, info); i >= 0 {
+				if err := db.MoveTrack(vanished[i].ID, path); err != nil {
+					logger.Logger.Printf("Library watcher: failed to move '%s' to '%s': %v", vanished[i].Path, path, err)
+				} else {
+					logger.Logger.Printf("Library watcher: '%s' moved to '%s'", vanished[i].Path, path)
+				}
+				vanished = append(vanished[:i], vanished[i+1:]...)
+			}
+		}
+
+		result := scanFile(path, known)
+		if result.err != nil {
+			logger.Logger.Printf("Library watcher: skipping '%s': %v", path, result.err)
+			continue
+		}
+		if result.skipped {
+			continue
+		}
+		if _, err := db.UpsertTrack(result.track); err != nil {
+			logger.Logger.Printf("Library watcher: failed to save '%s': %v", path, err)
+			continue
+		}
+		changed++
+	}
+
+	// Tracks moved away above no longer have their old paths, so only the rest are removed.
+	for _, path := range removed {
+		deleted, err := db.DeleteTracksByPath(path)
+		if err != nil {
+			logger.Logger.Printf("Library watcher: failed to remove '%s': %v", path, err)
+		}
+		changed += int(deleted)
+	}
+
+	if changed > 0 {
+		logger.Logger.Printf("Library watcher: applied %d change(s)", changed)
+		if w.OnChange != nil {
+			w.OnChange()
+		}
+	}
+}
+
+// tracksBelow returns the known tracks stored at any of paths or in a folder below one of them.
+func tracksBelow(paths []string, known map[string]db.TrackFileState) []db.TrackFileState {
+	var tracks []db.TrackFileState
+	for _, state := range known {
+		for _, path := range paths {
+			folder := strings.TrimSuffix(path, string(filepath.Separator)) + string(filepath.Separator)
+			if state.Path == path || strings.HasPrefix(state.Path, folder) {
+				tracks = append(tracks, state)
+				break
+			}
+		}
+	}
+	return tracks
+}
+
+// matchMoved returns the index of the vanished track whose file has the size and modification
+// time of info, preferring one with the same file name as path, or -1 when there is none.
+func matchMoved(vanished []db.TrackFileState, path string, info os.FileInfo) int {
+	match := -1
+	for i, state := range vanished {
+		if state.FileSize != info.Size() || !state.FileModTime.Equal(info.ModTime()) {
+			continue
+		}
+		if filepath.Base(state.Path) == filepath.Base(path) {
+			return i
+		}
+		if match < 0 {
+			match = i
+		}
+	}
+	return match
+}
@@ -0,0 +1,155 @@
+package library
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"megajam/db"
+	"megajam/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = log.New(io.Discard, "", 0)
+	os.Exit(m.Run())
+}
+
+// writeWAV writes a silent 16-bit stereo WAV file of the given number of frames.
+func writeWAV(t *testing.T, path string, frames int) {
+	t.Helper()
+	data := make([]byte, 44+4*frames)
+	copy(data, "RIFF")
+	binary.LittleEndian.PutUint32(data[4:], uint32(36+4*frames))
+	copy(data[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(data[16:], 16)
+	binary.LittleEndian.PutUint16(data[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(data[22:], 2)
+	binary.LittleEndian.PutUint32(data[24:], 44100)
+	binary.LittleEndian.PutUint32(data[28:], 44100*4)
+	binary.LittleEndian.PutUint16(data[32:], 4)
+	binary.LittleEndian.PutUint16(data[34:], 16)
+	copy(data[36:], "data")
+	binary.LittleEndian.PutUint32(data[40:], uint32(4*frames))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// flushPaths runs the watcher over a batch of changed paths, as the debounce timer would.
+func flushPaths(w *Watcher, paths ...string) {
+	for _, path := range paths {
+		w.pending[path] = struct{}{}
+	}
+	w.flush()
+}
+
+func tracksByPath(t *testing.T) map[string]db.Track {
+	t.Helper()
+	var tracks []db.Track
+	if err := db.DB.Unscoped().Find(&tracks).Error; err != nil {
+		t.Fatal(err)
+	}
+	byPath := make(map[string]db.Track)
+	for _, track := range tracks {
+		if track.DeletedAt.Valid {
+			continue
+		}
+		byPath[track.Path] = track
+	}
+	if len(byPath) != len(tracks) {
+		t.Errorf("%d of %d tracks are deleted", len(tracks)-len(byPath), len(tracks))
+	}
+	return byPath
+}
+
+func TestWatcherKeepsMovedTracks(t *testing.T) {
+	db.InitDatabase(filepath.Join(t.TempDir(), "library.db"))
+	root := t.TempDir()
+	one := filepath.Join(root, "House", "One.wav")
+	two := filepath.Join(root, "House", "Two.wav")
+	three := filepath.Join(root, "Three.wav")
+	writeWAV(t, one, 44100)
+	writeWAV(t, two, 22050)
+	writeWAV(t, three, 4410)
+
+	w := &Watcher{Roots: []string{root}, pending: make(map[string]struct{})}
+	flushPaths(w, one, two, three)
+	before := tracksByPath(t)
+	if len(before) != 3 {
+		t.Fatalf("scanned %d tracks, want 3", len(before))
+	}
+	if err := db.AddCuePoint(before[one].ID, "Drop", 12); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveBeatgrid(&db.Beatgrid{TrackID: before[three].ID, BPM: 128, Offset: 0.1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rename a file and a folder, as fsnotify reports them: the old paths, the new file and the
+	// files queued from the new folder.
+	renamed := filepath.Join(root, "Renamed.wav")
+	folder := filepath.Join(root, "Deep House")
+	if err := os.Rename(three, renamed); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(root, "House"), folder); err != nil {
+		t.Fatal(err)
+	}
+	changes := 0
+	w.OnChange = func() { changes++ }
+	flushPaths(w, three, renamed, filepath.Join(root, "House"), filepath.Join(folder, "One.wav"), filepath.Join(folder, "Two.wav"))
+
+	after := tracksByPath(t)
+	moves := map[string]string{one: filepath.Join(folder, "One.wav"), two: filepath.Join(folder, "Two.wav"), three: renamed}
+	for from, to := range moves {
+		if got, ok := after[to]; !ok || got.ID != before[from].ID {
+			t.Errorf("track at %s has ID %d, want %d from %s", to, got.ID, before[from].ID, from)
+		}
+	}
+	if len(after) != 3 {
+		t.Errorf("library holds %d tracks, want 3", len(after))
+	}
+	if title := after[renamed].Title; title != "Renamed" {
+		t.Errorf("title of the renamed track = %q", title)
+	}
+	if cues, _ := db.GetCuePoints(before[one].ID); len(cues) != 1 {
+		t.Errorf("moved track has %d cue points, want 1", len(cues))
+	}
+	if grid, _ := db.GetBeatgrid(before[three].ID); grid == nil {
+		t.Error("renamed track lost its beatgrid")
+	}
+	if changes != 1 {
+		t.Errorf("OnChange called %d times, want 1", changes)
+	}
+}
+
+func TestWatcherReplacedFileIsNotAMove(t *testing.T) {
+	db.InitDatabase(filepath.Join(t.TempDir(), "library.db"))
+	root := t.TempDir()
+	old := filepath.Join(root, "Old.wav")
+	writeWAV(t, old, 1000)
+	w := &Watcher{Roots: []string{root}, pending: make(map[string]struct{})}
+	flushPaths(w, old)
+
+	// A different file appears as the old one is deleted.
+	if err := os.Remove(old); err != nil {
+		t.Fatal(err)
+	}
+	fresh := filepath.Join(root, "New.wav")
+	writeWAV(t, fresh, 2000)
+	flushPaths(w, old, fresh)
+
+	var tracks []db.Track
+	if err := db.DB.Unscoped().Order("id").Find(&tracks).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 2 || !tracks[0].DeletedAt.Valid || tracks[1].Path != fresh || tracks[1].DeletedAt.Valid {
+		t.Errorf("tracks = %+v, want the old one deleted and the new one added", tracks)
+	}
+}
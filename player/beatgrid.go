@@ -0,0 +1,48 @@
+package player
+
+import (
+	"math"
+	"time"
+)
+
+// Beatgrid places the beats of a track: for any integer n, a beat falls at Offset + n*60/BPM seconds.
+type Beatgrid struct {
+	BPM    float64
+	Offset time.Duration // Position of the first downbeat
+}
+
+// BeatLength returns the time between two beats.
+func (g Beatgrid) BeatLength() time.Duration {
+	return time.Duration(60 / g.BPM * float64(time.Second))
+}
+
+// Beat returns the position of t in beats from the first downbeat, including the fraction
+// of the current beat.
+func (g Beatgrid) Beat(t time.Duration) float64 {
+	return float64(t-g.Offset) / float64(g.BeatLength())
+}
+
+// Phase returns how far t lies into its beat, from 0 on a beat up to but excluding 1.
+func (g Beatgrid) Phase(t time.Duration) float64 {
+	beat := g.Beat(t)
+	return beat - math.Floor(beat)
+}
+
+// Snap returns the beat nearest to t.
+func (g Beatgrid) Snap(t time.Duration) time.Duration {
+	return g.Offset + time.Duration(math.Round(g.Beat(t))*float64(g.BeatLength()))
+}
+
+// matchTempo returns the rate at which a track with beatgrid grid plays at the tempo of target,
+// in beats per minute. The track's tempo may be doubled or halved to keep the rate near 1, so
+// the returned grid has the tempo the track is counted in.
+func matchTempo(grid Beatgrid, target float64) (Beatgrid, float64) {
+	best, bestRate := grid, target/grid.BPM
+	for _, factor := range []float64{0.5, 2} {
+		scaled := Beatgrid{BPM: grid.BPM * factor, Offset: grid.Offset}
+		if rate := target / scaled.BPM; math.Abs(math.Log(rate)) < math.Abs(math.Log(bestRate)) {
+			best, bestRate = scaled, rate
+		}
+	}
+	return best, bestRate
+}
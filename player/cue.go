@@ -0,0 +1,145 @@
+package player
+
+import (
+	"math"
+
+	"megajam/dsp"
+	"megajam/logger"
+)
+
+// CueRouting selects where the headphone cue mix is played. The headphones share the main
+// output: the speaker package opens only the system's default device, so a second output
+// device cannot be driven.
+type CueRouting int
+
+const (
+	// CueOff plays only the master mix on the main output.
+	CueOff CueRouting = iota
+	// CueSplit plays the master mix in mono on the left channel of the main output and the cue
+	// mix in mono on the right, for headphones on a splitter cable.
+	CueSplit
+)
+
+// pflTap copies the pre-fader signal of a channel strip into the deck's cue buffer as it passes.
+type pflTap struct {
+	d *Deck
+}
+
+func (t pflTap) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = t.d.filter.Stream(samples)
+	if len(t.d.pfl) < n {
+		t.d.pfl = make([][2]float64, n)
+	}
+	t.d.pfl = t.d.pfl[:n]
+	copy(t.d.pfl, samples[:n])
+	return n, ok
+}
+
+func (t pflTap) Err() error {
+	return t.d.filter.Err()
+}
+
+// cueBus blends the pre-fader signals of the cued decks with the master mix. The engine holds mu
+// while streaming and fills masterBuf first.
+type cueBus struct {
+	e *Engine
+}
+
+func (b cueBus) Stream(samples [][2]float64) (n int, ok bool) {
+	e := b.e
+	for i := range samples {
+		samples[i] = [2]float64{}
+	}
+	for _, deck := range e.decks {
+		if !deck.cued {
+			continue
+		}
+		for i := range samples {
+			if i < len(deck.pfl) {
+				samples[i][0] += deck.pfl[i][0]
+				samples[i][1] += deck.pfl[i][1]
+			}
+		}
+	}
+	for i := range samples {
+		for c := range samples[i] {
+			samples[i][c] = (1-e.cueMix)*samples[i][c] + e.cueMix*e.masterBuf[i][c]
+		}
+	}
+	return len(samples), true
+}
+
+func (b cueBus) Err() error {
+	return nil
+}
+
+// newCueChain builds the headphone chain: the cue bus, the cue volume and a limiter.
+func (e *Engine) newCueChain() {
+	rate := e.format.SampleRate
+	e.cueGain = dsp.NewGain(cueBus{e}, rate, 1)
+	e.cueLimiter = dsp.NewLimiter(e.cueGain, rate, limiterCeiling)
+}
+
+// streamCue renders the headphone mix for the master mix just written to samples and routes it;
+// callers must hold mu.
+func (e *Engine) streamCue(samples [][2]float64) {
+	if e.cueRouting == CueOff {
+		return
+	}
+	if len(e.masterBuf) < len(samples) {
+		e.masterBuf = make([][2]float64, len(samples))
+		e.cueBuf = make([][2]float64, len(samples))
+	}
+	master, cue := e.masterBuf[:len(samples)], e.cueBuf[:len(samples)]
+	copy(master, samples)
+	e.cueLimiter.Stream(cue)
+
+	for i := range samples {
+		samples[i] = [2]float64{(master[i][0] + master[i][1]) / 2, (cue[i][0] + cue[i][1]) / 2}
+	}
+}
+
+// SetCue sets whether the deck is heard on the headphone cue bus. The cue bus takes the
+// signal before the channel fader, so a deck can be previewed while faded out of the mix.
+func (d *Deck) SetCue(cued bool) {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	d.cued = cued
+}
+
+// Cued reports whether the deck is heard on the headphone cue bus.
+func (d *Deck) Cued() bool {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	return d.cued
+}
+
+// SetCueMix blends the headphone mix between the cued decks (0) and the master mix (1).
+func (e *Engine) SetCueMix(mix float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cueMix = math.Max(0, math.Min(1, mix))
+}
+
+// SetCueVolume sets the headphone level, 0.0 (mute) to 1.0 (full level).
+func (e *Engine) SetCueVolume(level float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	level = math.Max(0, math.Min(1, level))
+	e.cueGain.SetGain(level * level)
+}
+
+// SetCueRouting selects where the headphone mix is played on the main output.
+func (e *Engine) SetCueRouting(routing CueRouting) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cueRouting = routing
+	logger.Logger.Printf("Cue routing set to %d", routing)
+}
+
+// CueRouting returns where the headphone mix is played on the main output.
+func (e *Engine) CueRouting() CueRouting {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.cueRouting
+}
@@ -0,0 +1,462 @@
+package player
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"megajam/dsp"
+	"megajam/logger"
+
+	"github.com/rickcollette/megasound"
+	"github.com/rickcollette/megasound/speaker"
+)
+
+const (
+	// MinDecks and MaxDecks bound the number of decks an Engine can mix.
+	MinDecks = 2
+	MaxDecks = 4
+)
+
+// Engine owns the audio output and mixes the decks into it.
+// The output device is initialised once, when the engine is created.
+type Engine struct {
+	format megasound.Format
+	decks  []*Deck
+	mu     sync.Mutex // Guards the players loaded into the decks and their settings
+	master int        // Index of the deck other decks sync to
+	mixBuf [][2]float64
+
+	crossfader float64 // 0 plays side A only, 1 side B only
+	curve      CrossfaderCurve
+	masterGain *dsp.Gain
+	limiter    *dsp.Limiter // End of the master chain, keeps the output from clipping
+
+	cueMix     float64 // 0 hears only the cued decks, 1 only the master mix
+	cueRouting CueRouting
+	cueGain    *dsp.Gain
+	cueLimiter *dsp.Limiter // End of the headphone chain
+	masterBuf  [][2]float64 // Master mix of the current buffer, blended into the cue mix
+	cueBuf     [][2]float64
+
+	masterSinks map[string]MasterSink // Receive the finished master mix, by name
+}
+
+// Deck is one independent playback channel of an Engine.
+type Deck struct {
+	index   int
+	engine  *Engine
+	player  *Player // nil until a track is loaded
+	volume  float64 // Channel fader
+	trim    float64 // Input gain
+	side    CrossfaderSide
+	eq      *dsp.EQ
+	filter  *dsp.Filter
+	channel *dsp.Gain // End of the channel strip, applies trim, fader and crossfader
+	cued    bool
+	pfl     [][2]float64 // Pre-fader signal of the current buffer, for the cue bus
+	rate    float64      // Playback speed, kept when a new track is loaded
+	keyLock bool         // Keeps the pitch when the rate changes, kept when a new track is loaded
+	grid    *Beatgrid    // Beatgrid of the loaded track, nil when unknown
+	locked  bool         // Follows the master deck's tempo when set
+
+	loopIn    time.Duration // Start of the next manual loop, set by LoopIn
+	loopInSet bool
+	quantize  bool          // Snaps cue points, loop points, jumps and play starts to the beatgrid
+	cuePoint  time.Duration // Set and returned to by Cue
+
+	eventsMu    sync.Mutex
+	subscribers []chan Event
+}
+
+// NewEngine initialises the speaker at sampleRate and starts mixing the given number of decks.
+func NewEngine(sampleRate megasound.SampleRate, bufferSize time.Duration, decks int) (*Engine, error) {
+	if decks < MinDecks || decks > MaxDecks {
+		return nil, fmt.Errorf("deck count must be between %d and %d, got %d", MinDecks, MaxDecks, decks)
+	}
+
+	e := &Engine{
+		format:     megasound.Format{SampleRate: sampleRate, NumChannels: 2, Precision: 2},
+		crossfader: 0.5,
+		curve:      CurveConstantPower,
+	}
+	for i := 0; i < decks; i++ {
+		deck := &Deck{index: i, engine: e, volume: 1, trim: 1, rate: 1}
+		// The first two decks sit either side of the crossfader.
+		switch i {
+		case 0:
+			deck.side = SideA
+		case 1:
+			deck.side = SideB
+		}
+		deck.newChannel()
+		deck.updateGain()
+		e.decks = append(e.decks, deck)
+	}
+	e.masterGain = dsp.NewGain(mixBus{e}, sampleRate, 1)
+	e.limiter = dsp.NewLimiter(e.masterGain, sampleRate, limiterCeiling)
+	e.newCueChain()
+
+	if err := speaker.Init(sampleRate, sampleRate.N(bufferSize)); err != nil {
+		logger.Logger.Printf("Failed to initialize speaker: %v", err)
+		return nil, fmt.Errorf("failed to initialize speaker: %w", err)
+	}
+	speaker.Play(e)
+	logger.Logger.Printf("Audio engine started at %d Hz with %d decks.", sampleRate, decks)
+	return e, nil
+}
+
+// Format returns the output format of the engine.
+func (e *Engine) Format() megasound.Format {
+	return e.format
+}
+
+// Deck returns the deck with the given zero-based index.
+func (e *Engine) Deck(index int) *Deck {
+	return e.decks[index]
+}
+
+// NumDecks returns the number of decks mixed by the engine.
+func (e *Engine) NumDecks() int {
+	return len(e.decks)
+}
+
+// SetMaster makes the deck with the given index the one other decks sync to.
+func (e *Engine) SetMaster(index int) {
+	e.mu.Lock()
+	e.master = index
+	e.mu.Unlock()
+	logger.Logger.Printf("Deck %d is now the master deck", index+1)
+	e.followMaster()
+}
+
+// Master returns the deck other decks sync to.
+func (e *Engine) Master() *Deck {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.decks[e.master]
+}
+
+// followMaster matches the tempo of every sync-locked deck to the master deck.
+func (e *Engine) followMaster() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	master := e.decks[e.master]
+	if master.grid == nil {
+		return
+	}
+	target := master.grid.BPM * master.rate
+	for _, deck := range e.decks {
+		if deck == master || !deck.locked || deck.grid == nil {
+			continue
+		}
+		_, deck.rate = matchTempo(*deck.grid, target)
+		if deck.player != nil {
+			deck.player.SetRate(deck.rate)
+		}
+	}
+}
+
+// Stream mixes all decks through their channel strips and the master level and limiter
+// into samples, hands the result to the master sinks and renders the headphone cue mix. It is called by the speaker and never drains.
+func (e *Engine) Stream(samples [][2]float64) (n int, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n, ok = e.limiter.Stream(samples)
+	for _, sink := range e.masterSinks {
+		sink(samples[:n])
+	}
+	e.streamCue(samples[:n])
+	return n, ok
+}
+
+// Err always returns nil; errors are handled per deck.
+func (e *Engine) Err() error {
+	return nil
+}
+
+// Close unloads all decks and stops the audio output.
+func (e *Engine) Close() {
+	for _, deck := range e.decks {
+		deck.Unload()
+	}
+	speaker.Clear()
+	logger.Logger.Println("Audio engine closed.")
+}
+
+// Index returns the zero-based position of the deck in its engine.
+func (d *Deck) Index() int {
+	return d.index
+}
+
+// Load decodes the file at path and replaces the deck's current track with it.
+// The new track starts paused at the deck's current volume.
+func (d *Deck) Load(path string) error {
+	p, err := NewPlayer(path, d.engine.format.SampleRate)
+	if err != nil {
+		return err
+	}
+	d.forwardEvents(p)
+
+	d.engine.mu.Lock()
+	p.SetRate(d.rate)
+	if err := p.SetKeyLock(d.keyLock); err != nil {
+		logger.Logger.Printf("Deck %d: %v", d.index+1, err)
+	}
+	previous := d.player
+	d.player = p
+	d.grid = nil
+	d.loopInSet = false
+	d.cuePoint = 0
+	d.engine.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	logger.Logger.Printf("Deck %d: loaded '%s'", d.index+1, path)
+	return nil
+}
+
+// Unload stops and removes the deck's current track.
+func (d *Deck) Unload() {
+	d.engine.mu.Lock()
+	previous := d.player
+	d.player = nil
+	d.grid = nil
+	d.engine.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+}
+
+// Player returns the deck's loaded track, or nil if the deck is empty.
+func (d *Deck) Player() *Player {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	return d.player
+}
+
+// Loaded reports whether a track is loaded into the deck.
+func (d *Deck) Loaded() bool {
+	return d.Player() != nil
+}
+
+// Play starts or resumes the deck's track. With quantize on, a paused track starts from the
+// beat nearest the playhead.
+func (d *Deck) Play() {
+	d.engine.mu.Lock()
+	p := d.player
+	var start time.Duration
+	snapped := false
+	if p != nil && p.Paused() && d.quantize && d.grid != nil {
+		start, snapped = d.snap(p.Position()), true
+	}
+	d.engine.mu.Unlock()
+
+	if p == nil {
+		return
+	}
+	if snapped {
+		if err := p.Seek(start); err != nil {
+			logger.Logger.Printf("Deck %d: %v", d.index+1, err)
+		}
+	}
+	p.Play()
+}
+
+// Pause pauses the deck's track.
+func (d *Deck) Pause() {
+	if p := d.Player(); p != nil {
+		p.Pause()
+	}
+}
+
+// Paused reports whether the deck is silent, either paused or empty.
+func (d *Deck) Paused() bool {
+	p := d.Player()
+	return p == nil || p.Paused()
+}
+
+// TogglePlay plays a paused deck and pauses a playing one.
+func (d *Deck) TogglePlay() {
+	if d.Paused() {
+		d.Play()
+	} else {
+		d.Pause()
+	}
+}
+
+// SetRate sets the deck's playback speed, where 1 is the original tempo. It is kept when a
+// new track is loaded. Changing the master deck's rate moves every sync-locked deck with it.
+func (d *Deck) SetRate(rate float64) {
+	d.engine.mu.Lock()
+	d.rate = math.Max(MinRate, math.Min(MaxRate, rate))
+	if d.player != nil {
+		d.player.SetRate(d.rate)
+	}
+	isMaster := d.engine.decks[d.engine.master] == d
+	d.engine.mu.Unlock()
+
+	if isMaster {
+		d.engine.followMaster()
+	}
+}
+
+// Rate returns the deck's playback speed.
+func (d *Deck) Rate() float64 {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	return d.rate
+}
+
+// SetKeyLock sets whether the deck keeps the pitch of its track when the rate changes.
+// It is kept when a new track is loaded.
+func (d *Deck) SetKeyLock(keyLock bool) error {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+
+	d.keyLock = keyLock
+	if d.player != nil {
+		return d.player.SetKeyLock(keyLock)
+	}
+	return nil
+}
+
+// KeyLock reports whether the deck keeps the pitch of its track when the rate changes.
+func (d *Deck) KeyLock() bool {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	return d.keyLock
+}
+
+// SetBeatgrid sets the beatgrid of the loaded track; nil clears it. Loading a track clears the grid.
+func (d *Deck) SetBeatgrid(grid *Beatgrid) {
+	d.engine.mu.Lock()
+	d.grid = grid
+	isMaster := d.engine.decks[d.engine.master] == d
+	d.engine.mu.Unlock()
+
+	if isMaster || d.SyncLocked() {
+		d.engine.followMaster()
+	}
+}
+
+// Beatgrid returns the beatgrid of the loaded track, or nil when it is unknown.
+func (d *Deck) Beatgrid() *Beatgrid {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	return d.grid
+}
+
+// BPM returns the tempo the deck is playing at, or zero when the beatgrid is unknown.
+func (d *Deck) BPM() float64 {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	if d.grid == nil {
+		return 0
+	}
+	return d.grid.BPM * d.rate
+}
+
+// SetSyncLock sets whether the deck follows the tempo of the master deck as it changes.
+func (d *Deck) SetSyncLock(locked bool) {
+	d.engine.mu.Lock()
+	d.locked = locked
+	d.engine.mu.Unlock()
+
+	if locked {
+		d.engine.followMaster()
+	}
+}
+
+// SyncLocked reports whether the deck follows the tempo of the master deck.
+func (d *Deck) SyncLocked() bool {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	return d.locked
+}
+
+// Sync matches the deck's tempo to the master deck and moves its track so that their beats
+// line up. Both decks need a loaded track with a beatgrid.
+func (d *Deck) Sync() error {
+	e := d.engine
+	e.mu.Lock()
+	master := e.decks[e.master]
+	if master == d {
+		e.mu.Unlock()
+		return fmt.Errorf("deck %d is the master deck", d.index+1)
+	}
+	if d.player == nil || master.player == nil || d.grid == nil || master.grid == nil {
+		e.mu.Unlock()
+		return fmt.Errorf("both decks need a track with a beatgrid to sync")
+	}
+	masterGrid, masterPlayer := *master.grid, master.player
+	grid, rate := matchTempo(*d.grid, masterGrid.BPM*master.rate)
+	d.rate = rate
+	p := d.player
+	p.SetRate(rate)
+	e.mu.Unlock()
+
+	// Move by the smallest phase difference, up to half a beat either way.
+	position := p.Position()
+	shift := masterGrid.Phase(masterPlayer.Position()) - grid.Phase(position)
+	shift -= math.Round(shift)
+	logger.Logger.Printf("Deck %d: synced to deck %d at %.2f BPM", d.index+1, master.index+1, grid.BPM*rate)
+	return p.Seek(position + time.Duration(shift*float64(grid.BeatLength())))
+}
+
+// Seek moves the deck's track to position.
+func (d *Deck) Seek(position time.Duration) error {
+	p := d.Player()
+	if p == nil {
+		return fmt.Errorf("no track loaded in deck %d", d.index+1)
+	}
+	return p.Seek(position)
+}
+
+// Position returns the playback position of the deck's track, or zero when the deck is empty.
+func (d *Deck) Position() time.Duration {
+	if p := d.Player(); p != nil {
+		return p.Position()
+	}
+	return 0
+}
+
+// Duration returns the length of the deck's track, or zero when the deck is empty.
+func (d *Deck) Duration() time.Duration {
+	if p := d.Player(); p != nil {
+		return p.Duration()
+	}
+	return 0
+}
+
+// Subscribe returns a channel that receives the events of every track loaded into the deck.
+// Like Player.Subscribe, events are dropped when the channel is full.
+func (d *Deck) Subscribe() <-chan Event {
+	d.eventsMu.Lock()
+	defer d.eventsMu.Unlock()
+
+	events := make(chan Event, eventBuffer)
+	d.subscribers = append(d.subscribers, events)
+	return events
+}
+
+// forwardEvents relays the events of p to the deck's subscribers until p is closed.
+func (d *Deck) forwardEvents(p *Player) {
+	events, _ := p.Subscribe()
+	go func() {
+		for event := range events {
+			d.eventsMu.Lock()
+			for _, subscriber := range d.subscribers {
+				select {
+				case subscriber <- event:
+				default:
+				}
+			}
+			d.eventsMu.Unlock()
+		}
+	}()
+}
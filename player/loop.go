@@ -0,0 +1,294 @@
+package player
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rickcollette/megasound"
+)
+
+// minLoopSamples is the shortest loop a Player accepts, so that a loop always makes progress.
+const minLoopSamples = 16
+
+// looper repeats a section of the decoded stream. When the stream reaches the loop end it
+// continues from the loop start within the same buffer, so the wrap is sample-accurate.
+// It only wraps at the end, so playback past the end of a loop is left alone.
+type looper struct {
+	s          megasound.StreamSeeker
+	start, end int // Loop section in file samples
+	active     bool
+	err        error
+}
+
+func (l *looper) Stream(samples [][2]float64) (n int, ok bool) {
+	for n < len(samples) {
+		want := len(samples) - n
+		if l.active {
+			position := l.s.Position()
+			if position == l.end {
+				if err := l.s.Seek(l.start); err != nil {
+					l.err = err
+					return n, n > 0
+				}
+				continue
+			}
+			if position < l.end && l.end-position < want {
+				want = l.end - position
+			}
+		}
+		m, ok := l.s.Stream(samples[n : n+want])
+		n += m
+		if !ok || m == 0 {
+			return n, n > 0
+		}
+	}
+	return n, true
+}
+
+func (l *looper) Err() error {
+	if l.err != nil {
+		return l.err
+	}
+	return l.s.Err()
+}
+
+// wrap maps a position reached by playing straight on from chainStart to the file position
+// actually played, taking loop wrap-arounds into account.
+func (l *looper) wrap(chainStart, position int) int {
+	if !l.active || chainStart > l.end || position < l.end {
+		return position
+	}
+	return l.start + (position-l.start)%(l.end-l.start)
+}
+
+// SetLoop starts looping between start and end. When the playhead is inside the current loop
+// it keeps its place relative to the loop start, wrapped into the new loop, so halving,
+// doubling and moving a loop carry on seamlessly.
+func (p *Player) SetLoop(start, end time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	startSample := p.clampSample(p.format.SampleRate.N(start))
+	endSample := p.clampSample(p.format.SampleRate.N(end))
+	if endSample-startSample < minLoopSamples {
+		return fmt.Errorf("loop from %v to %v is too short", start, end)
+	}
+	position := p.sample()
+	if p.loop.active && position >= p.loop.start && position < p.loop.end {
+		position += startSample - p.loop.start
+		if position >= endSample {
+			position = startSample + (position-startSample)%(endSample-startSample)
+		}
+	}
+	p.loop.start, p.loop.end, p.loop.active = startSample, endSample, true
+	return p.rebase(position)
+}
+
+// ExitLoop stops looping and plays on from the current position. The loop is kept for Reloop.
+func (p *Player) ExitLoop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.loop.active {
+		return nil
+	}
+	position := p.sample()
+	p.loop.active = false
+	return p.rebase(position)
+}
+
+// Reloop turns the last loop back on and jumps to its start.
+func (p *Player) Reloop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.loop.end <= p.loop.start {
+		return fmt.Errorf("no loop to return to")
+	}
+	p.loop.active = true
+	return p.rebase(p.loop.start)
+}
+
+// Loop returns the current or last loop and whether it is playing.
+func (p *Player) Loop() (start, end time.Duration, active bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.format.SampleRate.D(p.loop.start), p.format.SampleRate.D(p.loop.end), p.loop.active
+}
+
+// rebase moves the decoder to sample and rebuilds the chain, which has read ahead with the
+// previous loop settings; callers must hold mu.
+func (p *Player) rebase(sample int) error {
+	if err := p.streamer.Seek(sample); err != nil {
+		return fmt.Errorf("failed to move to the loop: %w", err)
+	}
+	p.resetChain()
+	p.publish(Event{Type: EventPosition, Position: p.position()})
+	return nil
+}
+
+// clampSample limits sample to the length of the track; callers must hold mu.
+func (p *Player) clampSample(sample int) int {
+	if sample < 0 {
+		return 0
+	}
+	if sample > p.streamer.Len() {
+		return p.streamer.Len()
+	}
+	return sample
+}
+
+// Beat lengths offered for auto-loops, from 1/32 to 32 beats.
+const (
+	MinLoopBeats = 1.0 / 32
+	MaxLoopBeats = 32.0
+)
+
+// LoopIn marks the current position as the start of a manual loop, closed by LoopOut.
+// Both snap to the beatgrid with quantize on.
+func (d *Deck) LoopIn() error {
+	p := d.Player()
+	if p == nil {
+		return fmt.Errorf("no track loaded in deck %d", d.index+1)
+	}
+	position := p.Position()
+	d.engine.mu.Lock()
+	d.loopIn, d.loopInSet = d.snap(position), true
+	d.engine.mu.Unlock()
+	return nil
+}
+
+// LoopOut starts looping from the LoopIn mark to the current position.
+func (d *Deck) LoopOut() error {
+	p := d.Player()
+	if p == nil {
+		return fmt.Errorf("no track loaded in deck %d", d.index+1)
+	}
+	position := p.Position()
+	d.engine.mu.Lock()
+	start, ok := d.loopIn, d.loopInSet
+	end := d.snap(position)
+	if end <= start && d.quantize && d.grid != nil {
+		end = start + d.grid.BeatLength() // The nearest beat is the in point, so loop at least one beat
+	}
+	d.engine.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("set a loop in point first")
+	}
+	return p.SetLoop(start, end)
+}
+
+// AutoLoop loops the given number of beats from the current beat, or the current fraction of a
+// beat for loops shorter than one beat, so the loop lines up with the beatgrid.
+func (d *Deck) AutoLoop(beats float64) error {
+	if beats < MinLoopBeats || beats > MaxLoopBeats {
+		return fmt.Errorf("loop length must be between 1/32 and 32 beats, got %g", beats)
+	}
+	p, grid, err := d.loopTarget()
+	if err != nil {
+		return err
+	}
+	unit := float64(grid.BeatLength()) * math.Min(beats, 1)
+	start := grid.Offset + time.Duration(math.Floor(float64(p.Position()-grid.Offset)/unit)*unit)
+	return p.SetLoop(start, start+time.Duration(beats*float64(grid.BeatLength())))
+}
+
+// HalveLoop halves the length of the current loop, keeping its start.
+func (d *Deck) HalveLoop() error {
+	return d.resizeLoop(0.5)
+}
+
+// DoubleLoop doubles the length of the current loop, keeping its start.
+func (d *Deck) DoubleLoop() error {
+	return d.resizeLoop(2)
+}
+
+// resizeLoop scales the length of the current loop by factor.
+func (d *Deck) resizeLoop(factor float64) error {
+	p := d.Player()
+	if p == nil {
+		return fmt.Errorf("no track loaded in deck %d", d.index+1)
+	}
+	start, end, _ := p.Loop()
+	if end <= start {
+		return fmt.Errorf("no loop set")
+	}
+	return p.SetLoop(start, start+time.Duration(float64(end-start)*factor))
+}
+
+// MoveLoop shifts the current loop by the given number of beats, negative to move it back.
+// A playing loop takes the playhead with it.
+func (d *Deck) MoveLoop(beats float64) error {
+	p, grid, err := d.loopTarget()
+	if err != nil {
+		return err
+	}
+	start, end, _ := p.Loop()
+	if end <= start {
+		return fmt.Errorf("no loop set")
+	}
+	shift := time.Duration(beats * float64(grid.BeatLength()))
+	if start+shift < 0 {
+		shift = -start
+	}
+	return p.SetLoop(start+shift, end+shift)
+}
+
+// SetLoop plays a stored loop, jumping to its start unless the playhead is already inside it.
+func (d *Deck) SetLoop(start, end time.Duration) error {
+	p := d.Player()
+	if p == nil {
+		return fmt.Errorf("no track loaded in deck %d", d.index+1)
+	}
+	if err := p.ExitLoop(); err != nil {
+		return err
+	}
+	if position := p.Position(); position < start || position >= end {
+		if err := p.Seek(start); err != nil {
+			return err
+		}
+	}
+	return p.SetLoop(start, end)
+}
+
+// Reloop turns the last loop back on and jumps to its start, or leaves a playing loop.
+func (d *Deck) Reloop() error {
+	p := d.Player()
+	if p == nil {
+		return fmt.Errorf("no track loaded in deck %d", d.index+1)
+	}
+	if _, _, active := p.Loop(); active {
+		return p.ExitLoop()
+	}
+	return p.Reloop()
+}
+
+// ExitLoop stops looping and plays on.
+func (d *Deck) ExitLoop() error {
+	if p := d.Player(); p != nil {
+		return p.ExitLoop()
+	}
+	return nil
+}
+
+// Loop returns the current or last loop of the deck's track and whether it is playing.
+func (d *Deck) Loop() (start, end time.Duration, active bool) {
+	if p := d.Player(); p != nil {
+		return p.Loop()
+	}
+	return 0, 0, false
+}
+
+// loopTarget returns the deck's track and beatgrid for a beat-based loop operation.
+func (d *Deck) loopTarget() (*Player, Beatgrid, error) {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	if d.player == nil {
+		return nil, Beatgrid{}, fmt.Errorf("no track loaded in deck %d", d.index+1)
+	}
+	if d.grid == nil {
+		return nil, Beatgrid{}, fmt.Errorf("the track in deck %d has no beatgrid yet", d.index+1)
+	}
+	return d.player, *d.grid, nil
+}
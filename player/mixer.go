@@ -0,0 +1,205 @@
+package player
+
+import (
+	"math"
+
+	"megajam/dsp"
+	"megajam/logger"
+)
+
+// CrossfaderCurve selects how the crossfader position maps to the levels of its two sides.
+type CrossfaderCurve int
+
+const (
+	// CurveLinear fades each side linearly, dipping in the middle.
+	CurveLinear CrossfaderCurve = iota
+	// CurveConstantPower keeps the overall loudness steady across the fade.
+	CurveConstantPower
+	// CurveCut keeps both sides at full level except at the very ends, for scratching.
+	CurveCut
+)
+
+// cutWidth is the part of the crossfader travel over which CurveCut fades a side out.
+const cutWidth = 0.05
+
+// CrossfaderSide assigns a deck to one side of the crossfader or bypasses it.
+type CrossfaderSide int
+
+const (
+	// SideThru leaves the deck unaffected by the crossfader.
+	SideThru CrossfaderSide = iota
+	// SideA puts the deck on the left of the crossfader.
+	SideA
+	// SideB puts the deck on the right of the crossfader.
+	SideB
+)
+
+// limiterCeiling is the highest sample level the master output reaches, just below full scale.
+const limiterCeiling = 0.98
+
+// crossfaderGains returns the gains of sides A and B at position, from 0 (all A) to 1 (all B).
+func crossfaderGains(position float64, curve CrossfaderCurve) (a, b float64) {
+	switch curve {
+	case CurveConstantPower:
+		return math.Cos(position * math.Pi / 2), math.Sin(position * math.Pi / 2)
+	case CurveCut:
+		return math.Min(1, (1-position)/cutWidth), math.Min(1, position/cutWidth)
+	default:
+		return 1 - position, position
+	}
+}
+
+// newChannel builds the channel strip of d: its track runs through the EQ, the filter, the tap
+// feeding the cue bus and then the channel gain, which combines trim, fader and crossfader.
+func (d *Deck) newChannel() {
+	rate := d.engine.format.SampleRate
+	d.eq = dsp.NewEQ(deckSource{d}, rate)
+	d.filter = dsp.NewFilter(d.eq, rate)
+	d.channel = dsp.NewGain(pflTap{d}, rate, 1)
+}
+
+// updateGain recalculates the channel gain from the deck's trim, fader and crossfader side;
+// callers must hold engine.mu.
+func (d *Deck) updateGain() {
+	d.channel.SetGain(d.channelGain())
+}
+
+// channelGain returns the gain of the trim, channel fader and crossfader together; callers
+// must hold the engine's mu.
+func (d *Deck) channelGain() float64 {
+	a, b := crossfaderGains(d.engine.crossfader, d.engine.curve)
+	gain := d.trim * d.volume * d.volume // The square gives the fader an audio taper
+	switch d.side {
+	case SideA:
+		gain *= a
+	case SideB:
+		gain *= b
+	}
+	return gain
+}
+
+// SetVolume sets the deck's channel fader, 0.0 (mute) to 1.0 (full level). It is kept when a
+// new track is loaded.
+func (d *Deck) SetVolume(volumeLevel float64) {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	d.volume = math.Max(0, math.Min(1, volumeLevel))
+	d.updateGain()
+}
+
+// SetTrim sets the deck's input gain in decibels, to level tracks mastered at different loudness.
+func (d *Deck) SetTrim(db float64) {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	d.trim = dsp.DecibelsToGain(db)
+	d.updateGain()
+}
+
+// SetEQ sets the linear gains of the deck's low, mid and high bands. 1 leaves a band unchanged
+// and 0 kills it.
+func (d *Deck) SetEQ(low, mid, high float64) {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	d.eq.SetGains(low, mid, high)
+}
+
+// SetFilter sets the position of the deck's filter knob, from -1 (low-pass) through 0 (off)
+// to 1 (high-pass).
+func (d *Deck) SetFilter(position float64) {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	d.filter.SetPosition(position)
+}
+
+// SetCrossfaderSide assigns the deck to a side of the crossfader.
+func (d *Deck) SetCrossfaderSide(side CrossfaderSide) {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	d.side = side
+	d.updateGain()
+}
+
+// SetCrossfader moves the crossfader, from 0 (side A only) to 1 (side B only).
+func (e *Engine) SetCrossfader(position float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.crossfader = math.Max(0, math.Min(1, position))
+	for _, deck := range e.decks {
+		deck.updateGain()
+	}
+}
+
+// SetCrossfaderCurve selects the crossfader curve.
+func (e *Engine) SetCrossfaderCurve(curve CrossfaderCurve) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.curve = curve
+	for _, deck := range e.decks {
+		deck.updateGain()
+	}
+	logger.Logger.Printf("Crossfader curve set to %d", curve)
+}
+
+// SetMasterLevel sets the master output level, 0.0 (mute) to 1.0 (full level). Peaks above
+// full scale are limited whatever the level.
+func (e *Engine) SetMasterLevel(level float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	level = math.Max(0, math.Min(1, level))
+	e.masterGain.SetGain(level * level)
+}
+
+// Limiting reports whether the master limiter is reducing peaks, to show a clip warning.
+func (e *Engine) Limiting() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.limiter.Limiting()
+}
+
+// deckSource streams a deck's loaded track, or silence while it is empty. The engine holds mu
+// while streaming.
+type deckSource struct {
+	d *Deck
+}
+
+func (s deckSource) Stream(samples [][2]float64) (n int, ok bool) {
+	if s.d.player == nil {
+		for i := range samples {
+			samples[i] = [2]float64{}
+		}
+		return len(samples), true
+	}
+	return s.d.player.Stream(samples)
+}
+
+func (s deckSource) Err() error {
+	return nil
+}
+
+// mixBus sums the channel strips of every deck. The engine holds mu while streaming.
+type mixBus struct {
+	e *Engine
+}
+
+func (m mixBus) Stream(samples [][2]float64) (n int, ok bool) {
+	e := m.e
+	if len(e.mixBuf) < len(samples) {
+		e.mixBuf = make([][2]float64, len(samples))
+	}
+	for i := range samples {
+		samples[i] = [2]float64{}
+	}
+	for _, deck := range e.decks {
+		buf := e.mixBuf[:len(samples)]
+		deck.channel.Stream(buf)
+		for i := range buf {
+			samples[i][0] += buf[i][0]
+			samples[i][1] += buf[i][1]
+		}
+	}
+	return len(samples), true
+}
+
+func (m mixBus) Err() error {
+	return nil
+}
@@ -2,112 +2,138 @@ package player
 
 import (
 	"fmt"
-	"os"
+	"math"
 	"sync"
 	"time"
 
+	"megajam/decoder"
+	"megajam/dsp"
 	"megajam/logger"
 
 	"github.com/rickcollette/megasound"
 	"github.com/rickcollette/megasound/effects"
-	"github.com/rickcollette/megasound/mp3"
-	"github.com/rickcollette/megasound/speaker"
 )
 
-type MP3Player struct {
-	streamer      megasound.StreamSeekCloser
-	format        megasound.Format
-	volumeCtrl    *effects.Volume
-	volumeMutex   sync.Mutex
-	playbackMutex sync.Mutex
-	paused        bool
-	done          chan bool
+// positionInterval is how often EventPosition is sent while a track plays. It is short
+// enough for the deck waveforms to scroll smoothly.
+const positionInterval = 40 * time.Millisecond
+
+// eventBuffer is the number of events a slow subscriber may fall behind before events are dropped.
+const eventBuffer = 16
+
+// EventType identifies the kind of Event sent to subscribers.
+type EventType int
+
+const (
+	// EventPosition is sent periodically while the track plays.
+	EventPosition EventType = iota
+	// EventEnded is sent once when the track plays to its end.
+	EventEnded
+)
+
+// Event reports a change in playback state.
+type Event struct {
+	Type     EventType
+	Position time.Duration
 }
 
-// NewMP3Player initializes a new MP3 player.
-func NewMP3Player(filePath string) (*MP3Player, error) {
-	logger.Logger.Println("Initializing MP3 player...")
+// resampleQuality is the interpolation quality used to convert the file's sample rate and playback rate.
+const resampleQuality = 4
 
-	f, err := os.Open(filePath)
-	if err != nil {
-		logger.Logger.Printf("Failed to open MP3 file: %v", err)
-		return nil, fmt.Errorf("failed to open MP3 file: %w", err)
-	}
-	logger.Logger.Println("MP3 file opened.")
+// MinRate and MaxRate bound the playback rate of a Player.
+const (
+	MinRate = 0.25
+	MaxRate = 4.0
+)
 
-	streamer, format, err := mp3.Decode(f)
-	if err != nil {
-		f.Close()
-		logger.Logger.Printf("Failed to decode MP3: %v", err)
-		return nil, fmt.Errorf("failed to decode MP3: %w", err)
-	}
-	logger.Logger.Println("MP3 decoded successfully.")
+// Player is a single decoded track. It produces audio when an Engine pulls from its deck.
+type Player struct {
+	streamer    megasound.StreamSeekCloser
+	loop        *looper              // Repeats the loop section between the decoder and the resampler
+	format      megasound.Format     // Format of the decoded file
+	outputRate  megasound.SampleRate // Sample rate the player produces
+	volumeCtrl  *effects.Volume
+	mu          sync.Mutex // Guards all fields below against the audio thread
+	resampler   *megasound.Resampler
+	stretch     *dsp.TimeStretch // Changes the tempo while key lock is on, nil otherwise
+	rate        float64          // Playback speed, 1 for the original tempo
+	keyLock     bool             // Keeps the pitch when the rate changes
+	chainStart  int              // File position when the resampler was last rebuilt
+	consumed    float64          // File samples played since chainStart
+	paused      bool
+	closed      bool
+	subscribers []chan Event
+	done        chan struct{} // Closed by Close to stop the position ticker
+}
+
+// NewPlayer decodes the audio file at filePath for playback at outputRate. The decoder is picked
+// from the file's contents, and the audio is resampled when the file uses a different rate.
+// The player starts paused.
+func NewPlayer(filePath string, outputRate megasound.SampleRate) (*Player, error) {
+	logger.Logger.Printf("Initializing player for '%s'...", filePath)
 
-	err = speaker.Init(format.SampleRate, format.SampleRate.N(time.Second/10))
+	streamer, format, err := decoder.Open(filePath)
 	if err != nil {
-		streamer.Close()
-		f.Close()
-		logger.Logger.Printf("Failed to initialize speaker: %v", err)
-		return nil, fmt.Errorf("failed to initialize speaker: %w", err)
+		logger.Logger.Printf("Failed to open audio file: %v", err)
+		return nil, err
 	}
-	logger.Logger.Println("Speaker initialized successfully.")
+	logger.Logger.Printf("Audio decoded successfully (%d Hz, %d channel(s)).", format.SampleRate, format.NumChannels)
 
 	volumeCtrl := &effects.Volume{
-		Streamer: streamer,
-		Base:     2,
-		Volume:   0, // No volume change by default
-		Silent:   false,
+		Base:   2,
+		Volume: 0, // No volume change by default
+		Silent: false,
 	}
 
-	return &MP3Player{
+	p := &Player{
 		streamer:   streamer,
+		loop:       &looper{s: streamer},
 		format:     format,
+		outputRate: outputRate,
 		volumeCtrl: volumeCtrl,
+		rate:       1,
 		paused:     true,
-		done:       make(chan bool),
-	}, nil
+		done:       make(chan struct{}),
+	}
+	p.resetChain()
+	go p.tickPosition()
+	return p, nil
 }
 
 // Play starts or resumes playback.
-func (p *MP3Player) Play() {
-	p.playbackMutex.Lock()
-	defer p.playbackMutex.Unlock()
+func (p *Player) Play() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
 	if p.paused {
-		speaker.Play(megasound.Seq(p.volumeCtrl, megasound.Callback(func() {
-			p.done <- true
-		})))
 		p.paused = false
 		logger.Logger.Println("Playback started.")
 	}
 }
 
 // Pause stops playback.
-func (p *MP3Player) Pause() {
-	p.playbackMutex.Lock()
-	defer p.playbackMutex.Unlock()
+func (p *Player) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
 	if !p.paused {
-		speaker.Lock()
 		p.paused = true
-		speaker.Unlock()
-		speaker.Clear()
 		logger.Logger.Println("Playback paused.")
 	}
 }
 
 // Paused checks if the player is currently paused.
-func (p *MP3Player) Paused() bool {
-	p.playbackMutex.Lock()
-	defer p.playbackMutex.Unlock()
+func (p *Player) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.paused
 }
 
 // SetVolume adjusts the volume of the player.
 // volumeLevel: 0.0 (mute) to 1.0 (max).
-func (p *MP3Player) SetVolume(volumeLevel float64) {
-	p.volumeMutex.Lock()
-	defer p.volumeMutex.Unlock()
+func (p *Player) SetVolume(volumeLevel float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
 	// Convert 0.0-1.0 range to natural volume control.
 	p.volumeCtrl.Volume = (volumeLevel - 0.5) * 2 // Scale to range [-1, 1]
@@ -115,9 +141,215 @@ func (p *MP3Player) SetVolume(volumeLevel float64) {
 	logger.Logger.Printf("Volume set to %.1f", p.volumeCtrl.Volume)
 }
 
+// SetRate sets the playback speed, where 1 is the original tempo. Pitch changes with the speed
+// unless key lock is on.
+func (p *Player) SetRate(rate float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rate = math.Max(MinRate, math.Min(MaxRate, rate))
+	if p.stretch != nil {
+		p.stretch.SetRatio(p.rate)
+	} else {
+		p.resampler.SetRatio(p.ratio())
+	}
+}
+
+// SetKeyLock sets whether the pitch is kept when the rate changes, by time-stretching
+// instead of resampling.
+func (p *Player) SetKeyLock(keyLock bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if keyLock == p.keyLock {
+		return nil
+	}
+	p.keyLock = keyLock
+	// The old chain has read ahead of the playback position, so rewind the decoder to it.
+	if err := p.streamer.Seek(p.sample()); err != nil {
+		return fmt.Errorf("failed to switch key lock: %w", err)
+	}
+	p.resetChain()
+	return nil
+}
+
+// KeyLock reports whether the pitch is kept when the rate changes.
+func (p *Player) KeyLock() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.keyLock
+}
+
+// Rate returns the playback speed.
+func (p *Player) Rate() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rate
+}
+
+// Format returns the sample format of the decoded file.
+func (p *Player) Format() megasound.Format {
+	return p.format
+}
+
+// Stream fills samples with the player's output, or with silence while paused.
+// It never drains; when the track ends the player pauses itself.
+func (p *Player) Stream(samples [][2]float64) (n int, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	filled := 0
+	if !p.paused {
+		filled, ok = p.volumeCtrl.Stream(samples)
+		p.consumed += float64(filled) * p.ratio()
+		if !ok || filled < len(samples) {
+			p.paused = true
+			p.publish(Event{Type: EventEnded, Position: p.position()})
+			logger.Logger.Println("Playback reached the end of the track.")
+		}
+	}
+	for i := filled; i < len(samples); i++ {
+		samples[i] = [2]float64{}
+	}
+	return len(samples), true
+}
+
+// Err returns the decoder error, if any.
+func (p *Player) Err() error {
+	return p.streamer.Err()
+}
+
+// Seek moves playback to position, clamped to the length of the track.
+func (p *Player) Seek(position time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.streamer.Seek(p.clampSample(p.format.SampleRate.N(position))); err != nil {
+		return fmt.Errorf("failed to seek: %w", err)
+	}
+	p.resetChain()
+	p.publish(Event{Type: EventPosition, Position: p.position()})
+	return nil
+}
+
+// Position returns the current playback position.
+func (p *Player) Position() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.position()
+}
+
+// Duration returns the length of the track.
+func (p *Player) Duration() time.Duration {
+	return p.format.SampleRate.D(p.streamer.Len())
+}
+
+// Subscribe returns a channel that receives playback events, and a function that cancels the
+// subscription. Events are dropped rather than blocking the audio thread when the channel is full.
+// The channel is closed when the subscription is cancelled or the player is closed.
+func (p *Player) Subscribe() (<-chan Event, func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	events := make(chan Event, eventBuffer)
+	if p.closed {
+		close(events)
+		return events, func() {}
+	}
+	p.subscribers = append(p.subscribers, events)
+
+	cancel := func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for i, subscriber := range p.subscribers {
+			if subscriber == events {
+				p.subscribers = append(p.subscribers[:i], p.subscribers[i+1:]...)
+				close(events)
+				return
+			}
+		}
+	}
+	return events, cancel
+}
+
 // Close releases resources.
-func (p *MP3Player) Close() {
+func (p *Player) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+	p.paused = true
+	close(p.done)
+	for _, subscriber := range p.subscribers {
+		close(subscriber)
+	}
+	p.subscribers = nil
 	p.streamer.Close()
-	speaker.Clear()
-	logger.Logger.Println("MP3Player closed.")
+	logger.Logger.Println("Player closed.")
+}
+
+// resetChain connects the looped decoded stream to the volume control through a resampler, which
+// converts the file to the output rate at the playback speed. With key lock on, the resampler only
+// converts the sample rate and a time-stretcher changes the speed. Both buffer ahead, so the chain
+// is rebuilt after every seek; callers must hold mu.
+func (p *Player) resetChain() {
+	p.chainStart = p.streamer.Position()
+	p.consumed = 0
+	if p.keyLock {
+		p.resampler = megasound.ResampleRatio(resampleQuality, p.ratio()/p.rate, p.loop)
+		p.stretch = dsp.NewTimeStretch(p.resampler, p.rate)
+		p.volumeCtrl.Streamer = p.stretch
+	} else {
+		p.resampler = megasound.ResampleRatio(resampleQuality, p.ratio(), p.loop)
+		p.stretch = nil
+		p.volumeCtrl.Streamer = p.resampler
+	}
+}
+
+// ratio returns the number of file samples played per output sample; callers must hold mu.
+func (p *Player) ratio() float64 {
+	return float64(p.format.SampleRate) / float64(p.outputRate) * p.rate
+}
+
+// position returns the playback position; callers must hold mu.
+func (p *Player) position() time.Duration {
+	return p.format.SampleRate.D(p.sample())
+}
+
+// sample returns the playback position in file samples. It counts the samples actually played
+// rather than the decoder position, which runs ahead by the resampler's buffer; callers must hold mu.
+func (p *Player) sample() int {
+	return p.clampSample(p.loop.wrap(p.chainStart, p.chainStart+int(p.consumed)))
+}
+
+// publish sends event to every subscriber without blocking; callers must hold mu.
+func (p *Player) publish(event Event) {
+	for _, subscriber := range p.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// tickPosition sends EventPosition while the track plays, until the player is closed.
+func (p *Player) tickPosition() {
+	ticker := time.NewTicker(positionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			if !p.paused {
+				p.publish(Event{Type: EventPosition, Position: p.position()})
+			}
+			p.mu.Unlock()
+		}
+	}
 }
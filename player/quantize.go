@@ -0,0 +1,89 @@
+package player
+
+import (
+	"fmt"
+	"time"
+)
+
+// SetQuantize sets whether cue points, loop points, jumps and play starts on the deck snap to
+// the nearest beat of its beatgrid.
+func (d *Deck) SetQuantize(quantize bool) {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	d.quantize = quantize
+}
+
+// Quantize reports whether the deck snaps to the beatgrid.
+func (d *Deck) Quantize() bool {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	return d.quantize
+}
+
+// Snap returns t moved to the nearest beat when quantize is on and the beatgrid is known,
+// and t unchanged otherwise.
+func (d *Deck) Snap(t time.Duration) time.Duration {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	return d.snap(t)
+}
+
+// snap is Snap for callers holding engine.mu.
+func (d *Deck) snap(t time.Duration) time.Duration {
+	if !d.quantize || d.grid == nil {
+		return t
+	}
+	if snapped := d.grid.Snap(t); snapped >= 0 {
+		return snapped
+	}
+	return t
+}
+
+// JumpTo moves the deck's track to t. With quantize on the jump lands on the beat nearest t,
+// and while playing it keeps the playhead's distance from its own nearest beat, so the track
+// stays in phase with the mix.
+func (d *Deck) JumpTo(t time.Duration) error {
+	d.engine.mu.Lock()
+	p := d.player
+	if p == nil {
+		d.engine.mu.Unlock()
+		return fmt.Errorf("no track loaded in deck %d", d.index+1)
+	}
+	target := d.snap(t)
+	if d.quantize && d.grid != nil && !p.Paused() {
+		position := p.Position()
+		target += position - d.snap(position)
+	}
+	d.engine.mu.Unlock()
+
+	if target < 0 {
+		target = 0
+	}
+	return p.Seek(target)
+}
+
+// Cue works like the cue button of a CD player. While playing it returns to the cue point and
+// pauses; while paused it sets the cue point at the playhead, snapped to the beat with quantize on.
+func (d *Deck) Cue() error {
+	d.engine.mu.Lock()
+	p := d.player
+	if p == nil {
+		d.engine.mu.Unlock()
+		return fmt.Errorf("no track loaded in deck %d", d.index+1)
+	}
+	if p.Paused() {
+		d.cuePoint = d.snap(p.Position())
+	} else {
+		p.Pause()
+	}
+	cuePoint := d.cuePoint
+	d.engine.mu.Unlock()
+	return p.Seek(cuePoint)
+}
+
+// CuePoint returns the deck's cue point, set by Cue.
+func (d *Deck) CuePoint() time.Duration {
+	d.engine.mu.Lock()
+	defer d.engine.mu.Unlock()
+	return d.cuePoint
+}
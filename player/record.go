@@ -0,0 +1,33 @@
+package player
+
+// audibleGain is the channel gain, about -20 dB, above which a playing deck counts as heard
+// in the master mix.
+const audibleGain = 0.1
+
+// MasterSink receives the finished master mix after every buffer, for recording or streaming
+// it. It runs on the audio thread and must not block or keep samples.
+type MasterSink func(samples [][2]float64)
+
+// SetMasterSink attaches sink under name, replacing any sink of that name; nil removes it.
+func (e *Engine) SetMasterSink(name string, sink MasterSink) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if sink == nil {
+		delete(e.masterSinks, name)
+		return
+	}
+	if e.masterSinks == nil {
+		e.masterSinks = make(map[string]MasterSink)
+	}
+	e.masterSinks[name] = sink
+}
+
+// Audible reports whether the deck is playing and turned up far enough by its trim, channel
+// fader and crossfader to be heard in the master mix.
+func (d *Deck) Audible() bool {
+	d.engine.mu.Lock()
+	p := d.player
+	gain := d.channelGain()
+	d.engine.mu.Unlock()
+	return p != nil && !p.Paused() && gain > audibleGain
+}
@@ -0,0 +1,156 @@
+package playlist
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Entry is a track of a playlist file with the details other players show for it.
+type Entry struct {
+	Path     string        // Absolute path, or a URL for streams
+	Title    string        // Display title, usually "Artist - Title"; empty when unknown
+	Duration time.Duration // Zero when unknown
+}
+
+// ReadFile reads the entries of an M3U, M3U8 or PLS playlist, chosen by its extension.
+// Relative paths are resolved against the playlist's folder.
+func ReadFile(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open playlist: %w", err)
+	}
+	defer f.Close()
+
+	dir := filepath.Dir(path)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".m3u", ".m3u8":
+		return ReadM3U(f, dir)
+	case ".pls":
+		return ReadPLS(f, dir)
+	}
+	return nil, fmt.Errorf("unsupported playlist format: %s", path)
+}
+
+// WriteFile writes entries as an M3U, M3U8 or PLS playlist, chosen by the extension of path.
+// Tracks inside the playlist's folder are written relative to it so the folder can be moved.
+func WriteFile(path string, entries []Entry) error {
+	var buf bytes.Buffer
+	dir := filepath.Dir(path)
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".m3u", ".m3u8":
+		err = WriteM3U(&buf, entries, dir)
+	case ".pls":
+		err = WritePLS(&buf, entries, dir)
+	default:
+		return fmt.Errorf("unsupported playlist format: %s", path)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write playlist: %w", err)
+	}
+	return nil
+}
+
+// Import reads a playlist file of any supported format, including the JSON written by Save,
+// into a playlist named after the file.
+func Import(path string) (*Playlist, error) {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return Load(path)
+	}
+	entries, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := NewPlaylist(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	for _, entry := range entries {
+		p.Tracks = append(p.Tracks, entry.Path)
+	}
+	return p, nil
+}
+
+// Export writes the playlist to path in the format of its extension. describe supplies the
+// title and duration of each track for the formats that list them; it may be nil.
+func (p *Playlist) Export(path string, describe func(track string) Entry) error {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return p.Save(path)
+	}
+
+	p.mu.Lock()
+	tracks := append([]string(nil), p.Tracks...)
+	p.mu.Unlock()
+	entries := make([]Entry, len(tracks))
+	for i, track := range tracks {
+		entries[i] = Entry{Path: track}
+		if describe != nil {
+			entries[i] = describe(track)
+			entries[i].Path = track
+		}
+	}
+	return WriteFile(path, entries)
+}
+
+// readText returns the lines of a playlist, decoding Latin-1 when the file is not UTF-8 as
+// older M3U files often are.
+func readText(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	text := string(data)
+	if !utf8.Valid(data) {
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	}
+	lines := strings.Split(text, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return lines, nil
+}
+
+// resolvePath turns a location from a playlist into an absolute path, resolving relative
+// paths against dir. URLs of streams are kept; file URLs become paths.
+func resolvePath(location, dir string) string {
+	if u, err := url.Parse(location); err == nil && len(u.Scheme) > 1 {
+		if u.Scheme != "file" {
+			return location
+		}
+		location = u.Path
+	}
+	if runtime.GOOS != "windows" {
+		// Playlists made on Windows separate folders with backslashes.
+		location = strings.ReplaceAll(location, `\`, "/")
+	}
+	location = filepath.FromSlash(location)
+	if !filepath.IsAbs(location) {
+		location = filepath.Join(dir, location)
+	}
+	return filepath.Clean(location)
+}
+
+// relativePath returns path relative to dir when it lies inside dir, and unchanged otherwise.
+func relativePath(path, dir string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return rel
+}
@@ -0,0 +1,78 @@
+package playlist
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteFileReadFile(t *testing.T) {
+	dir := t.TempDir()
+	entries := []Entry{
+		{Path: filepath.Join(dir, "tracks", "one.mp3"), Title: "Artist - One", Duration: 180 * time.Second},
+		{Path: filepath.Join(filepath.Dir(dir), "outside.flac"), Title: "Outside"},
+	}
+	for _, name := range []string{"set.m3u", "set.M3U8", "set.pls"} {
+		path := filepath.Join(dir, name)
+		if err := WriteFile(path, entries); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		read, err := ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(read, entries) {
+			t.Errorf("%s: read back %+v, want %+v", name, read, entries)
+		}
+	}
+
+	// The track inside the playlist's folder is written relative to it.
+	data, err := os.ReadFile(filepath.Join(dir, "set.m3u"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "\n"+filepath.Join("tracks", "one.mp3")+"\n") {
+		t.Errorf("track not written relative to the playlist:\n%s", data)
+	}
+
+	if err := WriteFile(filepath.Join(dir, "set.xspf"), entries); err == nil {
+		t.Error("WriteFile() accepted an unsupported format")
+	}
+	if _, err := ReadFile(filepath.Join(dir, "set.xspf")); err == nil {
+		t.Error("ReadFile() accepted an unsupported format")
+	}
+}
+
+func TestReadTextLatin1(t *testing.T) {
+	// "Café.mp3" encoded as Latin-1 is not valid UTF-8.
+	entries, err := ReadM3U(strings.NewReader("#EXTINF:10,Caf\xE9\r\nCaf\xE9.mp3\r\n"), filepath.FromSlash("/music"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{{Path: filepath.FromSlash("/music/Café.mp3"), Title: "Café", Duration: 10 * time.Second}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("ReadM3U() = %+v, want %+v", entries, want)
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Friday Set.m3u8")
+	if err := os.WriteFile(path, []byte("#EXTM3U\nb.mp3\na.mp3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Import(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Friday Set" {
+		t.Errorf("name = %q", p.Name)
+	}
+	want := []string{filepath.Join(dir, "b.mp3"), filepath.Join(dir, "a.mp3")}
+	if !reflect.DeepEqual(p.Tracks, want) {
+		t.Errorf("tracks = %v, want %v", p.Tracks, want)
+	}
+}
@@ -0,0 +1,72 @@
+package playlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadM3U reads a plain or extended M3U playlist. The duration and title of each track are
+// taken from the #EXTINF line before it, and other comments are skipped.
+func ReadM3U(r io.Reader, dir string) ([]Entry, error) {
+	lines, err := readText(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	var info Entry // From the last #EXTINF line
+	for _, line := range lines {
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			info = parseExtInf(strings.TrimPrefix(line, "#EXTINF:"))
+		case strings.HasPrefix(line, "#"):
+		default:
+			info.Path = resolvePath(line, dir)
+			entries = append(entries, info)
+			info = Entry{}
+		}
+	}
+	return entries, nil
+}
+
+// parseExtInf reads the "seconds,title" of an #EXTINF line. Attributes some players put
+// after the seconds are skipped.
+func parseExtInf(value string) Entry {
+	var entry Entry
+	length, title, _ := strings.Cut(value, ",")
+	entry.Title = strings.TrimSpace(title)
+	if fields := strings.Fields(length); len(fields) > 0 {
+		if seconds, err := strconv.ParseFloat(fields[0], 64); err == nil && seconds > 0 {
+			entry.Duration = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	return entry
+}
+
+// WriteM3U writes an extended M3U playlist with an #EXTINF line for every track.
+func WriteM3U(w io.Writer, entries []Entry, dir string) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "#EXTM3U")
+	for _, entry := range entries {
+		fmt.Fprintf(b, "#EXTINF:%d,%s\n", seconds(entry.Duration), entry.Title)
+		fmt.Fprintln(b, relativePath(entry.Path, dir))
+	}
+	if err := b.Flush(); err != nil {
+		return fmt.Errorf("failed to write playlist: %w", err)
+	}
+	return nil
+}
+
+// seconds returns d in whole seconds, or -1 when it is unknown.
+func seconds(d time.Duration) int {
+	if d <= 0 {
+		return -1
+	}
+	return int(math.Round(d.Seconds()))
+}
@@ -0,0 +1,94 @@
+package playlist
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadM3U(t *testing.T) {
+	dir := filepath.FromSlash("/music/sets")
+	input := "#EXTM3U\r\n" +
+		"#EXTINF:215,Artist - Opener\r\n" +
+		"opener.mp3\r\n" +
+		"\r\n" +
+		"# A comment between tracks\r\n" +
+		"#EXTINF:-1,Unknown Length\r\n" +
+		"../crates/second.flac\r\n" +
+		"/elsewhere/third.wav\r\n" +
+		"#EXTINF:61.6 tvg-id=\"x\" group-title=\"y\",Radio\r\n" +
+		"http://radio.example.com:8000/live\r\n" +
+		"file:///elsewhere/fourth%20track.mp3\r\n" +
+		`sub\fifth.mp3` + "\r\n"
+
+	entries, err := ReadM3U(strings.NewReader(input), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Path: filepath.FromSlash("/music/sets/opener.mp3"), Title: "Artist - Opener", Duration: 215 * time.Second},
+		{Path: filepath.FromSlash("/music/crates/second.flac"), Title: "Unknown Length"},
+		{Path: filepath.FromSlash("/elsewhere/third.wav")},
+		{Path: "http://radio.example.com:8000/live", Title: "Radio", Duration: 61600 * time.Millisecond},
+		{Path: filepath.FromSlash("/elsewhere/fourth track.mp3")},
+		{Path: filepath.FromSlash("/music/sets/sub/fifth.mp3")},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("ReadM3U() =\n%+v\nwant\n%+v", entries, want)
+	}
+}
+
+func TestReadM3UPlain(t *testing.T) {
+	// A plain M3U with a byte order mark and no #EXTM3U header.
+	input := "\xEF\xBB\xBFone.mp3\ntwo.mp3"
+	entries, err := ReadM3U(strings.NewReader(input), filepath.FromSlash("/music"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Path: filepath.FromSlash("/music/one.mp3")},
+		{Path: filepath.FromSlash("/music/two.mp3")},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("ReadM3U() = %+v, want %+v", entries, want)
+	}
+}
+
+func TestWriteM3U(t *testing.T) {
+	dir := filepath.FromSlash("/music/sets")
+	entries := []Entry{
+		{Path: filepath.FromSlash("/music/sets/opener.mp3"), Title: "Artist - Opener", Duration: 214600 * time.Millisecond},
+		{Path: filepath.FromSlash("/music/sets/deep/second.flac")},
+		{Path: filepath.FromSlash("/music/other/third.wav"), Title: "Third"},
+		{Path: "http://radio.example.com:8000/live", Title: "Radio"},
+	}
+	var b bytes.Buffer
+	if err := WriteM3U(&b, entries, dir); err != nil {
+		t.Fatal(err)
+	}
+	want := "#EXTM3U\n" +
+		"#EXTINF:215,Artist - Opener\n" +
+		"opener.mp3\n" +
+		"#EXTINF:-1,\n" +
+		filepath.FromSlash("deep/second.flac") + "\n" +
+		"#EXTINF:-1,Third\n" +
+		filepath.FromSlash("/music/other/third.wav") + "\n" +
+		"#EXTINF:-1,Radio\n" +
+		"http://radio.example.com:8000/live\n"
+	if b.String() != want {
+		t.Errorf("WriteM3U() =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	// Reading the playlist back gives the entries, with durations in whole seconds.
+	read, err := ReadM3U(&b, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries[0].Duration = 215 * time.Second
+	if !reflect.DeepEqual(read, entries) {
+		t.Errorf("read back %+v, want %+v", read, entries)
+	}
+}
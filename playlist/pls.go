@@ -0,0 +1,88 @@
+package playlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadPLS reads a PLS playlist. Entries are ordered by their number, which may skip values.
+func ReadPLS(r io.Reader, dir string) ([]Entry, error) {
+	lines, err := readText(r)
+	if err != nil {
+		return nil, err
+	}
+
+	byNumber := make(map[int]*Entry)
+	for _, line := range lines {
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue // The [playlist] header, blank lines and comments
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		var field string
+		for _, name := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, name) {
+				field = name
+				break
+			}
+		}
+		number, err := strconv.Atoi(strings.TrimPrefix(key, field))
+		if field == "" || err != nil {
+			continue // NumberOfEntries, Version and unknown keys
+		}
+		entry := byNumber[number]
+		if entry == nil {
+			entry = &Entry{}
+			byNumber[number] = entry
+		}
+		switch field {
+		case "file":
+			entry.Path = resolvePath(value, dir)
+		case "title":
+			entry.Title = value
+		case "length":
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				entry.Duration = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+
+	numbers := make([]int, 0, len(byNumber))
+	for number, entry := range byNumber {
+		if entry.Path != "" {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+	entries := make([]Entry, len(numbers))
+	for i, number := range numbers {
+		entries[i] = *byNumber[number]
+	}
+	return entries, nil
+}
+
+// WritePLS writes a version 2 PLS playlist.
+func WritePLS(w io.Writer, entries []Entry, dir string) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "[playlist]")
+	for i, entry := range entries {
+		fmt.Fprintf(b, "File%d=%s\n", i+1, relativePath(entry.Path, dir))
+		if entry.Title != "" {
+			fmt.Fprintf(b, "Title%d=%s\n", i+1, entry.Title)
+		}
+		fmt.Fprintf(b, "Length%d=%d\n", i+1, seconds(entry.Duration))
+	}
+	fmt.Fprintf(b, "NumberOfEntries=%d\n", len(entries))
+	fmt.Fprintln(b, "Version=2")
+	if err := b.Flush(); err != nil {
+		return fmt.Errorf("failed to write playlist: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package playlist
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadPLS(t *testing.T) {
+	// Entries are listed out of order and numbered with a gap; keys are matched in any case.
+	input := "\xEF\xBB\xBF[playlist]\r\n" +
+		"NumberOfEntries=4\r\n" +
+		"File3=/elsewhere/third.wav\r\n" +
+		"Title3=Third\r\n" +
+		"file1=opener.mp3\r\n" +
+		"Title1=Artist - Opener\r\n" +
+		"Length1=215\r\n" +
+		"\r\n" +
+		"File10=http://radio.example.com:8000/live\r\n" +
+		"Length10=-1\r\n" +
+		"Title7=Title without a file\r\n" +
+		"Version=2\r\n"
+
+	entries, err := ReadPLS(strings.NewReader(input), filepath.FromSlash("/music/sets"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Path: filepath.FromSlash("/music/sets/opener.mp3"), Title: "Artist - Opener", Duration: 215 * time.Second},
+		{Path: filepath.FromSlash("/elsewhere/third.wav"), Title: "Third"},
+		{Path: "http://radio.example.com:8000/live"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("ReadPLS() =\n%+v\nwant\n%+v", entries, want)
+	}
+}
+
+func TestWritePLS(t *testing.T) {
+	dir := filepath.FromSlash("/music/sets")
+	entries := []Entry{
+		{Path: filepath.FromSlash("/music/sets/opener.mp3"), Title: "Artist - Opener", Duration: 215 * time.Second},
+		{Path: filepath.FromSlash("/music/other/second.flac")},
+	}
+	var b bytes.Buffer
+	if err := WritePLS(&b, entries, dir); err != nil {
+		t.Fatal(err)
+	}
+	want := "[playlist]\n" +
+		"File1=opener.mp3\n" +
+		"Title1=Artist - Opener\n" +
+		"Length1=215\n" +
+		"File2=" + filepath.FromSlash("/music/other/second.flac") + "\n" +
+		"Length2=-1\n" +
+		"NumberOfEntries=2\n" +
+		"Version=2\n"
+	if b.String() != want {
+		t.Errorf("WritePLS() =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	read, err := ReadPLS(&b, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, entries) {
+		t.Errorf("read back %+v, want %+v", read, entries)
+	}
+}
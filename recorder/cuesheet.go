@@ -0,0 +1,60 @@
+package recorder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// cueFramesPerSecond is the resolution of cue sheet timestamps, in CD frames.
+const cueFramesPerSecond = 75
+
+// WriteCueSheet writes a cue sheet that splits the audio file into tracks.
+func WriteCueSheet(w io.Writer, title, file string, tracks []Track) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "PERFORMER \"Megajam\"\n")
+	fmt.Fprintf(b, "TITLE %s\n", cueString(title))
+	fmt.Fprintf(b, "FILE %s WAVE\n", cueString(file))
+	for i, track := range tracks {
+		fmt.Fprintf(b, "  TRACK %02d AUDIO\n", i+1)
+		fmt.Fprintf(b, "    TITLE %s\n", cueString(track.Title))
+		if track.Performer != "" {
+			fmt.Fprintf(b, "    PERFORMER %s\n", cueString(track.Performer))
+		}
+		fmt.Fprintf(b, "    INDEX 01 %s\n", cueTimestamp(track.Start))
+	}
+	return b.Flush()
+}
+
+// WriteTracklist writes the tracks as plain text, one "hh:mm:ss Performer - Title" per line.
+func WriteTracklist(w io.Writer, title string, tracks []Track) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "%s\n\n", title)
+	for _, track := range tracks {
+		name := track.Title
+		if track.Performer != "" {
+			name = track.Performer + " - " + track.Title
+		}
+		fmt.Fprintf(b, "%s %s\n", formatTimestamp(track.Start), name)
+	}
+	return b.Flush()
+}
+
+// cueString quotes s for a cue sheet, which has no escape for double quotes.
+func cueString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+}
+
+// cueTimestamp formats d as the mm:ss:ff of a cue sheet index; minutes are not limited to 99.
+func cueTimestamp(d time.Duration) string {
+	frames := int64(d) * cueFramesPerSecond / int64(time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", frames/(60*cueFramesPerSecond), frames/cueFramesPerSecond%60, frames%cueFramesPerSecond)
+}
+
+// formatTimestamp formats d as hh:mm:ss.
+func formatTimestamp(d time.Duration) string {
+	seconds := int64(d / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
@@ -0,0 +1,524 @@
+package recorder
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"os"
+)
+
+const (
+	// flacBlockSize is the number of samples per channel in each FLAC frame.
+	flacBlockSize = 4096
+	// maxFixedOrder is the highest order of the fixed predictors tried for each subframe.
+	maxFixedOrder = 4
+	// maxPartitionOrder is the finest split of a residual into Rice partitions that is tried.
+	maxPartitionOrder = 6
+	// maxRiceParameter is the largest Rice parameter of the 5-bit parameter coding.
+	maxRiceParameter = 30
+	// streamInfoSize is the length of the STREAMINFO metadata block body.
+	streamInfoSize = 34
+)
+
+// Subframe types, as written in the subframe header.
+const (
+	subframeConstant = 0
+	subframeVerbatim = 1
+	subframeFixed    = 8 // Plus the predictor order
+)
+
+// Channel assignments, as written in the frame header.
+const (
+	independentStereo = 1
+	leftSide          = 8
+	rightSide         = 9
+	midSide           = 10
+)
+
+// flacWriter encodes 16-bit stereo to a FLAC file with fixed linear prediction and Rice-coded
+// residuals. Each frame picks the stereo decorrelation and predictor order that codes smallest.
+// The stream length, frame sizes and MD5 signature are filled in on close.
+type flacWriter struct {
+	f            *os.File
+	w            *bufio.Writer
+	sampleRate   int
+	pending      [][2]int16 // Samples waiting for a full block
+	frames       uint64
+	totalSamples uint64
+	bytes        int64
+	minFrame     int
+	maxFrame     int
+	md5          hash.Hash
+	md5Buf       []byte
+
+	bits     bitWriter
+	channels [4][]int32 // Left, right, mid and side of the current block
+	residual []int32
+}
+
+// subframe describes how one channel of a frame is coded.
+type subframe struct {
+	kind           int
+	order          int
+	partitionOrder int
+	parameters     []int
+	bits           int // Coded size, estimated for the Rice partitions
+}
+
+// newFLACWriter creates the file at path and writes the stream header for an empty recording.
+func newFLACWriter(path string, sampleRate int) (*flacWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+	w := &flacWriter{
+		f:          f,
+		w:          bufio.NewWriterSize(f, 64*1024),
+		sampleRate: sampleRate,
+		md5:        md5.New(),
+		residual:   make([]int32, flacBlockSize),
+	}
+	for i := range w.channels {
+		w.channels[i] = make([]int32, flacBlockSize)
+	}
+
+	header := append([]byte("fLaC"), 0x80, 0, 0, streamInfoSize) // Last metadata block, STREAMINFO
+	header = append(header, w.streamInfo()...)
+	n, err := w.w.Write(header)
+	w.bytes += int64(n)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write FLAC header: %w", err)
+	}
+	return w, nil
+}
+
+// streamInfo returns the STREAMINFO block describing the samples written so far.
+func (w *flacWriter) streamInfo() []byte {
+	info := make([]byte, streamInfoSize)
+	binary.BigEndian.PutUint16(info[0:], flacBlockSize)
+	binary.BigEndian.PutUint16(info[2:], flacBlockSize)
+	putUint24(info[4:], uint32(w.minFrame))
+	putUint24(info[7:], uint32(w.maxFrame))
+	binary.BigEndian.PutUint64(info[10:], uint64(w.sampleRate)<<44|(channels-1)<<41|(bitsPerSample-1)<<36|w.totalSamples&(1<<36-1))
+	if w.totalSamples > 0 {
+		copy(info[18:], w.md5.Sum(nil))
+	}
+	return info
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v>>16), byte(v>>8), byte(v)
+}
+
+func (w *flacWriter) write(samples [][2]int16) error {
+	if len(w.md5Buf) < len(samples)*4 {
+		w.md5Buf = make([]byte, len(samples)*4)
+	}
+	for i, frame := range samples {
+		binary.LittleEndian.PutUint16(w.md5Buf[i*4:], uint16(frame[0]))
+		binary.LittleEndian.PutUint16(w.md5Buf[i*4+2:], uint16(frame[1]))
+	}
+	w.md5.Write(w.md5Buf[:len(samples)*4])
+
+	w.pending = append(w.pending, samples...)
+	start := 0
+	for len(w.pending)-start >= flacBlockSize {
+		if err := w.writeFrame(w.pending[start : start+flacBlockSize]); err != nil {
+			return err
+		}
+		start += flacBlockSize
+	}
+	w.pending = w.pending[:copy(w.pending, w.pending[start:])]
+	return nil
+}
+
+func (w *flacWriter) size() int64 {
+	return w.bytes
+}
+
+// close codes the remaining samples as a short final frame, fills in the stream header and
+// closes the file.
+func (w *flacWriter) close() error {
+	var err error
+	if len(w.pending) > 0 {
+		err = w.writeFrame(w.pending)
+	}
+	if err == nil {
+		if err = w.w.Flush(); err != nil {
+			err = fmt.Errorf("failed to write FLAC frame: %w", err)
+		}
+	}
+	if err == nil {
+		if _, err = w.f.WriteAt(w.streamInfo(), 8); err != nil {
+			err = fmt.Errorf("failed to finish FLAC header: %w", err)
+		}
+	}
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// writeFrame codes block as one frame.
+func (w *flacWriter) writeFrame(block [][2]int16) error {
+	n := len(block)
+	left, right, mid, side := w.channels[0][:n], w.channels[1][:n], w.channels[2][:n], w.channels[3][:n]
+	for i, frame := range block {
+		l, r := int32(frame[0]), int32(frame[1])
+		left[i], right[i], mid[i], side[i] = l, r, (l+r)>>1, l-r
+	}
+	plans := [4]subframe{
+		w.plan(left, bitsPerSample),
+		w.plan(right, bitsPerSample),
+		w.plan(mid, bitsPerSample),
+		w.plan(side, bitsPerSample+1), // The difference needs an extra bit
+	}
+
+	// Pick the stereo coding with the smallest pair of subframes.
+	assignment, first, second := independentStereo, 0, 1
+	best := plans[0].bits + plans[1].bits
+	for _, option := range []struct{ assignment, first, second int }{
+		{leftSide, 0, 3},
+		{rightSide, 3, 1},
+		{midSide, 2, 3},
+	} {
+		if bits := plans[option.first].bits + plans[option.second].bits; bits < best {
+			assignment, first, second, best = option.assignment, option.first, option.second, bits
+		}
+	}
+
+	b := &w.bits
+	b.reset()
+	b.write(0xFFF8, 16)             // Sync code, fixed block size
+	blockSizeCode := uint64(0b0111) // 16-bit block size at the end of the header
+	if n == flacBlockSize {
+		blockSizeCode = 0b1100
+	}
+	b.write(blockSizeCode, 4)
+	b.write(sampleRateCode(w.sampleRate), 4)
+	b.write(uint64(assignment), 4)
+	b.write(0b100, 3) // 16 bits per sample
+	b.write(0, 1)
+	b.writeBytes(utf8Number(w.frames))
+	if blockSizeCode == 0b0111 {
+		b.write(uint64(n-1), 16)
+	}
+	b.write(uint64(crc8(b.buf)), 8)
+
+	for _, channel := range []int{first, second} {
+		bps := bitsPerSample
+		if channel == 3 {
+			bps++
+		}
+		w.writeSubframe(w.channels[channel][:n], bps, plans[channel])
+	}
+	b.align()
+	crc := crc16(b.buf)
+	b.write(uint64(crc), 16)
+
+	written, err := w.w.Write(b.buf)
+	w.bytes += int64(written)
+	if err != nil {
+		return fmt.Errorf("failed to write FLAC frame: %w", err)
+	}
+	if w.minFrame == 0 || len(b.buf) < w.minFrame {
+		w.minFrame = len(b.buf)
+	}
+	w.maxFrame = max(w.maxFrame, len(b.buf))
+	w.frames++
+	w.totalSamples += uint64(n)
+	return nil
+}
+
+// sampleRateCode returns the frame header code of rate, or zero to refer to the stream header.
+func sampleRateCode(rate int) uint64 {
+	switch rate {
+	case 88200:
+		return 0b0001
+	case 176400:
+		return 0b0010
+	case 192000:
+		return 0b0011
+	case 32000:
+		return 0b1000
+	case 44100:
+		return 0b1001
+	case 48000:
+		return 0b1010
+	case 96000:
+		return 0b1011
+	}
+	return 0
+}
+
+// utf8Number codes a frame number the way UTF-8 codes a character, extended to 36 bits.
+func utf8Number(v uint64) []byte {
+	if v < 0x80 {
+		return []byte{byte(v)}
+	}
+	n := 2
+	for v >= 1<<(5*n+1) {
+		n++
+	}
+	out := make([]byte, n)
+	for i := n - 1; i > 0; i-- {
+		out[i] = 0x80 | byte(v&0x3F)
+		v >>= 6
+	}
+	out[0] = byte(0xFF<<(8-n)) | byte(v)
+	return out
+}
+
+// plan finds the smallest coding of the samples x of a bps-bit channel.
+func (w *flacWriter) plan(x []int32, bps int) subframe {
+	n := len(x)
+	constant := true
+	for _, v := range x[1:] {
+		if v != x[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		return subframe{kind: subframeConstant, bits: 8 + bps}
+	}
+
+	best := subframe{kind: subframeVerbatim, bits: 8 + n*bps}
+	for order := 0; order <= maxFixedOrder && order < n; order++ {
+		residual := w.residual[:n-order]
+		fixedResidual(x, order, residual)
+		candidate := planResidual(residual, n, order)
+		candidate.kind = subframeFixed
+		candidate.order = order
+		candidate.bits += 8 + order*bps
+		if candidate.bits < best.bits {
+			best = candidate
+		}
+	}
+	return best
+}
+
+// fixedResidual writes the prediction error of the fixed predictor of order for x[order:] to out.
+func fixedResidual(x []int32, order int, out []int32) {
+	for i := order; i < len(x); i++ {
+		var r int32
+		switch order {
+		case 0:
+			r = x[i]
+		case 1:
+			r = x[i] - x[i-1]
+		case 2:
+			r = x[i] - 2*x[i-1] + x[i-2]
+		case 3:
+			r = x[i] - 3*x[i-1] + 3*x[i-2] - x[i-3]
+		case 4:
+			r = x[i] - 4*x[i-1] + 6*x[i-2] - 4*x[i-3] + x[i-4]
+		}
+		out[i-order] = r
+	}
+}
+
+// planResidual picks the Rice partition order and parameters for the residual of a block of n
+// samples whose first order samples are stored verbatim.
+func planResidual(residual []int32, n, order int) subframe {
+	// Sum the folded residuals of the finest partitions, then merge them for the coarser ones.
+	finest := 0
+	for finest < maxPartitionOrder && n%(1<<(finest+1)) == 0 && n>>(finest+1) > order {
+		finest++
+	}
+	sums := make([]uint64, 1<<finest)
+	partitionSize := n >> finest
+	for i, r := range residual {
+		sums[(i+order)/partitionSize] += uint64(zigzag(r))
+	}
+
+	best := subframe{bits: -1}
+	for partitionOrder := finest; partitionOrder >= 0; partitionOrder-- {
+		count := n >> partitionOrder
+		parameters := make([]int, len(sums))
+		bits := 2 + 4
+		wide := false
+		for i, sum := range sums {
+			samples := count
+			if i == 0 {
+				samples -= order
+			}
+			k := riceParameter(sum, samples)
+			parameters[i] = k
+			bits += riceBits(sum, samples, k)
+			wide = wide || k > 14
+		}
+		if wide {
+			bits += 5 * len(sums)
+		} else {
+			bits += 4 * len(sums)
+		}
+		if best.bits < 0 || bits < best.bits {
+			best = subframe{partitionOrder: partitionOrder, parameters: parameters, bits: bits}
+		}
+		// Merge neighbouring partitions for the next coarser order.
+		for i := 0; i < len(sums)/2; i++ {
+			sums[i] = sums[2*i] + sums[2*i+1]
+		}
+		sums = sums[:len(sums)/2]
+	}
+	return best
+}
+
+// riceParameter returns the Rice parameter that codes samples values summing to sum smallest.
+func riceParameter(sum uint64, samples int) int {
+	best, bestBits := 0, riceBits(sum, samples, 0)
+	for k := 1; k <= maxRiceParameter; k++ {
+		bits := riceBits(sum, samples, k)
+		if bits >= bestBits {
+			break
+		}
+		best, bestBits = k, bits
+	}
+	return best
+}
+
+// riceBits estimates the coded size of samples values summing to sum with Rice parameter k.
+func riceBits(sum uint64, samples, k int) int {
+	return samples*(k+1) + int(sum>>k)
+}
+
+// zigzag folds a signed residual into an unsigned one for Rice coding.
+func zigzag(r int32) uint32 {
+	return uint32(r<<1) ^ uint32(r>>31)
+}
+
+// writeSubframe writes the samples x of a bps-bit channel as planned.
+func (w *flacWriter) writeSubframe(x []int32, bps int, plan subframe) {
+	b := &w.bits
+	b.write(0, 1)
+	switch plan.kind {
+	case subframeConstant:
+		b.write(subframeConstant, 6)
+		b.write(0, 1)
+		b.writeSigned(x[0], bps)
+		return
+	case subframeVerbatim:
+		b.write(subframeVerbatim, 6)
+		b.write(0, 1)
+		for _, v := range x {
+			b.writeSigned(v, bps)
+		}
+		return
+	}
+
+	b.write(uint64(subframeFixed+plan.order), 6)
+	b.write(0, 1)
+	for _, v := range x[:plan.order] {
+		b.writeSigned(v, bps)
+	}
+	residual := w.residual[:len(x)-plan.order]
+	fixedResidual(x, plan.order, residual)
+
+	parameterBits := 4
+	for _, k := range plan.parameters {
+		if k > 14 {
+			parameterBits = 5
+		}
+	}
+	b.write(uint64(parameterBits-4), 2) // Rice coding with 4- or 5-bit parameters
+	b.write(uint64(plan.partitionOrder), 4)
+	partitionSize := len(x) >> plan.partitionOrder
+	i := 0
+	for p, k := range plan.parameters {
+		b.write(uint64(k), parameterBits)
+		end := (p+1)*partitionSize - plan.order
+		for ; i < end; i++ {
+			u := zigzag(residual[i])
+			b.writeUnary(u >> k)
+			b.write(uint64(u), k)
+		}
+	}
+}
+
+// bitWriter packs values most significant bit first into a byte buffer.
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	bits int // Bits held in acc
+}
+
+func (b *bitWriter) reset() {
+	b.buf, b.acc, b.bits = b.buf[:0], 0, 0
+}
+
+// write appends the low bits of v, up to 32.
+func (b *bitWriter) write(v uint64, bits int) {
+	if bits == 0 {
+		return
+	}
+	b.acc = b.acc<<bits | v&(1<<bits-1)
+	b.bits += bits
+	for b.bits >= 8 {
+		b.bits -= 8
+		b.buf = append(b.buf, byte(b.acc>>b.bits))
+	}
+	b.acc &= 1<<b.bits - 1
+}
+
+func (b *bitWriter) writeSigned(v int32, bits int) {
+	b.write(uint64(uint32(v)), bits)
+}
+
+// writeUnary appends q zero bits and a one.
+func (b *bitWriter) writeUnary(q uint32) {
+	for q >= 32 {
+		b.write(0, 32)
+		q -= 32
+	}
+	b.write(1, int(q)+1)
+}
+
+func (b *bitWriter) writeBytes(bytes []byte) {
+	for _, v := range bytes {
+		b.write(uint64(v), 8)
+	}
+}
+
+// align pads with zero bits to the next byte boundary.
+func (b *bitWriter) align() {
+	if b.bits > 0 {
+		b.write(0, 8-b.bits)
+	}
+}
+
+// crc8 is the frame header checksum, polynomial x^8 + x^2 + x + 1.
+func crc8(data []byte) byte {
+	var crc byte
+	for _, v := range data {
+		crc ^= v
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// crc16 is the frame checksum, polynomial x^16 + x^15 + x^2 + 1.
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, v := range data {
+		crc ^= uint16(v) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
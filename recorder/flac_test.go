@@ -0,0 +1,142 @@
+package recorder
+
+import (
+	"crypto/md5"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/mewkiz/flac"
+)
+
+// encodeFLAC writes samples to a FLAC file in chunks of chunkSize frames and returns its path.
+func encodeFLAC(t *testing.T, sampleRate int, samples [][2]int16, chunkSize int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mix.flac")
+	w, err := newFLACWriter(path, sampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for start := 0; start < len(samples); start += chunkSize {
+		if err := w.write(samples[start:min(start+chunkSize, len(samples))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// decodeFLAC decodes the FLAC file at path, checking its stream header and frame checksums.
+func decodeFLAC(t *testing.T, path string, sampleRate int, frames int) [][2]int16 {
+	t.Helper()
+	stream, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	info := stream.Info
+	if int(info.SampleRate) != sampleRate || info.NChannels != 2 || info.BitsPerSample != 16 {
+		t.Fatalf("stream info = %d Hz, %d channels, %d bits", info.SampleRate, info.NChannels, info.BitsPerSample)
+	}
+	if info.NSamples != uint64(frames) {
+		t.Fatalf("stream info has %d samples, want %d", info.NSamples, frames)
+	}
+
+	var decoded [][2]int16
+	sum := md5.New()
+	for {
+		frame, err := stream.ParseNext()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("frame %d: %v", len(decoded)/flacBlockSize, err)
+		}
+		frame.Hash(sum)
+		if n := int(frame.BlockSize); n > flacBlockSize || int(frame.Subframes[0].NSamples) != n {
+			t.Fatalf("frame of %d samples", n)
+		}
+		left, right := frame.Subframes[0].Samples, frame.Subframes[1].Samples
+		for i := range left {
+			decoded = append(decoded, [2]int16{int16(left[i]), int16(right[i])})
+		}
+	}
+	if frames > 0 && string(sum.Sum(nil)) != string(info.MD5sum[:]) {
+		t.Error("MD5 signature does not match the decoded audio")
+	}
+	return decoded
+}
+
+func TestFLACRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	signal := func(n int, f func(i int) [2]int16) [][2]int16 {
+		samples := make([][2]int16, n)
+		for i := range samples {
+			samples[i] = f(i)
+		}
+		return samples
+	}
+	sine := func(i int) [2]int16 {
+		v := int16(20000 * math.Sin(float64(i)*2*math.Pi*440/44100))
+		return [2]int16{v, v / 2}
+	}
+
+	tests := []struct {
+		name    string
+		samples [][2]int16
+	}{
+		{"empty", nil},
+		{"silence", signal(2*flacBlockSize, func(int) [2]int16 { return [2]int16{} })},
+		{"constant", signal(flacBlockSize+1, func(int) [2]int16 { return [2]int16{1234, -1234} })},
+		{"full scale constant", signal(flacBlockSize, func(int) [2]int16 { return [2]int16{math.MaxInt16, math.MinInt16} })},
+		{"sine, short final block", signal(3*flacBlockSize+777, sine)},
+		{"identical channels", signal(flacBlockSize+3, func(i int) [2]int16 { v := sine(i)[0]; return [2]int16{v, v} })},
+		{"noise", signal(2*flacBlockSize+1, func(int) [2]int16 {
+			return [2]int16{int16(rng.Intn(1 << 16)), int16(rng.Intn(1 << 16))}
+		})},
+		{"full scale square", signal(flacBlockSize+100, func(i int) [2]int16 {
+			if i/7%2 == 0 {
+				return [2]int16{math.MaxInt16, math.MinInt16}
+			}
+			return [2]int16{math.MinInt16, math.MaxInt16}
+		})},
+		{"single sample", [][2]int16{{-5, 5}}},
+	}
+	for _, tt := range tests {
+		for _, chunkSize := range []int{1000, flacBlockSize} {
+			path := encodeFLAC(t, 44100, tt.samples, chunkSize)
+			decoded := decodeFLAC(t, path, 44100, len(tt.samples))
+			if len(decoded) != len(tt.samples) {
+				t.Errorf("%s, chunks of %d: decoded %d samples, want %d", tt.name, chunkSize, len(decoded), len(tt.samples))
+				continue
+			}
+			for i := range decoded {
+				if decoded[i] != tt.samples[i] {
+					t.Errorf("%s, chunks of %d: sample %d = %v, want %v", tt.name, chunkSize, i, decoded[i], tt.samples[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestFLACSampleRates(t *testing.T) {
+	samples := make([][2]int16, 500)
+	for i := range samples {
+		samples[i] = [2]int16{int16(i * 50), int16(-i * 50)}
+	}
+	for _, rate := range []int{22050, 32000, 44100, 48000, 88200, 96000, 192000, 37800} {
+		decoded := decodeFLAC(t, encodeFLAC(t, rate, samples, len(samples)), rate, len(samples))
+		for i := range decoded {
+			if decoded[i] != samples[i] {
+				t.Fatalf("%d Hz: sample %d = %v, want %v", rate, i, decoded[i], samples[i])
+			}
+		}
+	}
+}
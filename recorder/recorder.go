@@ -0,0 +1,319 @@
+package recorder
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"megajam/logger"
+)
+
+const (
+	channels      = 2
+	bitsPerSample = 16
+	// queueLength is the number of audio buffers held for the writer before new ones are dropped.
+	queueLength = 256
+)
+
+// Format selects the file format of a recording.
+type Format int
+
+const (
+	// WAV writes uncompressed 16-bit PCM.
+	WAV Format = iota
+	// FLAC writes losslessly compressed 16-bit audio.
+	FLAC
+)
+
+// ParseFormat returns the format named by name, "wav" or "flac" in any case.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "wav":
+		return WAV, nil
+	case "flac":
+		return FLAC, nil
+	}
+	return WAV, fmt.Errorf("unknown recording format '%s'", name)
+}
+
+// Extension returns the file extension of the format, including the dot.
+func (f Format) Extension() string {
+	if f == FLAC {
+		return ".flac"
+	}
+	return ".wav"
+}
+
+// String returns the name of the format.
+func (f Format) String() string {
+	if f == FLAC {
+		return "FLAC"
+	}
+	return "WAV"
+}
+
+// encoder writes 16-bit stereo samples to a file.
+type encoder interface {
+	write(samples [][2]int16) error
+	size() int64 // Bytes written so far
+	close() error
+}
+
+// Recorder writes audio handed to it from the audio thread to a file on a background goroutine,
+// and keeps a tracklist of the tracks heard in the recording.
+type Recorder struct {
+	path       string
+	format     Format
+	sampleRate int
+	started    time.Time
+	enc        encoder
+
+	mu      sync.Mutex // Guards stopped, gap and the queue against Stop
+	stopped bool
+	gap     int // Frames dropped since the last queued buffer, still to be written as silence
+	queue   chan chunk
+	free    chan [][2]float64 // Buffers returned by the writer for reuse
+	done    chan struct{}
+	err     error // First write error, read after done is closed
+
+	frames  atomic.Int64 // Sample frames received; dropped ones are written as silence
+	bytes   atomic.Int64
+	dropped atomic.Int64
+
+	tracksMu sync.Mutex
+	tracks   []Track
+}
+
+// chunk is a buffer of audio for the writer, preceded by silence standing in for the frames
+// dropped before it, so that the file keeps time with the tracklist.
+type chunk struct {
+	silence int
+	samples [][2]float64
+}
+
+// Track is an entry of a recording's tracklist.
+type Track struct {
+	Start     time.Duration // When the track became audible, from the start of the recording
+	Performer string
+	Title     string
+}
+
+// New creates the file at path and starts a recorder writing sampleRate audio to it in format.
+func New(path string, format Format, sampleRate int) (*Recorder, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create recordings folder: %w", err)
+	}
+	var enc encoder
+	var err error
+	switch format {
+	case FLAC:
+		enc, err = newFLACWriter(path, sampleRate)
+	default:
+		enc, err = newWAVWriter(path, sampleRate)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := start(path, format, sampleRate, enc)
+	logger.Logger.Printf("Recording started: %s", path)
+	return r, nil
+}
+
+// start starts a recorder writing to enc.
+func start(path string, format Format, sampleRate int, enc encoder) *Recorder {
+	r := &Recorder{
+		path:       path,
+		format:     format,
+		sampleRate: sampleRate,
+		started:    time.Now(),
+		enc:        enc,
+		queue:      make(chan chunk, queueLength),
+		free:       make(chan [][2]float64, queueLength),
+		done:       make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Write queues a copy of samples for the file. It is called on the audio thread and never
+// blocks; if the writer falls behind, the buffer is dropped and silence of the same length is
+// written in its place.
+func (r *Recorder) Write(samples [][2]float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	r.frames.Add(int64(len(samples)))
+
+	var buf [][2]float64
+	select {
+	case buf = <-r.free:
+	default:
+	}
+	if cap(buf) < len(samples) {
+		buf = make([][2]float64, len(samples))
+	}
+	buf = buf[:len(samples)]
+	copy(buf, samples)
+	select {
+	case r.queue <- chunk{silence: r.gap, samples: buf}:
+		r.gap = 0
+	default:
+		r.gap += len(samples)
+		r.dropped.Add(int64(len(samples)))
+	}
+}
+
+// run writes queued buffers to the file until the queue is closed.
+func (r *Recorder) run() {
+	defer close(r.done)
+	var pcm [][2]int16
+	for c := range r.queue {
+		if r.err == nil && c.silence > 0 {
+			r.err = r.writeSilence(c.silence)
+		}
+		if buf := c.samples; r.err == nil && len(buf) > 0 {
+			if len(pcm) < len(buf) {
+				pcm = make([][2]int16, len(buf))
+			}
+			for i, frame := range buf {
+				pcm[i] = [2]int16{toInt16(frame[0]), toInt16(frame[1])}
+			}
+			r.err = r.enc.write(pcm[:len(buf)])
+		}
+		r.bytes.Store(r.enc.size())
+		if c.samples != nil {
+			select {
+			case r.free <- c.samples:
+			default:
+			}
+		}
+	}
+}
+
+// writeSilence writes frames of silence to the file.
+func (r *Recorder) writeSilence(frames int) error {
+	silence := make([][2]int16, min(frames, 4096))
+	for frames > 0 {
+		n := min(frames, len(silence))
+		if err := r.enc.write(silence[:n]); err != nil {
+			return err
+		}
+		frames -= n
+	}
+	return nil
+}
+
+// toInt16 converts a sample from -1..1 to 16-bit, clipping anything beyond full scale.
+func toInt16(v float64) int16 {
+	return int16(math.Round(math.Max(-1, math.Min(1, v)) * math.MaxInt16))
+}
+
+// Path returns the file the recording is written to.
+func (r *Recorder) Path() string {
+	return r.path
+}
+
+// Elapsed returns the length of audio recorded so far, including any silence written for
+// dropped audio.
+func (r *Recorder) Elapsed() time.Duration {
+	return time.Duration(r.frames.Load()) * time.Second / time.Duration(r.sampleRate)
+}
+
+// Dropped returns the length of audio dropped because the disk fell behind, which the file
+// holds as silence.
+func (r *Recorder) Dropped() time.Duration {
+	return time.Duration(r.dropped.Load()) * time.Second / time.Duration(r.sampleRate)
+}
+
+// Size returns the number of bytes written to the file so far.
+func (r *Recorder) Size() int64 {
+	return r.bytes.Load()
+}
+
+// MarkTrack adds a track to the tracklist, starting at the current end of the recording.
+func (r *Recorder) MarkTrack(performer, title string) {
+	start := r.Elapsed()
+	r.tracksMu.Lock()
+	r.tracks = append(r.tracks, Track{Start: start, Performer: performer, Title: title})
+	r.tracksMu.Unlock()
+	logger.Logger.Printf("Recording: %s - %s at %s", performer, title, formatTimestamp(start))
+}
+
+// Tracks returns the tracklist so far.
+func (r *Recorder) Tracks() []Track {
+	r.tracksMu.Lock()
+	defer r.tracksMu.Unlock()
+	return append([]Track(nil), r.tracks...)
+}
+
+// Stop finishes the file and, when tracks were marked, writes a cue sheet and a plain text
+// tracklist beside it with the same name. The recorder cannot be restarted.
+func (r *Recorder) Stop() error {
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return nil
+	}
+	r.stopped = true
+	gap := r.gap
+	r.gap = 0
+	r.mu.Unlock()
+
+	// Write no longer queues anything once stopped is set, so the queue is finished without the
+	// lock, which the audio thread would wait on while this waits for room in the queue.
+	if gap > 0 {
+		r.queue <- chunk{silence: gap}
+	}
+	close(r.queue)
+
+	<-r.done
+	err := r.err
+	if closeErr := r.enc.close(); err == nil {
+		err = closeErr
+	}
+	r.bytes.Store(r.enc.size())
+	if dropped := r.dropped.Load(); dropped > 0 {
+		logger.Logger.Printf("Recording: %d sample frames were replaced by silence because the disk fell behind", dropped)
+	}
+	if err != nil {
+		logger.Logger.Printf("Recording failed: %v", err)
+		return err
+	}
+
+	if tracks := r.Tracks(); len(tracks) > 0 {
+		base := strings.TrimSuffix(r.path, filepath.Ext(r.path))
+		title := "Megajam mix " + r.started.Format("2006-01-02 15:04")
+		if err := writeFile(base+".cue", func(f *os.File) error {
+			return WriteCueSheet(f, title, filepath.Base(r.path), tracks)
+		}); err != nil {
+			return err
+		}
+		if err := writeFile(base+".txt", func(f *os.File) error {
+			return WriteTracklist(f, title, tracks)
+		}); err != nil {
+			return err
+		}
+	}
+	logger.Logger.Printf("Recording stopped: %s, %s", r.path, formatTimestamp(r.Elapsed()))
+	return nil
+}
+
+// writeFile creates the file at path and fills it with write.
+func writeFile(path string, write func(f *os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create '%s': %w", path, err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write '%s': %w", path, err)
+	}
+	return f.Close()
+}
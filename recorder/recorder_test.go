@@ -0,0 +1,138 @@
+package recorder
+
+import (
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"megajam/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = log.New(io.Discard, "", 0)
+	os.Exit(m.Run())
+}
+
+// gatedEncoder records the left channel of what is written to it, holding the first write
+// until release is closed.
+type gatedEncoder struct {
+	release chan struct{}
+	left    []int16
+}
+
+func (e *gatedEncoder) write(samples [][2]int16) error {
+	<-e.release
+	for _, s := range samples {
+		e.left = append(e.left, s[0])
+	}
+	return nil
+}
+
+func (e *gatedEncoder) size() int64  { return int64(4 * len(e.left)) }
+func (e *gatedEncoder) close() error { return nil }
+
+// waitForQueue waits until the writer has taken every queued buffer.
+func waitForQueue(t *testing.T, r *Recorder) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); len(r.queue) > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("writer did not take the queued buffers")
+		}
+	}
+}
+
+func TestDroppedAudioIsWrittenAsSilence(t *testing.T) {
+	const bufferFrames = 100
+	enc := &gatedEncoder{release: make(chan struct{})}
+	r := start("mix.wav", WAV, 44100, enc)
+
+	buf := make([][2]float64, bufferFrames)
+	for i := range buf {
+		buf[i] = [2]float64{0.5, 0.5}
+	}
+	// The writer holds one buffer and the queue the next queueLength; the rest are dropped.
+	const written = queueLength + 1
+	const dropped = 40
+	r.Write(buf)
+	waitForQueue(t, r)
+	for i := 1; i < written+dropped; i++ {
+		r.Write(buf)
+	}
+	close(enc.release)
+	waitForQueue(t, r)
+	r.Write(buf)
+	r.Write(buf)
+	if err := r.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	total := (written + dropped + 2) * bufferFrames
+	if len(enc.left) != total {
+		t.Fatalf("wrote %d frames, want %d", len(enc.left), total)
+	}
+	if want := time.Duration(total) * time.Second / 44100; r.Elapsed() != want {
+		t.Errorf("Elapsed() = %v, want %v", r.Elapsed(), want)
+	}
+	if want := time.Duration(dropped*bufferFrames) * time.Second / 44100; r.Dropped() != want {
+		t.Errorf("Dropped() = %v, want %v", r.Dropped(), want)
+	}
+	for i, v := range enc.left {
+		silent := i >= written*bufferFrames && i < (written+dropped)*bufferFrames
+		if (v == 0) != silent {
+			t.Fatalf("frame %d = %d, want silence: %t", i, v, silent)
+		}
+	}
+}
+
+func TestSilenceDroppedBeforeStop(t *testing.T) {
+	enc := &gatedEncoder{release: make(chan struct{})}
+	r := start("mix.wav", WAV, 48000, enc)
+	buf := make([][2]float64, 10)
+	r.Write(buf)
+	waitForQueue(t, r)
+	for i := 1; i < queueLength+5; i++ {
+		r.Write(buf)
+	}
+	close(enc.release)
+	if err := r.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if want := (queueLength + 5) * 10; len(enc.left) != want {
+		t.Errorf("wrote %d frames, want %d", len(enc.left), want)
+	}
+}
+
+func TestStopDoesNotHoldUpWrite(t *testing.T) {
+	enc := &gatedEncoder{release: make(chan struct{})}
+	r := start("mix.wav", WAV, 44100, enc)
+	buf := make([][2]float64, 10)
+	r.Write(buf)
+	waitForQueue(t, r)
+	for i := 1; i < queueLength+5; i++ {
+		r.Write(buf)
+	}
+
+	// Stop waits for room in the full queue to write the dropped audio as silence.
+	stopped := make(chan error)
+	go func() { stopped <- r.Stop() }()
+	time.Sleep(20 * time.Millisecond)
+	written := make(chan struct{})
+	go func() {
+		r.Write(buf)
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Error("Write blocked while Stop waited for the queue")
+	}
+	close(enc.release)
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	if want := (queueLength + 5) * 10; len(enc.left) != want {
+		t.Errorf("wrote %d frames, want %d", len(enc.left), want)
+	}
+}
@@ -0,0 +1,90 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+)
+
+// wavHeaderSize is the length of the RIFF, fmt and data chunk headers written before the samples.
+const wavHeaderSize = 44
+
+// wavWriter writes 16-bit stereo PCM to a WAV file. The chunk sizes are filled in on close.
+type wavWriter struct {
+	f         *os.File
+	w         *bufio.Writer
+	dataBytes int64
+	buf       []byte
+}
+
+// newWAVWriter creates the file at path and writes a header for an empty recording.
+func newWAVWriter(path string, sampleRate int) (*wavWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+	w := &wavWriter{f: f, w: bufio.NewWriterSize(f, 64*1024)}
+
+	var header [wavHeaderSize]byte
+	copy(header[0:], "RIFF")
+	copy(header[8:], "WAVE")
+	copy(header[12:], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(header[22:], channels)
+	binary.LittleEndian.PutUint32(header[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(sampleRate*channels*bitsPerSample/8))
+	binary.LittleEndian.PutUint16(header[32:], channels*bitsPerSample/8)
+	binary.LittleEndian.PutUint16(header[34:], bitsPerSample)
+	copy(header[36:], "data")
+	if _, err := w.w.Write(header[:]); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write WAV header: %w", err)
+	}
+	return w, nil
+}
+
+func (w *wavWriter) write(samples [][2]int16) error {
+	if len(w.buf) < len(samples)*4 {
+		w.buf = make([]byte, len(samples)*4)
+	}
+	buf := w.buf[:len(samples)*4]
+	for i, frame := range samples {
+		binary.LittleEndian.PutUint16(buf[i*4:], uint16(frame[0]))
+		binary.LittleEndian.PutUint16(buf[i*4+2:], uint16(frame[1]))
+	}
+	n, err := w.w.Write(buf)
+	w.dataBytes += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write WAV samples: %w", err)
+	}
+	return nil
+}
+
+func (w *wavWriter) size() int64 {
+	return wavHeaderSize + w.dataBytes
+}
+
+// close fills in the chunk sizes and closes the file. The sizes are 32-bit, so players stop
+// reading a recording longer than about six and a half hours at the 4 GiB mark.
+func (w *wavWriter) close() error {
+	if err := w.w.Flush(); err != nil {
+		w.f.Close()
+		return fmt.Errorf("failed to write WAV samples: %w", err)
+	}
+	dataBytes := uint32(min(w.dataBytes, math.MaxUint32-wavHeaderSize))
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], dataBytes+wavHeaderSize-8)
+	_, err := w.f.WriteAt(size[:], 4)
+	if err == nil {
+		binary.LittleEndian.PutUint32(size[:], dataBytes)
+		_, err = w.f.WriteAt(size[:], 40)
+	}
+	if err != nil {
+		w.f.Close()
+		return fmt.Errorf("failed to finish WAV header: %w", err)
+	}
+	return w.f.Close()
+}
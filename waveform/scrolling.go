@@ -0,0 +1,349 @@
+package waveform
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Zoom limits of ScrollingWaveform, in seconds visible across the widget.
+const (
+	MinVisibleSeconds     = 2.0
+	MaxVisibleSeconds     = 64.0
+	DefaultVisibleSeconds = 8.0
+)
+
+// Peaks is one zoom level of a track's waveform. Bucket i covers the time
+// [i*SecondsPerBucket, (i+1)*SecondsPerBucket) and holds the sample range within it.
+type Peaks struct {
+	SecondsPerBucket float64
+	Min              []float32
+	Max              []float32
+}
+
+// Marker is a point in time drawn over a waveform, such as a cue point.
+type Marker struct {
+	Time  float64 // Seconds from the start of the track
+	Color color.Color
+}
+
+// Region is a span of time shaded on a waveform, such as a loop.
+type Region struct {
+	Start float64 // Seconds from the start of the track
+	End   float64
+	Color color.Color
+}
+
+// track is the data shared by the scrolling and overview waveforms.
+type track struct {
+	mu       sync.Mutex
+	levels   []Peaks // Finest first
+	duration float64
+	position float64
+	markers  []Marker
+	regions  []Region
+}
+
+func (t *track) setPeaks(levels []Peaks, duration float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.levels = levels
+	t.duration = duration
+	t.position = 0
+}
+
+func (t *track) setPosition(seconds float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.position = seconds
+}
+
+func (t *track) setOverlays(markers []Marker, regions []Region) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.markers = markers
+	t.regions = regions
+}
+
+// levelFor returns the coarsest level whose buckets are no longer than secondsPerPixel;
+// callers must hold mu.
+func (t *track) levelFor(secondsPerPixel float64) *Peaks {
+	if len(t.levels) == 0 {
+		return nil
+	}
+	best := &t.levels[0]
+	for i := range t.levels {
+		if t.levels[i].SecondsPerBucket <= secondsPerPixel {
+			best = &t.levels[i]
+		}
+	}
+	return best
+}
+
+// draw renders the track between start and start+width*secondsPerPixel into an image;
+// callers must hold mu.
+func (t *track) draw(wd, ht int, start, secondsPerPixel float64, wave, background color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, wd, ht))
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	xFor := func(seconds float64) int { return int(math.Round((seconds - start) / secondsPerPixel)) }
+
+	for _, region := range t.regions {
+		fillColumns(img, xFor(region.Start), xFor(region.End), region.Color)
+	}
+
+	level := t.levelFor(secondsPerPixel)
+	if level != nil && level.SecondsPerBucket > 0 {
+		mid := float64(ht) / 2
+		for x := 0; x < wd; x++ {
+			from := start + float64(x)*secondsPerPixel
+			if from < 0 || from >= t.duration {
+				continue
+			}
+			first := int(from / level.SecondsPerBucket)
+			last := int((from + secondsPerPixel) / level.SecondsPerBucket)
+			if last <= first {
+				last = first + 1
+			}
+			lo, hi := float32(0), float32(0)
+			for i := first; i < last && i < len(level.Max); i++ {
+				if level.Min[i] < lo {
+					lo = level.Min[i]
+				}
+				if level.Max[i] > hi {
+					hi = level.Max[i]
+				}
+			}
+			top := int(mid - float64(hi)*mid)
+			bottom := int(mid - float64(lo)*mid)
+			for y := top; y <= bottom; y++ {
+				if y >= 0 && y < ht {
+					img.Set(x, y, wave)
+				}
+			}
+		}
+	}
+
+	for _, marker := range t.markers {
+		x := xFor(marker.Time)
+		fillColumns(img, x, x+2, marker.Color)
+	}
+	return img
+}
+
+// fillColumns blends c over the columns [from, to) of img.
+func fillColumns(img *image.NRGBA, from, to int, c color.Color) {
+	bounds := img.Bounds()
+	if from < bounds.Min.X {
+		from = bounds.Min.X
+	}
+	if to > bounds.Max.X {
+		to = bounds.Max.X
+	}
+	if from >= to {
+		return
+	}
+	rect := image.Rect(from, bounds.Min.Y, to, bounds.Max.Y)
+	draw.Draw(img, rect, image.NewUniform(c), image.Point{}, draw.Over)
+}
+
+// ScrollingWaveform shows the part of a track around the playhead, which stays centred.
+// It zooms with the mouse wheel or ZoomIn/ZoomOut and seeks when tapped.
+type ScrollingWaveform struct {
+	widget.BaseWidget
+	track          track
+	visibleSeconds float64
+	minSize        fyne.Size
+
+	WaveColor     color.Color
+	PlayheadColor color.Color
+	OnSeek        func(seconds float64) // Called with the tapped time; may be nil
+}
+
+// NewScrollingWaveform creates an empty scrolling waveform.
+func NewScrollingWaveform() *ScrollingWaveform {
+	w := &ScrollingWaveform{
+		visibleSeconds: DefaultVisibleSeconds,
+		minSize:        fyne.NewSize(300, 80),
+		WaveColor:      color.NRGBA{R: 0, G: 170, B: 255, A: 255},
+		PlayheadColor:  color.White,
+	}
+	w.ExtendBaseWidget(w)
+	return w
+}
+
+// SetPeaks replaces the displayed track. duration is the length of the track in seconds.
+func (w *ScrollingWaveform) SetPeaks(levels []Peaks, duration float64) {
+	w.track.setPeaks(levels, duration)
+	w.Refresh()
+}
+
+// SetPosition moves the playhead to seconds.
+func (w *ScrollingWaveform) SetPosition(seconds float64) {
+	w.track.setPosition(seconds)
+	w.Refresh()
+}
+
+// SetOverlays replaces the cue markers and loop regions drawn over the waveform.
+func (w *ScrollingWaveform) SetOverlays(markers []Marker, regions []Region) {
+	w.track.setOverlays(markers, regions)
+	w.Refresh()
+}
+
+// ZoomIn halves the visible time span.
+func (w *ScrollingWaveform) ZoomIn() {
+	w.visibleSeconds = math.Max(MinVisibleSeconds, w.visibleSeconds/2)
+	w.Refresh()
+}
+
+// ZoomOut doubles the visible time span.
+func (w *ScrollingWaveform) ZoomOut() {
+	w.visibleSeconds = math.Min(MaxVisibleSeconds, w.visibleSeconds*2)
+	w.Refresh()
+}
+
+// SetMinSize sets the smallest size of the widget.
+func (w *ScrollingWaveform) SetMinSize(newSize fyne.Size) {
+	w.minSize = newSize
+}
+
+// Tapped seeks to the time under the pointer.
+func (w *ScrollingWaveform) Tapped(event *fyne.PointEvent) {
+	if w.OnSeek == nil || w.Size().Width <= 0 {
+		return
+	}
+	w.track.mu.Lock()
+	position := w.track.position
+	w.track.mu.Unlock()
+	secondsPerUnit := w.visibleSeconds / float64(w.Size().Width)
+	w.OnSeek(math.Max(0, position+float64(event.Position.X-w.Size().Width/2)*secondsPerUnit))
+}
+
+// Scrolled zooms in when scrolling up and out when scrolling down.
+func (w *ScrollingWaveform) Scrolled(event *fyne.ScrollEvent) {
+	if event.Scrolled.DY > 0 {
+		w.ZoomIn()
+	} else if event.Scrolled.DY < 0 {
+		w.ZoomOut()
+	}
+}
+
+func (w *ScrollingWaveform) CreateRenderer() fyne.WidgetRenderer {
+	raster := canvas.NewRaster(w.render)
+	return &rasterRenderer{raster: raster, minSize: func() fyne.Size { return w.minSize }}
+}
+
+func (w *ScrollingWaveform) render(wd, ht int) image.Image {
+	w.track.mu.Lock()
+	defer w.track.mu.Unlock()
+
+	secondsPerPixel := w.visibleSeconds / float64(wd)
+	start := w.track.position - float64(wd)/2*secondsPerPixel
+	img := w.track.draw(wd, ht, start, secondsPerPixel, w.WaveColor, theme.BackgroundColor())
+	fillColumns(img, wd/2, wd/2+2, w.PlayheadColor)
+	return img
+}
+
+// Overview shows a whole track with the elapsed part shaded, and seeks when tapped.
+type Overview struct {
+	widget.BaseWidget
+	track   track
+	minSize fyne.Size
+
+	WaveColor    color.Color
+	ElapsedColor color.Color           // Blended over the part of the track already played
+	OnSeek       func(seconds float64) // Called with the tapped time; may be nil
+}
+
+// NewOverview creates an empty overview strip.
+func NewOverview() *Overview {
+	o := &Overview{
+		minSize:      fyne.NewSize(300, 30),
+		WaveColor:    color.NRGBA{R: 160, G: 160, B: 160, A: 255},
+		ElapsedColor: color.NRGBA{R: 0, G: 0, B: 0, A: 140},
+	}
+	o.ExtendBaseWidget(o)
+	return o
+}
+
+// SetPeaks replaces the displayed track. duration is the length of the track in seconds.
+func (o *Overview) SetPeaks(levels []Peaks, duration float64) {
+	o.track.setPeaks(levels, duration)
+	o.Refresh()
+}
+
+// SetPosition moves the end of the elapsed shading to seconds.
+func (o *Overview) SetPosition(seconds float64) {
+	o.track.setPosition(seconds)
+	o.Refresh()
+}
+
+// SetOverlays replaces the cue markers and loop regions drawn over the overview.
+func (o *Overview) SetOverlays(markers []Marker, regions []Region) {
+	o.track.setOverlays(markers, regions)
+	o.Refresh()
+}
+
+// SetMinSize sets the smallest size of the widget.
+func (o *Overview) SetMinSize(newSize fyne.Size) {
+	o.minSize = newSize
+}
+
+// Tapped seeks to the time under the pointer.
+func (o *Overview) Tapped(event *fyne.PointEvent) {
+	if o.OnSeek == nil || o.Size().Width <= 0 {
+		return
+	}
+	o.track.mu.Lock()
+	duration := o.track.duration
+	o.track.mu.Unlock()
+	o.OnSeek(duration * float64(event.Position.X/o.Size().Width))
+}
+
+func (o *Overview) CreateRenderer() fyne.WidgetRenderer {
+	raster := canvas.NewRaster(o.render)
+	return &rasterRenderer{raster: raster, minSize: func() fyne.Size { return o.minSize }}
+}
+
+func (o *Overview) render(wd, ht int) image.Image {
+	o.track.mu.Lock()
+	defer o.track.mu.Unlock()
+
+	if o.track.duration <= 0 {
+		return o.track.draw(wd, ht, 0, 1, o.WaveColor, theme.BackgroundColor())
+	}
+	secondsPerPixel := o.track.duration / float64(wd)
+	img := o.track.draw(wd, ht, 0, secondsPerPixel, o.WaveColor, theme.BackgroundColor())
+	fillColumns(img, 0, int(o.track.position/secondsPerPixel), o.ElapsedColor)
+	return img
+}
+
+// rasterRenderer renders a widget that is drawn entirely by a raster.
+type rasterRenderer struct {
+	raster  *canvas.Raster
+	minSize func() fyne.Size
+}
+
+func (r *rasterRenderer) Layout(size fyne.Size) {
+	r.raster.Resize(size)
+}
+
+func (r *rasterRenderer) MinSize() fyne.Size {
+	return r.minSize()
+}
+
+func (r *rasterRenderer) Refresh() {
+	canvas.Refresh(r.raster)
+}
+
+func (r *rasterRenderer) Objects() []fyne.CanvasObject {
+	return []fyne.CanvasObject{r.raster}
+}
+
+func (r *rasterRenderer) Destroy() {}
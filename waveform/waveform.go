@@ -47,6 +47,11 @@ func (w *Waveform) SetMinSize(newSize fyne.Size) {
 	w.minSize = newSize
 }
 
+func (w *Waveform) SetAudioData(data []int32) {
+	w.audioData = data
+	w.Refresh()
+}
+
 func (w *Waveform) audioDataToImage(wd, ht int) image.Image {
 	foregroundColor := theme.ForegroundColor()
 	if w.OverrideForeground {